@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 
 	. "chunkymonkey/types"
 	"chunkymonkey/util"
@@ -13,6 +14,7 @@ import (
 
 type chunkStoreAlpha struct {
 	worldPath string
+	readOnly  bool
 }
 
 // Creates an IChunkStore that reads the Minecraft Alpha world format.
@@ -44,15 +46,24 @@ func (s *chunkStoreAlpha) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err
 	}
 	defer file.Close()
 
+	fi, err := file.Stat()
+	if err != nil {
+		return
+	}
+
 	gzipReader, err := gzip.NewReader(file)
 	if err != nil {
 		return
 	}
 	defer gzipReader.Close()
-	reader, err = newNbtChunkReader(gzipReader)
+	nbtReader, err := newNbtChunkReader(gzipReader)
 	if err != nil {
 		return
 	}
+	// Alpha chunks are written to a file of their own, so the time the file
+	// was modified is the time the chunk was written.
+	nbtReader.timestamp = fi.Mtime_ns / 1e9
+	reader = nbtReader
 
 	loadedLoc := reader.ChunkLoc()
 	if loadedLoc.X != chunkLoc.X || loadedLoc.Z != chunkLoc.Z {
@@ -67,7 +78,7 @@ func (s *chunkStoreAlpha) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err
 }
 
 func (s *chunkStoreAlpha) SupportsWrite() bool {
-	return true
+	return !s.readOnly
 }
 
 func (s *chunkStoreAlpha) Writer() IChunkWriter {
@@ -75,6 +86,10 @@ func (s *chunkStoreAlpha) Writer() IChunkWriter {
 }
 
 func (s *chunkStoreAlpha) WriteChunk(writer IChunkWriter) (err os.Error) {
+	if s.readOnly {
+		return errReadOnlyStore
+	}
+
 	nbtWriter, ok := writer.(*nbtChunkWriter)
 	if !ok {
 		return fmt.Errorf("%T is incorrect IChunkWriter implementation for %T", writer, s)
@@ -107,6 +122,9 @@ func (s *chunkStoreAlpha) WriteChunk(writer IChunkWriter) (err os.Error) {
 	return os.Rename(file.Name(), destName)
 }
 
+func (s *chunkStoreAlpha) Close() {
+}
+
 // Utility functions:
 
 func base36Encode(n int32) (s string) {
@@ -131,3 +149,15 @@ func base36Encode(n int32) (s string) {
 	}
 	return
 }
+
+func base36Decode(s string) (n int32, err os.Error) {
+	n64, err := strconv.Btoi64(s, 36)
+	if err != nil {
+		return
+	}
+	n = int32(n64)
+	if int64(n) != n64 {
+		err = fmt.Errorf("%q is out of range", s)
+	}
+	return
+}
@@ -0,0 +1,213 @@
+package chunkstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+	"nbt"
+)
+
+// AlphaConversion is the result of converting the chunks of an Alpha world.
+type AlphaConversion struct {
+	// Converted is the number of chunks copied into region files.
+	Converted int
+	// Skipped is the number of Alpha chunk files that could not be read, and
+	// were not converted.
+	Skipped int
+}
+
+// ConvertAlphaChunks copies every chunk file in one dimension of an Alpha
+// world into the region files read by the Beta chunk store. The chunks are
+// then read back from the region files and checked against the Alpha chunks.
+// The Alpha chunk files are left in place. progress is called after each
+// chunk is copied, if it is not nil.
+//
+// The world must not be in use by a running server.
+func ConvertAlphaChunks(worldPath string, dimension DimensionId, progress func(done, total int)) (result AlphaConversion, err os.Error) {
+	alphaPath := worldPath
+	if dimension != DimensionNormal {
+		alphaPath = path.Join(worldPath, fmt.Sprintf("DIM%d", dimension))
+	}
+
+	chunkLocs, err := alphaChunkLocs(alphaPath)
+	if err != nil || len(chunkLocs) == 0 {
+		return
+	}
+
+	alphaStore, err := newChunkStoreAlpha(alphaPath, dimension)
+	if err != nil {
+		return
+	}
+	betaStore, err := newChunkStoreBeta(worldPath, dimension)
+	if err != nil {
+		return
+	}
+
+	// The checksum of each converted chunk, in the same order as converted.
+	var converted []ChunkXz
+	var checksums []uint32
+
+	for i, chunkLoc := range chunkLocs {
+		if progress != nil {
+			progress(i, len(chunkLocs))
+		}
+
+		reader, readErr := readAlphaChunk(alphaStore, chunkLoc)
+		if readErr != nil {
+			log.Printf("%s: skipping chunk %d,%d: %v", alphaPath, chunkLoc.X, chunkLoc.Z, readErr)
+			result.Skipped++
+			continue
+		}
+
+		writer := &nbtChunkWriter{
+			loc:      chunkLoc,
+			chunkTag: reader.chunkTag.(*nbt.Compound),
+		}
+		if err = betaStore.WriteChunk(writer); err != nil {
+			betaStore.Close()
+			return
+		}
+
+		converted = append(converted, chunkLoc)
+		checksums = append(checksums, chunkChecksum(reader))
+	}
+	betaStore.Close()
+	result.Converted = len(converted)
+
+	if progress != nil {
+		progress(len(chunkLocs), len(chunkLocs))
+	}
+
+	err = verifyConvertedChunks(worldPath, dimension, converted, checksums)
+
+	return
+}
+
+func readAlphaChunk(alphaStore *chunkStoreAlpha, chunkLoc ChunkXz) (r *nbtChunkReader, err os.Error) {
+	reader, err := alphaStore.ReadChunk(chunkLoc)
+	if err != nil {
+		return
+	}
+
+	r = reader.(*nbtChunkReader)
+	if err = r.validate(chunkLoc); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// verifyConvertedChunks checks that the region files of the dimension hold
+// exactly the given chunks, with the given checksums.
+func verifyConvertedChunks(worldPath string, dimension DimensionId, chunkLocs []ChunkXz, checksums []uint32) (err os.Error) {
+	betaStore, err := newChunkStoreBeta(worldPath, dimension)
+	if err != nil {
+		return
+	}
+	defer betaStore.Close()
+
+	for i, chunkLoc := range chunkLocs {
+		reader, err := betaStore.ReadChunk(chunkLoc)
+		if err != nil {
+			return fmt.Errorf("Chunk %d,%d could not be read back: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+		if chunkChecksum(reader) != checksums[i] {
+			return fmt.Errorf("Chunk %d,%d was read back with different data", chunkLoc.X, chunkLoc.Z)
+		}
+	}
+
+	regionChunkLocs, err := betaStore.chunkLocs()
+	if err != nil {
+		return
+	}
+	if len(regionChunkLocs) != len(chunkLocs) {
+		return fmt.Errorf("Region files hold %d chunks, but %d chunks were converted", len(regionChunkLocs), len(chunkLocs))
+	}
+
+	return
+}
+
+// chunkChecksum returns a checksum of the blocks and lighting of the chunk,
+// and of the number of entities and tile entities in it.
+func chunkChecksum(reader IChunkReader) uint32 {
+	hash := crc32.NewIEEE()
+	hash.Write(reader.Blocks())
+	hash.Write(reader.BlockData())
+	hash.Write(reader.SkyLight())
+	hash.Write(reader.BlockLight())
+	hash.Write(reader.HeightMap())
+	for _, listPath := range []string{"Level/Entities", "Level/TileEntities"} {
+		var count int32
+		if root := reader.RootTag(); root != nil {
+			if list, ok := root.Lookup(listPath).(*nbt.List); ok {
+				count = int32(len(list.Value))
+			}
+		}
+		binary.Write(hash, binary.BigEndian, count)
+	}
+	return hash.Sum32()
+}
+
+// alphaChunkLocs returns the location of every chunk file in an Alpha world.
+// Alpha worlds keep each chunk in a file named after its location, within two
+// levels of directories named after the location modulo 64.
+func alphaChunkLocs(alphaPath string) (chunkLocs []ChunkXz, err os.Error) {
+	for x := int32(0); x < 64; x++ {
+		for z := int32(0); z < 64; z++ {
+			dirPath := path.Join(alphaPath, base36Encode(x), base36Encode(z))
+			names, err := readDirNames(dirPath)
+			if err != nil {
+				if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
+					continue
+				}
+				return nil, err
+			}
+
+			for _, name := range names {
+				chunkLoc, ok := parseAlphaChunkName(name)
+				if ok && int32(chunkLoc.X&63) == x && int32(chunkLoc.Z&63) == z {
+					chunkLocs = append(chunkLocs, chunkLoc)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// parseAlphaChunkName returns the location of the chunk in an Alpha chunk file
+// with the given name, such as "c.-d.2i.dat".
+func parseAlphaChunkName(name string) (chunkLoc ChunkXz, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 4 || parts[0] != "c" || parts[3] != "dat" {
+		return
+	}
+
+	x, err := base36Decode(parts[1])
+	if err != nil || base36Encode(x) != parts[1] {
+		return
+	}
+	z, err := base36Decode(parts[2])
+	if err != nil || base36Encode(z) != parts[2] {
+		return
+	}
+
+	return ChunkXz{ChunkCoord(x), ChunkCoord(z)}, true
+}
+
+func readDirNames(dirPath string) (names []string, err os.Error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+
+	return dir.Readdirnames(-1)
+}
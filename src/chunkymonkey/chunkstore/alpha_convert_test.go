@@ -0,0 +1,105 @@
+package chunkstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func TestParseAlphaChunkName(t *testing.T) {
+	type Test struct {
+		name     string
+		expLoc   ChunkXz
+		expValid bool
+	}
+
+	tests := []Test{
+		{"c.0.0.dat", ChunkXz{0, 0}, true},
+		{"c.-d.2i.dat", ChunkXz{-13, 90}, true},
+		{"c.1a.-1.dat", ChunkXz{46, -1}, true},
+		{"c.0.0.dat.tmp", ChunkXz{}, false},
+		{"c.00.0.dat", ChunkXz{}, false},
+		{"c.A.0.dat", ChunkXz{}, false},
+		{"c.0.dat", ChunkXz{}, false},
+		{"level.dat", ChunkXz{}, false},
+	}
+
+	for _, test := range tests {
+		chunkLoc, ok := parseAlphaChunkName(test.name)
+		if ok != test.expValid || (ok && !chunkLoc.Equals(test.expLoc)) {
+			t.Errorf("parseAlphaChunkName(%q) expected %v, %t but got %v, %t",
+				test.name, test.expLoc, test.expValid, chunkLoc, ok)
+		}
+	}
+}
+
+func TestConvertAlphaChunks(t *testing.T) {
+	worldPath, err := ioutil.TempDir("", "chunkymonkey-world")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(worldPath)
+
+	alphaStore, err := newChunkStoreAlpha(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreAlpha: %v", err)
+	}
+
+	// The chunks are in several region files.
+	var writers []*nbtChunkWriter
+	for i, chunkLoc := range []ChunkXz{{0, 0}, {-1, 5}, {40, -70}} {
+		w := testChunkWriter(chunkLoc, 1, int64(i))
+		if err = alphaStore.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+		writers = append(writers, w)
+	}
+
+	// A chunk file that cannot be read is skipped.
+	badPath := alphaStore.chunkPath(ChunkXz{2, 2})
+	os.MkdirAll(path.Dir(badPath), 0777)
+	if err = ioutil.WriteFile(badPath, []byte("not a chunk"), 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	result, err := ConvertAlphaChunks(worldPath, DimensionNormal, nil)
+	if err != nil {
+		t.Fatalf("ConvertAlphaChunks: %v", err)
+	}
+	if result.Converted != 3 || result.Skipped != 1 {
+		t.Errorf("expected 3 chunks converted and 1 skipped, but got %+v", result)
+	}
+
+	betaStore, err := newChunkStoreBeta(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	defer betaStore.Close()
+
+	for _, w := range writers {
+		rf, err := betaStore.regionFile(w.ChunkLoc())
+		if err != nil {
+			t.Fatalf("regionFile(%v): %v", w.ChunkLoc(), err)
+		}
+		checkChunk(t, rf, w)
+	}
+}
+
+func TestChunkChecksum(t *testing.T) {
+	w := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	before := chunkChecksum(&nbtChunkReader{chunkTag: w.RootTag()})
+
+	w.RootTag().Lookup("Level").(*nbt.Compound).Set("TileEntities", &nbt.List{nbt.TagCompound, []nbt.ITag{
+		&nbt.Compound{map[string]nbt.ITag{
+			"id": &nbt.String{"Chest"},
+			"x":  &nbt.Int{3}, "y": &nbt.Int{20}, "z": &nbt.Int{5},
+		}},
+	}})
+	if chunkChecksum(&nbtChunkReader{chunkTag: w.RootTag()}) == before {
+		t.Errorf("expected the checksum to change when a tile entity is added")
+	}
+}
@@ -0,0 +1,330 @@
+package chunkstore
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// LevelVersionAnvil is the version in level.dat of worlds whose chunks are
+// stored in the Anvil format, as read by Notchian servers since Minecraft 1.2.
+const LevelVersionAnvil = 19133
+
+// AnvilBiomeUnknown is the Anvil biome ID of columns whose biome is left for
+// the game that loads the chunk to decide.
+const AnvilBiomeUnknown = 255
+
+const (
+	// Anvil chunks are divided into sections of 16x16x16 blocks, stacked from
+	// the bottom of the chunk.
+	anvilSectionShift  = 4
+	anvilSectionSize   = 1 << anvilSectionShift
+	anvilSectionBlocks = anvilSectionSize * anvilSectionSize * anvilSectionSize
+	anvilSectionCount  = ChunkSizeY / anvilSectionSize
+)
+
+// AnvilBiomeFunc returns the Anvil biome ID of each column in a chunk,
+// indexed by x + z*16, or nil if the biomes of the chunk are not known.
+type AnvilBiomeFunc func(chunkLoc ChunkXz) []byte
+
+// ExportAnvilChunks converts every chunk in one dimension of a McRegion world
+// from the store for the given chunk format to the Anvil format, and writes
+// them to region files with the ".mca" extension in the same layout under
+// exportPath. Each chunk is read back and checked after it is written. The
+// biomes of each chunk are given by biomes, if it is not nil.
+//
+// The world's level.dat and player data are not exported. The world must not
+// be in use by a running server.
+func ExportAnvilChunks(worldPath string, dimension DimensionId, format string, exportPath string, biomes AnvilBiomeFunc) (count int, err os.Error) {
+	store, err := chunkStoreForFormat(worldPath, dimension, format, true)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	chunkLocs, err := store.(chunkLister).chunkLocs()
+	if err != nil {
+		return
+	}
+	// Each region file is then opened only once.
+	sort.Sort(chunkLocsByRegion(chunkLocs))
+
+	regionPath := path.Join(exportPath, "region")
+	if dimension != DimensionNormal {
+		regionPath = path.Join(exportPath, fmt.Sprintf("DIM%d", dimension), "region")
+	}
+	if err = os.MkdirAll(regionPath, 0777); err != nil {
+		return
+	}
+
+	var rf *regionFile
+	defer func() {
+		if rf != nil {
+			rf.Close()
+		}
+	}()
+
+	for _, chunkLoc := range chunkLocs {
+		reader, err := store.ReadChunk(chunkLoc)
+		if err != nil {
+			return count, fmt.Errorf("Chunk %d,%d could not be read: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+		nbtReader, ok := reader.(*nbtChunkReader)
+		if !ok {
+			return count, fmt.Errorf("Chunk %d,%d was not read from NBT", chunkLoc.X, chunkLoc.Z)
+		}
+
+		var chunkBiomes []byte
+		if biomes != nil {
+			chunkBiomes = biomes(chunkLoc)
+		}
+		chunkTag, err := anvilChunkTag(nbtReader, chunkBiomes)
+		if err != nil {
+			return count, fmt.Errorf("Chunk %d,%d could not be converted: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+
+		regionLoc := regionLocForChunkXz(chunkLoc)
+		if rf == nil || rf.loc.regionKey() != regionLoc.regionKey() {
+			if rf != nil {
+				rf.Close()
+				rf = nil
+			}
+			if rf, err = newRegionFile(regionLoc.anvilRegionFilePath(regionPath)); err != nil {
+				return count, err
+			}
+			rf.loc = regionLoc
+		}
+
+		if err = rf.WriteChunkData(&nbtChunkWriter{loc: chunkLoc, chunkTag: chunkTag}); err != nil {
+			return count, err
+		}
+		if err = verifyAnvilChunk(rf, chunkLoc, chunkTag); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return
+}
+
+// verifyAnvilChunk reads the chunk back from the region file and checks that
+// it holds the same blocks and lighting as chunkTag.
+func verifyAnvilChunk(rf *regionFile, chunkLoc ChunkXz, chunkTag *nbt.Compound) (err os.Error) {
+	chunkData, err := rf.readChunkBytes(chunkLoc)
+	if err == nil {
+		var written *nbtChunkReader
+		if written, err = parseChunkData(chunkData); err == nil {
+			if anvilChunkChecksum(written.RootTag()) != anvilChunkChecksum(chunkTag) {
+				err = os.NewError("different data")
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Chunk %d,%d was not read back from %s: %v", chunkLoc.X, chunkLoc.Z, rf.filePath, err)
+	}
+
+	return
+}
+
+// anvilChunkTag converts a McRegion chunk to the Anvil format. Sections that
+// hold nothing but air are left out, as they are by Notchian servers. The
+// biomes are given in the order of AnvilBiomeFunc, or are unknown if nil.
+func anvilChunkTag(r *nbtChunkReader, biomes []byte) (chunkTag *nbt.Compound, err os.Error) {
+	chunkLoc := r.ChunkLoc()
+	if err = r.validate(chunkLoc); err != nil {
+		return
+	}
+
+	var sections []nbt.ITag
+	for sectionY := 0; sectionY < anvilSectionCount; sectionY++ {
+		if section := anvilSection(r, sectionY); section != nil {
+			sections = append(sections, section)
+		}
+	}
+
+	// Anvil height maps are ordered by z then x, unlike McRegion height maps.
+	heightMap := r.HeightMap()
+	anvilHeightMap := make([]int32, ChunkSizeH*ChunkSizeH)
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			anvilHeightMap[x+z<<ChunkHShift] = int32(heightMap[z+x<<ChunkHShift])
+		}
+	}
+
+	if len(biomes) != ChunkSizeH*ChunkSizeH {
+		biomes = make([]byte, ChunkSizeH*ChunkSizeH)
+		for i := range biomes {
+			biomes[i] = AnvilBiomeUnknown
+		}
+	}
+
+	var populated int8
+	if r.TerrainPopulated() {
+		populated = 1
+	}
+
+	entities, ok := r.chunkTag.Lookup("Level/Entities").(*nbt.List)
+	if !ok {
+		entities = &nbt.List{nbt.TagCompound, nil}
+	}
+
+	chunkTag = &nbt.Compound{map[string]nbt.ITag{
+		"Level": &nbt.Compound{map[string]nbt.ITag{
+			"xPos":             &nbt.Int{int32(chunkLoc.X)},
+			"zPos":             &nbt.Int{int32(chunkLoc.Z)},
+			"LastUpdate":       &nbt.Long{int64(r.LastUpdate())},
+			"TerrainPopulated": &nbt.Byte{populated},
+			"HeightMap":        &nbt.IntArray{anvilHeightMap},
+			"Biomes":           &nbt.ByteArray{cloneByteArray(biomes)},
+			"Sections":         &nbt.List{nbt.TagCompound, sections},
+			"Entities":         entities,
+			"TileEntities":     &nbt.List{nbt.TagCompound, anvilTileEntities(r)},
+		}},
+	}}
+
+	return
+}
+
+// anvilSection returns the Anvil section of the chunk sectionY sections from
+// the bottom, or nil if the section holds only air. Anvil sections order
+// their blocks by y, then z, then x, whereas McRegion chunks order them by x,
+// then z, then y.
+//
+// The Add array holds the upper four bits of block IDs above 255. McRegion
+// chunks only hold 8 bit block IDs, so it is left out.
+func anvilSection(r *nbtChunkReader, sectionY int) *nbt.Compound {
+	blocks := r.Blocks()
+	blockData := r.BlockData()
+	blockLight := r.BlockLight()
+	skyLight := r.SkyLight()
+
+	sectionBlocks := make([]byte, anvilSectionBlocks)
+	sectionData := make([]byte, anvilSectionBlocks/2)
+	sectionBlockLight := make([]byte, anvilSectionBlocks/2)
+	sectionSkyLight := make([]byte, anvilSectionBlocks/2)
+
+	empty := true
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			for y := 0; y < anvilSectionSize; y++ {
+				subLoc := SubChunkXyz{
+					X: SubChunkCoord(x),
+					Y: SubChunkCoord(sectionY<<anvilSectionShift + y),
+					Z: SubChunkCoord(z),
+				}
+				from, _ := subLoc.BlockIndex()
+				to := BlockIndex(x + z<<anvilSectionShift + y<<(2*anvilSectionShift))
+
+				blockId := from.BlockId(blocks)
+				if blockId != BlockIdAir {
+					empty = false
+				}
+				to.SetBlockId(sectionBlocks, blockId)
+				to.SetBlockData(sectionData, from.BlockData(blockData))
+				to.SetBlockData(sectionBlockLight, from.BlockData(blockLight))
+				to.SetBlockData(sectionSkyLight, from.BlockData(skyLight))
+			}
+		}
+	}
+
+	if empty {
+		return nil
+	}
+
+	return &nbt.Compound{map[string]nbt.ITag{
+		"Y":          &nbt.Byte{int8(sectionY)},
+		"Blocks":     &nbt.ByteArray{sectionBlocks},
+		"Data":       &nbt.ByteArray{sectionData},
+		"BlockLight": &nbt.ByteArray{sectionBlockLight},
+		"SkyLight":   &nbt.ByteArray{sectionSkyLight},
+	}}
+}
+
+// anvilTileEntities returns the tile entities of the chunk. Their tags are the
+// same in the Anvil format, but tile entities that are not within the chunk
+// would be misplaced by the game, and are left out.
+func anvilTileEntities(r *nbtChunkReader) (tileEntities []nbt.ITag) {
+	tileEntityList, ok := r.chunkTag.Lookup("Level/TileEntities").(*nbt.List)
+	if !ok {
+		return
+	}
+
+	chunkLoc := r.ChunkLoc()
+	for _, tag := range tileEntityList.Value {
+		x, xOk := tag.Lookup("x").(*nbt.Int)
+		y, yOk := tag.Lookup("y").(*nbt.Int)
+		z, zOk := tag.Lookup("z").(*nbt.Int)
+		if !xOk || !yOk || !zOk {
+			log.Printf("Chunk %d,%d: dropping tile entity without a position", chunkLoc.X, chunkLoc.Z)
+			continue
+		}
+
+		blockLoc := BlockXyz{BlockCoord(x.Value), BlockYCoord(y.Value), BlockCoord(z.Value)}
+		if !blockLoc.ToChunkXz().Equals(chunkLoc) || y.Value < 0 || y.Value >= ChunkSizeY {
+			log.Printf("Chunk %d,%d: dropping tile entity outside the chunk at %d,%d,%d", chunkLoc.X, chunkLoc.Z, x.Value, y.Value, z.Value)
+			continue
+		}
+
+		tileEntities = append(tileEntities, tag)
+	}
+
+	return
+}
+
+// anvilChunkChecksum returns a checksum of the sections and height map of an
+// Anvil chunk.
+func anvilChunkChecksum(chunkTag nbt.ITag) uint32 {
+	hash := crc32.NewIEEE()
+
+	if sections, ok := chunkTag.Lookup("Level/Sections").(*nbt.List); ok {
+		for _, section := range sections.Value {
+			if y, ok := section.Lookup("Y").(*nbt.Byte); ok {
+				hash.Write([]byte{byte(y.Value)})
+			}
+			for _, name := range []string{"Blocks", "Data", "BlockLight", "SkyLight"} {
+				if array, ok := section.Lookup(name).(*nbt.ByteArray); ok {
+					hash.Write(array.Value)
+				}
+			}
+		}
+	}
+
+	if heightMap, ok := chunkTag.Lookup("Level/HeightMap").(*nbt.IntArray); ok {
+		for _, height := range heightMap.Value {
+			hash.Write([]byte{byte(height)})
+		}
+	}
+
+	return hash.Sum32()
+}
+
+// chunkLocsByRegion sorts chunk locations so that the chunks in each region
+// are together.
+type chunkLocsByRegion []ChunkXz
+
+func (s chunkLocsByRegion) Len() int {
+	return len(s)
+}
+
+func (s chunkLocsByRegion) Less(i, j int) bool {
+	a, b := regionLocForChunkXz(s[i]), regionLocForChunkXz(s[j])
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	if a.Z != b.Z {
+		return a.Z < b.Z
+	}
+	return indexForChunkLoc(s[i]) < indexForChunkLoc(s[j])
+}
+
+func (s chunkLocsByRegion) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
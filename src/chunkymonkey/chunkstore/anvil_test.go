@@ -0,0 +1,145 @@
+package chunkstore
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func TestAnvilChunkTag(t *testing.T) {
+	w := testChunkWriter(ChunkXz{2, -1}, 0, 0)
+	blocks := w.RootTag().Lookup("Level/Blocks").(*nbt.ByteArray).Value
+	blockData := w.RootTag().Lookup("Level/Data").(*nbt.ByteArray).Value
+	skyLight := w.RootTag().Lookup("Level/SkyLight").(*nbt.ByteArray).Value
+	heightMap := w.RootTag().Lookup("Level/HeightMap").(*nbt.ByteArray).Value
+
+	subLoc := SubChunkXyz{X: 3, Y: 20, Z: 5}
+	index, _ := subLoc.BlockIndex()
+	index.SetBlockId(blocks, BlockIdStone)
+	index.SetBlockData(blockData, 7)
+	index.SetBlockData(skyLight, 15)
+	heightMap[3*ChunkSizeH+5] = 21
+
+	w.RootTag().Lookup("Level").(*nbt.Compound).Set("TileEntities", &nbt.List{nbt.TagCompound, []nbt.ITag{
+		&nbt.Compound{map[string]nbt.ITag{
+			"id": &nbt.String{"Chest"},
+			"x":  &nbt.Int{35}, "y": &nbt.Int{20}, "z": &nbt.Int{-11},
+		}},
+		// Outside of the chunk.
+		&nbt.Compound{map[string]nbt.ITag{
+			"id": &nbt.String{"Chest"},
+			"x":  &nbt.Int{3}, "y": &nbt.Int{20}, "z": &nbt.Int{5},
+		}},
+	}})
+
+	chunkTag, err := anvilChunkTag(&nbtChunkReader{chunkTag: w.RootTag()}, nil)
+	if err != nil {
+		t.Fatalf("anvilChunkTag: %v", err)
+	}
+
+	if x, ok := chunkTag.Lookup("Level/xPos").(*nbt.Int); !ok || x.Value != 2 {
+		t.Errorf("expected xPos 2 but got %v", x)
+	}
+
+	sections := chunkTag.Lookup("Level/Sections").(*nbt.List).Value
+	if len(sections) != 1 {
+		t.Fatalf("expected 1 section but got %d", len(sections))
+	}
+	section := sections[0]
+	if y := section.Lookup("Y").(*nbt.Byte).Value; y != 1 {
+		t.Errorf("expected section Y 1 but got %d", y)
+	}
+
+	// Blocks are ordered by y, then z, then x within the section.
+	anvilIndex := BlockIndex(4*256 + 5*16 + 3)
+	if blockId := anvilIndex.BlockId(section.Lookup("Blocks").(*nbt.ByteArray).Value); blockId != BlockIdStone {
+		t.Errorf("expected stone but got block %d", blockId)
+	}
+	if data := anvilIndex.BlockData(section.Lookup("Data").(*nbt.ByteArray).Value); data != 7 {
+		t.Errorf("expected block data 7 but got %d", data)
+	}
+	if light := anvilIndex.BlockData(section.Lookup("SkyLight").(*nbt.ByteArray).Value); light != 15 {
+		t.Errorf("expected sky light 15 but got %d", light)
+	}
+	if light := (anvilIndex + 1).BlockData(section.Lookup("SkyLight").(*nbt.ByteArray).Value); light != 0 {
+		t.Errorf("expected sky light 0 next to the block but got %d", light)
+	}
+
+	if height := chunkTag.Lookup("Level/HeightMap").(*nbt.IntArray).Value[5*16+3]; height != 21 {
+		t.Errorf("expected height 21 but got %d", height)
+	}
+
+	for _, biome := range chunkTag.Lookup("Level/Biomes").(*nbt.ByteArray).Value {
+		if biome != AnvilBiomeUnknown {
+			t.Errorf("expected unknown biomes but got %d", biome)
+			break
+		}
+	}
+
+	if tileEntities := chunkTag.Lookup("Level/TileEntities").(*nbt.List).Value; len(tileEntities) != 1 {
+		t.Errorf("expected 1 tile entity but got %d", len(tileEntities))
+	}
+}
+
+func TestExportAnvilChunks(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+	exportPath := tempWorld(t)
+	defer os.RemoveAll(exportPath)
+
+	betaStore, err := newChunkStoreBeta(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	// The chunks are in several region files.
+	chunkLocs := []ChunkXz{{0, 0}, {40, -70}, {1, 0}}
+	for i, chunkLoc := range chunkLocs {
+		if err = betaStore.WriteChunk(testChunkWriter(chunkLoc, 1, int64(i))); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	betaStore.Close()
+
+	biomes := func(chunkLoc ChunkXz) []byte {
+		columns := make([]byte, ChunkSizeH*ChunkSizeH)
+		for i := range columns {
+			columns[i] = byte(chunkLoc.X)
+		}
+		return columns
+	}
+
+	count, err := ExportAnvilChunks(worldPath, DimensionNormal, ChunkFormatRegion, exportPath, biomes)
+	if err != nil {
+		t.Fatalf("ExportAnvilChunks: %v", err)
+	}
+	if count != len(chunkLocs) {
+		t.Errorf("expected %d chunks exported but got %d", len(chunkLocs), count)
+	}
+
+	for _, chunkLoc := range chunkLocs {
+		regionLoc := regionLocForChunkXz(chunkLoc)
+		rf, err := newRegionFile(regionLoc.anvilRegionFilePath(path.Join(exportPath, "region")))
+		if err != nil {
+			t.Fatalf("newRegionFile: %v", err)
+		}
+		chunkData, err := rf.readChunkBytes(chunkLoc)
+		rf.Close()
+		if err != nil {
+			t.Fatalf("readChunkBytes(%v): %v", chunkLoc, err)
+		}
+		r, err := parseChunkData(chunkData)
+		if err != nil {
+			t.Fatalf("parseChunkData(%v): %v", chunkLoc, err)
+		}
+
+		if z := r.RootTag().Lookup("Level/zPos").(*nbt.Int).Value; z != int32(chunkLoc.Z) {
+			t.Errorf("chunk %v exported with zPos %d", chunkLoc, z)
+		}
+		if biome := r.RootTag().Lookup("Level/Biomes").(*nbt.ByteArray).Value[0]; biome != byte(chunkLoc.X) {
+			t.Errorf("chunk %v exported with biome %d", chunkLoc, biome)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package chunkstore
 
 import (
+	"container/list"
+	"expvar"
 	"fmt"
 	"os"
 	"path"
@@ -13,6 +15,10 @@ const (
 	regionFileEdge       = 32
 	regionFileEdgeShift  = 5
 	regionFileSectorSize = 4096
+	// The header occupies the first sectors of a region file.
+	regionFileHeaderSectors = 2
+	// Chunk offsets hold the sector count of a chunk in a single byte.
+	regionFileMaxChunkSectors = 255
 	// 5 is the size of chunkDataHeader in bytes.
 	chunkDataHeaderSize = 5
 	chunkDataGuessSize  = 8192
@@ -21,15 +27,53 @@ const (
 	chunkCompressionZlib = 2
 )
 
+// MaxOpenRegionFiles is the number of region files that each Beta chunk store
+// keeps open. The least recently used region file is closed when another
+// needs opening.
+var MaxOpenRegionFiles = 64
+
+var (
+	expVarRegionFileCacheHitCount      *expvar.Int
+	expVarRegionFileCacheMissCount     *expvar.Int
+	expVarRegionFileCacheEvictionCount *expvar.Int
+)
+
+func init() {
+	expVarRegionFileCacheHitCount = expvar.NewInt("region-file-cache-hit-count")
+	expVarRegionFileCacheMissCount = expvar.NewInt("region-file-cache-miss-count")
+	expVarRegionFileCacheEvictionCount = expvar.NewInt("region-file-cache-eviction-count")
+}
+
 type chunkStoreBeta struct {
-	regionPath  string
-	regionFiles map[uint64]*regionFile
+	regionPath     string
+	maxRegionFiles int
+
+	// regionFiles holds the open region files, keyed by regionLoc.regionKey().
+	// Each list element is a *regionFile, and the most recently used region
+	// file is at the front of recentRegionFiles.
+	regionFiles       map[uint64]*list.Element
+	recentRegionFiles *list.List
+
+	// readOnly is true if the store must not change any files.
+	readOnly bool
 }
 
 // Creates a chunkStoreBeta that reads the Minecraft Beta world format.
 func newChunkStoreBeta(worldPath string, dimension DimensionId) (s *chunkStoreBeta, err os.Error) {
+	return openChunkStoreBeta(worldPath, dimension, false)
+}
+
+// openChunkStoreBeta creates a chunkStoreBeta. A read-only store does not
+// create the region directory, and opens its region files read-only.
+func openChunkStoreBeta(worldPath string, dimension DimensionId, readOnly bool) (s *chunkStoreBeta, err os.Error) {
 	s = &chunkStoreBeta{
-		regionFiles: make(map[uint64]*regionFile),
+		maxRegionFiles:    MaxOpenRegionFiles,
+		regionFiles:       make(map[uint64]*list.Element),
+		recentRegionFiles: list.New(),
+		readOnly:          readOnly,
+	}
+	if s.maxRegionFiles < 1 {
+		s.maxRegionFiles = 1
 	}
 
 	if dimension == DimensionNormal {
@@ -38,37 +82,60 @@ func newChunkStoreBeta(worldPath string, dimension DimensionId) (s *chunkStoreBe
 		s.regionPath = path.Join(worldPath, fmt.Sprintf("DIM%d", dimension), "region")
 	}
 
-	if err = os.MkdirAll(s.regionPath, 0777); err != nil {
-		return nil, err
+	if !readOnly {
+		if err = os.MkdirAll(s.regionPath, 0777); err != nil {
+			return nil, err
+		}
 	}
 
 	return
 }
 
+func (s *chunkStoreBeta) openRegionFile(filePath string) (rf *regionFile, err os.Error) {
+	if s.readOnly {
+		return newRegionFileReadOnly(filePath)
+	}
+	return newRegionFile(filePath)
+}
+
 func (s *chunkStoreBeta) regionFile(chunkLoc ChunkXz) (rf *regionFile, err os.Error) {
 	regionLoc := regionLocForChunkXz(chunkLoc)
 
-	rf, ok := s.regionFiles[regionLoc.regionKey()]
-	if ok {
-		return rf, nil
+	if element, ok := s.regionFiles[regionLoc.regionKey()]; ok {
+		expVarRegionFileCacheHitCount.Add(1)
+		s.recentRegionFiles.MoveToFront(element)
+		return element.Value.(*regionFile), nil
 	}
+	expVarRegionFileCacheMissCount.Add(1)
 
-	// TODO limit number of regionFile objs to a maximum number of
-	// most-frequently-used regions. Close regionFile objects when no
-	// longer needed.
 	filePath := regionLoc.regionFilePath(s.regionPath)
-	rf, err = newRegionFile(filePath)
+	rf, err = s.openRegionFile(filePath)
 	if err != nil {
 		if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
 			err = NoSuchChunkError(false)
 		}
 		return
 	}
-	s.regionFiles[regionLoc.regionKey()] = rf
+	rf.loc = regionLoc
+
+	for s.recentRegionFiles.Len() >= s.maxRegionFiles {
+		s.closeRegionFile(s.recentRegionFiles.Back())
+		expVarRegionFileCacheEvictionCount.Add(1)
+	}
+	s.regionFiles[regionLoc.regionKey()] = s.recentRegionFiles.PushFront(rf)
 
 	return rf, nil
 }
 
+// closeRegionFile closes the region file and removes it from the open region
+// files.
+func (s *chunkStoreBeta) closeRegionFile(element *list.Element) {
+	rf := element.Value.(*regionFile)
+	s.recentRegionFiles.Remove(element)
+	s.regionFiles[rf.loc.regionKey()] = nil, false
+	rf.Close()
+}
+
 func (s *chunkStoreBeta) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Error) {
 	rf, err := s.regionFile(chunkLoc)
 	if err != nil {
@@ -84,7 +151,7 @@ func (s *chunkStoreBeta) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err o
 }
 
 func (s *chunkStoreBeta) SupportsWrite() bool {
-	return true
+	return !s.readOnly
 }
 
 func (s *chunkStoreBeta) Writer() IChunkWriter {
@@ -92,6 +159,10 @@ func (s *chunkStoreBeta) Writer() IChunkWriter {
 }
 
 func (s *chunkStoreBeta) WriteChunk(writer IChunkWriter) os.Error {
+	if s.readOnly {
+		return errReadOnlyStore
+	}
+
 	nbtWriter, ok := writer.(*nbtChunkWriter)
 	if !ok {
 		return fmt.Errorf("%T is incorrect IChunkWriter implementation for %T", writer, s)
@@ -104,3 +175,52 @@ func (s *chunkStoreBeta) WriteChunk(writer IChunkWriter) os.Error {
 
 	return rf.WriteChunkData(nbtWriter)
 }
+
+// chunkLocs returns the location of every chunk in the region files.
+func (s *chunkStoreBeta) chunkLocs() (chunkLocs []ChunkXz, err os.Error) {
+	names, err := readDirNames(s.regionPath)
+	if err != nil {
+		if errno, ok := util.Errno(err); ok && errno == os.ENOENT && s.readOnly {
+			// A read-only store does not create the region directory.
+			err = nil
+		}
+		return
+	}
+
+	for _, name := range names {
+		var regionX, regionZ int32
+		if n, _ := fmt.Sscanf(name, "r.%d.%d.mcr", &regionX, &regionZ); n != 2 {
+			continue
+		}
+		loc := regionLoc{regionCoord(regionX), regionCoord(regionZ)}
+		if loc.regionFilePath(s.regionPath) != path.Join(s.regionPath, name) {
+			continue
+		}
+
+		rf, err := s.openRegionFile(loc.regionFilePath(s.regionPath))
+		if err != nil {
+			return nil, err
+		}
+		for z := 0; z < regionFileEdge; z++ {
+			for x := 0; x < regionFileEdge; x++ {
+				chunkLoc := ChunkXz{
+					ChunkCoord(int(loc.X)<<regionFileEdgeShift + x),
+					ChunkCoord(int(loc.Z)<<regionFileEdgeShift + z),
+				}
+				if rf.offsets.Offset(chunkLoc).IsPresent() {
+					chunkLocs = append(chunkLocs, chunkLoc)
+				}
+			}
+		}
+		rf.Close()
+	}
+
+	return
+}
+
+// Close closes all of the open region files.
+func (s *chunkStoreBeta) Close() {
+	for s.recentRegionFiles.Len() > 0 {
+		s.closeRegionFile(s.recentRegionFiles.Front())
+	}
+}
@@ -7,25 +7,48 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path"
+	"time"
 
 	. "chunkymonkey/types"
 	"nbt"
 )
 
-// TODO Handle timestamps of chunks.
-// TODO Keep track of used/unused sectors for more efficient packing.
-
 // Handle on a chunk file - used to read chunk data from the file.
 type regionFile struct {
-	offsets   regionFileHeader
-	endSector uint32 // The index of the sector just beyond the last used sector.
-	file      *os.File
+	loc         regionLoc
+	filePath    string
+	offsets     regionFileHeader
+	timestamps  regionFileTimestamps
+	usedSectors sectorBitmap
+	journal     regionJournal
+	file        *os.File
+
+	// readOnly is true if the region file must not be changed. Its journal is
+	// then applied to the header in memory only, and chunks that cannot be
+	// read are left in place.
+	readOnly bool
 }
 
 func newRegionFile(filePath string) (rf *regionFile, err os.Error) {
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0666)
+	return openRegionFile(filePath, false)
+}
+
+// newRegionFileReadOnly opens an existing region file without ever writing to
+// it, such as one in a backup of a world.
+func newRegionFileReadOnly(filePath string) (rf *regionFile, err os.Error) {
+	return openRegionFile(filePath, true)
+}
+
+func openRegionFile(filePath string, readOnly bool) (rf *regionFile, err os.Error) {
+	var file *os.File
+	if readOnly {
+		file, err = os.Open(filePath)
+	} else {
+		file, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0666)
+	}
 	if err != nil {
 		if sysErr, ok := err.(*os.SyscallError); ok && sysErr.Errno == os.ENOENT {
 			err = NoSuchChunkError(false)
@@ -39,44 +62,70 @@ func newRegionFile(filePath string) (rf *regionFile, err os.Error) {
 	}
 
 	rf = &regionFile{
-		file: file,
+		filePath: filePath,
+		journal:  regionJournal{filePath: filePath + regionJournalSuffix},
+		file:     file,
+		readOnly: readOnly,
 	}
 
 	if fi.Size == 0 {
-		// Newly created region file. Create new header index if so.
-		if err = rf.offsets.Write(rf.file); err != nil {
-			return
+		// Newly created region file. Create new header index if so. An empty
+		// read-only region file simply holds no chunks.
+		if !readOnly {
+			if err = rf.offsets.Write(rf.file); err != nil {
+				return
+			}
+			if err = rf.timestamps.Write(rf.file); err != nil {
+				return
+			}
 		}
-
-		rf.endSector = 2
 	} else {
 		// Existing region file, read header index.
 		if err = rf.offsets.Read(rf.file); err != nil {
 			return
 		}
-
-		// Find the index of the sector at the end of the file.
-		for i := range rf.offsets {
-			sectorCount, sectorIndex := rf.offsets[i].Get()
-			lastUsedSector := sectorIndex + sectorCount
-			if lastUsedSector >= rf.endSector {
-				rf.endSector = lastUsedSector + 1
-			}
+		if err = rf.timestamps.Read(rf.file); err != nil {
+			return
 		}
+	}
 
-		if rf.endSector < 2 {
-			rf.endSector = 2
-		}
+	// Complete any header updates that were interrupted by a crash.
+	if err = rf.replayJournal(); err != nil {
+		return
 	}
 
+	rf.findUsedSectors()
+
 	return
 }
 
+// findUsedSectors marks the header sectors and the sectors of every chunk in
+// the offset table as used.
+func (rf *regionFile) findUsedSectors() {
+	rf.usedSectors = nil
+	rf.usedSectors.Set(0, regionFileHeaderSectors, true)
+
+	for i := range rf.offsets {
+		if sectorCount, sectorIndex, ok := rf.offsets[i].sectors(); ok {
+			rf.usedSectors.Set(sectorIndex, sectorCount, true)
+		}
+	}
+}
+
 func (rf *regionFile) Close() {
+	if rf.readOnly {
+		rf.file.Close()
+		return
+	}
+	if err := rf.checkpoint(); err != nil {
+		log.Printf("%s: could not checkpoint journal: %v", rf.filePath, err)
+	}
 	rf.file.Close()
 }
 
-func (rf *regionFile) ReadChunkData(chunkLoc ChunkXz) (r *nbtChunkReader, err os.Error) {
+// readChunkBytes returns the chunk data header and compressed data of the
+// chunk.
+func (rf *regionFile) readChunkBytes(chunkLoc ChunkXz) (chunkData []byte, err os.Error) {
 	offset := rf.offsets.Offset(chunkLoc)
 
 	if !offset.IsPresent() {
@@ -85,71 +134,207 @@ func (rf *regionFile) ReadChunkData(chunkLoc ChunkXz) (r *nbtChunkReader, err os
 		return
 	}
 
-	sectorCount, sectorIndex := offset.Get()
-
-	if sectorIndex == 0 || sectorCount == 0 {
-		err = os.NewError("Header gave bad chunk offset.")
+	sectorCount, sectorIndex, ok := offset.sectors()
+	if !ok {
+		err = corruptChunkError{os.NewError("Header gave bad chunk offset.")}
 		return
 	}
 
-	rf.file.Seek(int64(sectorIndex)*regionFileSectorSize, 0)
+	// The last chunk in the file might not fill its last sector.
+	chunkData = make([]byte, sectorCount*regionFileSectorSize)
+	n, err := rf.file.ReadAt(chunkData, int64(sectorIndex)*regionFileSectorSize)
+	if err == os.EOF && n >= chunkDataHeaderSize {
+		err = nil
+	} else if err == os.EOF {
+		return nil, corruptChunkError{os.NewError("Chunk is past the end of the file.")}
+	} else if err != nil {
+		return nil, err
+	}
+	chunkData = chunkData[:n]
 
 	maxChunkDataSize := (sectorCount * regionFileSectorSize) - chunkDataHeaderSize
 
 	var header chunkDataHeader
-	binary.Read(rf.file, binary.BigEndian, &header)
+	binary.Read(bytes.NewBuffer(chunkData), binary.BigEndian, &header)
 	if header.DataSize > maxChunkDataSize {
-		err = os.NewError("Chunk is too big for the sectors it is within.")
+		err = corruptChunkError{os.NewError("Chunk is too big for the sectors it is within.")}
+		return nil, err
+	}
+
+	// DataSize does not count the 4 bytes that hold it.
+	if end := int(header.DataSize) + 4; end < len(chunkData) {
+		chunkData = chunkData[:end]
+	}
+
+	return
+}
+
+// ReadChunkData reads the chunk. A chunk that is corrupt is moved to the
+// quarantine file, and NoSuchChunkError is returned so that the chunk is
+// generated again, unless the region file is read-only. Errors in reading the
+// file, which might not happen again, are returned unchanged.
+func (rf *regionFile) ReadChunkData(chunkLoc ChunkXz) (r *nbtChunkReader, err os.Error) {
+	chunkData, err := rf.readChunkBytes(chunkLoc)
+	if err == nil {
+		if r, err = parseChunkData(chunkData); err == nil {
+			err = r.validate(chunkLoc)
+		}
+		if err != nil {
+			err = corruptChunkError{err}
+		}
+	}
+
+	if err != nil {
+		if _, ok := err.(corruptChunkError); ok && !rf.readOnly {
+			return nil, rf.quarantineChunk(chunkLoc, err)
+		}
+		return nil, err
+	}
+
+	r.timestamp = int64(rf.timestamps.Timestamp(chunkLoc))
+
+	return
+}
+
+// parseChunkData decompresses and parses chunk data read by readChunkBytes.
+func parseChunkData(chunkData []byte) (r *nbtChunkReader, err os.Error) {
+	buffer := bytes.NewBuffer(chunkData)
+
+	var header chunkDataHeader
+	if err = binary.Read(buffer, binary.BigEndian, &header); err != nil {
 		return
 	}
 
-	dataReader, err := header.DataReader(rf.file)
+	dataReader, err := header.DataReader(buffer)
 	if err != nil {
 		return
 	}
 	defer dataReader.Close()
 
-	r, err = newNbtChunkReader(dataReader)
-
-	return
+	return newNbtChunkReader(dataReader)
 }
 
 func (rf *regionFile) WriteChunkData(w *nbtChunkWriter) (err os.Error) {
+	if rf.readOnly {
+		return errReadOnlyStore
+	}
+
 	chunkData, err := serializeChunkData(w)
 	if err != nil {
 		return
 	}
 
 	requiredSize := uint32(len(chunkData))
+	sectorCount := requiredSize / regionFileSectorSize
+	if requiredSize%regionFileSectorSize != 0 {
+		sectorCount++
+	}
+	if sectorCount > regionFileMaxChunkSectors {
+		return fmt.Errorf("Chunk %v is too big to store (%d bytes)", w.ChunkLoc(), requiredSize)
+	}
+
+	// The chunk is always written to free sectors, so that its old data is
+	// still intact if writing is interrupted. Its old sectors are only freed
+	// once the header points at the new ones.
+	sectorIndex := rf.usedSectors.FirstFit(sectorCount)
+	if _, err = rf.file.WriteAt(chunkData, int64(sectorIndex)*regionFileSectorSize); err != nil {
+		return
+	}
+	rf.usedSectors.Set(sectorIndex, sectorCount, true)
 
-	offset := rf.offsets.Offset(w.ChunkLoc())
-	sectorCount, sectorIndex := offset.Get()
+	var offset chunkOffset
+	offset.Set(sectorCount, sectorIndex)
 
-	if offset.IsPresent() && requiredSize <= sectorCount*regionFileSectorSize {
-		// Chunk already exists in the region file and the data will fit in its
-		// present location.
-		if _, err = rf.file.WriteAt(chunkData, int64(sectorIndex)*regionFileSectorSize); err != nil {
-			return
-		}
-	} else {
-		// Chunk doesn't yet exist in the region file or won't fit in its present
-		// location. Write it at the end of the file.
-		sectorIndex = rf.endSector
+	return rf.setChunkOffset(w.ChunkLoc(), offset)
+}
 
-		if _, err = rf.file.WriteAt(chunkData, int64(sectorIndex)*regionFileSectorSize); err != nil {
-			return
+// setChunkOffset points the header at new sectors for the chunk, or at none
+// if offset is zero, and frees the chunk's old sectors. The new sectors must
+// already hold the chunk data.
+func (rf *regionFile) setChunkOffset(chunkLoc ChunkXz, offset chunkOffset) (err os.Error) {
+	var timestamp uint32
+	if offset.IsPresent() {
+		timestamp = uint32(time.Seconds())
+	}
+
+	if err = rf.commit(chunkLoc, offset, timestamp); err != nil {
+		return
+	}
+
+	oldOffset := rf.offsets.Offset(chunkLoc)
+	if err = rf.offsets.SetOffset(chunkLoc, offset, rf.file); err != nil {
+		return
+	}
+	if err = rf.timestamps.SetTimestamp(chunkLoc, timestamp, rf.file); err != nil {
+		return
+	}
+
+	if oldSectorCount, oldSectorIndex, ok := oldOffset.sectors(); ok {
+		rf.usedSectors.Set(oldSectorIndex, oldSectorCount, false)
+	}
+
+	return
+}
+
+// sectorBitmap records which sectors of a region file are in use, with one bit
+// per sector.
+type sectorBitmap []uint32
+
+func (b sectorBitmap) IsUsed(sector uint32) bool {
+	word := sector >> 5
+	return word < uint32(len(b)) && b[word]&(1<<(sector&31)) != 0
+}
+
+// Set marks count sectors, starting at sectorIndex, as used or free.
+func (b *sectorBitmap) Set(sectorIndex, sectorCount uint32, used bool) {
+	end := sectorIndex + sectorCount
+
+	if used {
+		for words := int((end + 31) >> 5); len(*b) < words; {
+			*b = append(*b, 0)
 		}
+	}
 
-		sectorCount = requiredSize / regionFileSectorSize
-		if requiredSize%regionFileSectorSize != 0 {
-			sectorCount++
+	for sector := sectorIndex; sector < end; sector++ {
+		word, bit := sector>>5, uint32(1)<<(sector&31)
+		if word >= uint32(len(*b)) {
+			break
 		}
-		rf.endSector += sectorCount
+		if used {
+			(*b)[word] |= bit
+		} else {
+			(*b)[word] &^= bit
+		}
+	}
+}
 
-		offset.Set(sectorCount, sectorIndex)
-		rf.offsets.SetOffset(w.ChunkLoc(), offset, rf.file)
+// End returns the index of the sector just beyond the last used sector.
+func (b sectorBitmap) End() uint32 {
+	for word := len(b) - 1; word >= 0; word-- {
+		if b[word] == 0 {
+			continue
+		}
+		for bit := uint32(31); ; bit-- {
+			if b[word]&(1<<bit) != 0 {
+				return uint32(word)<<5 + bit + 1
+			}
+		}
 	}
+	return 0
+}
 
+// FirstFit returns the index of the first run of sectorCount free sectors.
+// If there is no large enough gap between used sectors, this is End().
+func (b sectorBitmap) FirstFit(sectorCount uint32) (sectorIndex uint32) {
+	end := b.End()
+	run := uint32(0)
+	for sector := uint32(0); sector < end && run < sectorCount; sector++ {
+		if b.IsUsed(sector) {
+			sectorIndex, run = sector+1, 0
+		} else {
+			run++
+		}
+	}
 	return
 }
 
@@ -199,6 +384,14 @@ func (o chunkOffset) Get() (sectorCount, sectorIndex uint32) {
 	return
 }
 
+// sectors returns the sectors that the chunk is within. ok is false if the
+// chunk is not present, or the offset is invalid.
+func (o chunkOffset) sectors() (sectorCount, sectorIndex uint32, ok bool) {
+	sectorCount, sectorIndex = o.Get()
+	ok = sectorCount != 0 && sectorIndex >= regionFileHeaderSectors
+	return
+}
+
 func (o *chunkOffset) Set(sectorCount, sectorIndex uint32) {
 	*o = chunkOffset(sectorIndex<<8 | sectorCount&0xff)
 }
@@ -238,6 +431,48 @@ func (h *regionFileHeader) SetOffset(chunkLoc ChunkXz, offset chunkOffset, file
 	return err
 }
 
+// Holds the time that each chunk was last written, in seconds since the Unix
+// epoch. The timestamps follow the chunk offsets in the region file header.
+type regionFileTimestamps [regionFileEdge * regionFileEdge]uint32
+
+func (ts *regionFileTimestamps) Read(file *os.File) (err os.Error) {
+	if _, err = file.Seek(regionFileSectorSize, os.SEEK_SET); err != nil {
+		return
+	}
+	err = binary.Read(file, binary.BigEndian, ts[:])
+	if err == os.EOF || err == io.ErrUnexpectedEOF {
+		// Region files written by older versions of chunkymonkey might not
+		// have timestamps.
+		*ts = regionFileTimestamps{}
+		err = nil
+	}
+	return
+}
+
+func (ts *regionFileTimestamps) Write(file *os.File) (err os.Error) {
+	if _, err = file.Seek(regionFileSectorSize, os.SEEK_SET); err != nil {
+		return
+	}
+	return binary.Write(file, binary.BigEndian, ts[:])
+}
+
+// Returns the timestamp for the given chunk, or 0 if it is not known.
+func (ts *regionFileTimestamps) Timestamp(chunkLoc ChunkXz) uint32 {
+	return ts[indexForChunkLoc(chunkLoc)]
+}
+
+func (ts *regionFileTimestamps) SetTimestamp(chunkLoc ChunkXz, timestamp uint32, file *os.File) os.Error {
+	index := indexForChunkLoc(chunkLoc)
+	ts[index] = timestamp
+
+	// Write that part of the timestamps.
+	var timestampBytes [4]byte
+	binary.BigEndian.PutUint32(timestampBytes[:], timestamp)
+	_, err := file.WriteAt(timestampBytes[:], regionFileSectorSize+int64(index)*4)
+
+	return err
+}
+
 func indexForChunkLoc(chunkLoc ChunkXz) int {
 	x := chunkLoc.X & (regionFileEdge - 1)
 	z := chunkLoc.Z & (regionFileEdge - 1)
@@ -289,3 +524,11 @@ func (loc *regionLoc) regionFilePath(regionPath string) string {
 		fmt.Sprintf("r.%d.%d.mcr", loc.X, loc.Z),
 	)
 }
+
+// anvilRegionFilePath returns the path of the region file in the Anvil format.
+func (loc *regionLoc) anvilRegionFilePath(regionPath string) string {
+	return path.Join(
+		regionPath,
+		fmt.Sprintf("r.%d.%d.mca", loc.X, loc.Z),
+	)
+}
@@ -0,0 +1,109 @@
+package chunkstore
+
+import (
+	"fmt"
+	"os"
+
+	. "chunkymonkey/types"
+)
+
+// CompactRegionFile rewrites a region file with its chunks packed together,
+// leaving no free sectors between them. Every chunk is checked to decompress
+// and parse before the file is replaced. If any chunk cannot be read, the
+// file is left unchanged and an error is returned.
+//
+// The region file must not be in use by a running server.
+func CompactRegionFile(filePath string) (oldSize, newSize int64, err os.Error) {
+	rf, err := newRegionFile(filePath)
+	if err != nil {
+		return
+	}
+	defer rf.Close()
+
+	fi, err := rf.file.Stat()
+	if err != nil {
+		return
+	}
+	oldSize = fi.Size
+
+	compactPath := filePath + ".compact"
+	file, err := os.OpenFile(compactPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(compactPath)
+		}
+	}()
+
+	compacted := &regionFile{
+		file: file,
+	}
+	compacted.findUsedSectors()
+
+	for z := 0; z < regionFileEdge; z++ {
+		for x := 0; x < regionFileEdge; x++ {
+			chunkLoc := ChunkXz{ChunkCoord(x), ChunkCoord(z)}
+			if !rf.offsets.Offset(chunkLoc).IsPresent() {
+				continue
+			}
+
+			var chunkData []byte
+			if chunkData, err = rf.readChunkBytes(chunkLoc); err == nil {
+				_, err = parseChunkData(chunkData)
+			}
+			if err != nil {
+				err = fmt.Errorf("Chunk %d,%d in region file: %v", x, z, err)
+				return
+			}
+
+			timestamp := rf.timestamps.Timestamp(chunkLoc)
+			if err = compacted.appendChunkBytes(chunkLoc, chunkData, timestamp); err != nil {
+				return
+			}
+		}
+	}
+
+	if err = compacted.offsets.Write(file); err != nil {
+		return
+	}
+	if err = compacted.timestamps.Write(file); err != nil {
+		return
+	}
+
+	// Pad the file to a whole number of sectors.
+	newSize = int64(compacted.usedSectors.End()) * regionFileSectorSize
+	if err = file.Truncate(newSize); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	err = os.Rename(compactPath, filePath)
+
+	return
+}
+
+// appendChunkBytes writes chunk data read by readChunkBytes after the last
+// used sector, and records its offset and timestamp in the in-memory header.
+func (rf *regionFile) appendChunkBytes(chunkLoc ChunkXz, chunkData []byte, timestamp uint32) (err os.Error) {
+	sectorCount := (uint32(len(chunkData)) + regionFileSectorSize - 1) / regionFileSectorSize
+	sectorIndex := rf.usedSectors.End()
+
+	if _, err = rf.file.WriteAt(chunkData, int64(sectorIndex)*regionFileSectorSize); err != nil {
+		return
+	}
+	rf.usedSectors.Set(sectorIndex, sectorCount, true)
+
+	index := indexForChunkLoc(chunkLoc)
+	rf.offsets[index].Set(sectorCount, sectorIndex)
+	rf.timestamps[index] = timestamp
+
+	return
+}
@@ -0,0 +1,307 @@
+package chunkstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+	"nbt"
+)
+
+const (
+	regionJournalSuffix    = ".journal"
+	regionQuarantineSuffix = ".quarantine"
+
+	// The number of header changes recorded in a journal before the region
+	// file is synced and the journal emptied.
+	regionJournalCheckpointRecords = 256
+)
+
+// A change to a region file header, recorded in the journal before the header
+// is written.
+type regionJournalRecord struct {
+	Index     uint32
+	Offset    uint32
+	Timestamp uint32
+	Checksum  uint32
+}
+
+func (rec *regionJournalRecord) checksum() uint32 {
+	var buf [12]byte
+	binary.BigEndian.PutUint32(buf[0:4], rec.Index)
+	binary.BigEndian.PutUint32(buf[4:8], rec.Offset)
+	binary.BigEndian.PutUint32(buf[8:12], rec.Timestamp)
+	return crc32.ChecksumIEEE(buf[:])
+}
+
+// regionJournal is the write-ahead journal of header changes to a region
+// file. The header is only written in place after the change has been synced
+// to the journal, so a header that is left half written by a crash can be
+// repaired by replaying the journal when the region file is next opened.
+type regionJournal struct {
+	filePath string
+	file     *os.File
+	records  int
+}
+
+func (j *regionJournal) Append(rec regionJournalRecord) (err os.Error) {
+	if j.file == nil {
+		j.file, err = os.OpenFile(j.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			j.file = nil
+			return
+		}
+	}
+
+	rec.Checksum = rec.checksum()
+	if err = binary.Write(j.file, binary.BigEndian, &rec); err != nil {
+		return
+	}
+	if err = j.file.Sync(); err != nil {
+		return
+	}
+	j.records++
+
+	return
+}
+
+// Remove deletes the journal. The region file header must have been synced
+// first.
+func (j *regionJournal) Remove() (err os.Error) {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	j.records = 0
+
+	err = os.Remove(j.filePath)
+	if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
+		err = nil
+	}
+	return
+}
+
+// readJournal reads the complete records in the journal. A record that was
+// only partly written when the server stopped, and anything after it, is
+// ignored.
+func readJournal(filePath string) (records []regionJournalRecord, err os.Error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errno, ok := util.Errno(err); ok && errno == os.ENOENT {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+
+	for {
+		var rec regionJournalRecord
+		if err = binary.Read(file, binary.BigEndian, &rec); err != nil {
+			if err == os.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			return
+		}
+		if rec.Checksum != rec.checksum() {
+			return
+		}
+		records = append(records, rec)
+	}
+
+	return
+}
+
+// replayJournal applies any header changes left in the journal, and removes
+// it. The changes are only applied in memory if the region file is read-only.
+func (rf *regionFile) replayJournal() (err os.Error) {
+	records, err := readJournal(rf.journal.filePath)
+	if err != nil || len(records) == 0 {
+		return
+	}
+
+	if rf.readOnly {
+		for _, rec := range records {
+			index := int(rec.Index) & (regionFileEdge*regionFileEdge - 1)
+			rf.offsets[index] = chunkOffset(rec.Offset)
+			rf.timestamps[index] = rec.Timestamp
+		}
+		return
+	}
+
+	log.Printf("%s: replaying %d journal records", rf.filePath, len(records))
+	for _, rec := range records {
+		index := int(rec.Index) & (regionFileEdge*regionFileEdge - 1)
+		chunkLoc := ChunkXz{
+			X: ChunkCoord(index & (regionFileEdge - 1)),
+			Z: ChunkCoord(index >> regionFileEdgeShift),
+		}
+		if err = rf.offsets.SetOffset(chunkLoc, chunkOffset(rec.Offset), rf.file); err != nil {
+			return
+		}
+		if err = rf.timestamps.SetTimestamp(chunkLoc, rec.Timestamp, rf.file); err != nil {
+			return
+		}
+	}
+
+	return rf.checkpoint()
+}
+
+// commit records a header change in the journal. The chunk data that the new
+// offset points at is synced first, so that the journal never refers to data
+// that is not on disk.
+func (rf *regionFile) commit(chunkLoc ChunkXz, offset chunkOffset, timestamp uint32) (err os.Error) {
+	if rf.journal.records >= regionJournalCheckpointRecords {
+		if err = rf.checkpoint(); err != nil {
+			return
+		}
+	}
+
+	if err = rf.file.Sync(); err != nil {
+		return
+	}
+
+	return rf.journal.Append(regionJournalRecord{
+		Index:     uint32(indexForChunkLoc(chunkLoc)),
+		Offset:    uint32(offset),
+		Timestamp: timestamp,
+	})
+}
+
+// checkpoint syncs the region file, after which the journal is no longer
+// needed.
+func (rf *regionFile) checkpoint() (err os.Error) {
+	if rf.journal.file == nil && rf.journal.records == 0 {
+		if _, err = os.Stat(rf.journal.filePath); err != nil {
+			// No journal to remove.
+			return nil
+		}
+	}
+
+	if err = rf.file.Sync(); err != nil {
+		return
+	}
+	return rf.journal.Remove()
+}
+
+// corruptChunkError is returned when a chunk was read from a region file but
+// is not valid chunk data, which no later read of it will change.
+type corruptChunkError struct {
+	err os.Error
+}
+
+func (err corruptChunkError) String() string {
+	return err.err.String()
+}
+
+// quarantineChunk is called when a chunk is corrupt. It appends the
+// chunk's sectors to the region's quarantine file for later inspection, and
+// removes the chunk from the region file so that it is generated again. It
+// returns NoSuchChunkError if the chunk was removed, or readErr otherwise.
+func (rf *regionFile) quarantineChunk(chunkLoc ChunkXz, readErr os.Error) os.Error {
+	log.Printf("%s: chunk %d,%d is corrupt: %v", rf.filePath, chunkLoc.X, chunkLoc.Z, readErr)
+
+	if err := rf.writeQuarantine(chunkLoc); err != nil {
+		log.Printf("%s: could not quarantine chunk %d,%d: %v", rf.filePath, chunkLoc.X, chunkLoc.Z, err)
+		return readErr
+	}
+
+	if err := rf.setChunkOffset(chunkLoc, 0); err != nil {
+		log.Printf("%s: could not remove chunk %d,%d: %v", rf.filePath, chunkLoc.X, chunkLoc.Z, err)
+		return readErr
+	}
+
+	log.Printf("%s: moved chunk %d,%d to %s%s", rf.filePath, chunkLoc.X, chunkLoc.Z, rf.filePath, regionQuarantineSuffix)
+
+	return NoSuchChunkError(false)
+}
+
+// A quarantine file holds a sequence of these headers, each followed by Size
+// bytes of the raw sectors of a chunk.
+type regionQuarantineHeader struct {
+	X, Z int32
+	Time int64
+	Size uint32
+}
+
+func (rf *regionFile) writeQuarantine(chunkLoc ChunkXz) (err os.Error) {
+	var data []byte
+	if sectorCount, sectorIndex, ok := rf.offsets.Offset(chunkLoc).sectors(); ok {
+		data = make([]byte, sectorCount*regionFileSectorSize)
+		var n int
+		n, err = rf.file.ReadAt(data, int64(sectorIndex)*regionFileSectorSize)
+		if err != nil && err != os.EOF {
+			return
+		}
+		data = data[:n]
+	}
+
+	header := regionQuarantineHeader{
+		X:    int32(chunkLoc.X),
+		Z:    int32(chunkLoc.Z),
+		Time: time.Seconds(),
+		Size: uint32(len(data)),
+	}
+	buffer := new(bytes.Buffer)
+	if err = binary.Write(buffer, binary.BigEndian, &header); err != nil {
+		return
+	}
+	buffer.Write(data)
+
+	file, err := os.OpenFile(rf.filePath+regionQuarantineSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if _, err = file.Write(buffer.Bytes()); err != nil {
+		return
+	}
+	return file.Sync()
+}
+
+// validate checks that the chunk has the structure that the rest of the
+// server expects, so that a damaged chunk is not loaded.
+func (r *nbtChunkReader) validate(chunkLoc ChunkXz) os.Error {
+	if _, ok := r.chunkTag.Lookup("Level").(*nbt.Compound); !ok {
+		return os.NewError("Chunk has no Level compound.")
+	}
+
+	xPos, xOk := r.chunkTag.Lookup("Level/xPos").(*nbt.Int)
+	zPos, zOk := r.chunkTag.Lookup("Level/zPos").(*nbt.Int)
+	if !xOk || !zOk {
+		return os.NewError("Chunk has no position.")
+	}
+	if xPos.Value != int32(chunkLoc.X) || zPos.Value != int32(chunkLoc.Z) {
+		return fmt.Errorf("Chunk has position %d,%d.", xPos.Value, zPos.Value)
+	}
+
+	const blockCount = ChunkSizeH * ChunkSizeH * ChunkSizeY
+	arrays := []struct {
+		name string
+		size int
+	}{
+		{"Blocks", blockCount},
+		{"Data", blockCount / 2},
+		{"SkyLight", blockCount / 2},
+		{"BlockLight", blockCount / 2},
+		{"HeightMap", ChunkSizeH * ChunkSizeH},
+	}
+	for _, array := range arrays {
+		tag, ok := r.chunkTag.Lookup("Level/" + array.name).(*nbt.ByteArray)
+		if !ok {
+			return fmt.Errorf("Chunk has no %s.", array.name)
+		}
+		if len(tag.Value) != array.size {
+			return fmt.Errorf("Chunk %s has %d bytes, expected %d.", array.name, len(tag.Value), array.size)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,465 @@
+package chunkstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"rand"
+	"testing"
+	"time"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func TestSectorBitmap(t *testing.T) {
+	var b sectorBitmap
+
+	if end := b.End(); end != 0 {
+		t.Errorf("empty bitmap End() expected 0 but got %d", end)
+	}
+
+	b.Set(0, 2, true)
+	b.Set(5, 40, true)
+	if end := b.End(); end != 45 {
+		t.Errorf("End() expected 45 but got %d", end)
+	}
+	if b.IsUsed(2) || !b.IsUsed(5) || !b.IsUsed(44) || b.IsUsed(45) || b.IsUsed(1000) {
+		t.Errorf("IsUsed() gave wrong results for bitmap %x", b)
+	}
+
+	type Test struct {
+		sectorCount uint32
+		expected    uint32
+	}
+
+	tests := []Test{
+		{1, 2},
+		{3, 2},
+		{4, 45},
+	}
+
+	for _, test := range tests {
+		if result := b.FirstFit(test.sectorCount); result != test.expected {
+			t.Errorf("FirstFit(%d) expected %d but got %d", test.sectorCount, test.expected, result)
+		}
+	}
+
+	b.Set(10, 35, false)
+	if end := b.End(); end != 10 {
+		t.Errorf("End() after freeing expected 10 but got %d", end)
+	}
+	if result := b.FirstFit(4); result != 10 {
+		t.Errorf("FirstFit(4) after freeing expected 10 but got %d", result)
+	}
+}
+
+// tempRegionFile creates a new region file, which the caller must close and
+// remove.
+func tempRegionFile(t *testing.T) (rf *regionFile, filePath string) {
+	file, err := ioutil.TempFile("", "chunkymonkey-region")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	filePath = file.Name()
+	file.Close()
+
+	if rf, err = newRegionFile(filePath); err != nil {
+		os.Remove(filePath)
+		t.Fatalf("newRegionFile: %v", err)
+	}
+
+	return
+}
+
+// testChunkWriter returns a writer for a chunk whose data compresses to about
+// the given number of sectors.
+func testChunkWriter(chunkLoc ChunkXz, sectors int, seed int64) *nbtChunkWriter {
+	randGen := rand.New(rand.NewSource(seed))
+	blocks := make([]byte, ChunkSizeH*ChunkSizeH*ChunkSizeY)
+	for i := 0; i < sectors*regionFileSectorSize && i < len(blocks); i++ {
+		blocks[i] = byte(randGen.Intn(256))
+	}
+
+	w := newNbtChunkWriter()
+	w.SetChunkLoc(chunkLoc)
+	w.SetBlocks(blocks)
+	w.SetBlockData(make([]byte, len(blocks)/2))
+	w.SetSkyLight(make([]byte, len(blocks)/2))
+	w.SetBlockLight(make([]byte, len(blocks)/2))
+	w.SetHeightMap(make([]byte, ChunkSizeH*ChunkSizeH))
+	return w
+}
+
+func checkChunk(t *testing.T, rf *regionFile, w *nbtChunkWriter) {
+	r, err := rf.ReadChunkData(w.ChunkLoc())
+	if err != nil {
+		t.Fatalf("ReadChunkData(%v): %v", w.ChunkLoc(), err)
+	}
+
+	expected := w.RootTag().Lookup("Level/Blocks").(*nbt.ByteArray).Value
+	if !r.ChunkLoc().Equals(w.ChunkLoc()) || string(r.Blocks()) != string(expected) {
+		t.Errorf("chunk %v read back with wrong data", w.ChunkLoc())
+	}
+}
+
+func TestRegionFile_ReusesFreedSectors(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer func() {
+		rf.Close()
+	}()
+
+	a := testChunkWriter(ChunkXz{0, 0}, 1, 1)
+	b := testChunkWriter(ChunkXz{1, 0}, 1, 2)
+	for _, w := range []*nbtChunkWriter{a, b} {
+		if err := rf.WriteChunkData(w); err != nil {
+			t.Fatalf("WriteChunkData: %v", err)
+		}
+	}
+	end := rf.usedSectors.End()
+
+	// Growing chunk a moves it to the end of the file, leaving a gap.
+	a = testChunkWriter(ChunkXz{0, 0}, 3, 3)
+	if err := rf.WriteChunkData(a); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	if _, sectorIndex := rf.offsets.Offset(a.ChunkLoc()).Get(); sectorIndex != end {
+		t.Errorf("grown chunk expected at sector %d but was at %d", end, sectorIndex)
+	}
+
+	// A new chunk fills the gap.
+	c := testChunkWriter(ChunkXz{0, 1}, 1, 4)
+	if err := rf.WriteChunkData(c); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	if _, sectorIndex := rf.offsets.Offset(c.ChunkLoc()).Get(); sectorIndex != regionFileHeaderSectors {
+		t.Errorf("new chunk expected at sector %d but was at %d", regionFileHeaderSectors, sectorIndex)
+	}
+
+	// The free sectors are found again when the file is reopened.
+	rf.Close()
+	var err os.Error
+	if rf, err = newRegionFile(filePath); err != nil {
+		t.Fatalf("newRegionFile: %v", err)
+	}
+	for _, w := range []*nbtChunkWriter{a, b, c} {
+		checkChunk(t, rf, w)
+		sectorCount, sectorIndex := rf.offsets.Offset(w.ChunkLoc()).Get()
+		for sector := sectorIndex; sector < sectorIndex+sectorCount; sector++ {
+			if !rf.usedSectors.IsUsed(sector) {
+				t.Errorf("sector %d of chunk %v not marked as used", sector, w.ChunkLoc())
+			}
+		}
+	}
+}
+
+func TestRegionFile_Timestamps(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer func() {
+		rf.Close()
+	}()
+
+	w := testChunkWriter(ChunkXz{3, 4}, 1, 0)
+	w.SetLastUpdate(12345)
+
+	before := time.Seconds()
+	if err := rf.WriteChunkData(w); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	after := time.Seconds()
+
+	rf.Close()
+	var err os.Error
+	if rf, err = newRegionFile(filePath); err != nil {
+		t.Fatalf("newRegionFile: %v", err)
+	}
+
+	r, err := rf.ReadChunkData(w.ChunkLoc())
+	if err != nil {
+		t.Fatalf("ReadChunkData: %v", err)
+	}
+	if timestamp := r.Timestamp(); timestamp < before || timestamp > after {
+		t.Errorf("Timestamp() expected between %d and %d but got %d", before, after, timestamp)
+	}
+	if lastUpdate := r.LastUpdate(); lastUpdate != 12345 {
+		t.Errorf("LastUpdate() expected 12345 but got %d", lastUpdate)
+	}
+}
+
+func TestCompactRegionFile(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+
+	var writers []*nbtChunkWriter
+	for i := 0; i < 4; i++ {
+		w := testChunkWriter(ChunkXz{ChunkCoord(i), 0}, 1, int64(i))
+		if err := rf.WriteChunkData(w); err != nil {
+			t.Fatalf("WriteChunkData: %v", err)
+		}
+		writers = append(writers, w)
+	}
+	for i := 0; i < 4; i += 2 {
+		writers[i] = testChunkWriter(ChunkXz{ChunkCoord(i), 0}, 3, int64(i+10))
+		if err := rf.WriteChunkData(writers[i]); err != nil {
+			t.Fatalf("WriteChunkData: %v", err)
+		}
+	}
+	timestamps := rf.timestamps
+	rf.Close()
+
+	oldSize, newSize, err := CompactRegionFile(filePath)
+	if err != nil {
+		t.Fatalf("CompactRegionFile: %v", err)
+	}
+	if newSize >= oldSize {
+		t.Errorf("compacted size %d expected to be less than %d", newSize, oldSize)
+	}
+
+	if rf, err = newRegionFile(filePath); err != nil {
+		t.Fatalf("newRegionFile: %v", err)
+	}
+	defer rf.Close()
+
+	usedSectors := uint32(regionFileHeaderSectors)
+	for _, w := range writers {
+		checkChunk(t, rf, w)
+		sectorCount, _ := rf.offsets.Offset(w.ChunkLoc()).Get()
+		usedSectors += sectorCount
+	}
+	for i := range timestamps {
+		if rf.timestamps[i] != timestamps[i] {
+			t.Errorf("chunk %d timestamp changed by compaction from %d to %d", i, timestamps[i], rf.timestamps[i])
+		}
+	}
+	if end := rf.usedSectors.End(); end != usedSectors || int64(end)*regionFileSectorSize != newSize {
+		t.Errorf("compacted file has %d sectors and %d bytes, but chunks use %d sectors", end, newSize, usedSectors)
+	}
+}
+
+func TestCompactRegionFile_BadChunk(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+
+	w := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	if err := rf.WriteChunkData(w); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	_, sectorIndex := rf.offsets.Offset(w.ChunkLoc()).Get()
+	garbage := []byte{0, 0, 0, 100, chunkCompressionZlib, 1, 2, 3, 4}
+	if _, err := rf.file.WriteAt(garbage, int64(sectorIndex)*regionFileSectorSize); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	rf.Close()
+
+	before, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	if _, _, err = CompactRegionFile(filePath); err == nil {
+		t.Errorf("CompactRegionFile expected to fail on corrupt chunk")
+	}
+
+	after, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("region file changed by failed compaction")
+	}
+	if _, err = os.Stat(filePath + ".compact"); err == nil {
+		os.Remove(filePath + ".compact")
+		t.Errorf("temporary compaction file left behind")
+	}
+}
+
+func TestRegionFile_WritesToFreeSectors(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer func() {
+		rf.Close()
+	}()
+
+	w := testChunkWriter(ChunkXz{0, 0}, 2, 0)
+	if err := rf.WriteChunkData(w); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	_, oldSectorIndex := rf.offsets.Offset(w.ChunkLoc()).Get()
+
+	// A chunk that still fits in its sectors is written elsewhere, so that the
+	// old data is intact until the header is updated.
+	w = testChunkWriter(ChunkXz{0, 0}, 1, 1)
+	if err := rf.WriteChunkData(w); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	if _, sectorIndex := rf.offsets.Offset(w.ChunkLoc()).Get(); sectorIndex == oldSectorIndex {
+		t.Errorf("chunk rewritten over its old sectors at %d", sectorIndex)
+	}
+	if rf.usedSectors.IsUsed(oldSectorIndex) {
+		t.Errorf("old sector %d not freed", oldSectorIndex)
+	}
+	checkChunk(t, rf, w)
+}
+
+func TestRegionFile_ReplaysJournal(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + regionJournalSuffix)
+
+	w := testChunkWriter(ChunkXz{5, 7}, 1, 0)
+	if err := rf.WriteChunkData(w); err != nil {
+		t.Fatalf("WriteChunkData: %v", err)
+	}
+	offset := rf.offsets.Offset(w.ChunkLoc())
+	timestamp := rf.timestamps.Timestamp(w.ChunkLoc())
+	rf.Close()
+
+	if _, err := os.Stat(filePath + regionJournalSuffix); err == nil {
+		t.Errorf("journal left behind after Close")
+	}
+
+	// Simulate a crash after the journal was written but before the header
+	// was, followed by a partly written journal record.
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	var header regionFileHeader
+	var timestamps regionFileTimestamps
+	header.Write(file)
+	timestamps.Write(file)
+	file.Close()
+
+	journal := regionJournal{filePath: filePath + regionJournalSuffix}
+	err = journal.Append(regionJournalRecord{
+		Index:     uint32(indexForChunkLoc(w.ChunkLoc())),
+		Offset:    uint32(offset),
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	journal.file.Write([]byte{0, 0, 0})
+	journal.file.Close()
+
+	if rf, err = newRegionFile(filePath); err != nil {
+		t.Fatalf("newRegionFile: %v", err)
+	}
+	defer rf.Close()
+
+	if rf.offsets.Offset(w.ChunkLoc()) != offset {
+		t.Errorf("offset expected %x after replay but got %x", offset, rf.offsets.Offset(w.ChunkLoc()))
+	}
+	if rf.timestamps.Timestamp(w.ChunkLoc()) != timestamp {
+		t.Errorf("timestamp expected %d after replay but got %d", timestamp, rf.timestamps.Timestamp(w.ChunkLoc()))
+	}
+	checkChunk(t, rf, w)
+	if _, err = os.Stat(filePath + regionJournalSuffix); err == nil {
+		t.Errorf("journal not removed after replay")
+	}
+}
+
+func TestRegionFile_QuarantinesCorruptChunk(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + regionQuarantineSuffix)
+	defer func() {
+		rf.Close()
+	}()
+
+	good := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	bad := testChunkWriter(ChunkXz{1, 0}, 1, 1)
+	for _, w := range []*nbtChunkWriter{good, bad} {
+		if err := rf.WriteChunkData(w); err != nil {
+			t.Fatalf("WriteChunkData: %v", err)
+		}
+	}
+
+	_, sectorIndex := rf.offsets.Offset(bad.ChunkLoc()).Get()
+	garbage := []byte{0, 0, 0, 100, chunkCompressionZlib, 1, 2, 3, 4}
+	if _, err := rf.file.WriteAt(garbage, int64(sectorIndex)*regionFileSectorSize); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	if _, err := rf.ReadChunkData(bad.ChunkLoc()); err == nil {
+		t.Fatalf("ReadChunkData expected to fail on corrupt chunk")
+	} else if _, ok := err.(NoSuchChunkError); !ok {
+		t.Errorf("ReadChunkData expected NoSuchChunkError but got %v", err)
+	}
+	if rf.offsets.Offset(bad.ChunkLoc()).IsPresent() {
+		t.Errorf("corrupt chunk still present in header")
+	}
+	if rf.usedSectors.IsUsed(sectorIndex) {
+		t.Errorf("sector %d of corrupt chunk not freed", sectorIndex)
+	}
+	checkChunk(t, rf, good)
+
+	quarantine, err := ioutil.ReadFile(filePath + regionQuarantineSuffix)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var header regionQuarantineHeader
+	buffer := bytes.NewBuffer(quarantine)
+	if err = binary.Read(buffer, binary.BigEndian, &header); err != nil {
+		t.Fatalf("reading quarantine header: %v", err)
+	}
+	if header.X != 1 || header.Z != 0 || int(header.Size) != buffer.Len() {
+		t.Errorf("quarantine header %+v does not match chunk 1,0 with %d bytes", header, buffer.Len())
+	}
+	if !bytes.HasPrefix(buffer.Bytes(), garbage) {
+		t.Errorf("quarantine file does not hold the corrupt chunk data")
+	}
+}
+
+func TestRegionFile_ReadOnly(t *testing.T) {
+	rf, filePath := tempRegionFile(t)
+	defer os.Remove(filePath)
+	defer os.Remove(filePath + regionQuarantineSuffix)
+
+	good := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	bad := testChunkWriter(ChunkXz{1, 0}, 1, 1)
+	for _, w := range []*nbtChunkWriter{good, bad} {
+		if err := rf.WriteChunkData(w); err != nil {
+			t.Fatalf("WriteChunkData: %v", err)
+		}
+	}
+	_, sectorIndex := rf.offsets.Offset(bad.ChunkLoc()).Get()
+	garbage := []byte{0, 0, 0, 100, chunkCompressionZlib, 1, 2, 3, 4}
+	if _, err := rf.file.WriteAt(garbage, int64(sectorIndex)*regionFileSectorSize); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	rf.Close()
+
+	before, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	if rf, err = newRegionFileReadOnly(filePath); err != nil {
+		t.Fatalf("newRegionFileReadOnly: %v", err)
+	}
+	checkChunk(t, rf, good)
+	if _, err = rf.ReadChunkData(bad.ChunkLoc()); err == nil {
+		t.Errorf("ReadChunkData expected to fail on corrupt chunk")
+	} else if _, ok := err.(corruptChunkError); !ok {
+		t.Errorf("ReadChunkData expected corruptChunkError but got %v", err)
+	}
+	if err = rf.WriteChunkData(testChunkWriter(ChunkXz{2, 0}, 1, 2)); err != errReadOnlyStore {
+		t.Errorf("WriteChunkData expected errReadOnlyStore but got %v", err)
+	}
+	rf.Close()
+
+	after, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("read-only region file was changed")
+	}
+	if _, err = os.Stat(filePath + regionQuarantineSuffix); err == nil {
+		t.Errorf("read-only region file quarantined a chunk")
+	}
+}
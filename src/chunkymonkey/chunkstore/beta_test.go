@@ -1,6 +1,8 @@
 package chunkstore
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	. "chunkymonkey/types"
@@ -64,3 +66,57 @@ func TestChunkOffset(t *testing.T) {
 		}
 	}
 }
+
+func TestChunkStoreBeta_RegionFileCache(t *testing.T) {
+	file, err := ioutil.TempFile("", "chunkymonkey-world")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	worldPath := file.Name()
+	file.Close()
+	os.Remove(worldPath)
+	defer os.RemoveAll(worldPath)
+
+	s, err := newChunkStoreBeta(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	s.maxRegionFiles = 2
+
+	evictions := expVarRegionFileCacheEvictionCount.String()
+
+	// Each chunk is in a different region.
+	chunkLocs := []ChunkXz{{0, 0}, {32, 0}, {0, 32}}
+	for _, chunkLoc := range chunkLocs {
+		if err := s.WriteChunk(testChunkWriter(chunkLoc, 0, 0)); err != nil {
+			t.Fatalf("WriteChunk(%v): %v", chunkLoc, err)
+		}
+	}
+
+	if len(s.regionFiles) != 2 || s.recentRegionFiles.Len() != 2 {
+		t.Errorf("expected 2 open region files but got %d", len(s.regionFiles))
+	}
+	first := regionLocForChunkXz(chunkLocs[0])
+	if _, ok := s.regionFiles[first.regionKey()]; ok {
+		t.Errorf("least recently used region file was not closed")
+	}
+	if evictions == expVarRegionFileCacheEvictionCount.String() {
+		t.Errorf("eviction count not updated")
+	}
+
+	// Chunks in closed region files are read by opening them again.
+	for _, chunkLoc := range chunkLocs {
+		reader, err := s.ReadChunk(chunkLoc)
+		if err != nil {
+			t.Fatalf("ReadChunk(%v): %v", chunkLoc, err)
+		}
+		if !reader.ChunkLoc().Equals(chunkLoc) {
+			t.Errorf("ReadChunk(%v) returned chunk %v", chunkLoc, reader.ChunkLoc())
+		}
+	}
+
+	s.Close()
+	if len(s.regionFiles) != 0 || s.recentRegionFiles.Len() != 0 {
+		t.Errorf("region files still open after Close")
+	}
+}
@@ -0,0 +1,692 @@
+package chunkstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	. "chunkymonkey/types"
+	"chunkymonkey/util"
+)
+
+// LogSegmentSize is the size in bytes at which a log chunk store starts
+// writing to a new segment file.
+var LogSegmentSize int64 = 32 << 20
+
+// LogRetention is how long, in seconds, a log chunk store keeps the older
+// versions of chunks after they have been replaced. Older versions can be
+// read with ReadChunkAt until they are removed by compaction.
+var LogRetention int64 = 24 * 60 * 60
+
+// ErrOutsideRetention is returned by ReadChunkAt for times longer ago than
+// LogRetention, as the version of a chunk in place then might have been
+// removed by compaction.
+var ErrOutsideRetention = os.NewError("The time is outside of the chunk log's retention.")
+
+const (
+	logRecordMagic = 0x434d4c52 // "CMLR"
+	// 36 is the size of logRecordHeader in bytes.
+	logRecordHeaderSize = 36
+)
+
+// IChunkHistory is implemented by chunk stores that keep older versions of
+// chunks.
+type IChunkHistory interface {
+	// ReadChunkAt returns the chunk as it was at the given time, in seconds
+	// since the Unix epoch. ErrOutsideRetention is returned if the store no
+	// longer knows what the chunk was at that time.
+	ReadChunkAt(chunkLoc ChunkXz, timestamp int64) (reader IChunkReader, err os.Error)
+}
+
+// Each version of a chunk in a segment file is a logRecordHeader followed by
+// Size bytes of chunk data, in the same form as in region files.
+type logRecordHeader struct {
+	Magic     uint32
+	X, Z      int32
+	Seq       uint64
+	Timestamp int64
+	Size      uint32
+	Checksum  uint32
+}
+
+// logEntry locates a version of a chunk in the segment files.
+type logEntry struct {
+	seq       uint64
+	timestamp int64
+	segment   uint32
+	offset    int64
+	size      uint32
+}
+
+func (e *logEntry) recordSize() int64 {
+	return logRecordHeaderSize + int64(e.size)
+}
+
+// A segment file. Only the active segment is written to; the others are
+// sealed and are only read, or replaced by compaction.
+type logSegment struct {
+	id   uint32
+	file *os.File
+	size int64
+}
+
+// chunkStoreLog stores chunks in append-only segment files. Each write of a
+// chunk appends a new version of it to the active segment, and an index in
+// memory, built by scanning the segments when the store is opened, locates
+// every version. Versions that have been replaced for longer than
+// LogRetention are removed by compacting segments in the background.
+type chunkStoreLog struct {
+	logPath string
+
+	segments      map[uint32]*logSegment
+	active        *logSegment
+	nextSegmentId uint32
+	nextSeq       uint64
+
+	// index holds the versions of each chunk, keyed by ChunkXz.ChunkKey(), in
+	// the order that they were written.
+	index map[uint64][]logEntry
+
+	compacting  bool
+	compactions chan *logCompaction
+
+	// readOnly is true if the store must not change any files. A read-only
+	// store has no active segment, and is never compacted.
+	readOnly bool
+}
+
+// Creates a chunkStoreLog that stores chunks in append-only segment files.
+func newChunkStoreLog(worldPath string, dimension DimensionId) (s *chunkStoreLog, err os.Error) {
+	return openChunkStoreLog(worldPath, dimension, false)
+}
+
+// openChunkStoreLog creates a chunkStoreLog. A read-only store does not create
+// the log directory, and opens its segments read-only.
+func openChunkStoreLog(worldPath string, dimension DimensionId, readOnly bool) (s *chunkStoreLog, err os.Error) {
+	s = &chunkStoreLog{
+		segments:    make(map[uint32]*logSegment),
+		index:       make(map[uint64][]logEntry),
+		compactions: make(chan *logCompaction, 1),
+		readOnly:    readOnly,
+	}
+
+	if dimension == DimensionNormal {
+		s.logPath = path.Join(worldPath, "chunklog")
+	} else {
+		s.logPath = path.Join(worldPath, fmt.Sprintf("DIM%d", dimension), "chunklog")
+	}
+
+	if !readOnly {
+		if err = os.MkdirAll(s.logPath, 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.openSegments(); err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+
+	if !readOnly {
+		s.startCompaction()
+	}
+
+	return
+}
+
+func (s *chunkStoreLog) segmentPath(id uint32) string {
+	return path.Join(s.logPath, fmt.Sprintf("chunks.%d.log", id))
+}
+
+// compactedPath returns the path that a compaction of the segment is written
+// to, before it replaces the segment.
+func compactedPath(segmentPath string) string {
+	return segmentPath + ".compact"
+}
+
+// openSegments opens the segment files and indexes the chunks in them. The
+// segment with the highest ID becomes the active segment, unless the store is
+// read-only. Compaction keeps the ID of the segment that it compacts, so the
+// segment with the highest ID is always the one that was last written to.
+func (s *chunkStoreLog) openSegments() (err os.Error) {
+	names, err := readDirNames(s.logPath)
+	if err != nil {
+		if errno, ok := util.Errno(err); ok && errno == os.ENOENT && s.readOnly {
+			// A read-only store does not create the log directory.
+			err = nil
+		}
+		return
+	}
+
+	var ids []int
+	for _, name := range names {
+		var id uint32
+		if n, _ := fmt.Sscanf(name, "chunks.%d.log", &id); n == 1 {
+			if name == path.Base(s.segmentPath(id)) {
+				ids = append(ids, int(id))
+			} else if name == path.Base(compactedPath(s.segmentPath(id))) && !s.readOnly {
+				// Left by a compaction that was interrupted before it
+				// replaced the segment.
+				os.Remove(compactedPath(s.segmentPath(id)))
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		segment := &logSegment{id: uint32(id)}
+		if s.readOnly {
+			segment.file, err = os.Open(s.segmentPath(segment.id))
+		} else {
+			segment.file, err = os.OpenFile(s.segmentPath(segment.id), os.O_RDWR, 0666)
+		}
+		if err != nil {
+			return
+		}
+		s.segments[segment.id] = segment
+
+		// The chunk data is only checked in the active segment, which is the
+		// one most likely to have been left with a partly written record.
+		// Records in other segments are checked when they are read.
+		isActive := i == len(ids)-1 && !s.readOnly
+		if err = s.scanSegment(segment, isActive); err != nil {
+			return
+		}
+		if isActive {
+			s.active = segment
+		}
+		s.nextSegmentId = segment.id + 1
+	}
+
+	if s.active == nil && !s.readOnly {
+		return s.newActiveSegment()
+	}
+
+	return
+}
+
+// scanSegment adds the versions in the segment to the index. Anything after
+// the last whole record is removed if the segment is active.
+func (s *chunkStoreLog) scanSegment(segment *logSegment, isActive bool) (err os.Error) {
+	fi, err := segment.file.Stat()
+	if err != nil {
+		return
+	}
+	fileSize := fi.Size
+
+	var offset int64
+	for offset+logRecordHeaderSize <= fileSize {
+		var header logRecordHeader
+		if header, err = readLogRecordHeader(segment.file, offset); err != nil {
+			return
+		}
+		end := offset + logRecordHeaderSize + int64(header.Size)
+		if header.Magic != logRecordMagic || end > fileSize {
+			break
+		}
+		if isActive {
+			if _, err = readLogRecordData(segment.file, offset, &header); err != nil {
+				break
+			}
+		}
+
+		s.addVersion(ChunkXz{ChunkCoord(header.X), ChunkCoord(header.Z)}, logEntry{
+			seq:       header.Seq,
+			timestamp: header.Timestamp,
+			segment:   segment.id,
+			offset:    offset,
+			size:      header.Size,
+		})
+		if header.Seq >= s.nextSeq {
+			s.nextSeq = header.Seq + 1
+		}
+
+		offset = end
+	}
+
+	if offset != fileSize {
+		log.Printf("%s: ignoring %d bytes after the last record", s.segmentPath(segment.id), fileSize-offset)
+		if isActive {
+			if err = segment.file.Truncate(offset); err != nil {
+				return
+			}
+		}
+	}
+	segment.size = offset
+
+	return nil
+}
+
+// addVersion adds a version of a chunk to the index, keeping the versions in
+// the order that they were written. A version that is already in the index is
+// ignored.
+func (s *chunkStoreLog) addVersion(chunkLoc ChunkXz, entry logEntry) {
+	key := chunkLoc.ChunkKey()
+	versions := s.index[key]
+
+	i := len(versions)
+	for i > 0 && versions[i-1].seq >= entry.seq {
+		if versions[i-1].seq == entry.seq {
+			return
+		}
+		i--
+	}
+
+	versions = append(versions, logEntry{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = entry
+	s.index[key] = versions
+}
+
+func (s *chunkStoreLog) newActiveSegment() (err os.Error) {
+	segment := &logSegment{id: s.nextSegmentId}
+	segment.file, err = os.OpenFile(s.segmentPath(segment.id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return
+	}
+	s.nextSegmentId++
+
+	s.segments[segment.id] = segment
+	s.active = segment
+
+	return
+}
+
+func readLogRecordHeader(file *os.File, offset int64) (header logRecordHeader, err os.Error) {
+	var headerBytes [logRecordHeaderSize]byte
+	if _, err = file.ReadAt(headerBytes[:], offset); err != nil {
+		return
+	}
+	err = binary.Read(bytes.NewBuffer(headerBytes[:]), binary.BigEndian, &header)
+	return
+}
+
+func readLogRecordData(file *os.File, offset int64, header *logRecordHeader) (chunkData []byte, err os.Error) {
+	chunkData = make([]byte, header.Size)
+	if _, err = file.ReadAt(chunkData, offset+logRecordHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(chunkData) != header.Checksum {
+		return nil, os.NewError("Chunk data does not match its checksum.")
+	}
+	return
+}
+
+// readVersion reads a version of a chunk from its segment.
+func (s *chunkStoreLog) readVersion(chunkLoc ChunkXz, entry *logEntry) (reader IChunkReader, err os.Error) {
+	segment, ok := s.segments[entry.segment]
+	if !ok {
+		return nil, fmt.Errorf("Chunk %d,%d is in missing segment %d", chunkLoc.X, chunkLoc.Z, entry.segment)
+	}
+
+	header, err := readLogRecordHeader(segment.file, entry.offset)
+	if err != nil {
+		return
+	}
+	if header.Magic != logRecordMagic || header.X != int32(chunkLoc.X) || header.Z != int32(chunkLoc.Z) || header.Seq != entry.seq {
+		return nil, fmt.Errorf("Chunk %d,%d has a bad record header in %s", chunkLoc.X, chunkLoc.Z, s.segmentPath(segment.id))
+	}
+
+	chunkData, err := readLogRecordData(segment.file, entry.offset, &header)
+	if err != nil {
+		return
+	}
+
+	r, err := parseChunkData(chunkData)
+	if err != nil {
+		return
+	}
+	r.timestamp = header.Timestamp
+
+	return r, nil
+}
+
+func (s *chunkStoreLog) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Error) {
+	s.pollCompaction()
+
+	versions := s.index[chunkLoc.ChunkKey()]
+	if len(versions) == 0 {
+		return nil, NoSuchChunkError(false)
+	}
+
+	// If the latest version cannot be read, such as when it was only partly
+	// written before a crash, the version before it is used instead.
+	for i := len(versions) - 1; i >= 0; i-- {
+		var readErr os.Error
+		if reader, readErr = s.readVersion(chunkLoc, &versions[i]); readErr == nil {
+			return reader, nil
+		}
+		log.Printf("%s: could not read version %d of chunk %d,%d: %v", s.logPath, versions[i].seq, chunkLoc.X, chunkLoc.Z, readErr)
+		if err == nil {
+			err = readErr
+		}
+	}
+
+	return nil, err
+}
+
+// ReadChunkAt returns the latest version of the chunk written at or before
+// timestamp. A version is retained until the version that replaced it has been
+// in place for LogRetention, so the version in place at any time since then is
+// still in the index.
+func (s *chunkStoreLog) ReadChunkAt(chunkLoc ChunkXz, timestamp int64) (reader IChunkReader, err os.Error) {
+	if timestamp < time.Seconds()-LogRetention {
+		return nil, ErrOutsideRetention
+	}
+
+	s.pollCompaction()
+
+	versions := s.index[chunkLoc.ChunkKey()]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].timestamp <= timestamp {
+			return s.readVersion(chunkLoc, &versions[i])
+		}
+	}
+
+	return nil, NoSuchChunkError(false)
+}
+
+func (s *chunkStoreLog) SupportsWrite() bool {
+	return !s.readOnly
+}
+
+func (s *chunkStoreLog) Writer() IChunkWriter {
+	return newNbtChunkWriter()
+}
+
+func (s *chunkStoreLog) WriteChunk(writer IChunkWriter) (err os.Error) {
+	if s.readOnly {
+		return errReadOnlyStore
+	}
+
+	s.pollCompaction()
+
+	nbtWriter, ok := writer.(*nbtChunkWriter)
+	if !ok {
+		return fmt.Errorf("%T is incorrect IChunkWriter implementation for %T", writer, s)
+	}
+
+	chunkData, err := serializeChunkData(nbtWriter)
+	if err != nil {
+		return
+	}
+
+	if s.active.size > 0 && s.active.size+logRecordHeaderSize+int64(len(chunkData)) > LogSegmentSize {
+		if err = s.active.file.Sync(); err != nil {
+			return
+		}
+		if err = s.newActiveSegment(); err != nil {
+			return
+		}
+		s.startCompaction()
+	}
+
+	chunkLoc := writer.ChunkLoc()
+	header := logRecordHeader{
+		Magic:     logRecordMagic,
+		X:         int32(chunkLoc.X),
+		Z:         int32(chunkLoc.Z),
+		Seq:       s.nextSeq,
+		Timestamp: time.Seconds(),
+		Size:      uint32(len(chunkData)),
+		Checksum:  crc32.ChecksumIEEE(chunkData),
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, logRecordHeaderSize+len(chunkData)))
+	if err = binary.Write(buffer, binary.BigEndian, &header); err != nil {
+		return
+	}
+	buffer.Write(chunkData)
+
+	offset := s.active.size
+	if _, err = s.active.file.WriteAt(buffer.Bytes(), offset); err != nil {
+		return
+	}
+	s.active.size += int64(buffer.Len())
+	s.nextSeq++
+
+	s.addVersion(chunkLoc, logEntry{
+		seq:       header.Seq,
+		timestamp: header.Timestamp,
+		segment:   s.active.id,
+		offset:    offset,
+		size:      header.Size,
+	})
+
+	return
+}
+
+// waitCompaction waits for any compaction to finish, and applies it.
+func (s *chunkStoreLog) waitCompaction() {
+	if s.compacting {
+		s.applyCompaction(<-s.compactions)
+	}
+}
+
+// sealedFiles returns the paths of the segments other than the active one,
+// which are only read until compaction removes them.
+func (s *chunkStoreLog) sealedFiles() (filePaths []string) {
+	s.waitCompaction()
+	for id, segment := range s.segments {
+		if segment != s.active {
+			filePaths = append(filePaths, s.segmentPath(id))
+		}
+	}
+	return
+}
+
+// Close waits for any compaction to finish, and closes the segment files.
+func (s *chunkStoreLog) Close() {
+	s.waitCompaction()
+	if s.active != nil {
+		if err := s.active.file.Sync(); err != nil {
+			log.Printf("%s: %v", s.segmentPath(s.active.id), err)
+		}
+	}
+	s.closeSegments()
+}
+
+func (s *chunkStoreLog) closeSegments() {
+	for _, segment := range s.segments {
+		segment.file.Close()
+	}
+	s.segments = make(map[uint32]*logSegment)
+}
+
+// chunkLocs returns the location of every chunk in the store.
+func (s *chunkStoreLog) chunkLocs() (chunkLocs []ChunkXz, err os.Error) {
+	for key := range s.index {
+		chunkLocs = append(chunkLocs, ChunkXz{ChunkCoord(int32(key >> 32)), ChunkCoord(int32(uint32(key)))})
+	}
+	return
+}
+
+// isRetained returns true if the version versions[i] of a chunk must be kept.
+// The latest version is always kept. Older versions are kept until the
+// version that replaced them has been in place for LogRetention, as until
+// then they might be read by ReadChunkAt.
+func isRetained(versions []logEntry, i int, cutoff int64) bool {
+	return i == len(versions)-1 || versions[i+1].timestamp > cutoff
+}
+
+// A version of a chunk that is kept when compacting a segment.
+type logRelocation struct {
+	key   uint64
+	entry logEntry
+}
+
+// The result of compacting a segment, sent from the compacting goroutine.
+type logCompaction struct {
+	oldSegment *logSegment
+	// segment holds the kept versions, or is nil if no versions were kept. It
+	// replaces the old segment, keeping its ID.
+	segment *logSegment
+	kept    []logRelocation
+	// offsets holds the offset in segment of each kept version.
+	offsets []int64
+	err     os.Error
+}
+
+// startCompaction starts compacting the sealed segment with the most space
+// taken by versions that are no longer retained, if at least half of the
+// segment is unused. Only one segment is compacted at a time.
+func (s *chunkStoreLog) startCompaction() {
+	if s.compacting {
+		return
+	}
+
+	cutoff := time.Seconds() - LogRetention
+	used := make(map[uint32]int64)
+	for _, versions := range s.index {
+		for i := range versions {
+			if isRetained(versions, i, cutoff) {
+				used[versions[i].segment] += versions[i].recordSize()
+			}
+		}
+	}
+
+	var oldSegment *logSegment
+	var mostUnused int64
+	for id, segment := range s.segments {
+		unused := segment.size - used[id]
+		if segment != s.active && unused*2 >= segment.size && unused >= mostUnused {
+			oldSegment, mostUnused = segment, unused
+		}
+	}
+	if oldSegment == nil {
+		return
+	}
+
+	var kept []logRelocation
+	for key, versions := range s.index {
+		for i := range versions {
+			if versions[i].segment == oldSegment.id && isRetained(versions, i, cutoff) {
+				kept = append(kept, logRelocation{key, versions[i]})
+			}
+		}
+	}
+
+	compaction := &logCompaction{
+		oldSegment: oldSegment,
+		kept:       kept,
+	}
+	if len(kept) > 0 {
+		compaction.segment = &logSegment{id: oldSegment.id}
+	}
+
+	s.compacting = true
+	go compaction.run(s.segmentPath(oldSegment.id), s.compactions)
+}
+
+// run copies the kept versions into the new segment, which is written beside
+// the old segment until it replaces it. It runs in its own goroutine, and only
+// reads the old segment, which is sealed.
+func (c *logCompaction) run(oldPath string, results chan<- *logCompaction) {
+	defer func() {
+		results <- c
+	}()
+
+	if c.segment == nil {
+		return
+	}
+
+	file, err := os.OpenFile(compactedPath(oldPath), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.segment.file = file
+
+	c.offsets = make([]int64, len(c.kept))
+	for i := range c.kept {
+		record := make([]byte, c.kept[i].entry.recordSize())
+		if _, err = c.oldSegment.file.ReadAt(record, c.kept[i].entry.offset); err != nil {
+			c.err = err
+			return
+		}
+		c.offsets[i] = c.segment.size
+		if _, err = file.WriteAt(record, c.segment.size); err != nil {
+			c.err = err
+			return
+		}
+		c.segment.size += int64(len(record))
+	}
+
+	c.err = file.Sync()
+}
+
+// pollCompaction applies the result of a compaction, if one has finished.
+func (s *chunkStoreLog) pollCompaction() {
+	select {
+	case compaction := <-s.compactions:
+		s.applyCompaction(compaction)
+	default:
+	}
+}
+
+// applyCompaction replaces the compacted segment with the new segment, moving
+// the kept versions to their offsets in it, or removes the compacted segment if
+// no versions were kept.
+func (s *chunkStoreLog) applyCompaction(c *logCompaction) {
+	s.compacting = false
+	oldPath := s.segmentPath(c.oldSegment.id)
+
+	if c.err == nil {
+		// Versions that replaced those being removed must be on disk before
+		// the old segment is replaced.
+		c.err = s.active.file.Sync()
+	}
+	if c.err == nil && c.segment != nil {
+		c.err = os.Rename(compactedPath(oldPath), oldPath)
+	}
+	if c.err != nil {
+		log.Printf("%s: compaction failed: %v", oldPath, c.err)
+		if c.segment != nil && c.segment.file != nil {
+			c.segment.file.Close()
+			os.Remove(compactedPath(oldPath))
+		}
+		return
+	}
+
+	// The offsets in the new segment of the kept versions, by their offset in
+	// the old segment.
+	offsets := make(map[int64]int64, len(c.kept))
+	for i := range c.kept {
+		offsets[c.kept[i].entry.offset] = c.offsets[i]
+	}
+
+	// Remove the versions that were not kept.
+	for key, versions := range s.index {
+		retained := versions[:0]
+		for _, entry := range versions {
+			if entry.segment == c.oldSegment.id {
+				offset, ok := offsets[entry.offset]
+				if !ok {
+					continue
+				}
+				entry.offset = offset
+			}
+			retained = append(retained, entry)
+		}
+		s.index[key] = retained
+	}
+
+	c.oldSegment.file.Close()
+	if c.segment != nil {
+		s.segments[c.segment.id] = c.segment
+		return
+	}
+
+	s.segments[c.oldSegment.id] = nil, false
+	if err := os.Remove(oldPath); err != nil {
+		if errno, ok := util.Errno(err); !ok || errno != os.ENOENT {
+			log.Printf("%s: could not remove compacted segment: %v", oldPath, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package chunkstore
+
+import (
+	"fmt"
+	"os"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// ConvertChunkFormat copies every chunk in one dimension of a McRegion world
+// from the store for one chunk format, such as ChunkFormatRegion, to the
+// store for another, such as ChunkFormatLog. Each chunk is read back and
+// checked after it is copied. The chunks in the old format are left in place,
+// and the world's level.dat is not changed.
+//
+// The world must not be in use by a running server.
+func ConvertChunkFormat(worldPath string, dimension DimensionId, fromFormat, toFormat string) (count int, err os.Error) {
+	if fromFormat == toFormat {
+		return 0, fmt.Errorf("Chunks are already in the %s format", toFormat)
+	}
+
+	fromStore, err := chunkStoreForFormat(worldPath, dimension, fromFormat, true)
+	if err != nil {
+		return
+	}
+	defer fromStore.Close()
+
+	toStore, err := chunkStoreForFormat(worldPath, dimension, toFormat, false)
+	if err != nil {
+		return
+	}
+	defer toStore.Close()
+
+	chunkLocs, err := fromStore.(chunkLister).chunkLocs()
+	if err != nil {
+		return
+	}
+
+	for _, chunkLoc := range chunkLocs {
+		reader, err := fromStore.ReadChunk(chunkLoc)
+		if err != nil {
+			return count, fmt.Errorf("Chunk %d,%d could not be read: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+		chunkTag, ok := reader.RootTag().(*nbt.Compound)
+		if !ok {
+			return count, fmt.Errorf("Chunk %d,%d is not an NBT compound", chunkLoc.X, chunkLoc.Z)
+		}
+
+		writer := &nbtChunkWriter{
+			loc:      chunkLoc,
+			chunkTag: chunkTag,
+		}
+		if err = toStore.WriteChunk(writer); err != nil {
+			return count, err
+		}
+
+		written, err := toStore.ReadChunk(chunkLoc)
+		if err != nil {
+			return count, fmt.Errorf("Chunk %d,%d could not be read back: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+		if chunkChecksum(written) != chunkChecksum(reader) {
+			return count, fmt.Errorf("Chunk %d,%d was read back with different data", chunkLoc.X, chunkLoc.Z)
+		}
+
+		count++
+	}
+
+	toChunkLocs, err := toStore.(chunkLister).chunkLocs()
+	if err != nil {
+		return
+	}
+	if len(toChunkLocs) != len(chunkLocs) {
+		return count, fmt.Errorf("The %s store holds %d chunks, but %d chunks were converted", toFormat, len(toChunkLocs), len(chunkLocs))
+	}
+
+	return
+}
+
+// chunkLister is implemented by stores that can list their chunks.
+type chunkLister interface {
+	chunkLocs() (chunkLocs []ChunkXz, err os.Error)
+}
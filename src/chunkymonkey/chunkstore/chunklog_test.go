@@ -0,0 +1,457 @@
+package chunkstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "chunkymonkey/types"
+)
+
+// tempWorld creates an empty world directory, which the caller must remove.
+func tempWorld(t *testing.T) (worldPath string) {
+	worldPath, err := ioutil.TempDir("", "chunkymonkey-world")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	return
+}
+
+func testChunkStoreLog(t *testing.T, worldPath string) *chunkStoreLog {
+	s, err := newChunkStoreLog(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreLog: %v", err)
+	}
+	return s
+}
+
+func readAndCheck(t *testing.T, s IChunkStoreForeground, w *nbtChunkWriter) {
+	reader, err := s.ReadChunk(w.ChunkLoc())
+	if err != nil {
+		t.Fatalf("ReadChunk(%v): %v", w.ChunkLoc(), err)
+	}
+	checkReader(t, reader, w)
+}
+
+func TestChunkStoreLog(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	s := testChunkStoreLog(t, worldPath)
+
+	if _, err := s.ReadChunk(ChunkXz{0, 0}); err == nil {
+		t.Errorf("ReadChunk of missing chunk expected to fail")
+	} else if _, ok := err.(NoSuchChunkError); !ok {
+		t.Errorf("ReadChunk of missing chunk expected NoSuchChunkError but got %v", err)
+	}
+
+	writers := []*nbtChunkWriter{
+		testChunkWriter(ChunkXz{0, 0}, 1, 0),
+		testChunkWriter(ChunkXz{-5, 100}, 1, 1),
+		testChunkWriter(ChunkXz{0, 0}, 1, 2),
+	}
+	for _, w := range writers {
+		if err := s.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	readAndCheck(t, s, writers[1])
+	readAndCheck(t, s, writers[2])
+	s.Close()
+
+	// The index is rebuilt when the store is opened again.
+	s = testChunkStoreLog(t, worldPath)
+	defer s.Close()
+	readAndCheck(t, s, writers[1])
+	readAndCheck(t, s, writers[2])
+
+	chunkLocs, _ := s.chunkLocs()
+	if len(chunkLocs) != 2 {
+		t.Errorf("expected 2 chunks but got %v", chunkLocs)
+	}
+}
+
+func TestChunkStoreLog_ReadChunkAt(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	s := testChunkStoreLog(t, worldPath)
+	defer s.Close()
+
+	chunkLoc := ChunkXz{1, 2}
+	older := testChunkWriter(chunkLoc, 1, 0)
+	newer := testChunkWriter(chunkLoc, 1, 1)
+	for _, w := range []*nbtChunkWriter{older, newer} {
+		if err := s.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+
+	// Both versions were written in the same second, so give them different
+	// times.
+	versions := s.index[chunkLoc.ChunkKey()]
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions but got %d", len(versions))
+	}
+	now := time.Seconds()
+	versions[0].timestamp = now - 1000
+	versions[1].timestamp = now - 500
+
+	var history IChunkHistory = s
+	if _, err := history.ReadChunkAt(chunkLoc, now-1001); err == nil {
+		t.Errorf("ReadChunkAt before the first version expected to fail")
+	}
+	for _, test := range []struct {
+		timestamp int64
+		expected  *nbtChunkWriter
+	}{
+		{now - 1000, older},
+		{now - 501, older},
+		{now - 500, newer},
+		{now, newer},
+	} {
+		reader, err := history.ReadChunkAt(chunkLoc, test.timestamp)
+		if err != nil {
+			t.Fatalf("ReadChunkAt(%d): %v", test.timestamp, err)
+		}
+		checkReader(t, reader, test.expected)
+	}
+}
+
+func TestChunkStoreLog_ReadChunkAtOutsideRetention(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	oldSegmentSize, oldRetention := LogSegmentSize, LogRetention
+	defer func() {
+		LogSegmentSize, LogRetention = oldSegmentSize, oldRetention
+	}()
+	// Every version is written to a segment of its own.
+	LogSegmentSize = 1
+	LogRetention = 1000
+
+	s := testChunkStoreLog(t, worldPath)
+	defer s.Close()
+
+	chunkLoc := ChunkXz{1, 2}
+	for i := 0; i < 3; i++ {
+		if err := s.WriteChunk(testChunkWriter(chunkLoc, 1, int64(i))); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	latest := testChunkWriter(chunkLoc, 1, 3)
+	if err := s.WriteChunk(latest); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// The first versions were replaced long ago, and the last version before
+	// the retention cutoff replaced them.
+	now := time.Seconds()
+	versions := s.index[chunkLoc.ChunkKey()]
+	versions[0].timestamp = now - 5000
+	versions[1].timestamp = now - 4000
+	versions[2].timestamp = now - 3000
+	versions[3].timestamp = now - 2000
+
+	// Compact until there is nothing left to compact.
+	for {
+		if s.compacting {
+			s.applyCompaction(<-s.compactions)
+		}
+		s.startCompaction()
+		if !s.compacting {
+			break
+		}
+	}
+	if versions := s.index[chunkLoc.ChunkKey()]; len(versions) != 1 {
+		t.Fatalf("expected 1 version after compaction but got %d", len(versions))
+	}
+
+	// Only the latest version is left, which was not the chunk at these times.
+	for _, timestamp := range []int64{now - 4500, now - 2000, now - 1001} {
+		if _, err := s.ReadChunkAt(chunkLoc, timestamp); err != ErrOutsideRetention {
+			t.Errorf("ReadChunkAt(now - %d): expected ErrOutsideRetention but got %v", now-timestamp, err)
+		}
+	}
+
+	reader, err := s.ReadChunkAt(chunkLoc, now-999)
+	if err != nil {
+		t.Fatalf("ReadChunkAt(now - 999): %v", err)
+	}
+	checkReader(t, reader, latest)
+}
+
+func TestChunkStoreLog_TruncatedRecord(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	s := testChunkStoreLog(t, worldPath)
+	w := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	if err := s.WriteChunk(w); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if err := s.WriteChunk(testChunkWriter(ChunkXz{0, 0}, 1, 1)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	segmentPath := s.segmentPath(s.active.id)
+	size := s.active.size
+	s.Close()
+
+	// Simulate a crash part way through writing the second version.
+	if err := os.Truncate(segmentPath, size-100); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	s = testChunkStoreLog(t, worldPath)
+	defer s.Close()
+	readAndCheck(t, s, w)
+
+	// New versions are written after the last whole record.
+	w = testChunkWriter(ChunkXz{0, 0}, 1, 2)
+	if err := s.WriteChunk(w); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	readAndCheck(t, s, w)
+}
+
+func TestChunkStoreLog_Compaction(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	oldSegmentSize, oldRetention := LogSegmentSize, LogRetention
+	defer func() {
+		LogSegmentSize, LogRetention = oldSegmentSize, oldRetention
+	}()
+	// Every version is written to a segment of its own, and replaced versions
+	// are not retained.
+	LogSegmentSize = 1
+	LogRetention = 0
+
+	s := testChunkStoreLog(t, worldPath)
+
+	var w *nbtChunkWriter
+	for i := 0; i < 5; i++ {
+		w = testChunkWriter(ChunkXz{0, 0}, 1, int64(i))
+		if err := s.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	kept := testChunkWriter(ChunkXz{1, 0}, 1, 10)
+	if err := s.WriteChunk(kept); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// Compact until there is nothing left to compact.
+	for {
+		if s.compacting {
+			s.applyCompaction(<-s.compactions)
+		}
+		s.startCompaction()
+		if !s.compacting {
+			break
+		}
+	}
+
+	if versions := s.index[w.loc.ChunkKey()]; len(versions) != 1 {
+		t.Errorf("expected 1 version after compaction but got %d", len(versions))
+	}
+	readAndCheck(t, s, w)
+	readAndCheck(t, s, kept)
+	s.Close()
+
+	names, err := readDirNames(s.logPath)
+	if err != nil {
+		t.Fatalf("readDirNames: %v", err)
+	}
+	if len(names) > 3 {
+		t.Errorf("expected at most 3 segments after compaction but got %v", names)
+	}
+
+	s = testChunkStoreLog(t, worldPath)
+	defer s.Close()
+	readAndCheck(t, s, w)
+	readAndCheck(t, s, kept)
+}
+
+func TestChunkStoreLog_CompactionThenTruncatedRecord(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	oldSegmentSize, oldRetention := LogSegmentSize, LogRetention
+	defer func() {
+		LogSegmentSize, LogRetention = oldSegmentSize, oldRetention
+	}()
+	LogRetention = 0
+
+	s := testChunkStoreLog(t, worldPath)
+
+	// The first segment is mostly taken by versions of a chunk that is then
+	// replaced in the next segment.
+	for i := 0; i < 3; i++ {
+		if err := s.WriteChunk(testChunkWriter(ChunkXz{0, 0}, 1, int64(i))); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	kept := testChunkWriter(ChunkXz{1, 0}, 1, 10)
+	if err := s.WriteChunk(kept); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	LogSegmentSize = 1
+	w := testChunkWriter(ChunkXz{0, 0}, 1, 3)
+	if err := s.WriteChunk(w); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	LogSegmentSize = oldSegmentSize
+
+	s.startCompaction()
+	if !s.compacting {
+		t.Fatalf("expected the first segment to be compacted")
+	}
+	s.applyCompaction(<-s.compactions)
+
+	activeId, size := s.active.id, s.active.size
+	if err := s.WriteChunk(testChunkWriter(ChunkXz{0, 0}, 1, 4)); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	s.Close()
+
+	// Simulate a crash part way through writing the last version.
+	if err := os.Truncate(s.segmentPath(activeId), size+100); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	s = testChunkStoreLog(t, worldPath)
+	defer s.Close()
+	if s.active.id != activeId {
+		t.Errorf("expected segment %d to be active but got %d", activeId, s.active.id)
+	}
+	if s.active.size != size {
+		t.Errorf("expected the partly written record to be removed, leaving %d bytes but got %d", size, s.active.size)
+	}
+	readAndCheck(t, s, w)
+	readAndCheck(t, s, kept)
+}
+
+func TestConvertChunkFormat(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	betaStore, err := newChunkStoreBeta(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	var writers []*nbtChunkWriter
+	for i, chunkLoc := range []ChunkXz{{0, 0}, {31, 31}, {-1, 40}} {
+		w := testChunkWriter(chunkLoc, 1, int64(i))
+		if err = betaStore.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+		writers = append(writers, w)
+	}
+	betaStore.Close()
+
+	count, err := ConvertChunkFormat(worldPath, DimensionNormal, ChunkFormatRegion, ChunkFormatLog)
+	if err != nil {
+		t.Fatalf("ConvertChunkFormat to log: %v", err)
+	}
+	if count != len(writers) {
+		t.Errorf("expected %d chunks converted but got %d", len(writers), count)
+	}
+
+	s := testChunkStoreLog(t, worldPath)
+	for _, w := range writers {
+		readAndCheck(t, s, w)
+	}
+	s.Close()
+
+	// Converting back to region files gives the same chunks.
+	os.RemoveAll(betaStore.regionPath)
+	if _, err = ConvertChunkFormat(worldPath, DimensionNormal, ChunkFormatLog, ChunkFormatRegion); err != nil {
+		t.Fatalf("ConvertChunkFormat to region: %v", err)
+	}
+
+	if betaStore, err = newChunkStoreBeta(worldPath, DimensionNormal); err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	defer betaStore.Close()
+	for _, w := range writers {
+		readAndCheck(t, betaStore, w)
+	}
+}
+
+func TestChunkStoreLog_SealedFiles(t *testing.T) {
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	oldSegmentSize := LogSegmentSize
+	defer func() {
+		LogSegmentSize = oldSegmentSize
+	}()
+	LogSegmentSize = 1
+
+	s := testChunkStoreLog(t, worldPath)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := s.WriteChunk(testChunkWriter(ChunkXz{ChunkCoord(i), 0}, 1, int64(i))); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+
+	sealed := s.sealedFiles()
+	if len(sealed) != len(s.segments)-1 {
+		t.Errorf("expected %d sealed segments but got %v", len(s.segments)-1, sealed)
+	}
+	for _, filePath := range sealed {
+		if filePath == s.segmentPath(s.active.id) {
+			t.Errorf("active segment %s listed as sealed", filePath)
+		}
+	}
+}
+
+func TestChunkStoreForFormat_ReadOnly(t *testing.T) {
+	for _, format := range []string{ChunkFormatRegion, ChunkFormatLog} {
+		worldPath := tempWorld(t)
+		defer os.RemoveAll(worldPath)
+
+		// A read-only store of a world without chunks creates nothing.
+		store, err := chunkStoreForFormat(worldPath, DimensionNormal, format, true)
+		if err != nil {
+			t.Fatalf("%s: chunkStoreForFormat: %v", format, err)
+		}
+		if chunkLocs, err := store.(chunkLister).chunkLocs(); err != nil || len(chunkLocs) != 0 {
+			t.Errorf("%s: expected no chunks but got %v, %v", format, chunkLocs, err)
+		}
+		if _, err = store.ReadChunk(ChunkXz{0, 0}); err == nil {
+			t.Errorf("%s: ReadChunk of missing chunk expected to fail", format)
+		} else if _, ok := err.(NoSuchChunkError); !ok {
+			t.Errorf("%s: ReadChunk of missing chunk expected NoSuchChunkError but got %v", format, err)
+		}
+		store.Close()
+		if names, _ := readDirNames(worldPath); len(names) != 0 {
+			t.Errorf("%s: read-only store created %v", format, names)
+		}
+
+		if store, err = chunkStoreForFormat(worldPath, DimensionNormal, format, false); err != nil {
+			t.Fatalf("%s: chunkStoreForFormat: %v", format, err)
+		}
+		w := testChunkWriter(ChunkXz{3, -4}, 1, 0)
+		if err = store.WriteChunk(w); err != nil {
+			t.Fatalf("%s: WriteChunk: %v", format, err)
+		}
+		store.Close()
+
+		if store, err = chunkStoreForFormat(worldPath, DimensionNormal, format, true); err != nil {
+			t.Fatalf("%s: chunkStoreForFormat: %v", format, err)
+		}
+		readAndCheck(t, store, w)
+		if store.SupportsWrite() {
+			t.Errorf("%s: read-only store supports writing", format)
+		}
+		if err = store.WriteChunk(testChunkWriter(ChunkXz{0, 0}, 1, 1)); err != errReadOnlyStore {
+			t.Errorf("%s: WriteChunk expected errReadOnlyStore but got %v", format, err)
+		}
+		store.Close()
+	}
+}
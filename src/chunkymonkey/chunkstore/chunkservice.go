@@ -3,6 +3,7 @@ package chunkstore
 import (
 	"log"
 	"os"
+	"sync"
 
 	. "chunkymonkey/types"
 )
@@ -17,6 +18,23 @@ type IChunkStoreForeground interface {
 	SupportsWrite() bool
 	Writer() IChunkWriter
 	WriteChunk(writer IChunkWriter) os.Error
+
+	// Close releases any resources held by the store, such as open files.
+	Close()
+}
+
+// iSealedFiles is implemented by foreground stores that keep chunks in files
+// that are never changed once they are complete.
+type iSealedFiles interface {
+	// sealedFiles finishes any writes being made in the background, and
+	// returns the paths of the files that will not be written to again. Such
+	// files may be hard linked rather than copied into a snapshot.
+	sealedFiles() (filePaths []string)
+}
+
+type pauseRequest struct {
+	fn   func(sealedFiles []string)
+	done chan bool
 }
 
 // ChunkService adapts an IChunkStoreForeground (which can only be accessed
@@ -25,6 +43,10 @@ type ChunkService struct {
 	store  IChunkStoreForeground
 	reads  chan readRequest
 	writes chan IChunkWriter
+	closes chan chan bool
+	pauses chan pauseRequest
+
+	closeOnce sync.Once
 }
 
 func NewChunkService(store IChunkStoreForeground) (s *ChunkService) {
@@ -32,6 +54,8 @@ func NewChunkService(store IChunkStoreForeground) (s *ChunkService) {
 		store:  store,
 		reads:  make(chan readRequest),
 		writes: make(chan IChunkWriter),
+		closes: make(chan chan bool),
+		pauses: make(chan pauseRequest),
 	}
 }
 
@@ -45,6 +69,17 @@ func (s *ChunkService) Serve() {
 			if err := s.store.WriteChunk(writer); err != nil {
 				log.Printf("Could not write chunk at %#v: %v", writer.ChunkLoc(), err)
 			}
+		case request := <-s.pauses:
+			var sealedFiles []string
+			if sealer, ok := s.store.(iSealedFiles); ok {
+				sealedFiles = sealer.sealedFiles()
+			}
+			request.fn(sealedFiles)
+			request.done <- true
+		case done := <-s.closes:
+			s.store.Close()
+			done <- true
+			return
 		}
 	}
 }
@@ -71,3 +106,25 @@ func (s *ChunkService) Writer() IChunkWriter {
 func (s *ChunkService) WriteChunk(writer IChunkWriter) {
 	s.writes <- writer
 }
+
+// Pause waits for the chunks already submitted to be written, then calls fn.
+// Reads and writes wait until fn returns, so that fn sees the files of the
+// store in a consistent state. sealedFiles holds the paths of the store's
+// files that will not be changed again.
+func (s *ChunkService) Pause(fn func(sealedFiles []string)) {
+	done := make(chan bool)
+	s.pauses <- pauseRequest{fn, done}
+	<-done
+}
+
+// Close waits for the chunks already submitted to be written, then closes the
+// underlying store and stops serving. The service must not be used
+// afterwards, except that closing it again does nothing, as a service may
+// also be closed by the store of a service in front of it.
+func (s *ChunkService) Close() {
+	s.closeOnce.Do(func() {
+		done := make(chan bool)
+		s.closes <- done
+		<-done
+	})
+}
@@ -0,0 +1,133 @@
+package chunkstore
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// A chunk held by MemoryStore.
+type memoryChunk struct {
+	// chunkData is the chunk data as it would be stored in a region file.
+	chunkData []byte
+	timestamp int64
+}
+
+// MemoryStore is an IChunkStoreForeground that keeps chunks in memory, in the
+// same compressed form as in region files. Chunks that have not been written
+// to it are read from an underlying store, if there is one, so that a world
+// can be played without changing its saved chunks. It is useful for tests,
+// and for worlds that are reset when the server restarts. A corrupt chunk in
+// the underlying store is treated as missing, so that it is generated again.
+type MemoryStore struct {
+	base   IChunkStoreForeground
+	chunks map[uint64]memoryChunk
+}
+
+// NewMemoryStore creates a MemoryStore. base may be nil, in which case the
+// store starts empty.
+func NewMemoryStore(base IChunkStoreForeground) *MemoryStore {
+	return &MemoryStore{
+		base:   base,
+		chunks: make(map[uint64]memoryChunk),
+	}
+}
+
+func (s *MemoryStore) ReadChunk(chunkLoc ChunkXz) (reader IChunkReader, err os.Error) {
+	chunk, ok := s.chunks[chunkLoc.ChunkKey()]
+	if !ok {
+		if s.base == nil {
+			return nil, NoSuchChunkError(false)
+		}
+		reader, err = s.base.ReadChunk(chunkLoc)
+		if _, ok := err.(corruptChunkError); ok {
+			log.Printf("Chunk %d,%d is corrupt, and will be generated again: %v", chunkLoc.X, chunkLoc.Z, err)
+			return nil, NoSuchChunkError(false)
+		}
+		return
+	}
+
+	r, err := parseChunkData(chunk.chunkData)
+	if err != nil {
+		return
+	}
+	r.timestamp = chunk.timestamp
+
+	return r, nil
+}
+
+func (s *MemoryStore) SupportsWrite() bool {
+	return true
+}
+
+func (s *MemoryStore) Writer() IChunkWriter {
+	return newNbtChunkWriter()
+}
+
+func (s *MemoryStore) WriteChunk(writer IChunkWriter) (err os.Error) {
+	nbtWriter, ok := writer.(*nbtChunkWriter)
+	if !ok {
+		return fmt.Errorf("%T is incorrect IChunkWriter implementation for %T", writer, s)
+	}
+
+	chunkData, err := serializeChunkData(nbtWriter)
+	if err != nil {
+		return
+	}
+
+	chunkLoc := writer.ChunkLoc()
+	s.chunks[chunkLoc.ChunkKey()] = memoryChunk{
+		chunkData: chunkData,
+		timestamp: time.Seconds(),
+	}
+
+	return
+}
+
+// Close discards the chunks, and closes the underlying store.
+func (s *MemoryStore) Close() {
+	s.chunks = make(map[uint64]memoryChunk)
+	if s.base != nil {
+		s.base.Close()
+	}
+}
+
+// sealedFiles returns the sealed files of the underlying store. The chunks in
+// memory are not in any file.
+func (s *MemoryStore) sealedFiles() (filePaths []string) {
+	if sealer, ok := s.base.(iSealedFiles); ok {
+		return sealer.sealedFiles()
+	}
+	return nil
+}
+
+// Len returns the number of chunks that have been written to the store.
+func (s *MemoryStore) Len() int {
+	return len(s.chunks)
+}
+
+// Snapshot writes the chunks that have been written to the store into store,
+// such as the chunk store of a copy of the world, replacing any chunks that it
+// has at the same locations.
+func (s *MemoryStore) Snapshot(store IChunkStoreForeground) (err os.Error) {
+	for _, chunk := range s.chunks {
+		r, err := parseChunkData(chunk.chunkData)
+		if err != nil {
+			return err
+		}
+
+		writer := &nbtChunkWriter{
+			loc:      r.ChunkLoc(),
+			chunkTag: r.chunkTag.(*nbt.Compound),
+		}
+		if err = store.WriteChunk(writer); err != nil {
+			return err
+		}
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+package chunkstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func checkReader(t *testing.T, reader IChunkReader, w *nbtChunkWriter) {
+	expected := w.RootTag().Lookup("Level/Blocks").(*nbt.ByteArray).Value
+	if !reader.ChunkLoc().Equals(w.ChunkLoc()) || string(reader.Blocks()) != string(expected) {
+		t.Errorf("chunk %v read back with wrong data", w.ChunkLoc())
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(nil)
+	defer s.Close()
+
+	chunkLoc := ChunkXz{3, -7}
+	if _, err := s.ReadChunk(chunkLoc); err == nil {
+		t.Errorf("ReadChunk of missing chunk expected to fail")
+	} else if _, ok := err.(NoSuchChunkError); !ok {
+		t.Errorf("ReadChunk of missing chunk expected NoSuchChunkError but got %v", err)
+	}
+
+	w := testChunkWriter(chunkLoc, 1, 0)
+	if err := s.WriteChunk(w); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// The stored chunk is not affected by later changes to the writer.
+	w.SetBlocks(make([]byte, ChunkSizeH*ChunkSizeH*ChunkSizeY))
+	reader, err := s.ReadChunk(chunkLoc)
+	if err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	checkReader(t, reader, testChunkWriter(chunkLoc, 1, 0))
+
+	if s.Len() != 1 {
+		t.Errorf("Len() expected 1 but got %d", s.Len())
+	}
+}
+
+func TestMemoryStore_BaseAndSnapshot(t *testing.T) {
+	worldPath, err := ioutil.TempDir("", "chunkymonkey-world")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(worldPath)
+
+	baseStore, err := newChunkStoreBeta(worldPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	saved := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	if err = baseStore.WriteChunk(saved); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	s := NewMemoryStore(baseStore)
+
+	// Chunks not in memory are read from the underlying store.
+	reader, err := s.ReadChunk(saved.ChunkLoc())
+	if err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	checkReader(t, reader, saved)
+
+	// Writes only change the chunks in memory.
+	changed := testChunkWriter(ChunkXz{0, 0}, 1, 1)
+	added := testChunkWriter(ChunkXz{40, 1}, 1, 2)
+	for _, w := range []*nbtChunkWriter{changed, added} {
+		if err = s.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+	if reader, err = s.ReadChunk(changed.ChunkLoc()); err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	checkReader(t, reader, changed)
+	if reader, err = baseStore.ReadChunk(saved.ChunkLoc()); err != nil {
+		t.Fatalf("ReadChunk: %v", err)
+	}
+	checkReader(t, reader, saved)
+	if _, err = baseStore.ReadChunk(added.ChunkLoc()); err == nil {
+		t.Errorf("chunk written to memory store found in underlying store")
+	}
+
+	// A snapshot writes the chunks in memory to another store.
+	snapshotPath, err := ioutil.TempDir("", "chunkymonkey-world")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(snapshotPath)
+	snapshotStore, err := newChunkStoreBeta(snapshotPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	if err = s.Snapshot(snapshotStore); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	snapshotStore.Close()
+	s.Close()
+
+	betaStore, err := newChunkStoreBeta(snapshotPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	defer betaStore.Close()
+	for _, w := range []*nbtChunkWriter{changed, added} {
+		if reader, err = betaStore.ReadChunk(w.ChunkLoc()); err != nil {
+			t.Fatalf("ReadChunk(%v): %v", w.ChunkLoc(), err)
+		}
+		checkReader(t, reader, w)
+	}
+}
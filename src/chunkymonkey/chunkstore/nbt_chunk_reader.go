@@ -12,7 +12,8 @@ import (
 
 // Returned to chunks to pull their data from.
 type nbtChunkReader struct {
-	chunkTag nbt.ITag
+	chunkTag  nbt.ITag
+	timestamp int64
 }
 
 // Load a chunk from its NBT representation
@@ -56,6 +57,24 @@ func (r *nbtChunkReader) HeightMap() []byte {
 	return r.chunkTag.Lookup("Level/HeightMap").(*nbt.ByteArray).Value
 }
 
+func (r *nbtChunkReader) TerrainPopulated() bool {
+	// Chunks without the flag are treated as populated, so that features are
+	// never added to them twice.
+	populated, ok := r.chunkTag.Lookup("Level/TerrainPopulated").(*nbt.Byte)
+	return !ok || populated.Value != 0
+}
+
+func (r *nbtChunkReader) LastUpdate() Ticks {
+	if lastUpdate, ok := r.chunkTag.Lookup("Level/LastUpdate").(*nbt.Long); ok {
+		return Ticks(lastUpdate.Value)
+	}
+	return 0
+}
+
+func (r *nbtChunkReader) Timestamp() int64 {
+	return r.timestamp
+}
+
 func (r *nbtChunkReader) Entities() (entities []gamerules.INonPlayerEntity) {
 	entityListTag, ok := r.chunkTag.Lookup("Level/Entities").(*nbt.List)
 	if !ok {
@@ -32,8 +32,8 @@ func newNbtChunkWriter() *nbtChunkWriter {
 				"HeightMap":        &nbt.ByteArray{},
 				"SkyLight":         &nbt.ByteArray{},
 				"BlockLight":       &nbt.ByteArray{},
-				"LastUpdate":       &nbt.Long{0}, // TODO
-				"TerrainPopulated": &nbt.Byte{1}, // TODO
+				"LastUpdate":       &nbt.Long{0},
+				"TerrainPopulated": &nbt.Byte{1},
 				"xPos":             &nbt.Int{0},
 				"zPos":             &nbt.Int{0},
 			}},
@@ -71,6 +71,18 @@ func (w *nbtChunkWriter) SetHeightMap(heightMap []byte) {
 	w.chunkTag.Lookup("Level/HeightMap").(*nbt.ByteArray).Value = cloneByteArray(heightMap)
 }
 
+func (w *nbtChunkWriter) SetTerrainPopulated(populated bool) {
+	var value int8
+	if populated {
+		value = 1
+	}
+	w.chunkTag.Lookup("Level/TerrainPopulated").(*nbt.Byte).Value = value
+}
+
+func (w *nbtChunkWriter) SetLastUpdate(lastUpdate Ticks) {
+	w.chunkTag.Lookup("Level/LastUpdate").(*nbt.Long).Value = int64(lastUpdate)
+}
+
 func (w *nbtChunkWriter) SetEntities(entities map[EntityId]gamerules.INonPlayerEntity) {
 	entitiesNbt := make([]nbt.ITag, 0, len(entities))
 	for _, entity := range entities {
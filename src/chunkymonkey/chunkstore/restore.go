@@ -0,0 +1,70 @@
+package chunkstore
+
+import (
+	"fmt"
+	"os"
+
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// ChunksInArea returns the locations of the chunks in the rectangle with the
+// chunks a and b at opposite corners.
+func ChunksInArea(a, b ChunkXz) (chunkLocs []ChunkXz) {
+	if a.X > b.X {
+		a.X, b.X = b.X, a.X
+	}
+	if a.Z > b.Z {
+		a.Z, b.Z = b.Z, a.Z
+	}
+
+	for x := a.X; x <= b.X; x++ {
+		for z := a.Z; z <= b.Z; z++ {
+			chunkLocs = append(chunkLocs, ChunkXz{x, z})
+		}
+	}
+
+	return
+}
+
+// CopyChunkExactly sets the writer's chunk data from the reader like
+// CopyChunk, except that chunks read from and written to NBT are copied tag
+// for tag, keeping any data that the server does not understand. The reader
+// must not be used afterwards.
+func CopyChunkExactly(writer IChunkWriter, reader IChunkReader) {
+	nbtWriter, ok := writer.(*nbtChunkWriter)
+	if ok {
+		if chunkTag, ok := reader.RootTag().(*nbt.Compound); ok {
+			nbtWriter.loc = reader.ChunkLoc()
+			nbtWriter.chunkTag = chunkTag
+			return
+		}
+	}
+
+	CopyChunk(writer, reader)
+}
+
+// CopyChunks copies the chunks at chunkLocs from one store to another,
+// replacing the chunks there, and returns the number of chunks copied. Chunks
+// that the from store does not have are skipped.
+func CopyChunks(from, to IChunkStoreForeground, chunkLocs []ChunkXz) (count int, err os.Error) {
+	for _, chunkLoc := range chunkLocs {
+		reader, err := from.ReadChunk(chunkLoc)
+		if err != nil {
+			if _, ok := err.(NoSuchChunkError); ok {
+				continue
+			}
+			return count, fmt.Errorf("Chunk %d,%d could not be read: %v", chunkLoc.X, chunkLoc.Z, err)
+		}
+
+		writer := to.Writer()
+		CopyChunkExactly(writer, reader)
+		if err = to.WriteChunk(writer); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return
+}
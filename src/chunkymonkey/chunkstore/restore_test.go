@@ -0,0 +1,63 @@
+package chunkstore
+
+import (
+	"os"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestChunksInArea(t *testing.T) {
+	chunkLocs := ChunksInArea(ChunkXz{1, -1}, ChunkXz{0, 1})
+	expected := []ChunkXz{{0, -1}, {0, 0}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+	if len(chunkLocs) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, chunkLocs)
+	}
+	for i := range expected {
+		if !chunkLocs[i].Equals(expected[i]) {
+			t.Errorf("expected %v but got %v", expected, chunkLocs)
+			break
+		}
+	}
+
+	if chunkLocs = ChunksInArea(ChunkXz{5, 5}, ChunkXz{5, 5}); len(chunkLocs) != 1 {
+		t.Errorf("expected a single chunk but got %v", chunkLocs)
+	}
+}
+
+func TestCopyChunks(t *testing.T) {
+	backupPath := tempWorld(t)
+	defer os.RemoveAll(backupPath)
+	worldPath := tempWorld(t)
+	defer os.RemoveAll(worldPath)
+
+	backupStore, err := newChunkStoreBeta(backupPath, DimensionNormal)
+	if err != nil {
+		t.Fatalf("newChunkStoreBeta: %v", err)
+	}
+	defer backupStore.Close()
+	worldStore := testChunkStoreLog(t, worldPath)
+	defer worldStore.Close()
+
+	backedUp := testChunkWriter(ChunkXz{0, 0}, 1, 0)
+	if err = backupStore.WriteChunk(backedUp); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	unchanged := testChunkWriter(ChunkXz{0, 1}, 1, 1)
+	for _, w := range []*nbtChunkWriter{testChunkWriter(ChunkXz{0, 0}, 1, 2), unchanged} {
+		if err = worldStore.WriteChunk(w); err != nil {
+			t.Fatalf("WriteChunk: %v", err)
+		}
+	}
+
+	// The chunk missing from the backup is left as it is.
+	count, err := CopyChunks(backupStore, worldStore, []ChunkXz{{0, 0}, {0, 1}})
+	if err != nil {
+		t.Fatalf("CopyChunks: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 chunk copied but got %d", count)
+	}
+	readAndCheck(t, worldStore, backedUp)
+	readAndCheck(t, worldStore, unchanged)
+}
@@ -25,6 +25,9 @@ type IChunkStore interface {
 	// Submits the set chunk data for writing. The chunk writer must not be
 	// altered any further after calling this.
 	WriteChunk(writer IChunkWriter)
+
+	// Waits for submitted chunks to be written, then closes the store.
+	Close()
 }
 
 type IChunkReader interface {
@@ -46,6 +49,18 @@ type IChunkReader interface {
 	// Returns the height map data in the chunk.
 	HeightMap() []byte
 
+	// Returns true if the chunk has been populated with features (such as ores
+	// and trees) that may extend into neighbouring chunks.
+	TerrainPopulated() bool
+
+	// Returns the world time at which the chunk was last saved by a running
+	// server.
+	LastUpdate() Ticks
+
+	// Returns the time at which the chunk was last written to its store, in
+	// seconds since the Unix epoch, or 0 if the store does not know.
+	Timestamp() int64
+
 	// Return a slice of the entities (items, mobs) within the chunk.
 	Entities() []gamerules.INonPlayerEntity
 
@@ -85,6 +100,14 @@ type IChunkWriter interface {
 	// SetHeightMap sets the height map data in the chunk.
 	SetHeightMap(heightMap []byte)
 
+	// SetTerrainPopulated sets whether the chunk has been populated with
+	// features by the world generator.
+	SetTerrainPopulated(populated bool)
+
+	// SetLastUpdate sets the world time at which the chunk is being saved.
+	// The store sets the chunk's timestamp itself.
+	SetLastUpdate(lastUpdate Ticks)
+
 	// SetEntities sets a list of the entities (items, mobs) within the chunk.
 	SetEntities(entities map[EntityId]gamerules.INonPlayerEntity)
 
@@ -93,17 +116,70 @@ type IChunkWriter interface {
 	SetTileEntities(tileEntities map[BlockIndex]gamerules.ITileEntity)
 }
 
+// CopyChunk sets all of the writer's chunk data from the reader.
+func CopyChunk(writer IChunkWriter, reader IChunkReader) {
+	entities := make(map[EntityId]gamerules.INonPlayerEntity)
+	for i, entity := range reader.Entities() {
+		entities[EntityId(i)] = entity
+	}
+
+	tileEntities := make(map[BlockIndex]gamerules.ITileEntity)
+	for _, tileEntity := range reader.TileEntities() {
+		blockLoc := tileEntity.Block()
+		_, subLoc := blockLoc.ToChunkLocal()
+		if index, ok := subLoc.BlockIndex(); ok {
+			tileEntities[index] = tileEntity
+		}
+	}
+
+	writer.SetChunkLoc(reader.ChunkLoc())
+	writer.SetBlocks(reader.Blocks())
+	writer.SetBlockData(reader.BlockData())
+	writer.SetBlockLight(reader.BlockLight())
+	writer.SetSkyLight(reader.SkyLight())
+	writer.SetHeightMap(reader.HeightMap())
+	writer.SetTerrainPopulated(reader.TerrainPopulated())
+	writer.SetLastUpdate(reader.LastUpdate())
+	writer.SetEntities(entities)
+	writer.SetTileEntities(tileEntities)
+}
+
+// LevelVersionMcRegion is the version in level.dat of worlds whose chunks are
+// stored in region files. Worlds without a version store each chunk in a file
+// of its own, in the Alpha format.
+const LevelVersionMcRegion = 19132
+
 // Given the NamedTag for a level.dat, returns an appropriate
 // IChunkStoreForeground.
 func ChunkStoreForLevel(worldPath string, levelData nbt.ITag, dimension DimensionId) (store IChunkStoreForeground, err os.Error) {
+	return chunkStoreForLevel(worldPath, levelData, dimension, false)
+}
+
+// ReadOnlyChunkStoreForLevel is like ChunkStoreForLevel, except that the
+// store never changes the world's files, so that it can read a world that
+// must be left as it is, such as a backup. Corrupt chunks are left in place,
+// and the store does not support writing.
+func ReadOnlyChunkStoreForLevel(worldPath string, levelData nbt.ITag, dimension DimensionId) (store IChunkStoreForeground, err os.Error) {
+	return chunkStoreForLevel(worldPath, levelData, dimension, true)
+}
+
+func chunkStoreForLevel(worldPath string, levelData nbt.ITag, dimension DimensionId, readOnly bool) (store IChunkStoreForeground, err os.Error) {
 	versionTag, ok := levelData.Lookup("Data/version").(*nbt.Int)
 
 	if !ok {
-		store, err = newChunkStoreAlpha(worldPath, dimension)
+		var alphaStore *chunkStoreAlpha
+		if alphaStore, err = newChunkStoreAlpha(worldPath, dimension); err == nil {
+			alphaStore.readOnly = readOnly
+			store = alphaStore
+		}
 	} else {
 		switch version := versionTag.Value; version {
-		case 19132:
-			store, err = newChunkStoreBeta(worldPath, dimension)
+		case LevelVersionMcRegion:
+			format := ChunkFormatRegion
+			if formatTag, ok := levelData.Lookup("Data/chunkFormat").(*nbt.String); ok {
+				format = formatTag.Value
+			}
+			store, err = chunkStoreForFormat(worldPath, dimension, format, readOnly)
 		default:
 			err = UnknownLevelVersion(version)
 		}
@@ -112,6 +188,36 @@ func ChunkStoreForLevel(worldPath string, levelData nbt.ITag, dimension Dimensio
 	return
 }
 
+// Chunk formats that can be chosen by the string chunkFormat in the Data
+// compound of a McRegion world's level.dat. Worlds without a chunkFormat use
+// ChunkFormatRegion.
+const (
+	// Chunks are stored in region files, as read by Notchian servers.
+	ChunkFormatRegion = "region"
+	// Chunks are stored in append-only segment files, which keep older
+	// versions of chunks for a time.
+	ChunkFormatLog = "log"
+)
+
+func chunkStoreForFormat(worldPath string, dimension DimensionId, format string, readOnly bool) (store IChunkStoreForeground, err os.Error) {
+	switch format {
+	case ChunkFormatRegion:
+		store, err = openChunkStoreBeta(worldPath, dimension, readOnly)
+	case ChunkFormatLog:
+		store, err = openChunkStoreLog(worldPath, dimension, readOnly)
+	default:
+		err = UnknownChunkFormat(format)
+	}
+
+	return
+}
+
+type UnknownChunkFormat string
+
+func (err UnknownChunkFormat) String() string {
+	return fmt.Sprintf("Unknown chunk format %q", string(err))
+}
+
 type UnknownLevelVersion int32
 
 func (err UnknownLevelVersion) String() string {
@@ -123,3 +229,7 @@ type NoSuchChunkError bool
 func (err NoSuchChunkError) String() string {
 	return "Chunk does not exist."
 }
+
+// errReadOnlyStore is returned when writing to a store that was opened
+// read-only.
+var errReadOnlyStore = os.NewError("The chunk store is read-only.")
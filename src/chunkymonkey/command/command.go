@@ -14,8 +14,15 @@ type Command struct {
 	Description string          // A description of what the command does.
 	Usage       string          // A usage string for the command.
 	Callback    CommandCallback // This function will be called if a Message begins with the CommandPrefix and the Trigger.
+	Permission  string          // The permission node needed to use the command, or "" if any player may.
 }
 
 func NewCommand(trigger, desc, usage string, callback CommandCallback) *Command {
 	return &Command{Trigger: trigger, Description: desc, Usage: usage, Callback: callback}
 }
+
+// NewRestrictedCommand creates a command that can only be used by players with
+// the given permission node.
+func NewRestrictedCommand(trigger, desc, usage, permission string, callback CommandCallback) *Command {
+	return &Command{Trigger: trigger, Description: desc, Usage: usage, Callback: callback, Permission: permission}
+}
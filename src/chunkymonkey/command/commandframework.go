@@ -9,6 +9,8 @@ import (
 
 var ErrCmdExists = os.NewError("The command already exists.")
 
+const msgPermissionDenied = "You do not have permission to use this command."
+
 // The CommandFramework handles all message based commands.
 // It uses channels to safly handle multiple calls.
 type CommandFramework struct {
@@ -44,6 +46,10 @@ func (cf *CommandFramework) Process(player gamerules.IPlayerClient, message stri
 	attr := strings.Split(message, " ")
 	trigger := attr[0][1:]
 	if cmd, ok := cf.cmds[trigger]; ok {
+		if cmd.Permission != "" && !player.HasPermission(cmd.Permission) {
+			player.EchoMessage(msgPermissionDenied)
+			return
+		}
 		cmd.Callback(player, message, game)
 	}
 }
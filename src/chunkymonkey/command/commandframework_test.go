@@ -6,6 +6,7 @@ import (
 	"gomock.googlecode.com/hg/gomock"
 
 	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
 	"testmatcher"
 )
 
@@ -21,6 +22,9 @@ func TestCommandFramework(t *testing.T) {
 
 	cf := NewCommandFramework("/")
 
+	// mockPlayer may use every command.
+	mockPlayer.EXPECT().HasPermission(gomock.Any()).Return(true).AnyTimes()
+
 	mockGame.EXPECT().BroadcastMessage("§dthis is a broadcast")
 	cf.Process(mockPlayer, "/say this is a broadcast", mockGame)
 
@@ -44,6 +48,58 @@ func TestCommandFramework(t *testing.T) {
 	mockPlayer.EXPECT().EchoMessage("Cannot give more than 512 items at once")
 	cf.Process(mockPlayer, "/give otherPlayer 1 513", mockGame)
 
+	mockGame.EXPECT().SetWeather(true, true)
+	mockPlayer.EXPECT().EchoMessage("Weather changed to thunder")
+	cf.Process(mockPlayer, "/weather thunder", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("weather <clear|rain|thunder>")
+	cf.Process(mockPlayer, "/weather snow", mockGame)
+
+	mockOther.EXPECT().HasPermission("admin.commands.weather").Return(false)
+	mockOther.EXPECT().EchoMessage("You do not have permission to use this command.")
+	cf.Process(mockOther, "/weather clear", mockGame)
+
+	mockGame.EXPECT().WorldNames().Return([]string{"survival", "creative"})
+	mockPlayer.EXPECT().EchoMessage("Worlds: survival, creative")
+	cf.Process(mockPlayer, "/world", mockGame)
+
+	mockGame.EXPECT().ChangePlayerWorld(mockPlayer, "creative").Return(true)
+	mockPlayer.EXPECT().EchoMessage("Moving to world creative")
+	cf.Process(mockPlayer, "/world creative", mockGame)
+
+	mockGame.EXPECT().ChangePlayerWorld(mockPlayer, "nowhere").Return(false)
+	mockPlayer.EXPECT().EchoMessage("Unknown world 'nowhere'")
+	cf.Process(mockPlayer, "/world nowhere", mockGame)
+
+	mockOther.EXPECT().HasPermission("user.commands.world").Return(false)
+	mockOther.EXPECT().EchoMessage("You do not have permission to use this command.")
+	cf.Process(mockOther, "/world creative", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("Making a snapshot of the worlds")
+	mockGame.EXPECT().SnapshotWorlds(mockPlayer)
+	cf.Process(mockPlayer, "/snapshot", mockGame)
+
+	mockOther.EXPECT().HasPermission("admin.commands.snapshot").Return(false)
+	mockOther.EXPECT().EchoMessage("You do not have permission to use this command.")
+	cf.Process(mockOther, "/snapshot", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("Restoring chunks -1,0 to 2,3 from snapshot 20111105-120000")
+	mockGame.EXPECT().RestoreChunks(mockPlayer, "20111105-120000", ChunkXz{-1, 0}, ChunkXz{2, 3})
+	cf.Process(mockPlayer, "/restore 20111105-120000 -1 15 40 63", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("Cannot restore more than 1024 chunks at once")
+	cf.Process(mockPlayer, "/restore 20111105-120000 0 0 1000 1000", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("restore <snapshot> <x1> <z1> [<x2> <z2>]")
+	cf.Process(mockPlayer, "/restore ../../worlds/creative 0 0", mockGame)
+
+	mockPlayer.EXPECT().EchoMessage("restore <snapshot> <x1> <z1> [<x2> <z2>]")
+	cf.Process(mockPlayer, "/restore .. 0 0", mockGame)
+
+	mockOther.EXPECT().HasPermission("admin.commands.restore").Return(false)
+	mockOther.EXPECT().EchoMessage("You do not have permission to use this command.")
+	cf.Process(mockOther, "/restore 20111105-120000 0 0", mockGame)
+
 	mockPlayer.EXPECT().EchoMessage(&testmatcher.StringPrefix{"Commands:"})
 	cf.Process(mockPlayer, "/help", mockGame)
 
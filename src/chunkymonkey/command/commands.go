@@ -17,6 +17,10 @@ func getCommands() map[string]*Command {
 	cmds[killCmd] = NewCommand(killCmd, killDesc, killUsage, cmdKill)
 	cmds[tellCmd] = NewCommand(tellCmd, tellDesc, tellUsage, cmdTell)
 	cmds[giveCmd] = NewCommand(giveCmd, giveDesc, giveUsage, cmdGive)
+	cmds[weatherCmd] = NewRestrictedCommand(weatherCmd, weatherDesc, weatherUsage, weatherPermission, cmdWeather)
+	cmds[worldCmd] = NewRestrictedCommand(worldCmd, worldDesc, worldUsage, worldPermission, cmdWorld)
+	cmds[snapshotCmd] = NewRestrictedCommand(snapshotCmd, snapshotDesc, snapshotUsage, snapshotPermission, cmdSnapshot)
+	cmds[restoreCmd] = NewRestrictedCommand(restoreCmd, restoreDesc, restoreUsage, restorePermission, cmdRestore)
 	return cmds
 }
 
@@ -222,3 +226,131 @@ func cmdGive(player gamerules.IPlayerClient, message string, cmdHandler gamerule
 		target.EchoMessage(msg)
 	}
 }
+
+// /weather clear|rain|thunder
+const weatherCmd = "weather"
+const weatherUsage = "weather <clear|rain|thunder>"
+const weatherDesc = "Changes the weather."
+const weatherPermission = "admin.commands.weather"
+
+func cmdWeather(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	if len(args) != 2 {
+		player.EchoMessage(weatherUsage)
+		return
+	}
+
+	switch args[1] {
+	case "clear":
+		cmdHandler.SetWeather(false, false)
+	case "rain":
+		cmdHandler.SetWeather(true, false)
+	case "thunder":
+		cmdHandler.SetWeather(true, true)
+	default:
+		player.EchoMessage(weatherUsage)
+		return
+	}
+
+	player.EchoMessage("Weather changed to " + args[1])
+}
+
+// /world [name]
+const worldCmd = "world"
+const worldUsage = "world [name]"
+const worldDesc = "Moves to another world, or lists the worlds."
+const worldPermission = "user.commands.world"
+
+func cmdWorld(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	switch len(args) {
+	case 1:
+		player.EchoMessage("Worlds: " + strings.Join(cmdHandler.WorldNames(), ", "))
+	case 2:
+		if !cmdHandler.ChangePlayerWorld(player, args[1]) {
+			player.EchoMessage(fmt.Sprintf("Unknown world '%s'", args[1]))
+			return
+		}
+		player.EchoMessage("Moving to world " + args[1])
+	default:
+		player.EchoMessage(worldUsage)
+	}
+}
+
+// /snapshot
+const snapshotCmd = "snapshot"
+const snapshotUsage = "snapshot"
+const snapshotDesc = "Makes a backup snapshot of the worlds."
+const snapshotPermission = "admin.commands.snapshot"
+
+func cmdSnapshot(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	if len(args) != 1 {
+		player.EchoMessage(snapshotUsage)
+		return
+	}
+
+	player.EchoMessage("Making a snapshot of the worlds")
+	cmdHandler.SnapshotWorlds(player)
+}
+
+// /restore <snapshot> <x1> <z1> [<x2> <z2>]
+const restoreCmd = "restore"
+const restoreUsage = "restore <snapshot> <x1> <z1> [<x2> <z2>]"
+const restoreDesc = "Restores the chunks containing the blocks between (x1, z1) and (x2, z2) from a snapshot of the world."
+const restorePermission = "admin.commands.restore"
+
+// restoreMaxChunks is the largest number of chunks that may be restored by a
+// single command.
+const restoreMaxChunks = 1024
+
+func cmdRestore(player gamerules.IPlayerClient, message string, cmdHandler gamerules.IGame) {
+	args := strings.Split(message, " ")
+	if len(args) != 4 && len(args) != 6 {
+		player.EchoMessage(restoreUsage)
+		return
+	}
+
+	// A snapshot is named by the time it was made, and is found in the
+	// snapshots of the player's world, so the name is never a path.
+	snapshotName := args[1]
+	if snapshotName == "" || snapshotName == "." || snapshotName == ".." || strings.Contains(snapshotName, "/") {
+		player.EchoMessage(restoreUsage)
+		return
+	}
+
+	var coords []BlockCoord
+	for _, arg := range args[2:] {
+		coord, err := strconv.Atoi(arg)
+		if err != nil {
+			player.EchoMessage(restoreUsage)
+			return
+		}
+		coords = append(coords, BlockCoord(coord))
+	}
+	if len(coords) == 2 {
+		coords = append(coords, coords...)
+	}
+
+	var from, to ChunkXz
+	from.X, _ = coords[0].ToChunkLocalCoord()
+	from.Z, _ = coords[1].ToChunkLocalCoord()
+	to.X, _ = coords[2].ToChunkLocalCoord()
+	to.Z, _ = coords[3].ToChunkLocalCoord()
+
+	width := int(to.X - from.X)
+	if width < 0 {
+		width = -width
+	}
+	depth := int(to.Z - from.Z)
+	if depth < 0 {
+		depth = -depth
+	}
+	if (width+1)*(depth+1) > restoreMaxChunks {
+		player.EchoMessage(fmt.Sprintf("Cannot restore more than %d chunks at once", restoreMaxChunks))
+		return
+	}
+
+	player.EchoMessage(fmt.Sprintf("Restoring chunks %d,%d to %d,%d from snapshot %s", from.X, from.Z, to.X, to.Z, snapshotName))
+	cmdHandler.RestoreChunks(player, snapshotName, from, to)
+}
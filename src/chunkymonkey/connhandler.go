@@ -11,9 +11,7 @@ import (
 	"chunkymonkey/player"
 	"chunkymonkey/proto"
 	"chunkymonkey/server_auth"
-	"chunkymonkey/shardserver"
 	. "chunkymonkey/types"
-	"chunkymonkey/worldstore"
 	"nbt"
 )
 
@@ -45,9 +43,8 @@ type GameInfo struct {
 	serverDesc     string
 	maintenanceMsg string
 	serverId       string
-	shardManager   *shardserver.LocalShardManager
 	entityManager  *EntityManager
-	worldStore     *worldstore.WorldStore
+	world          *player.World // The world that players log in to.
 	authserver     server_auth.IAuthenticator
 }
 
@@ -207,12 +204,12 @@ func (l *pktHandler) handleLogin(conn net.Conn) (err, clientErr os.Error) {
 	entityId := l.gameInfo.entityManager.NewEntity()
 
 	var playerData *nbt.Compound
-	if playerData, err = l.gameInfo.game.worldStore.PlayerData(l.username); err != nil {
+	if playerData, err = l.gameInfo.world.Store.PlayerData(l.username); err != nil {
 		clientErr = clientErrUserData
 		return
 	}
 
-	player := player.NewPlayer(entityId, l.gameInfo.shardManager, conn, l.username, l.gameInfo.worldStore.SpawnPosition, l.gameInfo.game.playerDisconnect, l.gameInfo.game)
+	player := player.NewPlayer(entityId, l.gameInfo.world, conn, l.username, l.gameInfo.game.playerDisconnect, l.gameInfo.game)
 	if playerData != nil {
 		if err = player.UnmarshalNbt(playerData); err != nil {
 			// Don't let the player log in, as they will only have default inventory
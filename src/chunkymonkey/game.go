@@ -8,6 +8,7 @@ import (
 	"os"
 	"rand"
 	"regexp"
+	"sync"
 	"time"
 
 	"chunkymonkey/command"
@@ -16,9 +17,7 @@ import (
 	"chunkymonkey/player"
 	"chunkymonkey/proto"
 	"chunkymonkey/server_auth"
-	"chunkymonkey/shardserver"
 	. "chunkymonkey/types"
-	"chunkymonkey/worldstore"
 	"nbt"
 )
 
@@ -26,12 +25,21 @@ import (
 // That is: characters that might be abused in filename components, etc.
 var validPlayerUsername = regexp.MustCompile(`^[\-a-zA-Z0-9_]+$`)
 
+// playerQueryTimeout is the time in nanoseconds to wait for a player's
+// goroutine to answer a query, after which the player is assumed to have
+// disconnected.
+const playerQueryTimeout = 5e9
+
 type Game struct {
-	shardManager  *shardserver.LocalShardManager
 	entityManager EntityManager
-	worldStore    *worldstore.WorldStore
 	connHandler   *ConnHandler
 
+	// Worlds hosted by the game, by name. Players join the default world when
+	// they log in.
+	worlds       map[string]*world
+	worldNames   []string
+	defaultWorld *world
+
 	// Mapping between entityId/name and player object
 	players     map[EntityId]*player.Player
 	playerNames map[string]*player.Player
@@ -43,14 +51,19 @@ type Game struct {
 
 	// Server information
 	time           Ticks
+	weather        weather
 	serverId       string
 	maintenanceMsg string // if set, logins are disallowed.
+
+	// snapshotLock is held while a snapshot of the worlds is made.
+	snapshotLock sync.Mutex
 }
 
-func NewGame(worldPath string, listener net.Listener, serverDesc, maintenanceMsg string, maxPlayerCount int) (game *Game, err os.Error) {
-	worldStore, err := worldstore.LoadWorldStore(worldPath)
-	if err != nil {
-		return nil, err
+// NewGame creates a game hosting the worlds in the given directories. The
+// first world is the default world.
+func NewGame(worldPaths []string, listener net.Listener, serverDesc, maintenanceMsg string, maxPlayerCount int) (game *Game, err os.Error) {
+	if len(worldPaths) == 0 {
+		return nil, os.NewError("no worlds given")
 	}
 
 	authserver, err := server_auth.NewServerAuth("http://www.minecraft.net/game/checkserver.jsp")
@@ -64,16 +77,41 @@ func NewGame(worldPath string, listener net.Listener, serverDesc, maintenanceMsg
 		workQueue:        make(chan func(*Game), 256),
 		playerConnect:    make(chan *player.Player),
 		playerDisconnect: make(chan EntityId),
-		time:             worldStore.Time,
-		worldStore:       worldStore,
+		worlds:           make(map[string]*world),
 	}
 
 	game.entityManager.Init()
 
-	game.serverId = fmt.Sprintf("%016x", rand.NewSource(worldStore.Seed).Int63())
-	//game.serverId = "-"
+	for _, worldPath := range worldPaths {
+		var w *world
+		if w, err = loadWorld(worldPath, &game.entityManager); err != nil {
+			return nil, err
+		}
+		if _, exists := game.worlds[w.name]; exists {
+			return nil, fmt.Errorf("more than one world named %q", w.name)
+		}
+		game.worlds[w.name] = w
+		game.worldNames = append(game.worldNames, w.name)
+	}
+	game.defaultWorld = game.worlds[game.worldNames[0]]
+
+	// The time and weather are shared by all worlds, and taken from the
+	// default world.
+	defaultStore := game.defaultWorld.store
+	game.time = defaultStore.Time
+	game.weather = weather{
+		raining:     defaultStore.Raining,
+		rainTime:    defaultStore.RainTime,
+		thundering:  defaultStore.Thundering,
+		thunderTime: defaultStore.ThunderTime,
+		rand:        rand.New(rand.NewSource(time.Nanoseconds())),
+	}
+	for _, w := range game.worlds {
+		w.setWeather(game.weather.raining, game.weather.thundering)
+	}
 
-	game.shardManager = shardserver.NewLocalShardManager(worldStore.ChunkStore, &game.entityManager)
+	game.serverId = fmt.Sprintf("%016x", rand.NewSource(defaultStore.Seed).Int63())
+	//game.serverId = "-"
 
 	// TODO: Load the prefix from a config file
 	gamerules.CommandFramework = command.NewCommandFramework("/")
@@ -85,9 +123,8 @@ func NewGame(worldPath string, listener net.Listener, serverDesc, maintenanceMsg
 		serverDesc:     serverDesc,
 		maintenanceMsg: maintenanceMsg,
 		serverId:       game.serverId,
-		shardManager:   game.shardManager,
 		entityManager:  &game.entityManager,
-		worldStore:     game.worldStore,
+		world:          &game.defaultWorld.playerWorld,
 		authserver:     authserver,
 	})
 
@@ -118,6 +155,14 @@ func (game *Game) Serve() {
 func (game *Game) onPlayerConnect(newPlayer *player.Player) {
 	game.players[newPlayer.GetEntityId()] = newPlayer
 	game.playerNames[newPlayer.Name()] = newPlayer
+
+	if game.weather.raining {
+		// Enqueued so that it is sent after the player's login packet.
+		packet := game.weatherPacket()
+		newPlayer.Enqueue(func(p *player.Player) {
+			p.TransmitPacket(packet)
+		})
+	}
 }
 
 // A player has disconnected from the server
@@ -133,7 +178,13 @@ func (game *Game) onPlayerDisconnect(entityId EntityId) {
 		return
 	}
 
-	if err := game.worldStore.WritePlayerData(oldPlayer.Name(), playerData); err != nil {
+	game.writePlayerData(oldPlayer.WorldName(), oldPlayer.Name(), playerData)
+}
+
+// writePlayerData writes the data of a player to the world that they are in.
+func (game *Game) writePlayerData(worldName, playerName string, playerData *nbt.Compound) {
+	store := game.worlds[worldName].store
+	if err := store.WritePlayerData(playerName, playerData); err != nil {
 		log.Printf("Failed when writing player data: %v", err)
 	}
 }
@@ -142,6 +193,15 @@ func (game *Game) onTick() {
 	game.time++
 	if game.time%TicksPerSecond == 0 {
 		game.sendTimeUpdate()
+		for _, w := range game.worlds {
+			w.setTime(game.time)
+		}
+	}
+
+	game.weatherTick()
+
+	if game.time%ticksBetweenLevelSaves == 0 {
+		game.saveLevelData()
 	}
 }
 
@@ -212,6 +272,32 @@ func (game *Game) PlayerByEntityId(id EntityId) gamerules.IPlayerClient {
 	return <-result
 }
 
+func (game *Game) SetWeather(raining, thundering bool) {
+	game.enqueue(func(_ *Game) {
+		wasRaining := game.weather.raining
+		game.weather.set(raining, thundering)
+		game.weatherChanged(wasRaining)
+	})
+}
+
+func (game *Game) WorldNames() []string {
+	return game.worldNames
+}
+
+func (game *Game) ChangePlayerWorld(client gamerules.IPlayerClient, worldName string) bool {
+	result := make(chan bool)
+	game.enqueue(func(_ *Game) {
+		w, ok := game.worlds[worldName]
+		if ok {
+			if p, isPlayer := game.players[client.GetEntityId()]; isPlayer {
+				p.ChangeWorld(&w.playerWorld)
+			}
+		}
+		result <- ok
+	})
+	return <-result
+}
+
 func (game *Game) PlayerByName(name string) gamerules.IPlayerClient {
 	result := make(chan gamerules.IPlayerClient)
 	game.enqueue(func(_ *Game) {
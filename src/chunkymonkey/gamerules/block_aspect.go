@@ -27,6 +27,14 @@ type IChunkBlock interface {
 
 	// AddActiveBlockIndex flags a block in the chunk itself as active by index.
 	AddActiveBlockIndex(blockIndex types.BlockIndex)
+
+	// BlockInstance returns the BlockInstance for a block in the chunk, or in
+	// a neighbouring chunk if it is available. ok is false if the block cannot
+	// be looked up.
+	BlockInstance(blockLoc *types.BlockXyz) (instance *BlockInstance, ok bool)
+
+	// RainingAt returns true if rain is falling on the block in the chunk.
+	RainingAt(blockIndex types.BlockIndex) bool
 }
 
 // IUnsubscribed is the interface by which blocks (and potentially other
@@ -84,3 +92,11 @@ type IBlockAspect interface {
 	// if the block should not tick again.
 	Tick(instance *BlockInstance) bool
 }
+
+// IIgnitable is implemented by block aspects that can be set alight, such as
+// TNT.
+type IIgnitable interface {
+	// Ignite lights the block. fuse is the number of ticks until the block
+	// explodes, with zero meaning the aspect's default.
+	Ignite(instance *BlockInstance, fuse types.Ticks)
+}
@@ -0,0 +1,37 @@
+package gamerules
+
+const (
+	// Cauldrons hold up to this many levels of water (stored in the block data).
+	cauldronMaxLevel = 3
+
+	// While rain falls on a cauldron it fills by a level on average once every
+	// cauldronFillChance ticks.
+	cauldronFillChance = 600
+)
+
+func makeCauldronAspect() (aspect IBlockAspect) {
+	return &CauldronAspect{}
+}
+
+// CauldronAspect is the behaviour of cauldrons, which slowly fill with water
+// when rained on.
+type CauldronAspect struct {
+	StandardAspect
+}
+
+func (aspect *CauldronAspect) Name() string {
+	return "Cauldron"
+}
+
+func (aspect *CauldronAspect) Tick(instance *BlockInstance) bool {
+	if instance.Data >= cauldronMaxLevel {
+		return false
+	}
+
+	if instance.Chunk.RainingAt(instance.Index) && instance.Chunk.Rand().Intn(cauldronFillChance) == 0 {
+		instance.Data++
+		instance.Chunk.SetBlockByIndex(instance.Index, aspect.blockAttrs.id, instance.Data)
+	}
+
+	return true
+}
@@ -17,6 +17,17 @@ func NewChestTileEntity() ITileEntity {
 	return createChestInventory(nil)
 }
 
+// NewChestTileEntityAt creates a chest at blockLoc holding the given items,
+// which are indexed by slot. SetChunk must be called before any other methods.
+func NewChestTileEntityAt(blockLoc types.BlockXyz, items []Slot) ITileEntity {
+	inv := NewChestInventory()
+	copy(inv.slots, items)
+
+	blkInv := newBlockInventory(nil, inv, false, types.InvTypeIdChest)
+	blkInv.blockLoc = blockLoc
+	return blkInv
+}
+
 func createChestInventory(instance *BlockInstance) *blockInventory {
 	return newBlockInventory(
 		instance,
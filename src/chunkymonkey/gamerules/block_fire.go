@@ -0,0 +1,218 @@
+package gamerules
+
+import (
+	"chunkymonkey/types"
+)
+
+const (
+	// Fire only updates on average once every fireTickChance ticks.
+	fireTickChance = 30
+
+	// Age (stored in the block data) at which fire may burn out.
+	fireMaxAge = 15
+
+	// Denominators for the chance of burning a neighbouring block. Blocks above
+	// and below burn more readily than those to the side.
+	fireBurnChanceSide     = 300
+	fireBurnChanceVertical = 250
+)
+
+func makeFireAspect() (aspect IBlockAspect) {
+	return &FireAspect{}
+}
+
+// FireAspect is the behaviour of fire. Fire ages over time and eventually
+// burns out (or is put out by rain), destroys flammable blocks adjacent to it,
+// and spreads into air blocks next to flammable blocks. The likelihood of a
+// block catching fire is controlled by its Flammability attribute, and the
+// likelihood of it being destroyed by its BurnChance attribute.
+type FireAspect struct {
+	StandardAspect
+	// Fire on top of any of these block IDs never burns out and does not
+	// spread. (Block IDs are held as ints as the json package would otherwise
+	// encode them as a byte string).
+	EternalOn []int
+	// Fire adjacent to any of these block IDs is put out.
+	ExtinguishedBy []int
+}
+
+func (aspect *FireAspect) Name() string {
+	return "Fire"
+}
+
+// fireNeighbours are the offsets of blocks adjacent to a fire block.
+var fireNeighbours = []struct {
+	dx, dy, dz int
+	chance     int
+}{
+	{-1, 0, 0, fireBurnChanceSide},
+	{1, 0, 0, fireBurnChanceSide},
+	{0, -1, 0, fireBurnChanceVertical},
+	{0, 1, 0, fireBurnChanceVertical},
+	{0, 0, -1, fireBurnChanceSide},
+	{0, 0, 1, fireBurnChanceSide},
+}
+
+func (aspect *FireAspect) Tick(instance *BlockInstance) bool {
+	rand := instance.Chunk.Rand()
+	if rand.Intn(fireTickChance) != 0 {
+		return true
+	}
+
+	if below, ok := neighbourInstance(instance, 0, -1, 0); ok && blockIdIn(below.BlockType.id, aspect.EternalOn) {
+		return true
+	}
+
+	if instance.Chunk.RainingAt(instance.Index) {
+		aspect.extinguish(instance)
+		return false
+	}
+
+	for _, n := range fireNeighbours {
+		if neighbour, ok := neighbourInstance(instance, n.dx, n.dy, n.dz); ok && blockIdIn(neighbour.BlockType.id, aspect.ExtinguishedBy) {
+			aspect.extinguish(instance)
+			return false
+		}
+	}
+
+	age := instance.Data
+	if age < fireMaxAge {
+		age += byte(rand.Intn(3) / 2)
+		instance.Chunk.SetBlockByIndex(instance.Index, instance.BlockType.id, age)
+		instance.Data = age
+	}
+
+	if !aspect.hasFlammableNeighbour(instance) {
+		below, ok := neighbourInstance(instance, 0, -1, 0)
+		if !ok || !below.BlockType.Solid || age > 3 {
+			aspect.extinguish(instance)
+			return false
+		}
+	} else if age == fireMaxAge && rand.Intn(4) == 0 {
+		if below, ok := neighbourInstance(instance, 0, -1, 0); !ok || below.BlockType.BurnChance == 0 {
+			aspect.extinguish(instance)
+			return false
+		}
+	}
+
+	for _, n := range fireNeighbours {
+		aspect.burnNeighbour(instance, n.dx, n.dy, n.dz, n.chance)
+	}
+
+	aspect.spread(instance)
+
+	return true
+}
+
+func (aspect *FireAspect) extinguish(instance *BlockInstance) {
+	instance.Chunk.SetBlockByIndex(instance.Index, types.BlockIdAir, 0)
+}
+
+// hasFlammableNeighbour returns true if any block adjacent to the given block
+// can catch fire.
+func (aspect *FireAspect) hasFlammableNeighbour(instance *BlockInstance) bool {
+	for _, n := range fireNeighbours {
+		if neighbour, ok := neighbourInstance(instance, n.dx, n.dy, n.dz); ok && neighbour.BlockType.Flammability > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// burnNeighbour potentially destroys the adjacent block, possibly replacing it
+// with fire. Ignitable blocks (such as TNT) are ignited instead.
+func (aspect *FireAspect) burnNeighbour(instance *BlockInstance, dx, dy, dz int, chance int) {
+	neighbour, ok := neighbourInstance(instance, dx, dy, dz)
+	if !ok || neighbour.BlockType.BurnChance == 0 {
+		return
+	}
+
+	rand := instance.Chunk.Rand()
+	if rand.Intn(chance) >= neighbour.BlockType.BurnChance {
+		return
+	}
+
+	if ignitable, ok := neighbour.BlockType.Aspect.(IIgnitable); ok {
+		ignitable.Ignite(neighbour, 0)
+		return
+	}
+
+	if rand.Intn(int(instance.Data)+10) < 5 {
+		age := instance.Data + byte(rand.Intn(5)/4)
+		if age > fireMaxAge {
+			age = fireMaxAge
+		}
+		aspect.setFire(neighbour, age)
+	} else {
+		neighbour.Chunk.SetBlockByIndex(neighbour.Index, types.BlockIdAir, 0)
+	}
+}
+
+// spread potentially sets fire to air blocks near the fire that are next to
+// flammable blocks.
+func (aspect *FireAspect) spread(instance *BlockInstance) {
+	rand := instance.Chunk.Rand()
+
+	for dx := -1; dx <= 1; dx++ {
+		for dz := -1; dz <= 1; dz++ {
+			for dy := -1; dy <= 4; dy++ {
+				if dx == 0 && dy == 0 && dz == 0 {
+					continue
+				}
+
+				target, ok := neighbourInstance(instance, dx, dy, dz)
+				if !ok || target.BlockType.id != types.BlockIdAir {
+					continue
+				}
+
+				flammability := 0
+				for _, n := range fireNeighbours {
+					if neighbour, ok := neighbourInstance(target, n.dx, n.dy, n.dz); ok && neighbour.BlockType.Flammability > flammability {
+						flammability = neighbour.BlockType.Flammability
+					}
+				}
+				if flammability == 0 {
+					continue
+				}
+
+				// Fire spreads upwards more readily than downwards.
+				chance := 100
+				if dy > 1 {
+					chance += (dy - 1) * 100
+				}
+
+				if rand.Intn(chance) < (flammability+40)/(int(instance.Data)+30) {
+					age := instance.Data + byte(rand.Intn(5)/4)
+					if age > fireMaxAge {
+						age = fireMaxAge
+					}
+					aspect.setFire(target, age)
+				}
+			}
+		}
+	}
+}
+
+func (aspect *FireAspect) setFire(instance *BlockInstance, age byte) {
+	instance.Chunk.SetBlockByIndex(instance.Index, aspect.blockAttrs.id, age)
+	instance.Chunk.AddActiveBlockIndex(instance.Index)
+}
+
+// neighbourInstance returns the BlockInstance for the block at the given
+// offset from instance, if it is available.
+func neighbourInstance(instance *BlockInstance, dx, dy, dz int) (neighbour *BlockInstance, ok bool) {
+	blockLoc := instance.BlockLoc.AddXyz(types.BlockCoord(dx), types.BlockYCoord(dy), types.BlockCoord(dz))
+	if blockLoc == nil {
+		return nil, false
+	}
+	return instance.Chunk.BlockInstance(blockLoc)
+}
+
+func blockIdIn(blockId types.BlockId, blockIds []int) bool {
+	for _, id := range blockIds {
+		if types.BlockId(id) == blockId {
+			return true
+		}
+	}
+	return false
+}
@@ -193,8 +193,10 @@ func LoadBlocksFromFile(filename string) (blockTypes BlockTypeList, err os.Error
 
 func init() {
 	aspectMakers = map[string]aspectMakerFn{
+		"Cauldron":     makeCauldronAspect,
 		"Chest":        makeChestAspect,
 		"Dispenser":    makeDispenserAspect,
+		"Fire":         makeFireAspect,
 		"Furnace":      makeFurnaceAspect,
 		"MobSpawner":   makeMobSpawnerAspect,
 		"Music":        makeMusicAspect,
@@ -202,6 +204,7 @@ func init() {
 		"Sapling":      makeSaplingAspect,
 		"Sign":         makeSignAspect,
 		"Standard":     makeStandardAspect,
+		"Tnt":          makeTntAspect,
 		"Todo":         makeTodoAspect,
 		"Void":         makeVoidAspect,
 		"Workbench":    makeWorkbenchAspect,
@@ -11,6 +11,9 @@ func makeMobSpawnerAspect() (aspect IBlockAspect) {
 	return &MobSpawnerAspect{}
 }
 
+// mobSpawnerInitialDelay is the delay before a new mob spawner first spawns.
+const mobSpawnerInitialDelay = types.Ticks(20)
+
 type mobSpawnerTileEntity struct {
 	tileEntity
 	entityMobType string
@@ -21,6 +24,17 @@ func NewMobSpawnerTileEntity() ITileEntity {
 	return &mobSpawnerTileEntity{}
 }
 
+// NewMobSpawnerTileEntityAt creates a mob spawner at blockLoc that spawns
+// mobs of the named type, e.g "Zombie". SetChunk must be called before any
+// other methods.
+func NewMobSpawnerTileEntityAt(blockLoc types.BlockXyz, entityMobType string) ITileEntity {
+	return &mobSpawnerTileEntity{
+		tileEntity:    tileEntity{blockLoc: blockLoc},
+		entityMobType: entityMobType,
+		delay:         mobSpawnerInitialDelay,
+	}
+}
+
 func (mobSpawner *mobSpawnerTileEntity) UnmarshalNbt(tag *nbt.Compound) (err os.Error) {
 	if err = mobSpawner.tileEntity.UnmarshalNbt(tag); err != nil {
 		return
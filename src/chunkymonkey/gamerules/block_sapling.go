@@ -38,6 +38,10 @@ func (aspect *SaplingAspect) makeTree(instance *BlockInstance) bool {
 	height := minheight + rand.Intn(maxheight-minheight)
 	maxy := loc.Y + types.SubChunkCoord(height)
 
+	// The sapling's data gives the type of tree, which is also the data value
+	// of its wood and leaves.
+	treeType := instance.Data & 0x3
+
 	for y := loc.Y; y < maxy; y++ {
 		loc.Y = y
 		index, ok := loc.BlockIndex()
@@ -45,7 +49,7 @@ func (aspect *SaplingAspect) makeTree(instance *BlockInstance) bool {
 			// TODO: Can't place a block outside chunk boundaries
 			log.Printf("Couldn't place a tree block (%v,%v,%v)", loc.X, loc.Y, loc.Z)
 		} else {
-			instance.Chunk.SetBlockByIndex(index, types.BlockId(17), byte(0))
+			instance.Chunk.SetBlockByIndex(index, types.BlockId(17), treeType)
 		}
 	}
 
@@ -77,7 +81,7 @@ func (aspect *SaplingAspect) makeTree(instance *BlockInstance) bool {
 						// TODO: Can't place a block outside chunk boundaries
 						log.Printf("Couldn't place a leaf block (%v,%v,%v)", loc.X, loc.Y, loc.Z)
 					} else {
-						instance.Chunk.SetBlockByIndex(index, types.BlockId(18), byte(0))
+						instance.Chunk.SetBlockByIndex(index, types.BlockId(18), treeType)
 					}
 				}
 			}
@@ -0,0 +1,44 @@
+package gamerules
+
+import (
+	"fmt"
+	"os"
+
+	"chunkymonkey/types"
+)
+
+func makeTntAspect() (aspect IBlockAspect) {
+	return &TntAspect{}
+}
+
+// TntAspect is the behaviour of TNT blocks. They can be dug up like a
+// standard block, but when ignited they turn into an ActivatedTnt entity which
+// explodes once its fuse runs out.
+type TntAspect struct {
+	StandardAspect
+	// Fuse is the number of ticks between being ignited by a player and
+	// exploding.
+	Fuse types.Ticks
+}
+
+func (aspect *TntAspect) Name() string {
+	return "Tnt"
+}
+
+func (aspect *TntAspect) Check() os.Error {
+	if aspect.Fuse <= 0 {
+		return fmt.Errorf("block %q: Fuse must be positive", aspect.blockAttrs.Name)
+	}
+	return aspect.StandardAspect.Check()
+}
+
+func (aspect *TntAspect) Ignite(instance *BlockInstance, fuse types.Ticks) {
+	if fuse <= 0 {
+		fuse = aspect.Fuse
+	}
+
+	instance.Chunk.SetBlockByIndex(instance.Index, types.BlockIdAir, 0)
+
+	position := instance.BlockLoc.MidPointToAbsXyz()
+	instance.Chunk.AddEntity(NewActivatedTntAt(&position, fuse))
+}
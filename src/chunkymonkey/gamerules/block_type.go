@@ -13,6 +13,14 @@ type BlockAttrs struct {
 	Solid        bool
 	Replaceable  bool
 	Attachable   bool
+	// BlastResistance is how strongly the block resists explosions. An
+	// explosion ray loses (BlastResistance/5 + 0.3) * 0.3 of its intensity
+	// passing through the block.
+	BlastResistance float64
+	// Flammability is how readily the block catches fire from fire next to it.
+	Flammability int
+	// BurnChance is how likely the block is to be destroyed by fire next to it.
+	BurnChance int
 }
 
 // The core information about any block type.
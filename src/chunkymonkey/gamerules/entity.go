@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"chunkymonkey/physics"
+	"chunkymonkey/proto"
 	"chunkymonkey/types"
 	"nbt"
 )
@@ -54,6 +55,50 @@ type INonPlayerEntity interface {
 	Tick(physics.IBlockQuerier) (leftBlock bool)
 }
 
+// IPushable is implemented by entities that can be moved by external forces,
+// such as the blast from an explosion.
+type IPushable interface {
+	AddVelocity(dv *types.AbsVelocity)
+}
+
+// IDamageable is implemented by entities that have health and can be hurt.
+type IDamageable interface {
+	// Damage reduces the health of the entity. It returns true if the entity
+	// has died as a result.
+	Damage(damage types.Health) (dead bool)
+}
+
+// IBurnable is implemented by entities that can be set on fire.
+type IBurnable interface {
+	Burning() bool
+
+	// SetBurning sets the entity on fire for a while, or puts it out.
+	SetBurning(burn bool)
+
+	// BurnTick counts down the time that the entity has left to burn, and
+	// returns the number of ticks left. The entity should be put out once
+	// none are left.
+	BurnTick() (ticksLeft types.Ticks)
+
+	// FormatMetadata returns the entity metadata, which includes the burning
+	// state to send to clients.
+	FormatMetadata() []proto.EntityMetadata
+}
+
+// IExplosive is implemented by entities that explode once their fuse runs
+// out, such as activated TNT and creepers.
+type IExplosive interface {
+	// Ignite lights the fuse of the entity, if it is not already lit.
+	Ignite()
+
+	// Detonated returns true once the fuse has run out and the entity should
+	// explode.
+	Detonated() bool
+
+	// ExplosionPower returns the strength of the resulting explosion.
+	ExplosionPower() float32
+}
+
 // ITileEntity is the interface common to entities that are tile-based.
 type ITileEntity interface {
 	INbtSerializable
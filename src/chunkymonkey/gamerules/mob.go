@@ -20,6 +20,14 @@ func init() {
 	expVarMobSpawnCount = expvar.NewInt("mob-spawn-count")
 }
 
+const (
+	// TODO Per mob type health.
+	mobDefaultHealth = types.Health(10)
+
+	// Time that a mob burns for after it is set on fire.
+	mobBurnTicks = types.Ticks(160)
+)
+
 // When using an object of type Mob or a sub-type, the caller must set an
 // EntityId, most likely obtained from the EntityManager.
 type Mob struct {
@@ -27,6 +35,8 @@ type Mob struct {
 	physics.PointObject
 	mobType types.EntityMobType
 	look    types.LookDegrees
+	health  types.Health
+	fire    types.Ticks // Ticks left burning.
 	// TODO(nictuku): Move to a more structured form.
 	metadata map[byte]byte
 	// TODO: Change to an AABB object when we have that.
@@ -34,6 +44,7 @@ type Mob struct {
 
 func (mob *Mob) Init(id types.EntityMobType) {
 	mob.mobType = id
+	mob.health = mobDefaultHealth
 	mob.metadata = map[byte]byte{
 		0:  byte(0),
 		16: byte(0),
@@ -56,8 +67,11 @@ func (mob *Mob) UnmarshalNbt(tag *nbt.Compound) (err os.Error) {
 	_ = tag.Lookup("AttackTime").(*nbt.Short).Value
 	_ = tag.Lookup("DeathTime").(*nbt.Short).Value
 	_ = tag.Lookup("FallDistance").(*nbt.Float).Value
-	_ = tag.Lookup("Fire").(*nbt.Short).Value
-	_ = tag.Lookup("Health").(*nbt.Short).Value
+	if fire := tag.Lookup("Fire").(*nbt.Short).Value; fire > 0 {
+		mob.SetBurning(true)
+		mob.fire = types.Ticks(fire)
+	}
+	mob.health = types.Health(tag.Lookup("Health").(*nbt.Short).Value)
 	_ = tag.Lookup("HurtTime").(*nbt.Short).Value
 
 	return nil
@@ -81,9 +95,9 @@ func (mob *Mob) MarshalNbt(tag *nbt.Compound) (err os.Error) {
 	tag.Set("AttackTime", &nbt.Short{0})
 	tag.Set("DeathTime", &nbt.Short{0})
 	tag.Set("FallDistance", &nbt.Float{0})
-	tag.Set("Fire", &nbt.Short{0})
-	tag.Set("Health", &nbt.Short{0})
+	tag.Set("Fire", &nbt.Short{int16(mob.fire)})
 	tag.Set("HurtTime", &nbt.Short{0})
+	tag.Set("Health", &nbt.Short{int16(mob.health)})
 	return nil
 }
 
@@ -91,14 +105,35 @@ func (mob *Mob) SetLook(look types.LookDegrees) {
 	mob.look = look
 }
 
+func (mob *Mob) Burning() bool {
+	return mob.metadata[0]&0x01 != 0
+}
+
 func (mob *Mob) SetBurning(burn bool) {
 	if burn {
 		mob.metadata[0] |= 0x01
+		mob.fire = mobBurnTicks
 	} else {
-		mob.metadata[0] ^= 0x01
+		mob.metadata[0] &^= 0x01
+		mob.fire = 0
 	}
 }
 
+func (mob *Mob) BurnTick() (ticksLeft types.Ticks) {
+	if mob.fire > 0 {
+		mob.fire--
+	}
+	return mob.fire
+}
+
+func (mob *Mob) Damage(damage types.Health) (dead bool) {
+	mob.health -= damage
+	if mob.health < 0 {
+		mob.health = 0
+	}
+	return mob.health == 0
+}
+
 func (mob *Mob) Tick(blockQuerier physics.IBlockQuerier) (leftBlock bool) {
 	// TODO: Spontaneous mob movement.
 	return mob.PointObject.Tick(blockQuerier)
@@ -150,21 +185,64 @@ func (mob *Mob) SendSpawn(writer io.Writer) (err os.Error) {
 
 type Creeper struct {
 	Mob
+	fuse    types.Ticks
+	fuseLit bool
 }
 
 var (
 	creeperNormal   = byte(0)
 	creeperBlueAura = byte(1)
+
+	creeperFuseIdle = byte(255)
+	creeperFuseLit  = byte(1)
+)
+
+const (
+	creeperFuseTicks      = types.Ticks(30)
+	creeperPower          = 3
+	creeperBlueAuraFactor = 2
 )
 
 func NewCreeper() INonPlayerEntity {
 	c := new(Creeper)
 	c.Mob.Init(CreeperType.Id)
 	c.Mob.metadata[17] = creeperNormal
-	c.Mob.metadata[16] = byte(255)
+	c.Mob.metadata[16] = creeperFuseIdle
 	return c
 }
 
+func (c *Creeper) Tick(blockQuerier physics.IBlockQuerier) (leftBlock bool) {
+	if c.fuseLit && c.fuse > 0 {
+		c.fuse--
+	}
+	return c.Mob.Tick(blockQuerier)
+}
+
+func (c *Creeper) Ignite() {
+	if c.fuseLit {
+		return
+	}
+	c.fuseLit = true
+	c.fuse = creeperFuseTicks
+	c.Mob.metadata[16] = creeperFuseLit
+}
+
+// Ignited returns true if the creeper's fuse has been lit.
+func (c *Creeper) Ignited() bool {
+	return c.fuseLit
+}
+
+func (c *Creeper) Detonated() bool {
+	return c.fuseLit && c.fuse <= 0
+}
+
+func (c *Creeper) ExplosionPower() float32 {
+	if c.Mob.metadata[17] == creeperBlueAura {
+		return creeperPower * creeperBlueAuraFactor
+	}
+	return creeperPower
+}
+
 func (c *Creeper) SetNormalStatus() {
 	c.Mob.metadata[17] = creeperNormal
 }
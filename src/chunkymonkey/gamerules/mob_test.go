@@ -89,3 +89,61 @@ func TestMobSpawn(t *testing.T) {
 		}
 	}
 }
+
+type airBlockQuerier struct{}
+
+func (q airBlockQuerier) BlockQuery(blockLoc types.BlockXyz) (isSolid bool, isWithinChunk bool) {
+	return false, true
+}
+
+func TestCreeperFuse(t *testing.T) {
+	c := NewCreeper().(*Creeper)
+	c.PointObject.Init(&types.AbsXyz{8, 70, 8}, &types.AbsVelocity{})
+
+	for i := 0; i < 100; i++ {
+		c.Tick(airBlockQuerier{})
+	}
+	if c.Detonated() {
+		t.Fatalf("unlit creeper detonated")
+	}
+
+	c.Ignite()
+	for i := types.Ticks(1); i < creeperFuseTicks; i++ {
+		c.Tick(airBlockQuerier{})
+		if c.Detonated() {
+			t.Fatalf("creeper detonated early after %d ticks", i)
+		}
+	}
+	c.Tick(airBlockQuerier{})
+	if !c.Detonated() {
+		t.Errorf("creeper did not detonate after %d ticks", creeperFuseTicks)
+	}
+
+	if power := c.ExplosionPower(); power != creeperPower {
+		t.Errorf("expected power %v, got %v", creeperPower, power)
+	}
+	c.CreeperSetBlueAura()
+	if power := c.ExplosionPower(); power != creeperPower*creeperBlueAuraFactor {
+		t.Errorf("expected charged power %v, got %v", creeperPower*creeperBlueAuraFactor, power)
+	}
+}
+
+func TestMobBurnTick(t *testing.T) {
+	m := NewPig().(*Pig)
+	m.SetBurning(true)
+
+	for i := types.Ticks(1); i < mobBurnTicks; i++ {
+		if ticksLeft := m.BurnTick(); ticksLeft != mobBurnTicks-i {
+			t.Fatalf("expected %d ticks left but got %d", mobBurnTicks-i, ticksLeft)
+		}
+	}
+	if ticksLeft := m.BurnTick(); ticksLeft != 0 {
+		t.Errorf("expected the fire to burn out but got %d ticks left", ticksLeft)
+	}
+
+	m.SetBurning(true)
+	m.SetBurning(false)
+	if m.Burning() || m.BurnTick() != 0 {
+		t.Errorf("expected the fire to be put out")
+	}
+}
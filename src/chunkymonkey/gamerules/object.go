@@ -105,7 +105,22 @@ func NewPoweredCart() INonPlayerEntity {
 }
 
 func NewActivatedTnt() INonPlayerEntity {
-	return NewObject(types.ObjTypeIdActivatedTnt)
+	tnt := &ActivatedTnt{
+		Object: *NewObject(types.ObjTypeIdActivatedTnt),
+		fuse:   activatedTntDefaultFuse,
+	}
+	return tnt
+}
+
+// NewActivatedTntAt creates lit TNT at the given position that will explode
+// after fuse ticks.
+func NewActivatedTntAt(position *types.AbsXyz, fuse types.Ticks) *ActivatedTnt {
+	tnt := &ActivatedTnt{
+		Object: *NewObject(types.ObjTypeIdActivatedTnt),
+		fuse:   fuse,
+	}
+	tnt.PointObject.Init(position, &types.AbsVelocity{0, 0, 0})
+	return tnt
 }
 
 func NewArrow() INonPlayerEntity {
@@ -131,3 +146,56 @@ func NewFallingGravel() INonPlayerEntity {
 func NewFishingFloat() INonPlayerEntity {
 	return NewObject(types.ObjTypeIdFishingFloat)
 }
+
+const (
+	activatedTntDefaultFuse = types.Ticks(80)
+	activatedTntPower       = 4
+)
+
+// ActivatedTnt is a lit block of TNT, which falls like any other object until
+// it explodes.
+type ActivatedTnt struct {
+	Object
+	fuse types.Ticks
+}
+
+func (tnt *ActivatedTnt) UnmarshalNbt(tag *nbt.Compound) (err os.Error) {
+	if err = tnt.Object.UnmarshalNbt(tag); err != nil {
+		return
+	}
+
+	if fuseTag, ok := tag.Lookup("Fuse").(*nbt.Byte); !ok {
+		return os.NewError("missing or incorrect type for ActivatedTnt Fuse")
+	} else {
+		tnt.fuse = types.Ticks(fuseTag.Value)
+	}
+
+	return
+}
+
+func (tnt *ActivatedTnt) MarshalNbt(tag *nbt.Compound) (err os.Error) {
+	if err = tnt.Object.MarshalNbt(tag); err != nil {
+		return
+	}
+	tag.Set("Fuse", &nbt.Byte{int8(tnt.fuse)})
+	return
+}
+
+func (tnt *ActivatedTnt) Tick(blockQuerier physics.IBlockQuerier) (leftBlock bool) {
+	if tnt.fuse > 0 {
+		tnt.fuse--
+	}
+	return tnt.Object.PointObject.Tick(blockQuerier)
+}
+
+// Ignite does nothing, as activated TNT is already lit.
+func (tnt *ActivatedTnt) Ignite() {
+}
+
+func (tnt *ActivatedTnt) Detonated() bool {
+	return tnt.fuse <= 0
+}
+
+func (tnt *ActivatedTnt) ExplosionPower() float32 {
+	return activatedTntPower
+}
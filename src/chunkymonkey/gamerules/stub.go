@@ -68,6 +68,12 @@ type IPlayerShardClient interface {
 	// ReqInventoryUnsubscribed requests that the inventory for the block be
 	// unsubscribed to.
 	ReqInventoryUnsubscribed(block types.BlockXyz)
+
+	// ReqLinkPortal requests that the player is moved into the nearest portal
+	// to the target, creating a new portal at the target if there is none
+	// nearby. Used after the player has travelled through a portal from
+	// another dimension.
+	ReqLinkPortal(target types.BlockXyz)
 }
 
 // IShardShardClient provides an interface for shards to make requests against
@@ -79,6 +85,19 @@ type IShardShardClient interface {
 	ReqSetActiveBlocks(blocks []types.BlockXyz)
 
 	ReqTransferEntity(loc types.ChunkXz, entity INonPlayerEntity)
+
+	// ReqExplode continues an explosion centered in another shard. The given
+	// rays are cast on from where they left the other shard. If
+	// affectEntities is true then entities within range of the explosion are
+	// also damaged.
+	ReqExplode(center types.AbsXyz, power float32, rays []ExplosionRay, affectEntities bool)
+}
+
+// ExplosionRay is a ray of an explosion that has crossed into another shard.
+type ExplosionRay struct {
+	X, Y, Z    float64 // Current position.
+	Dx, Dy, Dz float64 // Distance moved per step.
+	Intensity  float64
 }
 
 // IGame provide an interface for interacting with and taking action on the
@@ -99,6 +118,26 @@ type IGame interface {
 	// Return an ItemType from a numeric item. The boolean flag indicates
 	// whether or not 'id' was a valid item type.
 	ItemTypeById(id int) (ItemType, bool)
+
+	// Force the weather into the given state.
+	SetWeather(raining, thundering bool)
+
+	// Return the names of the worlds hosted by the server.
+	WorldNames() []string
+
+	// Move a player into the named world. Returns false if there is no such
+	// world.
+	ChangePlayerWorld(player IPlayerClient, worldName string) bool
+
+	// Make a snapshot of the worlds in the background, and tell the player
+	// where it was made.
+	SnapshotWorlds(player IPlayerClient)
+
+	// Restore the chunks in the area with the chunks from and to at opposite
+	// corners, in the world and dimension that the player is in, from the
+	// snapshot of that world with the given name. This happens in the
+	// background, and the player is told the outcome.
+	RestoreChunks(player IPlayerClient, snapshotName string, from, to types.ChunkXz)
 }
 
 // IShardClient is the interface by which shards communicate to players on
@@ -163,6 +202,17 @@ type IPlayerClient interface {
 
 	// EchoMessage displays a message to the player
 	EchoMessage(msg string)
+
+	// HasPermission returns true if the player has the given permission node.
+	HasPermission(node string) bool
+
+	// InflictDamage reduces the player's health and pushes them with the given
+	// knockback velocity, e.g when caught in an explosion.
+	InflictDamage(damage types.Health, knockback types.AbsVelocity)
+
+	// TransferDimension moves the player into another dimension at the given
+	// position, e.g when they walk into a portal.
+	TransferDimension(dimension types.DimensionId, position types.AbsXyz)
 }
 
 type ICommandFramework interface {
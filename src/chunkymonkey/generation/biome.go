@@ -0,0 +1,242 @@
+package generation
+
+import (
+	. "chunkymonkey/types"
+	"perlin"
+)
+
+type BiomeId byte
+
+const (
+	BiomeIdTundra = BiomeId(iota)
+	BiomeIdTaiga
+	BiomeIdSwampland
+	BiomeIdSavanna
+	BiomeIdShrubland
+	BiomeIdDesert
+	BiomeIdPlains
+	BiomeIdForest
+	BiomeIdRainforest
+)
+
+// Sapling data values, which determine the type of tree that grows.
+const (
+	treeTypeOak    = 0
+	treeTypeSpruce = 1
+	treeTypeBirch  = 2
+)
+
+// Biome describes how the terrain is generated in a region of the world with
+// a particular climate.
+type Biome struct {
+	Id   BiomeId
+	Name string
+
+	// TopBlock covers the surface of land above the beaches, and FillerBlock
+	// lies in a thin layer beneath it.
+	TopBlock    BlockId
+	FillerBlock BlockId
+
+	// If SnowCover is true, the land is covered in snow and water is frozen.
+	SnowCover bool
+
+	// TreeChance is the percentage of grass columns that have a sapling. The
+	// sapling type is chosen from TreeTypes.
+	TreeChance int
+	TreeTypes  []byte
+
+	// Terrain height above sea level is multiplied by HeightScale and raised
+	// by HeightOffset.
+	HeightScale  float64
+	HeightOffset float64
+
+	// AnvilId is the ID of the closest Notchian biome, which is given to the
+	// columns of chunks exported to the Anvil format.
+	AnvilId byte
+}
+
+// Biomes contains all biomes, indexed by BiomeId.
+var Biomes = []*Biome{
+	BiomeIdTundra: &Biome{
+		Id: BiomeIdTundra, Name: "Tundra", AnvilId: 12,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt, SnowCover: true,
+		HeightScale: 0.6, HeightOffset: 2,
+	},
+	BiomeIdTaiga: &Biome{
+		Id: BiomeIdTaiga, Name: "Taiga", AnvilId: 5,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt, SnowCover: true,
+		TreeChance: 6, TreeTypes: []byte{treeTypeSpruce},
+		HeightScale: 1.2, HeightOffset: 4,
+	},
+	BiomeIdSwampland: &Biome{
+		Id: BiomeIdSwampland, Name: "Swampland", AnvilId: 6,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 3, TreeTypes: []byte{treeTypeOak},
+		HeightScale: 0.2, HeightOffset: -1,
+	},
+	BiomeIdSavanna: &Biome{
+		Id: BiomeIdSavanna, Name: "Savanna", AnvilId: 1,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 1, TreeTypes: []byte{treeTypeOak},
+		HeightScale: 0.7, HeightOffset: 1,
+	},
+	BiomeIdShrubland: &Biome{
+		Id: BiomeIdShrubland, Name: "Shrubland", AnvilId: 1,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 2, TreeTypes: []byte{treeTypeOak},
+		HeightScale: 0.8, HeightOffset: 1,
+	},
+	BiomeIdDesert: &Biome{
+		Id: BiomeIdDesert, Name: "Desert", AnvilId: 2,
+		TopBlock: BlockIdSand, FillerBlock: BlockIdSand,
+		HeightScale: 0.5, HeightOffset: 1,
+	},
+	BiomeIdPlains: &Biome{
+		Id: BiomeIdPlains, Name: "Plains", AnvilId: 1,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 1, TreeTypes: []byte{treeTypeOak},
+		HeightScale: 0.6, HeightOffset: 1,
+	},
+	BiomeIdForest: &Biome{
+		Id: BiomeIdForest, Name: "Forest", AnvilId: 4,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 8, TreeTypes: []byte{treeTypeOak, treeTypeOak, treeTypeBirch},
+		HeightScale: 1, HeightOffset: 2,
+	},
+	BiomeIdRainforest: &Biome{
+		Id: BiomeIdRainforest, Name: "Rainforest", AnvilId: 21,
+		TopBlock: BlockIdGrass, FillerBlock: BlockIdDirt,
+		TreeChance: 12, TreeTypes: []byte{treeTypeOak, treeTypeBirch},
+		HeightScale: 1.3, HeightOffset: 3,
+	},
+}
+
+const (
+	// The temperature and rainfall noise fields are derived from the world
+	// seed by multiplying it with these values.
+	temperatureSeedFactor = 9871
+	rainfallSeedFactor    = 39811
+
+	// Biome height modifiers are averaged over a grid of this many blocks
+	// either side of a column, so that the terrain does not have cliffs at
+	// biome borders.
+	biomeBlendDistance = 8
+)
+
+// BiomeSource decides the biome of each column of the world from temperature
+// and rainfall maps derived from the world seed.
+type BiomeSource struct {
+	temperature ISource
+	rainfall    ISource
+}
+
+func NewBiomeSource(seed int64) *BiomeSource {
+	temperature := perlin.NewPerlinNoise(seed * temperatureSeedFactor)
+	rainfall := perlin.NewPerlinNoise(seed * rainfallSeedFactor)
+
+	return &BiomeSource{
+		temperature: &Sum{
+			Inputs: []ISource{
+				&Scale{Wavelength: 400, Amplitude: 1, Source: temperature},
+				&Scale{Wavelength: 40, Amplitude: 0.1, Source: &Offset{17.3, 0, temperature}},
+			},
+		},
+		rainfall: &Sum{
+			Inputs: []ISource{
+				&Scale{Wavelength: 300, Amplitude: 1, Source: rainfall},
+				&Scale{Wavelength: 30, Amplitude: 0.1, Source: &Offset{0, 23.9, rainfall}},
+			},
+		},
+	}
+}
+
+// Climate returns the temperature and rainfall of the column, both in the
+// range [0, 1].
+func (s *BiomeSource) Climate(x, z BlockCoord) (temperature, rainfall float64) {
+	return s.climateAt(float64(x), float64(z))
+}
+
+func (s *BiomeSource) climateAt(x, z float64) (temperature, rainfall float64) {
+	temperature = clampUnit(0.65 + 1.2*s.temperature.At2d(x, z))
+	rainfall = clampUnit(0.5 + 1.2*s.rainfall.At2d(x, z))
+	return
+}
+
+// BiomeAt returns the biome of the column.
+func (s *BiomeSource) BiomeAt(x, z BlockCoord) *Biome {
+	return s.biomeAt(float64(x), float64(z))
+}
+
+func (s *BiomeSource) biomeAt(x, z float64) *Biome {
+	return Biomes[biomeForClimate(s.climateAt(x, z))]
+}
+
+// heightModifiers returns the height scale and offset for the column, blended
+// between the biomes around it.
+func (s *BiomeSource) heightModifiers(x, z float64) (scale, offset float64) {
+	count := 0
+	for dx := -biomeBlendDistance; dx <= biomeBlendDistance; dx += biomeBlendDistance {
+		for dz := -biomeBlendDistance; dz <= biomeBlendDistance; dz += biomeBlendDistance {
+			biome := s.biomeAt(x+float64(dx), z+float64(dz))
+			scale += biome.HeightScale
+			offset += biome.HeightOffset
+			count++
+		}
+	}
+	return scale / float64(count), offset / float64(count)
+}
+
+// chunkBiomes returns the biome of each column in the chunk, in the same
+// order as the chunk's height map.
+func (s *BiomeSource) chunkBiomes(chunkLoc ChunkXz) (biomes []*Biome) {
+	corner := chunkLoc.ChunkCornerBlockXY()
+	biomes = make([]*Biome, 0, ChunkSizeH*ChunkSizeH)
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			biomes = append(biomes, s.BiomeAt(corner.X+BlockCoord(x), corner.Z+BlockCoord(z)))
+		}
+	}
+	return
+}
+
+// biomeForClimate chooses a biome for the given temperature and rainfall.
+func biomeForClimate(temperature, rainfall float64) BiomeId {
+	// Hot places dry out faster.
+	rainfall *= temperature
+
+	switch {
+	case temperature < 0.1:
+		return BiomeIdTundra
+	case rainfall < 0.2:
+		switch {
+		case temperature < 0.5:
+			return BiomeIdTundra
+		case temperature < 0.95:
+			return BiomeIdSavanna
+		}
+		return BiomeIdDesert
+	case rainfall > 0.5 && temperature < 0.7:
+		return BiomeIdSwampland
+	case temperature < 0.5:
+		return BiomeIdTaiga
+	case temperature < 0.97:
+		if rainfall < 0.35 {
+			return BiomeIdShrubland
+		}
+		return BiomeIdForest
+	case rainfall < 0.45:
+		return BiomeIdPlains
+	case rainfall < 0.9:
+		return BiomeIdForest
+	}
+	return BiomeIdRainforest
+}
+
+func clampUnit(v float64) float64 {
+	if v < 0 {
+		return 0
+	} else if v > 1 {
+		return 1
+	}
+	return v
+}
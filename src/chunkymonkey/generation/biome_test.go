@@ -0,0 +1,106 @@
+package generation
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestBiomes_Ids(t *testing.T) {
+	for i, biome := range Biomes {
+		if biome == nil {
+			t.Errorf("biome %d is missing", i)
+			continue
+		}
+		if biome.Id != BiomeId(i) {
+			t.Errorf("biome %q has Id %d, but is at index %d", biome.Name, biome.Id, i)
+		}
+		if biome.TreeChance > 0 && len(biome.TreeTypes) == 0 {
+			t.Errorf("biome %q has trees but no tree types", biome.Name)
+		}
+		if biome.HeightScale <= 0 {
+			t.Errorf("biome %q has non-positive height scale", biome.Name)
+		}
+	}
+}
+
+func TestBiomeForClimate(t *testing.T) {
+	tests := []struct {
+		temperature, rainfall float64
+		want                  BiomeId
+	}{
+		{0.05, 0.5, BiomeIdTundra},
+		{0.3, 0.1, BiomeIdTundra},
+		{0.8, 0.1, BiomeIdSavanna},
+		{1.0, 0.1, BiomeIdDesert},
+		{0.6, 0.95, BiomeIdSwampland},
+		{0.4, 0.6, BiomeIdTaiga},
+		{0.8, 0.3, BiomeIdShrubland},
+		{0.8, 0.6, BiomeIdForest},
+		{1.0, 0.3, BiomeIdPlains},
+		{1.0, 0.7, BiomeIdForest},
+		{1.0, 1.0, BiomeIdRainforest},
+	}
+
+	for _, test := range tests {
+		if got := biomeForClimate(test.temperature, test.rainfall); got != test.want {
+			t.Errorf("biomeForClimate(%v, %v) = %s, want %s",
+				test.temperature, test.rainfall, Biomes[got].Name, Biomes[test.want].Name)
+		}
+	}
+}
+
+func TestBiomeSource_Climate(t *testing.T) {
+	sourceA := NewBiomeSource(10)
+	sourceB := NewBiomeSource(10)
+
+	for x := BlockCoord(-1000); x < 1000; x += 37 {
+		for z := BlockCoord(-1000); z < 1000; z += 41 {
+			temperature, rainfall := sourceA.Climate(x, z)
+			if temperature < 0 || temperature > 1 || rainfall < 0 || rainfall > 1 {
+				t.Fatalf("climate at (%d, %d) out of range: %v, %v", x, z, temperature, rainfall)
+			}
+			if sourceA.BiomeAt(x, z) != sourceB.BiomeAt(x, z) {
+				t.Fatalf("biome at (%d, %d) differs for the same seed", x, z)
+			}
+		}
+	}
+}
+
+func TestAddSnow(t *testing.T) {
+	// This area lies on the border of a snowy biome for seed 0.
+	area := stoneArea(ChunkXz{7, 0})
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			for y := 70; y < ChunkSizeY; y++ {
+				area.SetBlock(x, y, z, BlockIdAir, 0)
+			}
+			area.SetBlock(x, 69, z, BlockIdStationaryWater, 0)
+		}
+	}
+	for _, row := range area.chunks {
+		for _, data := range row {
+			setHeightMap(data)
+		}
+	}
+
+	biomes := NewBiomeSource(0)
+	addSnow(area, biomes)
+
+	frozen := 0
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			inside := x >= populateMin && x < populateMax && z >= populateMin && z < populateMax
+			wantIce := inside && area.biomeAt(biomes, x, z).SnowCover
+			if gotIce := area.BlockAt(x, 69, z) == BlockIdIce; gotIce != wantIce {
+				t.Errorf("(%d, %d): frozen = %v, want %v", x, z, gotIce, wantIce)
+			}
+			if wantIce {
+				frozen++
+			}
+		}
+	}
+	if frozen == 0 {
+		t.Errorf("expected water to freeze in the snowy biome")
+	}
+}
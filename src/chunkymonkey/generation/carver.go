@@ -0,0 +1,227 @@
+package generation
+
+import (
+	"math"
+	"rand"
+
+	. "chunkymonkey/types"
+)
+
+const (
+	// carveRange is the distance in chunks from which a tunnel can reach into
+	// a chunk. Tunnels are walked from the origin of every chunk within this
+	// range, so that they line up across chunk borders without needing the
+	// neighbouring chunks to be generated.
+	carveRange = 8
+
+	// One in caveChance chunks is the origin of a cave system, and one in
+	// ravineChance chunks is the origin of a ravine.
+	caveChance   = 10
+	ravineChance = 50
+
+	caveMaxTunnels = 4
+
+	// Open space carved below this height is filled with lava.
+	caveLavaLevel = 10
+
+	// carverSeedSalt is mixed into the world seed so that the carver's random
+	// decisions differ from the other random decisions made for a chunk.
+	carverSeedSalt = 0x2f0bc3a1d45e7
+)
+
+// caveCarver cuts worm-like cave tunnels and ravines out of generated
+// terrain.
+type caveCarver struct {
+	seed int64
+}
+
+func newCaveCarver(seed int64) *caveCarver {
+	return &caveCarver{
+		seed: seed ^ carverSeedSalt,
+	}
+}
+
+// tunnel is the state of a tunnel as it is walked through the world.
+type tunnel struct {
+	x, y, z    float64
+	yaw, pitch float64
+	radius     float64
+	// vScale is the ratio of the tunnel's height to its width.
+	vScale float64
+	steps  int
+	// branches is true if the tunnel may fork part way along its length.
+	branches bool
+}
+
+// Carve cuts the parts of all tunnels that pass through the chunk.
+func (c *caveCarver) Carve(data *ChunkData) {
+	for dx := -carveRange; dx <= carveRange; dx++ {
+		for dz := -carveRange; dz <= carveRange; dz++ {
+			origin := ChunkXz{
+				X: data.loc.X + ChunkCoord(dx),
+				Z: data.loc.Z + ChunkCoord(dz),
+			}
+			c.carveFrom(origin, chunkRand(c.seed, origin), data)
+		}
+	}
+}
+
+// carveFrom carves the tunnels that start in the origin chunk. The tunnels are
+// always walked in full, regardless of whether they reach the chunk being
+// carved, so that the same random numbers are used for them in every chunk.
+func (c *caveCarver) carveFrom(origin ChunkXz, randGen *rand.Rand, data *ChunkData) {
+	corner := origin.ChunkCornerBlockXY()
+	baseX, baseZ := float64(corner.X), float64(corner.Z)
+
+	if randGen.Intn(caveChance) == 0 {
+		numTunnels := 1 + randGen.Intn(caveMaxTunnels)
+		for i := 0; i < numTunnels; i++ {
+			t := &tunnel{
+				x:        baseX + randGen.Float64()*ChunkSizeH,
+				y:        float64(8 + randGen.Intn(SeaLevel)),
+				z:        baseZ + randGen.Float64()*ChunkSizeH,
+				yaw:      randGen.Float64() * 2 * math.Pi,
+				pitch:    (randGen.Float64() - 0.5) / 2,
+				radius:   1.5 + randGen.Float64()*2,
+				vScale:   1,
+				steps:    60 + randGen.Intn(60),
+				branches: true,
+			}
+			c.carveTunnel(t, randGen, data)
+		}
+	}
+
+	if randGen.Intn(ravineChance) == 0 {
+		t := &tunnel{
+			x:      baseX + randGen.Float64()*ChunkSizeH,
+			y:      float64(20 + randGen.Intn(40)),
+			z:      baseZ + randGen.Float64()*ChunkSizeH,
+			yaw:    randGen.Float64() * 2 * math.Pi,
+			pitch:  (randGen.Float64() - 0.5) / 4,
+			radius: 2 + randGen.Float64()*2,
+			vScale: 3,
+			steps:  80 + randGen.Intn(40),
+		}
+		c.carveTunnel(t, randGen, data)
+	}
+}
+
+// carveTunnel walks the tunnel, carving each step that falls within the
+// chunk.
+func (c *caveCarver) carveTunnel(t *tunnel, randGen *rand.Rand, data *ChunkData) {
+	var yawDelta, pitchDelta float64
+	forkStep := -1
+	if t.branches {
+		forkStep = t.steps/4 + randGen.Intn(t.steps/2)
+	}
+
+	for step := 0; step < t.steps; step++ {
+		if step == forkStep && randGen.Intn(3) == 0 {
+			// Split into two narrower tunnels heading off to either side.
+			for _, turn := range []float64{-math.Pi / 2, math.Pi / 2} {
+				c.carveTunnel(&tunnel{
+					x:      t.x,
+					y:      t.y,
+					z:      t.z,
+					yaw:    t.yaw + turn,
+					pitch:  t.pitch / 3,
+					radius: t.radius * 0.7,
+					vScale: t.vScale,
+					steps:  t.steps - step,
+				}, randGen, data)
+			}
+			return
+		}
+
+		t.x += math.Cos(t.yaw) * math.Cos(t.pitch)
+		t.y += math.Sin(t.pitch)
+		t.z += math.Sin(t.yaw) * math.Cos(t.pitch)
+
+		// Ravines stay mostly level, caves wander up and down.
+		if t.vScale > 1 {
+			t.pitch *= 0.7
+		} else {
+			t.pitch *= 0.92
+		}
+		t.yaw += yawDelta * 0.1
+		t.pitch += pitchDelta * 0.1
+		yawDelta = yawDelta*0.75 + (randGen.Float64()-randGen.Float64())*randGen.Float64()*4
+		pitchDelta = pitchDelta*0.9 + (randGen.Float64()-randGen.Float64())*randGen.Float64()*2
+
+		// Tunnels are narrow at their ends and widest in the middle.
+		radius := 1 + t.radius*math.Sin(float64(step)*math.Pi/float64(t.steps))
+		c.carveEllipsoid(data, t.x, t.y, t.z, radius, radius*t.vScale)
+	}
+}
+
+// carveEllipsoid carves the part of the ellipsoid that falls within the
+// chunk. Stone, dirt and grass are carved, but not blocks directly beneath
+// water, so that the sea and lakes do not hang over caves.
+func (c *caveCarver) carveEllipsoid(data *ChunkData, cx, cy, cz, rh, rv float64) {
+	corner := data.loc.ChunkCornerBlockXY()
+	cx -= float64(corner.X)
+	cz -= float64(corner.Z)
+
+	if cx+rh < 0 || cx-rh >= ChunkSizeH || cz+rh < 0 || cz-rh >= ChunkSizeH {
+		return
+	}
+
+	minX, maxX := clampInt(int(math.Floor(cx-rh)), 0, ChunkSizeH-1), clampInt(int(math.Ceil(cx+rh)), 0, ChunkSizeH-1)
+	minY, maxY := clampInt(int(math.Floor(cy-rv)), 1, ChunkSizeY-2), clampInt(int(math.Ceil(cy+rv)), 1, ChunkSizeY-2)
+	minZ, maxZ := clampInt(int(math.Floor(cz-rh)), 0, ChunkSizeH-1), clampInt(int(math.Ceil(cz+rh)), 0, ChunkSizeH-1)
+
+	for x := minX; x <= maxX; x++ {
+		dx := (float64(x) + 0.5 - cx) / rh
+		for z := minZ; z <= maxZ; z++ {
+			dz := (float64(z) + 0.5 - cz) / rh
+			if dx*dx+dz*dz >= 1 {
+				continue
+			}
+			column := data.blocks[(x*ChunkSizeH+z)*ChunkSizeY:]
+			for y := maxY; y >= minY; y-- {
+				dy := (float64(y) + 0.5 - cy) / rv
+				if dx*dx+dy*dy+dz*dz >= 1 {
+					continue
+				}
+				switch BlockId(column[y]) {
+				case BlockIdStone, BlockIdDirt, BlockIdGrass:
+				default:
+					continue
+				}
+				if column[y+1] == byte(BlockIdStationaryWater) {
+					continue
+				}
+				if y < caveLavaLevel {
+					column[y] = byte(BlockIdLava)
+				} else {
+					column[y] = byte(BlockIdAir)
+				}
+			}
+		}
+	}
+}
+
+// setHeightMap sets the height map of each column to one above its highest
+// non-air block.
+func setHeightMap(data *ChunkData) {
+	for column := range data.heightMap {
+		blocks := data.blocks[column*ChunkSizeY : (column+1)*ChunkSizeY]
+		height := 0
+		for y := ChunkSizeY - 1; y >= 0; y-- {
+			if blocks[y] != byte(BlockIdAir) {
+				height = y + 1
+				break
+			}
+		}
+		data.heightMap[column] = byte(height)
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,72 @@
+package generation
+
+import (
+	"bytes"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func stoneChunk(loc ChunkXz) *ChunkData {
+	data := newChunkData(loc)
+	for i := range data.blocks {
+		data.blocks[i] = byte(BlockIdStone)
+	}
+	return data
+}
+
+func TestCaveCarver_Carve(t *testing.T) {
+	carved := 0
+
+	for x := ChunkCoord(-4); x < 4; x++ {
+		for z := ChunkCoord(-4); z < 4; z++ {
+			loc := ChunkXz{x, z}
+
+			dataA := stoneChunk(loc)
+			newCaveCarver(5).Carve(dataA)
+			dataB := stoneChunk(loc)
+			newCaveCarver(5).Carve(dataB)
+
+			if !bytes.Equal(dataA.blocks, dataB.blocks) {
+				t.Errorf("chunk %v: carved differently with the same seed", loc)
+			}
+
+			for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+				base := column * ChunkSizeY
+				if dataA.blocks[base] != byte(BlockIdStone) || dataA.blocks[base+ChunkSizeY-1] != byte(BlockIdStone) {
+					t.Fatalf("chunk %v: carved the top or bottom of the world", loc)
+				}
+				for y := 1; y < ChunkSizeY-1; y++ {
+					if dataA.blocks[base+y] != byte(BlockIdStone) {
+						carved++
+					}
+				}
+			}
+		}
+	}
+
+	if carved == 0 {
+		t.Errorf("expected some blocks to be carved")
+	}
+}
+
+func TestCaveCarver_UnderWater(t *testing.T) {
+	// Blocks beneath water are never carved.
+	for x := ChunkCoord(-4); x < 4; x++ {
+		loc := ChunkXz{x, 0}
+		data := stoneChunk(loc)
+		for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+			for y := 40; y < ChunkSizeY; y++ {
+				data.blocks[column*ChunkSizeY+y] = byte(BlockIdStationaryWater)
+			}
+		}
+
+		newCaveCarver(5).Carve(data)
+
+		for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+			if data.blocks[column*ChunkSizeY+39] != byte(BlockIdStone) {
+				t.Fatalf("chunk %v: carved block beneath water", loc)
+			}
+		}
+	}
+}
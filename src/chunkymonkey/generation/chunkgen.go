@@ -1,27 +1,28 @@
 package generation
 
 import (
-	"os"
-
 	"chunkymonkey/chunkstore"
 	"chunkymonkey/gamerules"
 	. "chunkymonkey/types"
 	"nbt"
 	"perlin"
-	"rand"
-	"time"
 )
 
 const SeaLevel = 63
 
 // ChunkData implements chunkstore.IChunkReader.
 type ChunkData struct {
-	loc        ChunkXz
-	blocks     []byte
-	blockData  []byte
-	blockLight []byte
-	skyLight   []byte
-	heightMap  []byte
+	loc          ChunkXz
+	blocks       []byte
+	blockData    []byte
+	blockLight   []byte
+	skyLight     []byte
+	heightMap    []byte
+	populated    bool
+	lastUpdate   Ticks
+	timestamp    int64
+	entities     []gamerules.INonPlayerEntity
+	tileEntities []gamerules.ITileEntity
 }
 
 func newChunkData(loc ChunkXz) *ChunkData {
@@ -35,6 +36,24 @@ func newChunkData(loc ChunkXz) *ChunkData {
 	}
 }
 
+// chunkDataFromReader creates ChunkData from a chunk read from a chunk store,
+// so that it can be modified while it is populated.
+func chunkDataFromReader(reader chunkstore.IChunkReader) *ChunkData {
+	return &ChunkData{
+		loc:          reader.ChunkLoc(),
+		blocks:       reader.Blocks(),
+		blockData:    reader.BlockData(),
+		blockLight:   reader.BlockLight(),
+		skyLight:     reader.SkyLight(),
+		heightMap:    reader.HeightMap(),
+		populated:    reader.TerrainPopulated(),
+		lastUpdate:   reader.LastUpdate(),
+		timestamp:    reader.Timestamp(),
+		entities:     reader.Entities(),
+		tileEntities: reader.TileEntities(),
+	}
+}
+
 func (data *ChunkData) ChunkLoc() ChunkXz {
 	return data.loc
 }
@@ -59,34 +78,58 @@ func (data *ChunkData) HeightMap() []byte {
 	return data.heightMap
 }
 
+func (data *ChunkData) TerrainPopulated() bool {
+	return data.populated
+}
+
+func (data *ChunkData) LastUpdate() Ticks {
+	return data.lastUpdate
+}
+
+func (data *ChunkData) Timestamp() int64 {
+	return data.timestamp
+}
+
 func (data *ChunkData) Entities() []gamerules.INonPlayerEntity {
-	return nil
+	return data.entities
 }
 
 func (data *ChunkData) TileEntities() []gamerules.ITileEntity {
-	return nil
+	return data.tileEntities
+}
+
+// removeTileEntityAt removes the tile entity for the block at blockLoc, if
+// there is one.
+func (data *ChunkData) removeTileEntityAt(blockLoc BlockXyz) {
+	for i, tileEntity := range data.tileEntities {
+		if tileEntity.Block() == blockLoc {
+			last := len(data.tileEntities) - 1
+			data.tileEntities[i] = data.tileEntities[last]
+			data.tileEntities = data.tileEntities[:last]
+			return
+		}
+	}
 }
 
 func (data *ChunkData) RootTag() nbt.ITag {
 	return nil
 }
 
-// TestGenerator implements chunkstore.IChunkStore.
+// TestGenerator implements IChunkGenerator.
 type TestGenerator struct {
+	seed         int64
 	heightSource ISource
-	randSource   rand.Source
-	randGen      *rand.Rand
+	biomes       *BiomeSource
+	carver       *caveCarver
 }
 
 func NewTestGenerator(seed int64) *TestGenerator {
 	perlin := perlin.NewPerlinNoise(seed)
 
-	randSource := rand.NewSource(time.Nanoseconds())
-	randGen := rand.New(randSource)
-
 	return &TestGenerator{
-		randSource: randSource,
-		randGen:    randGen,
+		seed:   seed,
+		biomes: NewBiomeSource(seed),
+		carver: newCaveCarver(seed),
 		heightSource: &Sum{
 			Inputs: []ISource{
 				&Turbulence{
@@ -127,31 +170,21 @@ func NewTestGenerator(seed int64) *TestGenerator {
 	}
 }
 
-func (s *TestGenerator) SupportsWrite() bool {
-	return false
-}
-
-func (s *TestGenerator) Writer() chunkstore.IChunkWriter {
-	return nil
-}
-
-func (s *TestGenerator) WriteChunk(writer chunkstore.IChunkWriter) os.Error {
-	return os.NewError("writes not supported by TestGenerator")
-}
-
-func (gen *TestGenerator) ReadChunk(chunkLoc ChunkXz) (reader chunkstore.IChunkReader, err os.Error) {
+func (gen *TestGenerator) GenerateTerrain(chunkLoc ChunkXz) *ChunkData {
 	baseBlockXyz := chunkLoc.ChunkCornerBlockXY()
 
 	baseX, baseZ := baseBlockXyz.X, baseBlockXyz.Z
 
 	data := newChunkData(chunkLoc)
+	biomes := gen.biomes.chunkBiomes(chunkLoc)
 
 	baseIndex := BlockIndex(0)
 	heightMapIndex := 0
 	for x := 0; x < ChunkSizeH; x++ {
 		for z := 0; z < ChunkSizeH; z++ {
 			xf, zf := float64(x)+float64(baseX), float64(z)+float64(baseZ)
-			height := int(SeaLevel + gen.heightSource.At2d(xf, zf))
+			heightScale, heightOffset := gen.biomes.heightModifiers(xf, zf)
+			height := int(SeaLevel + gen.heightSource.At2d(xf, zf)*heightScale + heightOffset)
 
 			if height < 0 {
 				height = 0
@@ -161,6 +194,7 @@ func (gen *TestGenerator) ReadChunk(chunkLoc ChunkXz) (reader chunkstore.IChunkR
 
 			skyLightHeight := gen.setBlockStack(
 				height,
+				biomes[heightMapIndex],
 				data.blocks[baseIndex:baseIndex+ChunkSizeY])
 
 			data.heightMap[heightMapIndex] = byte(skyLightHeight)
@@ -170,14 +204,24 @@ func (gen *TestGenerator) ReadChunk(chunkLoc ChunkXz) (reader chunkstore.IChunkR
 		}
 	}
 
-	// The chunk has been generated, now add some trees if appropriate
-	gen.addSaplings(data)
-	gen.setSkylight(data)
+	gen.carver.Carve(data)
+	setHeightMap(data)
+
+	return data
+}
 
-	return data, nil
+func (gen *TestGenerator) Populate(area *PopulationArea) {
+	randGen := chunkRand(gen.seed, area.Loc)
+	addLakes(area, randGen)
+	addDungeons(area, randGen)
+	addOres(area, randGen)
+	addBeaches(area)
+	addTrees(area, gen.biomes, randGen)
+	addSnow(area, gen.biomes)
+	area.relight()
 }
 
-func (gen *TestGenerator) setBlockStack(height int, blocks []byte) (skyLightHeight int) {
+func (gen *TestGenerator) setBlockStack(height int, biome *Biome, blocks []byte) (skyLightHeight int) {
 	var topBlockType byte
 	if height < SeaLevel+1 {
 		skyLightHeight = SeaLevel + 1
@@ -193,8 +237,8 @@ func (gen *TestGenerator) setBlockStack(height int, blocks []byte) (skyLightHeig
 			blocks[height] = 12 // sand
 			topBlockType = 12
 		} else {
-			blocks[height] = 2 // grass
-			topBlockType = 3   // dirt
+			blocks[height] = byte(biome.TopBlock)
+			topBlockType = byte(biome.FillerBlock)
 		}
 	}
 
@@ -212,13 +256,16 @@ func (gen *TestGenerator) setBlockStack(height int, blocks []byte) (skyLightHeig
 	return
 }
 
-func (gen *TestGenerator) setSkyLightStack(skyLightHeight int, blocks []byte, skyLight []byte) {
+func setSkyLightStack(skyLightHeight int, blocks []byte, skyLight []byte) {
 	for y := ChunkSizeY - 1; y >= skyLightHeight; y-- {
 		BlockIndex(y).SetBlockData(skyLight, 15)
 	}
 
 	var lightLevel int8 = 15
 
+	if skyLightHeight >= ChunkSizeY {
+		skyLightHeight = ChunkSizeY - 1
+	}
 	for y := skyLightHeight; y >= 0 && lightLevel > 0; y-- {
 		blockType, ok := gamerules.Blocks.Get(BlockId(blocks[y]))
 		if lightLevel > 0 && ok && blockType.Opacity > 0 {
@@ -229,7 +276,9 @@ func (gen *TestGenerator) setSkyLightStack(skyLightHeight int, blocks []byte, sk
 	}
 }
 
-func (gen *TestGenerator) setSkylight(data *ChunkData) {
+// setSkylight sets the sky light of each column in the chunk, starting from
+// the top of the world down to the height map.
+func setSkylight(data *ChunkData) {
 	baseIndex := 0
 	heightMapIndex := 0
 
@@ -237,7 +286,7 @@ func (gen *TestGenerator) setSkylight(data *ChunkData) {
 		for z := 0; z < ChunkSizeH; z++ {
 			lightBase := baseIndex >> 1
 
-			gen.setSkyLightStack(
+			setSkyLightStack(
 				int(data.heightMap[heightMapIndex]),
 				data.blocks[baseIndex:baseIndex+ChunkSizeY],
 				data.skyLight[lightBase:lightBase+ChunkSizeY/2])
@@ -247,58 +296,3 @@ func (gen *TestGenerator) setSkylight(data *ChunkData) {
 	}
 
 }
-
-func (gen *TestGenerator) addSaplings(data *ChunkData) {
-	baseIndex := 0
-	heightMapIndex := 0
-
-	// Move throughout the chunk, but refuse to create trees on chunk
-	// boundaries.
-	for x := 0; x < ChunkSizeH; x++ {
-		for z := 0; z < ChunkSizeH; z++ {
-			topBlock := int(data.heightMap[heightMapIndex] - 1)
-			blockIndex := baseIndex + int(topBlock)
-
-			if data.blocks[blockIndex] == 2 {
-				// We could add a tree, check to see if we want to
-				addTree := gen.randGen.Intn(100) > 95
-				if addTree && x > 0 && x < ChunkSizeH-1 && z > 0 && z < ChunkSizeH-1 {
-					if !adjacentBlockIs(data, x, topBlock, z, 2, 2, 2, 6) {
-						// Check if an adjacent block has a sapling already
-						data.blocks[blockIndex+1] = 6
-					}
-				}
-			}
-
-			heightMapIndex++
-			baseIndex += ChunkSizeY
-		}
-	}
-}
-
-// adjacentBlockIs return whether or not at least one block adjacent to
-// (bx,by,bz) is of type 'blockType'. The area which this function checks is
-// specified by dx,dy,dz. Blocks outside the given chunk are not checked.
-func adjacentBlockIs(data *ChunkData, bx, by, bz, dx, dy, dz int, blockType byte) bool {
-	subChunk := new(SubChunkXyz)
-
-	for x := bx - dx; x <= bx+dx; x++ {
-		for z := bz - dz; z <= bz+dz; z++ {
-			for y := by - dy; y <= by+dy; y++ {
-				if x != bx || y != by || z != bz {
-					subChunk.X = SubChunkCoord(x)
-					subChunk.Y = SubChunkCoord(y)
-					subChunk.Z = SubChunkCoord(z)
-					index, ok := subChunk.BlockIndex()
-					if ok {
-						if data.blocks[index] == blockType {
-							return true
-						}
-					}
-				}
-			}
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,179 @@
+package generation
+
+import (
+	. "chunkymonkey/types"
+	"perlin"
+)
+
+const (
+	// The density function is sampled on a coarse grid of cells, and
+	// interpolated between the samples. This is much faster than sampling every
+	// block and smooths out the noise.
+	densityCellH = 4
+	densityCellY = 8
+
+	densitySamplesH = ChunkSizeH/densityCellH + 1
+	densitySamplesY = ChunkSizeY/densityCellY + 1
+)
+
+// DensityGenerator implements IChunkGenerator. Unlike TestGenerator,
+// which only produces a height map, it decides whether each block is solid
+// from a 3D density function, so that it can produce overhangs, cliffs and
+// floating islands.
+type DensityGenerator struct {
+	seed    int64
+	density ISource3d
+	biomes  *BiomeSource
+	carver  *caveCarver
+}
+
+func NewDensityGenerator(seed int64) *DensityGenerator {
+	perlin := perlin.NewPerlinNoise(seed)
+
+	return &DensityGenerator{
+		seed:   seed,
+		biomes: NewBiomeSource(seed),
+		carver: newCaveCarver(seed),
+		density: &Sum3d{
+			Inputs: []ISource3d{
+				// Mostly solid below sea level and mostly empty above it.
+				&HeightGradient{Base: SeaLevel + 8, Falloff: 24},
+				// Broad hills and valleys.
+				&Extrude{&Scale{Wavelength: 200, Amplitude: 0.6, Source: perlin}},
+				// Overhangs, cliffs and floating islands.
+				&Turbulence3d{
+					Dx:     &Scale3d{Wavelength: 40, Amplitude: 1, Source: &Offset3d{20.1, 0, 0, perlin}},
+					Dy:     &Scale3d{Wavelength: 40, Amplitude: 1, Source: &Offset3d{10.1, 0, 0, perlin}},
+					Dz:     &Scale3d{Wavelength: 40, Amplitude: 1, Source: &Offset3d{0, 0, 30.1, perlin}},
+					Factor: 8,
+					Source: &Scale3d{
+						Wavelength: 60,
+						Amplitude:  3,
+						YScale:     0.5,
+						Source:     perlin,
+					},
+				},
+				// Surface detail.
+				&Scale3d{
+					Wavelength: 16,
+					Amplitude:  0.25,
+					Source:     &Offset3d{0, 30.3, 0, perlin},
+				},
+			},
+		},
+	}
+}
+
+func (gen *DensityGenerator) GenerateTerrain(chunkLoc ChunkXz) *ChunkData {
+	baseBlockXyz := chunkLoc.ChunkCornerBlockXY()
+	baseX, baseZ := float64(baseBlockXyz.X), float64(baseBlockXyz.Z)
+
+	var samples [densitySamplesH][densitySamplesY][densitySamplesH]float64
+	for sx := 0; sx < densitySamplesH; sx++ {
+		for sz := 0; sz < densitySamplesH; sz++ {
+			x, z := baseX+float64(sx*densityCellH), baseZ+float64(sz*densityCellH)
+
+			// The biome stretches and raises the terrain about sea level.
+			heightScale, heightOffset := gen.biomes.heightModifiers(x, z)
+			for sy := 0; sy < densitySamplesY; sy++ {
+				y := SeaLevel + (float64(sy*densityCellY)-SeaLevel-heightOffset)/heightScale
+				samples[sx][sy][sz] = gen.density.At3d(x, y, z)
+			}
+		}
+	}
+
+	data := newChunkData(chunkLoc)
+	biomes := gen.biomes.chunkBiomes(chunkLoc)
+
+	baseIndex := BlockIndex(0)
+	column := 0
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			blocks := data.blocks[baseIndex : baseIndex+ChunkSizeY]
+
+			for y := 0; y < ChunkSizeY; y++ {
+				if interpolateDensity(&samples, x, y, z) > 0 {
+					blocks[y] = byte(BlockIdStone)
+				}
+			}
+			blocks[0] = byte(BlockIdBedrock)
+
+			gen.setSurface(blocks, biomes[column])
+
+			column++
+			baseIndex += ChunkSizeY
+		}
+	}
+
+	gen.carver.Carve(data)
+	setHeightMap(data)
+
+	return data
+}
+
+func (gen *DensityGenerator) Populate(area *PopulationArea) {
+	randGen := chunkRand(gen.seed, area.Loc)
+	addLakes(area, randGen)
+	addDungeons(area, randGen)
+	addOres(area, randGen)
+	addBeaches(area)
+	addTrees(area, gen.biomes, randGen)
+	addSnow(area, gen.biomes)
+	area.relight()
+}
+
+// interpolateDensity returns the density at the given block within the chunk
+// by trilinear interpolation between the surrounding samples.
+func interpolateDensity(samples *[densitySamplesH][densitySamplesY][densitySamplesH]float64, x, y, z int) float64 {
+	sx, sy, sz := x/densityCellH, y/densityCellY, z/densityCellH
+	fx := float64(x%densityCellH) / densityCellH
+	fy := float64(y%densityCellY) / densityCellY
+	fz := float64(z%densityCellH) / densityCellH
+
+	lerp := func(a, b, f float64) float64 {
+		return a + f*(b-a)
+	}
+
+	c00 := lerp(samples[sx][sy][sz], samples[sx+1][sy][sz], fx)
+	c01 := lerp(samples[sx][sy][sz+1], samples[sx+1][sy][sz+1], fx)
+	c10 := lerp(samples[sx][sy+1][sz], samples[sx+1][sy+1][sz], fx)
+	c11 := lerp(samples[sx][sy+1][sz+1], samples[sx+1][sy+1][sz+1], fx)
+
+	return lerp(lerp(c00, c10, fy), lerp(c01, c11, fy), fz)
+}
+
+// setSurface covers the stone in a column with the biome's surface blocks (or
+// sand near the sea), and fills open space below sea level with water.
+func (gen *DensityGenerator) setSurface(blocks []byte, biome *Biome) {
+	// open is true until the first solid block from the top of the column, so
+	// that caves and the space under overhangs do not fill with water.
+	open := true
+	depth := -1
+	var fillBlock BlockId
+
+	for y := ChunkSizeY - 1; y > 0; y-- {
+		if blocks[y] == byte(BlockIdAir) {
+			if open && y <= SeaLevel {
+				blocks[y] = byte(BlockIdStationaryWater)
+			}
+			depth = -1
+			continue
+		}
+
+		open = false
+		depth++
+
+		switch {
+		case depth == 0:
+			if y <= SeaLevel+1 {
+				blocks[y] = byte(BlockIdSand)
+				fillBlock = BlockIdSand
+			} else {
+				blocks[y] = byte(biome.TopBlock)
+				fillBlock = biome.FillerBlock
+			}
+		case depth < 3:
+			blocks[y] = byte(fillBlock)
+		}
+	}
+}
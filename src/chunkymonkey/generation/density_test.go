@@ -0,0 +1,65 @@
+package generation
+
+import (
+	"bytes"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestDensityGenerator_Column(t *testing.T) {
+	gen := NewDensityGenerator(0)
+
+	reader, err := NewPopulatingStore(nil, gen).ReadChunk(ChunkXz{1, -3})
+	if err != nil {
+		t.Fatalf("ReadChunk returned error: %v", err)
+	}
+
+	blocks := reader.Blocks()
+	heightMap := reader.HeightMap()
+	for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+		base := column * ChunkSizeY
+		if blocks[base] != byte(BlockIdBedrock) {
+			t.Errorf("column %d: expected bedrock at bottom, got %d", column, blocks[base])
+		}
+
+		height := int(heightMap[column])
+		if height < 1 || height >= ChunkSizeY {
+			t.Errorf("column %d: height %d out of range", column, height)
+			continue
+		}
+		if blocks[base+height-1] == byte(BlockIdAir) {
+			t.Errorf("column %d: expected non-air block below height %d", column, height)
+		}
+		for y := height; y < ChunkSizeY; y++ {
+			if blocks[base+y] != byte(BlockIdAir) {
+				t.Errorf("column %d: expected air above height %d, got %d at %d", column, height, blocks[base+y], y)
+				break
+			}
+		}
+	}
+}
+
+func TestDensityGenerator_Deterministic(t *testing.T) {
+	loc := ChunkXz{7, 2}
+
+	dataA := NewDensityGenerator(42).GenerateTerrain(loc)
+	dataB := NewDensityGenerator(42).GenerateTerrain(loc)
+
+	if !bytes.Equal(dataA.Blocks(), dataB.Blocks()) {
+		t.Errorf("chunks generated with the same seed differ")
+	}
+}
+
+func Benchmark_DensityGenerator_generate(b *testing.B) {
+	gen := NewDensityGenerator(0)
+	var loc ChunkXz
+
+	b.ResetTimer()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		loc.X = ChunkCoord(i & 0xffff)
+		gen.GenerateTerrain(loc)
+	}
+}
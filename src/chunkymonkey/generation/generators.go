@@ -0,0 +1,220 @@
+package generation
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// DefaultGeneratorName is the generator used by worlds that do not name one.
+const DefaultGeneratorName = "default"
+
+// GeneratorFactory creates a generator for the normal dimension of a world,
+// given the world seed and the generator options stored in its level.dat.
+type GeneratorFactory func(seed int64, options string) (IChunkGenerator, os.Error)
+
+// Generators contains the generators that worlds can choose between, keyed
+// by the generatorName stored in level.dat.
+var Generators = map[string]GeneratorFactory{
+	DefaultGeneratorName: func(seed int64, options string) (IChunkGenerator, os.Error) {
+		return NewTestGenerator(seed), nil
+	},
+	"density": func(seed int64, options string) (IChunkGenerator, os.Error) {
+		return NewDensityGenerator(seed), nil
+	},
+	"flat": func(seed int64, options string) (IChunkGenerator, os.Error) {
+		if options == "" {
+			options = DefaultFlatLayers
+		}
+		layers, err := ParseFlatLayers(options)
+		if err != nil {
+			return nil, err
+		}
+		return NewFlatGenerator(layers), nil
+	},
+	"void": func(seed int64, options string) (IChunkGenerator, os.Error) {
+		platform := BlockIdStone
+		if options != "" {
+			var err os.Error
+			if platform, err = parseBlockName(options); err != nil {
+				return nil, err
+			}
+		}
+		return NewVoidGenerator(platform), nil
+	},
+}
+
+type UnknownGeneratorError string
+
+func (err UnknownGeneratorError) String() string {
+	return fmt.Sprintf("Unknown world generator %q", string(err))
+}
+
+// NewGenerator creates the named generator. An empty name selects the default
+// generator.
+func NewGenerator(name string, seed int64, options string) (generator IChunkGenerator, err os.Error) {
+	if name == "" {
+		name = DefaultGeneratorName
+	}
+
+	factory, ok := Generators[name]
+	if !ok {
+		return nil, UnknownGeneratorError(name)
+	}
+
+	return factory(seed, options)
+}
+
+// ISpawnGenerator is implemented by generators that decide where players
+// first spawn in a new world.
+type ISpawnGenerator interface {
+	SpawnPosition() BlockXyz
+}
+
+// DefaultFlatLayers are the layers of a flat world that has no generator
+// options.
+const DefaultFlatLayers = "bedrock,2*dirt,grass"
+
+// ParseFlatLayers parses a comma separated list of the block layers in a flat
+// world, from the bottom up. Each layer is a block name or ID, optionally
+// prefixed by a count, e.g "bedrock,2*dirt,grass".
+func ParseFlatLayers(options string) (layers []BlockId, err os.Error) {
+	for _, layer := range strings.Split(options, ",") {
+		count := 1
+		if star := strings.Index(layer, "*"); star >= 0 {
+			if count, err = strconv.Atoi(strings.TrimSpace(layer[:star])); err != nil || count < 1 {
+				return nil, fmt.Errorf("Bad layer count in %q", layer)
+			}
+			layer = layer[star+1:]
+		}
+
+		blockId, err := parseBlockName(layer)
+		if err != nil {
+			return nil, err
+		}
+
+		// Leave room above the layers for players to stand.
+		if len(layers)+count >= ChunkSizeY {
+			return nil, fmt.Errorf("Flat world layers must be less than %d blocks high", ChunkSizeY)
+		}
+		for i := 0; i < count; i++ {
+			layers = append(layers, blockId)
+		}
+	}
+
+	return
+}
+
+// parseBlockName returns the ID of the block with the given name or ID.
+func parseBlockName(name string) (blockId BlockId, err os.Error) {
+	name = strings.TrimSpace(name)
+
+	if id, err := strconv.Atoi(name); err == nil {
+		if id >= BlockIdMin && id <= BlockIdMax {
+			if _, ok := gamerules.Blocks.Get(BlockId(id)); ok {
+				return BlockId(id), nil
+			}
+		}
+		return 0, fmt.Errorf("Unknown block ID %d", id)
+	}
+
+	for id := range gamerules.Blocks {
+		if blockType, ok := gamerules.Blocks.Get(BlockId(id)); ok && strings.ToLower(blockType.Name) == strings.ToLower(name) {
+			return BlockId(id), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown block name %q", name)
+}
+
+// FlatGenerator implements IChunkGenerator. It generates the same layers of
+// blocks in every column, with no features.
+type FlatGenerator struct {
+	layers []BlockId
+}
+
+func NewFlatGenerator(layers []BlockId) *FlatGenerator {
+	return &FlatGenerator{
+		layers: layers,
+	}
+}
+
+func (gen *FlatGenerator) GenerateTerrain(chunkLoc ChunkXz) *ChunkData {
+	data := newChunkData(chunkLoc)
+
+	for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+		blocks := data.blocks[column*ChunkSizeY : (column+1)*ChunkSizeY]
+		for y, blockId := range gen.layers {
+			blocks[y] = byte(blockId)
+		}
+	}
+
+	setHeightMap(data)
+	setSkylight(data)
+
+	return data
+}
+
+func (gen *FlatGenerator) Populate(area *PopulationArea) {
+}
+
+func (gen *FlatGenerator) SpawnPosition() BlockXyz {
+	return BlockXyz{0, BlockYCoord(len(gen.layers)), 0}
+}
+
+const (
+	// The void generator's spawn platform is voidPlatformSize blocks square,
+	// centred on the origin, with its top at SeaLevel.
+	voidPlatformSize = 5
+)
+
+// VoidGenerator implements IChunkGenerator. It generates empty chunks, except
+// for a small platform for players to spawn on.
+type VoidGenerator struct {
+	platform BlockId
+}
+
+func NewVoidGenerator(platform BlockId) *VoidGenerator {
+	return &VoidGenerator{
+		platform: platform,
+	}
+}
+
+func (gen *VoidGenerator) GenerateTerrain(chunkLoc ChunkXz) *ChunkData {
+	data := newChunkData(chunkLoc)
+	corner := chunkLoc.ChunkCornerBlockXY()
+
+	column := 0
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			blockX, blockZ := int(corner.X)+x, int(corner.Z)+z
+			if abs(blockX) <= voidPlatformSize/2 && abs(blockZ) <= voidPlatformSize/2 {
+				data.blocks[column*ChunkSizeY+SeaLevel] = byte(gen.platform)
+			}
+			column++
+		}
+	}
+
+	setHeightMap(data)
+	setSkylight(data)
+
+	return data
+}
+
+func (gen *VoidGenerator) Populate(area *PopulationArea) {
+}
+
+func (gen *VoidGenerator) SpawnPosition() BlockXyz {
+	return BlockXyz{0, SeaLevel + 1, 0}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+package generation
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestParseFlatLayers(t *testing.T) {
+	tests := []struct {
+		options string
+		want    []BlockId
+		wantErr bool
+	}{
+		{"bedrock,2*dirt,grass", []BlockId{BlockIdBedrock, BlockIdDirt, BlockIdDirt, BlockIdGrass}, false},
+		{" 7 , 3 * stone ", []BlockId{BlockIdBedrock, BlockIdStone, BlockIdStone, BlockIdStone}, false},
+		{"Wooden Plank", []BlockId{5}, false},
+		{"bedrock,unobtainium", nil, true},
+		{"0*dirt", nil, true},
+		{"x*dirt", nil, true},
+		{"300", nil, true},
+		{"128*stone", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFlatLayers(test.options)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got none", test.options)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.options, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got layers %v, want %v", test.options, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got layers %v, want %v", test.options, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNewGenerator(t *testing.T) {
+	for name := range Generators {
+		if _, err := NewGenerator(name, 0, ""); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	if _, ok := mustGenerator(t, "", "").(*TestGenerator); !ok {
+		t.Errorf("expected empty name to select the default generator")
+	}
+
+	if _, err := NewGenerator("nonexistent", 0, ""); err == nil {
+		t.Errorf("expected error for unknown generator")
+	}
+	if _, err := NewGenerator("flat", 0, "bedrock,nothing"); err == nil {
+		t.Errorf("expected error for bad flat world options")
+	}
+}
+
+func mustGenerator(t *testing.T, name, options string) IChunkGenerator {
+	generator, err := NewGenerator(name, 0, options)
+	if err != nil {
+		t.Fatalf("NewGenerator(%q, %q) returned error: %v", name, options, err)
+	}
+	return generator
+}
+
+func TestFlatGenerator(t *testing.T) {
+	generator := mustGenerator(t, "flat", "bedrock,2*dirt,grass")
+	data := generator.GenerateTerrain(ChunkXz{-3, 5})
+
+	want := []BlockId{BlockIdBedrock, BlockIdDirt, BlockIdDirt, BlockIdGrass, BlockIdAir}
+	for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+		for y, blockId := range want {
+			if got := BlockId(data.blocks[column*ChunkSizeY+y]); got != blockId {
+				t.Fatalf("column %d: got block %d at height %d, want %d", column, got, y, blockId)
+			}
+		}
+		if data.heightMap[column] != 4 {
+			t.Fatalf("column %d: got height %d, want 4", column, data.heightMap[column])
+		}
+	}
+
+	spawn := generator.(ISpawnGenerator).SpawnPosition()
+	if spawn.Y != 4 {
+		t.Errorf("got spawn height %d, want 4", spawn.Y)
+	}
+}
+
+func TestVoidGenerator(t *testing.T) {
+	generator := mustGenerator(t, "void", "")
+	spawn := generator.(ISpawnGenerator).SpawnPosition()
+
+	platform := 0
+	for x := ChunkCoord(-1); x <= 1; x++ {
+		for z := ChunkCoord(-1); z <= 1; z++ {
+			data := generator.GenerateTerrain(ChunkXz{x, z})
+			for i, blockId := range data.blocks {
+				if blockId == byte(BlockIdAir) {
+					continue
+				}
+				if blockId != byte(BlockIdStone) || i%ChunkSizeY != int(spawn.Y)-1 {
+					t.Fatalf("chunk %v: unexpected block %d at index %d", data.loc, blockId, i)
+				}
+				platform++
+			}
+		}
+	}
+
+	if platform != voidPlatformSize*voidPlatformSize {
+		t.Errorf("got %d platform blocks, want %d", platform, voidPlatformSize*voidPlatformSize)
+	}
+}
@@ -0,0 +1,66 @@
+package generation
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"testing"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+func init() {
+	if err := gamerules.LoadGameRules("blocks.json", "items.json", "recipes.json", "furnace.json", "users.json", "groups.json"); err != nil {
+		panic(err)
+	}
+
+	var err os.Error
+	if Ores, err = LoadOresFromFile("ores.json"); err != nil {
+		panic(err)
+	}
+}
+
+// goldenSeed is the world seed that the golden hashes were generated with.
+const goldenSeed = 1234
+
+// hashChunkGrid generates and populates a grid of chunks around the origin
+// and returns a hash of their contents.
+func hashChunkGrid(t *testing.T, gen IChunkGenerator) string {
+	store := NewPopulatingStore(nil, gen)
+	h := sha1.New()
+	for x := ChunkCoord(-2); x < 2; x++ {
+		for z := ChunkCoord(-2); z < 2; z++ {
+			reader, err := store.ReadChunk(ChunkXz{x, z})
+			if err != nil {
+				t.Fatalf("ReadChunk(%d, %d) returned error: %v", x, z, err)
+			}
+			h.Write(reader.Blocks())
+			h.Write(reader.BlockData())
+			h.Write(reader.SkyLight())
+			h.Write(reader.HeightMap())
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// The golden tests pin the output of the generators for a fixed seed, so that
+// a world continues to generate the same way across releases. If a change to
+// the generated terrain is intended, update the expected hash.
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		gen  IChunkGenerator
+		want string
+	}{
+		{"TestGenerator", NewTestGenerator(goldenSeed), "690ec180a21d21603f81763b7763830797a3f589"},
+		{"DensityGenerator", NewDensityGenerator(goldenSeed), "4b340f63c2c29321394147242d551a43069e0087"},
+		{"NetherGenerator", NewNetherGenerator(goldenSeed), "1e99ea27f0b8c1979c77babf60d3be09a63136d1"},
+	}
+
+	for _, test := range tests {
+		if got := hashChunkGrid(t, test.gen); got != test.want {
+			t.Errorf("%s: generated chunks hash to %s, want %s (update the golden hash if the change is intended)", test.name, got, test.want)
+		}
+	}
+}
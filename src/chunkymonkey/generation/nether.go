@@ -0,0 +1,146 @@
+package generation
+
+import (
+	"rand"
+
+	. "chunkymonkey/types"
+	"perlin"
+)
+
+const (
+	// Open space in the nether below this height is filled with lava.
+	NetherLavaLevel = 31
+
+	netherFloorBase   = 40
+	netherCeilingBase = 100
+
+	// Bedrock is randomly scattered within this many blocks of the bottom and
+	// top of the world.
+	netherBedrockDepth = 4
+
+	// Chance (as a percentage) of a glowstone cluster hanging from the ceiling
+	// of any given column.
+	netherGlowstoneChance = 2
+)
+
+// NetherGenerator implements IChunkGenerator. It generates a cavernous
+// world of netherrack between bedrock floor and ceiling, with a lava sea,
+// patches of soul sand and glowstone clusters.
+type NetherGenerator struct {
+	seed          int64
+	floorSource   ISource
+	ceilingSource ISource
+	soulSand      ISource
+}
+
+func NewNetherGenerator(seed int64) *NetherGenerator {
+	perlin := perlin.NewPerlinNoise(seed)
+
+	return &NetherGenerator{
+		seed: seed,
+		floorSource: &Sum{
+			Inputs: []ISource{
+				&Scale{Wavelength: 60, Amplitude: 24, Source: perlin},
+				&Scale{Wavelength: 12, Amplitude: 6, Source: &Offset{30.3, 0, perlin}},
+			},
+		},
+		ceilingSource: &Sum{
+			Inputs: []ISource{
+				&Scale{Wavelength: 50, Amplitude: 16, Source: &Offset{0, 40.7, perlin}},
+				&Scale{Wavelength: 10, Amplitude: 5, Source: &Offset{50.5, 50.5, perlin}},
+			},
+		},
+		soulSand: &Scale{Wavelength: 30, Amplitude: 1, Source: &Offset{70.1, 10.9, perlin}},
+	}
+}
+
+func (gen *NetherGenerator) GenerateTerrain(chunkLoc ChunkXz) *ChunkData {
+	baseBlockXyz := chunkLoc.ChunkCornerBlockXY()
+	baseX, baseZ := baseBlockXyz.X, baseBlockXyz.Z
+
+	randGen := chunkRand(gen.seed, chunkLoc)
+
+	data := newChunkData(chunkLoc)
+
+	baseIndex := BlockIndex(0)
+	heightMapIndex := 0
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			xf, zf := float64(x)+float64(baseX), float64(z)+float64(baseZ)
+
+			floor := clampHeight(int(netherFloorBase + gen.floorSource.At2d(xf, zf)))
+			ceiling := clampHeight(int(netherCeilingBase + gen.ceilingSource.At2d(xf, zf)))
+			if ceiling <= floor {
+				ceiling = floor + 1
+			}
+			soulSand := gen.soulSand.At2d(xf, zf) > 0.3
+
+			gen.setBlockStack(
+				floor, ceiling, soulSand, randGen,
+				data.blocks[baseIndex:baseIndex+ChunkSizeY])
+
+			// There is no sky in the nether, so the height map is the top of the
+			// world.
+			data.heightMap[heightMapIndex] = ChunkSizeY
+
+			heightMapIndex++
+			baseIndex += ChunkSizeY
+		}
+	}
+
+	return data
+}
+
+// Populate does nothing, as all of the nether's features are placed along
+// with its terrain.
+func (gen *NetherGenerator) Populate(area *PopulationArea) {
+}
+
+func (gen *NetherGenerator) setBlockStack(floor, ceiling int, soulSand bool, randGen *rand.Rand, blocks []byte) {
+	for y := 0; y < ChunkSizeY; y++ {
+		var blockId BlockId
+		switch {
+		case y < floor || y > ceiling:
+			blockId = BlockIdNetherrack
+		case y <= NetherLavaLevel:
+			blockId = BlockIdLava
+		default:
+			blockId = BlockIdAir
+		}
+		blocks[y] = byte(blockId)
+	}
+
+	if soulSand && floor > NetherLavaLevel {
+		for y := floor - 1; y >= floor-3 && y > 0; y-- {
+			blocks[y] = byte(BlockIdSoulSand)
+		}
+	}
+
+	if ceiling > floor+4 && randGen.Intn(100) < netherGlowstoneChance {
+		length := 1 + randGen.Intn(4)
+		for y := ceiling; y > ceiling-length; y-- {
+			blocks[y] = byte(BlockIdGlowstone)
+		}
+	}
+
+	// Bedrock floor and ceiling, ragged at the edges.
+	blocks[0] = byte(BlockIdBedrock)
+	blocks[ChunkSizeY-1] = byte(BlockIdBedrock)
+	for i := 1; i <= netherBedrockDepth; i++ {
+		if randGen.Intn(netherBedrockDepth+1) >= i {
+			blocks[i] = byte(BlockIdBedrock)
+		}
+		if randGen.Intn(netherBedrockDepth+1) >= i {
+			blocks[ChunkSizeY-1-i] = byte(BlockIdBedrock)
+		}
+	}
+}
+
+func clampHeight(height int) int {
+	if height < 1 {
+		return 1
+	} else if height >= ChunkSizeY-1 {
+		return ChunkSizeY - 2
+	}
+	return height
+}
@@ -0,0 +1,34 @@
+package generation
+
+import (
+	"bytes"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestNetherGenerator_BedrockBounds(t *testing.T) {
+	gen := NewNetherGenerator(0)
+
+	blocks := gen.GenerateTerrain(ChunkXz{3, -7}).Blocks()
+	for column := 0; column < ChunkSizeH*ChunkSizeH; column++ {
+		base := column * ChunkSizeY
+		if blocks[base] != byte(BlockIdBedrock) {
+			t.Errorf("column %d: expected bedrock at bottom, got %d", column, blocks[base])
+		}
+		if top := blocks[base+ChunkSizeY-1]; top != byte(BlockIdBedrock) {
+			t.Errorf("column %d: expected bedrock at top, got %d", column, top)
+		}
+	}
+}
+
+func TestNetherGenerator_Deterministic(t *testing.T) {
+	loc := ChunkXz{-2, 5}
+
+	dataA := NewNetherGenerator(42).GenerateTerrain(loc)
+	dataB := NewNetherGenerator(42).GenerateTerrain(loc)
+
+	if !bytes.Equal(dataA.Blocks(), dataB.Blocks()) {
+		t.Errorf("chunks generated with the same seed differ")
+	}
+}
@@ -0,0 +1,132 @@
+package generation
+
+import (
+	"fmt"
+	"io"
+	"json"
+	"math"
+	"os"
+	"rand"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// Ores contains the ore veins placed in generated chunks. It is empty until
+// loaded by LoadOres or LoadOresFromFile.
+var Ores []OreDef
+
+// OreDef describes veins of a block type that replace stone in generated
+// chunks.
+type OreDef struct {
+	Comment string
+	BlockId BlockId
+	// VeinSize is roughly the number of blocks in each vein.
+	VeinSize int
+	// VeinsPerChunk is the number of veins attempted in each chunk.
+	VeinsPerChunk int
+	// Veins are centred between MinHeight (inclusive) and MaxHeight
+	// (exclusive).
+	MinHeight int
+	MaxHeight int
+}
+
+// oresDef is used in unmarshalling data from the JSON definition of Ores.
+type oresDef struct {
+	Ores []OreDef
+}
+
+// LoadOres reads ore definitions from the reader. The game rules must already
+// be loaded, so that the block types of the ores can be checked.
+func LoadOres(reader io.Reader) (ores []OreDef, err os.Error) {
+	decoder := json.NewDecoder(reader)
+
+	var def oresDef
+	if err = decoder.Decode(&def); err != nil {
+		return
+	}
+
+	for _, ore := range def.Ores {
+		if _, ok := gamerules.Blocks.Get(ore.BlockId); !ok {
+			err = fmt.Errorf("Ore %q has unknown block ID %d", ore.Comment, ore.BlockId)
+			return
+		}
+		if ore.VeinSize <= 0 || ore.VeinsPerChunk < 0 {
+			err = fmt.Errorf("Ore %q has invalid vein size or count", ore.Comment)
+			return
+		}
+		if ore.MinHeight < 0 || ore.MaxHeight > ChunkSizeY || ore.MinHeight >= ore.MaxHeight {
+			err = fmt.Errorf("Ore %q has invalid height range %d-%d", ore.Comment, ore.MinHeight, ore.MaxHeight)
+			return
+		}
+	}
+
+	return def.Ores, nil
+}
+
+// LoadOresFromFile reads ore definitions from the named file.
+func LoadOresFromFile(filename string) (ores []OreDef, err os.Error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	return LoadOres(file)
+}
+
+// addOres places the veins of all Ores, centred in the middle of the area.
+func addOres(area *PopulationArea, randGen *rand.Rand) {
+	for i := range Ores {
+		ore := &Ores[i]
+		for vein := 0; vein < ore.VeinsPerChunk; vein++ {
+			ore.addVein(area, randGen)
+		}
+	}
+}
+
+// addVein places a single vein of the ore, as a series of blobs along a short
+// line. Only stone is replaced, and the parts of the vein that fall outside
+// the area are lost.
+func (ore *OreDef) addVein(area *PopulationArea, randGen *rand.Rand) {
+	size := float64(ore.VeinSize)
+
+	x := populateMin + randGen.Float64()*ChunkSizeH
+	y := float64(ore.MinHeight + randGen.Intn(ore.MaxHeight-ore.MinHeight))
+	z := populateMin + randGen.Float64()*ChunkSizeH
+
+	angle := randGen.Float64() * math.Pi
+	dx := math.Sin(angle) * size / 8
+	dz := math.Cos(angle) * size / 8
+	dy := float64(randGen.Intn(3) - 1)
+
+	for i := 0; i <= ore.VeinSize; i++ {
+		f := float64(i) / size
+		radius := ((math.Sin(f*math.Pi)+1)*randGen.Float64()*size/16 + 1) / 2
+
+		placeBlob(
+			area, ore.BlockId,
+			x+dx*(1-2*f), y+dy*(1-2*f), z+dz*(1-2*f),
+			radius)
+	}
+}
+
+// placeBlob replaces the stone within a sphere with the given block type.
+func placeBlob(area *PopulationArea, blockId BlockId, cx, cy, cz, radius float64) {
+	minX, maxX := clampInt(int(math.Floor(cx-radius)), 0, populationAreaSize-1), clampInt(int(math.Floor(cx+radius)), 0, populationAreaSize-1)
+	minY, maxY := clampInt(int(math.Floor(cy-radius)), 0, ChunkSizeY-1), clampInt(int(math.Floor(cy+radius)), 0, ChunkSizeY-1)
+	minZ, maxZ := clampInt(int(math.Floor(cz-radius)), 0, populationAreaSize-1), clampInt(int(math.Floor(cz+radius)), 0, populationAreaSize-1)
+
+	for x := minX; x <= maxX; x++ {
+		ddx := (float64(x) + 0.5 - cx) / radius
+		for z := minZ; z <= maxZ; z++ {
+			ddz := (float64(z) + 0.5 - cz) / radius
+			for y := minY; y <= maxY; y++ {
+				ddy := (float64(y) + 0.5 - cy) / radius
+				if ddx*ddx+ddy*ddy+ddz*ddz < 1 && area.BlockAt(x, y, z) == BlockIdStone {
+					area.SetBlock(x, y, z, blockId, 0)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package generation
+
+import (
+	"bytes"
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+func TestLoadOres(t *testing.T) {
+	tests := []struct {
+		comment string
+		input   string
+		wantErr bool
+	}{
+		{
+			"valid",
+			`{"Ores": [{"Comment": "coal", "BlockId": 16, "VeinSize": 16, "VeinsPerChunk": 20, "MinHeight": 0, "MaxHeight": 128}]}`,
+			false,
+		},
+		{
+			"unknown block",
+			`{"Ores": [{"Comment": "bad", "BlockId": 250, "VeinSize": 16, "VeinsPerChunk": 20, "MinHeight": 0, "MaxHeight": 128}]}`,
+			true,
+		},
+		{
+			"empty height range",
+			`{"Ores": [{"Comment": "bad", "BlockId": 16, "VeinSize": 16, "VeinsPerChunk": 20, "MinHeight": 16, "MaxHeight": 16}]}`,
+			true,
+		},
+		{
+			"height range too high",
+			`{"Ores": [{"Comment": "bad", "BlockId": 16, "VeinSize": 16, "VeinsPerChunk": 20, "MinHeight": 0, "MaxHeight": 129}]}`,
+			true,
+		},
+		{
+			"zero vein size",
+			`{"Ores": [{"Comment": "bad", "BlockId": 16, "VeinSize": 0, "VeinsPerChunk": 20, "MinHeight": 0, "MaxHeight": 128}]}`,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := LoadOres(bytes.NewBufferString(test.input))
+		if test.wantErr && err == nil {
+			t.Errorf("%s: expected error, got none", test.comment)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.comment, err)
+		}
+	}
+}
+
+func TestOreDef_addVein(t *testing.T) {
+	area := stoneArea(ChunkXz{0, 0})
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			for y := 64; y < ChunkSizeY; y++ {
+				area.SetBlock(x, y, z, BlockIdAir, 0)
+			}
+		}
+	}
+
+	ore := &OreDef{BlockId: 16, VeinSize: 16, VeinsPerChunk: 1, MinHeight: 0, MaxHeight: ChunkSizeY}
+	randGen := chunkRand(0, area.Loc)
+	for i := 0; i < 50; i++ {
+		ore.addVein(area, randGen)
+	}
+
+	placed := 0
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			for y := 0; y < ChunkSizeY; y++ {
+				if area.BlockAt(x, y, z) == 16 {
+					placed++
+					if y >= 64 {
+						t.Fatalf("ore placed in air at (%d, %d, %d)", x, y, z)
+					}
+				}
+			}
+		}
+	}
+	if placed == 0 {
+		t.Errorf("expected some ore to be placed")
+	}
+}
@@ -0,0 +1,392 @@
+package generation
+
+import (
+	"os"
+	"rand"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// IChunkGenerator generates chunks in two phases. The terrain of each chunk is
+// generated in isolation. The chunk is later populated with features such as
+// ores and trees, once its neighbours have terrain, so that the features can
+// cross chunk borders.
+type IChunkGenerator interface {
+	// GenerateTerrain returns the terrain of the chunk. The terrain must only
+	// depend on the seed and the chunk location, and it may be called from
+	// several goroutines at once.
+	GenerateTerrain(chunkLoc ChunkXz) *ChunkData
+
+	// Populate adds features to the chunk at area.Loc. The features may extend
+	// into the neighbouring chunks in the positive X and Z directions.
+	Populate(area *PopulationArea)
+}
+
+// populationAreaSize is the width of a PopulationArea in blocks.
+const populationAreaSize = 2 * ChunkSizeH
+
+// PopulationArea gives access to the blocks of the 2x2 chunks that may be
+// written to when populating the chunk at Loc. Block coordinates are relative
+// to the corner of that chunk, and range over [0, populationAreaSize) on the X
+// and Z axes. Features should be centred in the middle of the area, in
+// [ChunkSizeH/2, 3*ChunkSizeH/2), so that every column is decorated by
+// exactly one population, and features may spread by up to half a chunk in
+// any direction.
+type PopulationArea struct {
+	Loc          ChunkXz
+	baseX, baseZ BlockCoord
+	chunks       [2][2]*ChunkData
+}
+
+func newPopulationArea(loc ChunkXz) *PopulationArea {
+	corner := loc.ChunkCornerBlockXY()
+	return &PopulationArea{
+		Loc:   loc,
+		baseX: corner.X,
+		baseZ: corner.Z,
+	}
+}
+
+// blockIndex returns the chunk that holds the given block, and the index of
+// the block within it. ok is false if the block is outside the area.
+func (area *PopulationArea) blockIndex(x, y, z int) (data *ChunkData, index BlockIndex, ok bool) {
+	if x < 0 || x >= populationAreaSize || z < 0 || z >= populationAreaSize || y < 0 || y >= ChunkSizeY {
+		return
+	}
+	data = area.chunks[x>>ChunkHShift][z>>ChunkHShift]
+	index = BlockIndex((((x&ChunkHMask)<<ChunkHShift)|(z&ChunkHMask))<<ChunkYShift | y)
+	return data, index, true
+}
+
+// BlockAt returns the block at the given position. Blocks outside the area
+// are treated as air.
+func (area *PopulationArea) BlockAt(x, y, z int) BlockId {
+	data, index, ok := area.blockIndex(x, y, z)
+	if !ok {
+		return BlockIdAir
+	}
+	return BlockId(data.blocks[index])
+}
+
+// SetBlock sets the block at the given position, and updates the height map.
+// Any tile entity belonging to the replaced block is removed. Blocks outside
+// the area are not set.
+func (area *PopulationArea) SetBlock(x, y, z int, blockId BlockId, blockData byte) {
+	data, index, ok := area.blockIndex(x, y, z)
+	if !ok {
+		return
+	}
+	data.blocks[index] = byte(blockId)
+	index.SetBlockData(data.blockData, blockData)
+	data.removeTileEntityAt(area.BlockXyz(x, y, z))
+
+	column := (x&ChunkHMask)<<ChunkHShift | (z & ChunkHMask)
+	height := int(data.heightMap[column])
+	if blockId != BlockIdAir && y >= height {
+		data.heightMap[column] = byte(y + 1)
+	} else if blockId == BlockIdAir && y == height-1 {
+		// The top block was removed, so look for the next one down.
+		for height = y; height > 0 && area.BlockAt(x, height-1, z) == BlockIdAir; height-- {
+		}
+		data.heightMap[column] = byte(height)
+	}
+}
+
+// Height returns the height map value of the column, which is one above the
+// highest non-air block.
+func (area *PopulationArea) Height(x, z int) int {
+	data, _, ok := area.blockIndex(x, 0, z)
+	if !ok {
+		return 0
+	}
+	return int(data.heightMap[(x&ChunkHMask)<<ChunkHShift|(z&ChunkHMask)])
+}
+
+// BlockXyz returns the world position of the given block.
+func (area *PopulationArea) BlockXyz(x, y, z int) BlockXyz {
+	return BlockXyz{
+		X: area.baseX + BlockCoord(x),
+		Y: BlockYCoord(y),
+		Z: area.baseZ + BlockCoord(z),
+	}
+}
+
+// AddTileEntity adds a tile entity to the chunk that holds its block.
+func (area *PopulationArea) AddTileEntity(x, y, z int, tileEntity gamerules.ITileEntity) {
+	data, _, ok := area.blockIndex(x, y, z)
+	if !ok {
+		return
+	}
+	data.tileEntities = append(data.tileEntities, tileEntity)
+}
+
+// biomeAt returns the biome of the column.
+func (area *PopulationArea) biomeAt(biomes *BiomeSource, x, z int) *Biome {
+	return biomes.biomeAt(float64(area.baseX)+float64(x), float64(area.baseZ)+float64(z))
+}
+
+// adjacentBlockIs returns whether or not at least one block near (bx,by,bz)
+// is of type blockId. The area which this function checks is specified by
+// dx,dy,dz.
+func (area *PopulationArea) adjacentBlockIs(bx, by, bz, dx, dy, dz int, blockId BlockId) bool {
+	for x := bx - dx; x <= bx+dx; x++ {
+		for z := bz - dz; z <= bz+dz; z++ {
+			for y := by - dy; y <= by+dy; y++ {
+				if (x != bx || y != by || z != bz) && area.BlockAt(x, y, z) == blockId {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// relight recalculates the sky light of the chunks in the area.
+func (area *PopulationArea) relight() {
+	for _, row := range area.chunks {
+		for _, data := range row {
+			setSkylight(data)
+		}
+	}
+}
+
+// populateMin and populateMax bound the columns that each population
+// decorates, on both the X and Z axes of a PopulationArea.
+const (
+	populateMin = ChunkSizeH / 2
+	populateMax = populateMin + ChunkSizeH
+)
+
+// addTrees grows trees on grass in the middle of the area, with a density and
+// type of tree chosen by the biome.
+func addTrees(area *PopulationArea, biomes *BiomeSource, randGen *rand.Rand) {
+	for x := populateMin; x < populateMax; x++ {
+		for z := populateMin; z < populateMax; z++ {
+			y := area.Height(x, z) - 1
+			if area.BlockAt(x, y, z) != BlockIdGrass {
+				continue
+			}
+
+			biome := area.biomeAt(biomes, x, z)
+			if randGen.Intn(100) >= biome.TreeChance {
+				continue
+			}
+			treeType := biome.TreeTypes[randGen.Intn(len(biome.TreeTypes))]
+			trunkHeight := 4 + randGen.Intn(3)
+
+			if y+trunkHeight+2 >= ChunkSizeY || area.adjacentBlockIs(x, y+1, z, 2, 2, 2, BlockIdWood) {
+				continue
+			}
+
+			growTree(area, x, y+1, z, trunkHeight, treeType, randGen)
+		}
+	}
+}
+
+// growTree places a tree whose trunk starts at (x, y, z). Leaves only replace
+// air, so that trees do not cut into the terrain or each other.
+func growTree(area *PopulationArea, x, y, z, trunkHeight int, treeType byte, randGen *rand.Rand) {
+	top := y + trunkHeight
+	for ly := top - 2; ly <= top+1; ly++ {
+		radius := 2
+		if ly > top-1 {
+			radius = 1
+		}
+		for lx := x - radius; lx <= x+radius; lx++ {
+			for lz := z - radius; lz <= z+radius; lz++ {
+				corner := (lx-x == radius || x-lx == radius) && (lz-z == radius || z-lz == radius)
+				if corner && (ly == top+1 || randGen.Intn(2) == 0) {
+					continue
+				}
+				if area.BlockAt(lx, ly, lz) == BlockIdAir {
+					area.SetBlock(lx, ly, lz, BlockIdLeaves, treeType)
+				}
+			}
+		}
+	}
+
+	area.SetBlock(x, y-1, z, BlockIdDirt, 0)
+	for ty := y; ty < top; ty++ {
+		area.SetBlock(x, ty, z, BlockIdWood, treeType)
+	}
+}
+
+// addSnow covers the land in the middle of the area with snow, and freezes the
+// surface of water, where the biome is snowy.
+func addSnow(area *PopulationArea, biomes *BiomeSource) {
+	for x := populateMin; x < populateMax; x++ {
+		for z := populateMin; z < populateMax; z++ {
+			height := area.Height(x, z)
+			if height < 1 || height >= ChunkSizeY || !area.biomeAt(biomes, x, z).SnowCover {
+				continue
+			}
+
+			switch area.BlockAt(x, height-1, z) {
+			case BlockIdStationaryWater:
+				area.SetBlock(x, height-1, z, BlockIdIce, 0)
+			case BlockIdStone, BlockIdGrass, BlockIdDirt, BlockIdSand, BlockIdGravel, BlockIdLeaves:
+				area.SetBlock(x, height, z, BlockIdSnow, 0)
+			}
+		}
+	}
+}
+
+// PopulatingStore implements chunkstore.IChunkStoreForeground. It generates
+// chunks that are missing from the underlying store, and populates them once
+// the chunks around them have terrain. A chunk is only returned from
+// ReadChunk once every population that can write into it has happened, so
+// that chunks are never altered by the generator after being loaded into the
+// game.
+//
+// Chunks that have been generated or altered, but are not yet complete, are
+// written to the underlying store with TerrainPopulated set truthfully, so
+// that population can resume after a restart. If there is no underlying store
+// (or it does not support writes), all chunks are kept in memory.
+type PopulatingStore struct {
+	store     chunkstore.IChunkStore
+	generator IChunkGenerator
+
+	// chunks caches chunks that have been read or generated, keyed by
+	// ChunkXz.ChunkKey(). dirty holds the keys of those that need writing to
+	// the underlying store.
+	chunks map[uint64]*ChunkData
+	dirty  map[uint64]bool
+}
+
+// populatingStoreMaxCached is the number of cached chunks above which the
+// cache is emptied, when chunks can be written to the underlying store.
+const populatingStoreMaxCached = 1024
+
+func NewPopulatingStore(store chunkstore.IChunkStore, generator IChunkGenerator) *PopulatingStore {
+	return &PopulatingStore{
+		store:     store,
+		generator: generator,
+		chunks:    make(map[uint64]*ChunkData),
+		dirty:     make(map[uint64]bool),
+	}
+}
+
+func (s *PopulatingStore) ReadChunk(chunkLoc ChunkXz) (reader chunkstore.IChunkReader, err os.Error) {
+	// The chunk is covered by the population areas of itself and its
+	// neighbours in the negative X and Z directions.
+	for dx := ChunkCoord(-1); dx <= 0; dx++ {
+		for dz := ChunkCoord(-1); dz <= 0; dz++ {
+			if err = s.populate(ChunkXz{chunkLoc.X + dx, chunkLoc.Z + dz}); err != nil {
+				return
+			}
+		}
+	}
+
+	data, err := s.chunk(chunkLoc)
+	if err != nil {
+		return
+	}
+
+	if s.SupportsWrite() {
+		s.flush()
+
+		// The chunk now belongs to the caller, and the other cached chunks are
+		// safely stored.
+		s.chunks[chunkLoc.ChunkKey()] = nil, false
+		if len(s.chunks) > populatingStoreMaxCached {
+			s.chunks = make(map[uint64]*ChunkData)
+		}
+	} else {
+		s.dirty = make(map[uint64]bool)
+	}
+
+	return data, nil
+}
+
+// chunk returns the chunk at chunkLoc, reading it from the underlying store or
+// generating its terrain if it is not already cached.
+func (s *PopulatingStore) chunk(chunkLoc ChunkXz) (data *ChunkData, err os.Error) {
+	key := chunkLoc.ChunkKey()
+	if data, ok := s.chunks[key]; ok {
+		return data, nil
+	}
+
+	if s.store != nil {
+		result := <-s.store.ReadChunk(chunkLoc)
+		if result.Err == nil {
+			data = chunkDataFromReader(result.Reader)
+		} else if _, ok := result.Err.(chunkstore.NoSuchChunkError); !ok {
+			return nil, result.Err
+		}
+	}
+
+	if data == nil {
+		data = s.generator.GenerateTerrain(chunkLoc)
+		s.dirty[key] = true
+	}
+
+	s.chunks[key] = data
+	return data, nil
+}
+
+// populate populates the chunk at chunkLoc, if it has not been already.
+func (s *PopulatingStore) populate(chunkLoc ChunkXz) os.Error {
+	data, err := s.chunk(chunkLoc)
+	if err != nil || data.populated {
+		return err
+	}
+
+	area := newPopulationArea(chunkLoc)
+	for dx := 0; dx < 2; dx++ {
+		for dz := 0; dz < 2; dz++ {
+			loc := ChunkXz{chunkLoc.X + ChunkCoord(dx), chunkLoc.Z + ChunkCoord(dz)}
+			if area.chunks[dx][dz], err = s.chunk(loc); err != nil {
+				return err
+			}
+			s.dirty[loc.ChunkKey()] = true
+		}
+	}
+
+	s.generator.Populate(area)
+	data.populated = true
+
+	return nil
+}
+
+// flush writes the dirty chunks to the underlying store.
+func (s *PopulatingStore) flush() {
+	for key := range s.dirty {
+		writer := s.store.Writer()
+		chunkstore.CopyChunk(writer, s.chunks[key])
+		s.store.WriteChunk(writer)
+	}
+	s.dirty = make(map[uint64]bool)
+}
+
+func (s *PopulatingStore) SupportsWrite() bool {
+	return s.store != nil && s.store.SupportsWrite()
+}
+
+func (s *PopulatingStore) Writer() chunkstore.IChunkWriter {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Writer()
+}
+
+func (s *PopulatingStore) WriteChunk(writer chunkstore.IChunkWriter) os.Error {
+	if !s.SupportsWrite() {
+		return os.NewError("writes not supported")
+	}
+
+	// Any cached copy of the chunk is now stale.
+	loc := writer.ChunkLoc()
+	s.chunks[loc.ChunkKey()] = nil, false
+
+	s.store.WriteChunk(writer)
+	return nil
+}
+
+// Close closes the underlying store.
+func (s *PopulatingStore) Close() {
+	if s.store != nil {
+		s.store.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package generation
+
+import (
+	"bytes"
+	"testing"
+
+	"chunkymonkey/chunkstore"
+	. "chunkymonkey/types"
+)
+
+// stoneArea returns a population area made up of solid stone chunks.
+func stoneArea(loc ChunkXz) *PopulationArea {
+	area := newPopulationArea(loc)
+	for dx := range area.chunks {
+		for dz := range area.chunks[dx] {
+			area.chunks[dx][dz] = stoneChunk(ChunkXz{loc.X + ChunkCoord(dx), loc.Z + ChunkCoord(dz)})
+		}
+	}
+	return area
+}
+
+// memoryChunkService returns a service for an empty MemoryStore, used to
+// check what PopulatingStore writes. The caller must close it.
+func memoryChunkService() *chunkstore.ChunkService {
+	service := chunkstore.NewChunkService(chunkstore.NewMemoryStore(nil))
+	go service.Serve()
+	return service
+}
+
+func TestPopulatingStore_Flags(t *testing.T) {
+	backing := memoryChunkService()
+	defer backing.Close()
+	store := NewPopulatingStore(backing, NewTestGenerator(goldenSeed))
+
+	if _, err := store.ReadChunk(ChunkXz{0, 0}); err != nil {
+		t.Fatalf("ReadChunk returned error: %v", err)
+	}
+
+	// Reading (0, 0) populates it and its three neighbours in the negative
+	// directions, which writes terrain into the chunks around them.
+	for x := ChunkCoord(-1); x <= 1; x++ {
+		for z := ChunkCoord(-1); z <= 1; z++ {
+			loc := ChunkXz{x, z}
+			result := <-backing.ReadChunk(loc)
+			if result.Err != nil {
+				t.Errorf("chunk %v: expected chunk to be saved: %v", loc, result.Err)
+				continue
+			}
+			if want := x <= 0 && z <= 0; result.Reader.TerrainPopulated() != want {
+				t.Errorf("chunk %v: populated = %v, want %v", loc, result.Reader.TerrainPopulated(), want)
+			}
+		}
+	}
+}
+
+func TestPopulatingStore_Resume(t *testing.T) {
+	gen := NewTestGenerator(goldenSeed)
+	locs := []ChunkXz{{0, 0}, {1, 0}}
+
+	// Generate the chunks without interruption.
+	uninterruptedBacking := memoryChunkService()
+	defer uninterruptedBacking.Close()
+	uninterrupted := NewPopulatingStore(uninterruptedBacking, gen)
+	want := make([][]byte, len(locs))
+	for i, loc := range locs {
+		reader, err := uninterrupted.ReadChunk(loc)
+		if err != nil {
+			t.Fatalf("ReadChunk(%v) returned error: %v", loc, err)
+		}
+		want[i] = reader.Blocks()
+	}
+
+	// Generate the same chunks, restarting between them.
+	backing := memoryChunkService()
+	defer backing.Close()
+	for i, loc := range locs {
+		reader, err := NewPopulatingStore(backing, gen).ReadChunk(loc)
+		if err != nil {
+			t.Fatalf("ReadChunk(%v) returned error: %v", loc, err)
+		}
+		if !bytes.Equal(reader.Blocks(), want[i]) {
+			t.Errorf("chunk %v: differs after resuming generation", loc)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package generation
+
+import (
+	"rand"
+
+	. "chunkymonkey/types"
+)
+
+// chunkSeed derives the seed for the random decisions made while generating
+// a chunk from the world seed and the chunk's location.
+func chunkSeed(seed int64, loc ChunkXz) int64 {
+	return seed ^ (int64(loc.X)*341873128712 + int64(loc.Z)*132897987541)
+}
+
+// chunkRand returns a random number generator for generating the chunk at
+// loc. All random decisions made by generators must come from here (or from
+// noise seeded by the world seed), so that a chunk is always generated the
+// same way for a given world seed.
+func chunkRand(seed int64, loc ChunkXz) *rand.Rand {
+	return rand.New(rand.NewSource(chunkSeed(seed, loc)))
+}
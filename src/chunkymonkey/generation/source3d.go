@@ -0,0 +1,105 @@
+package generation
+
+// ISource3d is the 3D counterpart of ISource. It is used for density functions
+// where the terrain is not simply a height map.
+type ISource3d interface {
+	At3d(x, y, z float64) float64
+}
+
+type Const3d float64
+
+func (gen Const3d) At3d(x, y, z float64) float64 {
+	return float64(gen)
+}
+
+type Offset3d struct {
+	Dx, Dy, Dz float64
+	Source     ISource3d
+}
+
+func (gen *Offset3d) At3d(x, y, z float64) float64 {
+	return gen.Source.At3d(x+gen.Dx, y+gen.Dy, z+gen.Dz)
+}
+
+type Turbulence3d struct {
+	Dx, Dy, Dz ISource3d
+	Factor     float64
+	Source     ISource3d
+}
+
+func (gen *Turbulence3d) At3d(x, y, z float64) float64 {
+	dx := gen.Dx.At3d(x, y, z) * gen.Factor
+	dy := gen.Dy.At3d(x, y, z) * gen.Factor
+	dz := gen.Dz.At3d(x, y, z) * gen.Factor
+	return gen.Source.At3d(x+dx, y+dy, z+dz)
+}
+
+// Scale3d scales the input coordinates by Wavelength and the output by
+// Amplitude. YScale stretches the source vertically relative to the
+// horizontal axes, a value of 0 is treated as 1.
+type Scale3d struct {
+	Wavelength float64
+	Amplitude  float64
+	YScale     float64
+	Source     ISource3d
+}
+
+func (gen *Scale3d) At3d(x, y, z float64) float64 {
+	yWavelength := gen.Wavelength
+	if gen.YScale != 0 {
+		yWavelength *= gen.YScale
+	}
+	return gen.Source.At3d(x/gen.Wavelength, y/yWavelength, z/gen.Wavelength) * gen.Amplitude
+}
+
+type Mult3d struct {
+	A, B ISource3d
+}
+
+func (gen *Mult3d) At3d(x, y, z float64) float64 {
+	return gen.A.At3d(x, y, z) * gen.B.At3d(x, y, z)
+}
+
+type Add3d struct {
+	Source ISource3d
+	Value  float64
+}
+
+func (gen *Add3d) At3d(x, y, z float64) float64 {
+	return gen.Source.At3d(x, y, z) + gen.Value
+}
+
+type Sum3d struct {
+	Inputs []ISource3d
+}
+
+func (gen *Sum3d) At3d(x, y, z float64) float64 {
+	var accum float64
+	for _, input := range gen.Inputs {
+		accum += input.At3d(x, y, z)
+	}
+	return accum
+}
+
+// Extrude turns a 2D source into a 3D one that is constant along the Y axis.
+// The 2D source is sampled at (x, z).
+type Extrude struct {
+	Source ISource
+}
+
+func (gen *Extrude) At3d(x, y, z float64) float64 {
+	return gen.Source.At2d(x, z)
+}
+
+// HeightGradient is a 3D source that decreases linearly with height, crossing
+// zero at Base and falling by one for every Falloff blocks above it. Adding it
+// to 3D noise gives a density function that is mostly solid below Base and
+// mostly empty above it.
+type HeightGradient struct {
+	Base    float64
+	Falloff float64
+}
+
+func (gen *HeightGradient) At3d(x, y, z float64) float64 {
+	return (gen.Base - y) / gen.Falloff
+}
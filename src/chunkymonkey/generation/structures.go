@@ -0,0 +1,347 @@
+package generation
+
+import (
+	"math"
+	"rand"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+const (
+	// One in lakeChance populations attempts to place a water lake on the
+	// surface, and one in lavaPoolChance attempts to place a lava pool
+	// underground.
+	lakeChance     = 4
+	lavaPoolChance = 8
+
+	// Lakes are shaped within a box of this size. The lower half of the box is
+	// filled with liquid, and the upper half is cleared.
+	lakeSizeH = 16
+	lakeSizeY = 8
+
+	// dungeonAttempts is the number of places that each population tries to
+	// fit a dungeon. Most attempts fail, as dungeons need an enclosed space
+	// with a few openings into caves.
+	dungeonAttempts    = 8
+	dungeonMinOpenings = 1
+	dungeonMaxOpenings = 5
+	dungeonHeight      = 4
+
+	dungeonChests        = 2
+	dungeonChestAttempts = 3
+	dungeonChestItems    = 8
+	dungeonChestSlots    = 27
+
+	// Grass and dirt at most beachHeight blocks above sea level, and within
+	// beachWidth blocks of water, become sand.
+	beachHeight = 2
+	beachWidth  = 3
+)
+
+// dungeonMobTypes are the mobs that dungeon spawners may spawn. Repeated
+// entries are more likely.
+var dungeonMobTypes = []string{"Skeleton", "Zombie", "Zombie", "Spider"}
+
+// dungeonLoot describes an item that may be found in dungeon chests.
+type dungeonLoot struct {
+	itemTypeId ItemTypeId
+	data       ItemData
+	// The item is found in stacks of between minCount and maxCount.
+	minCount, maxCount ItemCount
+	// weight is the relative chance of the item being chosen.
+	weight int
+}
+
+var dungeonLootTable = []dungeonLoot{
+	{itemTypeId: 329, minCount: 1, maxCount: 1, weight: 1},  // saddle
+	{itemTypeId: 265, minCount: 1, maxCount: 4, weight: 2},  // iron ingot
+	{itemTypeId: 297, minCount: 1, maxCount: 1, weight: 2},  // bread
+	{itemTypeId: 296, minCount: 1, maxCount: 4, weight: 2},  // wheat
+	{itemTypeId: 289, minCount: 1, maxCount: 4, weight: 2},  // gunpowder
+	{itemTypeId: 287, minCount: 1, maxCount: 4, weight: 2},  // string
+	{itemTypeId: 325, minCount: 1, maxCount: 1, weight: 2},  // bucket
+	{itemTypeId: 322, minCount: 1, maxCount: 1, weight: 1},  // golden apple
+	{itemTypeId: 331, minCount: 1, maxCount: 4, weight: 1},  // redstone
+	{itemTypeId: 2256, minCount: 1, maxCount: 1, weight: 1}, // gold music disc
+	{itemTypeId: 2257, minCount: 1, maxCount: 1, weight: 1}, // green music disc
+}
+
+// isSolid returns true if the block is neither air nor a liquid.
+func isSolid(blockId BlockId) bool {
+	switch blockId {
+	case BlockIdAir, BlockIdWater, BlockIdStationaryWater, BlockIdFlowingLava, BlockIdLava:
+		return false
+	}
+	return true
+}
+
+// isLiquid returns true if the block is water or lava.
+func isLiquid(blockId BlockId) bool {
+	switch blockId {
+	case BlockIdWater, BlockIdStationaryWater, BlockIdFlowingLava, BlockIdLava:
+		return true
+	}
+	return false
+}
+
+// addLakes may place a water lake on the surface and a lava pool underground,
+// centred in the middle of the area.
+func addLakes(area *PopulationArea, randGen *rand.Rand) {
+	if randGen.Intn(lakeChance) == 0 {
+		x := populateMin + randGen.Intn(ChunkSizeH)
+		z := populateMin + randGen.Intn(ChunkSizeH)
+		// The surface of the lake is a block below the ground, so that it is
+		// enclosed by its banks. Lakes below sea level would just be part of
+		// the sea.
+		y := area.Height(x, z) - 2
+		if y > SeaLevel {
+			placeLake(area, x, y, z, BlockIdStationaryWater, randGen)
+		}
+	}
+
+	if randGen.Intn(lavaPoolChance) == 0 {
+		x := populateMin + randGen.Intn(ChunkSizeH)
+		z := populateMin + randGen.Intn(ChunkSizeH)
+		y := lakeSizeY + randGen.Intn(SeaLevel-2*lakeSizeY)
+		placeLake(area, x, y, z, BlockIdLava, randGen)
+	}
+}
+
+// placeLake fills a blob-shaped hole whose surface is at height cy with the
+// given liquid. The lake is not placed if it would not be contained by solid
+// blocks, or if it would break into other liquid above its surface.
+func placeLake(area *PopulationArea, cx, cy, cz int, liquid BlockId, randGen *rand.Rand) (placed bool) {
+	var shape [lakeSizeH][lakeSizeY][lakeSizeH]bool
+	baseX, baseY, baseZ := cx-lakeSizeH/2, cy-lakeSizeY/2+1, cz-lakeSizeH/2
+
+	// The lake is the union of several overlapping ellipsoids.
+	numBlobs := 4 + randGen.Intn(4)
+	for i := 0; i < numBlobs; i++ {
+		rx := 1.5 + randGen.Float64()*2.5
+		ry := 1 + randGen.Float64()*1.5
+		rz := 1.5 + randGen.Float64()*2.5
+		bx := rx + 1 + randGen.Float64()*(lakeSizeH-2*rx-2)
+		by := ry + 1 + randGen.Float64()*(lakeSizeY-2*ry-2)
+		bz := rz + 1 + randGen.Float64()*(lakeSizeH-2*rz-2)
+
+		for x := 1; x < lakeSizeH-1; x++ {
+			dx := (float64(x) + 0.5 - bx) / rx
+			for y := 1; y < lakeSizeY-1; y++ {
+				dy := (float64(y) + 0.5 - by) / ry
+				for z := 1; z < lakeSizeH-1; z++ {
+					dz := (float64(z) + 0.5 - bz) / rz
+					if dx*dx+dy*dy+dz*dz < 1 {
+						shape[x][y][z] = true
+					}
+				}
+			}
+		}
+	}
+
+	inShape := func(x, y, z int) bool {
+		return x >= 0 && x < lakeSizeH && y >= 0 && y < lakeSizeY && z >= 0 && z < lakeSizeH && shape[x][y][z]
+	}
+
+	// Check the blocks bordering the lake.
+	for x := 0; x < lakeSizeH; x++ {
+		for y := 0; y < lakeSizeY; y++ {
+			for z := 0; z < lakeSizeH; z++ {
+				if shape[x][y][z] || !(inShape(x-1, y, z) || inShape(x+1, y, z) ||
+					inShape(x, y-1, z) || inShape(x, y+1, z) ||
+					inShape(x, y, z-1) || inShape(x, y, z+1)) {
+					continue
+				}
+
+				blockId := area.BlockAt(baseX+x, baseY+y, baseZ+z)
+				if y < lakeSizeY/2 && !isSolid(blockId) {
+					return false
+				} else if y >= lakeSizeY/2 && isLiquid(blockId) {
+					return false
+				}
+			}
+		}
+	}
+
+	for x := 0; x < lakeSizeH; x++ {
+		for y := lakeSizeY - 1; y >= 0; y-- {
+			for z := 0; z < lakeSizeH; z++ {
+				if !shape[x][y][z] {
+					continue
+				}
+				if y < lakeSizeY/2 {
+					area.SetBlock(baseX+x, baseY+y, baseZ+z, liquid, 0)
+				} else {
+					area.SetBlock(baseX+x, baseY+y, baseZ+z, BlockIdAir, 0)
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// addDungeons tries to fit dungeons into enclosed spaces in the middle of the
+// area.
+func addDungeons(area *PopulationArea, randGen *rand.Rand) {
+	for i := 0; i < dungeonAttempts; i++ {
+		x := populateMin + randGen.Intn(ChunkSizeH)
+		y := 1 + randGen.Intn(ChunkSizeY-dungeonHeight-2)
+		z := populateMin + randGen.Intn(ChunkSizeH)
+		placeDungeon(area, x, y, z, randGen)
+	}
+}
+
+// placeDungeon places a cobblestone room with its floor at height y, centred
+// on (x, z). The room holds a mob spawner and loot chests. The dungeon is
+// only placed if its floor and ceiling are solid and its walls have a few
+// openings.
+func placeDungeon(area *PopulationArea, x, y, z int, randGen *rand.Rand) (placed bool) {
+	rx := 2 + randGen.Intn(2)
+	rz := 2 + randGen.Intn(2)
+
+	minX, maxX := x-rx-1, x+rx+1
+	minZ, maxZ := z-rz-1, z+rz+1
+	floor, ceiling := y-1, y+dungeonHeight
+
+	openings := 0
+	for bx := minX; bx <= maxX; bx++ {
+		for bz := minZ; bz <= maxZ; bz++ {
+			if !isSolid(area.BlockAt(bx, floor, bz)) || !isSolid(area.BlockAt(bx, ceiling, bz)) {
+				return false
+			}
+			wall := bx == minX || bx == maxX || bz == minZ || bz == maxZ
+			if wall && area.BlockAt(bx, y, bz) == BlockIdAir && area.BlockAt(bx, y+1, bz) == BlockIdAir {
+				openings++
+			}
+		}
+	}
+	if openings < dungeonMinOpenings || openings > dungeonMaxOpenings {
+		return false
+	}
+
+	for bx := minX; bx <= maxX; bx++ {
+		for bz := minZ; bz <= maxZ; bz++ {
+			wall := bx == minX || bx == maxX || bz == minZ || bz == maxZ
+			for by := ceiling; by >= floor; by-- {
+				switch {
+				case by == floor:
+					if randGen.Intn(4) == 0 {
+						area.SetBlock(bx, by, bz, BlockIdCobblestone, 0)
+					} else {
+						area.SetBlock(bx, by, bz, BlockIdMossyCobblestone, 0)
+					}
+				case !wall && by != ceiling:
+					area.SetBlock(bx, by, bz, BlockIdAir, 0)
+				case isSolid(area.BlockAt(bx, by, bz)):
+					// Walls and ceiling keep the openings into the caves.
+					area.SetBlock(bx, by, bz, BlockIdCobblestone, 0)
+				}
+			}
+		}
+	}
+
+	for i := 0; i < dungeonChests; i++ {
+		for attempt := 0; attempt < dungeonChestAttempts; attempt++ {
+			cx := x - rx + randGen.Intn(2*rx+1)
+			cz := z - rz + randGen.Intn(2*rz+1)
+			if (cx == x && cz == z) || area.BlockAt(cx, y, cz) != BlockIdAir || !chestAgainstWall(area, cx, y, cz) {
+				continue
+			}
+
+			area.SetBlock(cx, y, cz, BlockIdChest, 0)
+			area.AddTileEntity(cx, y, cz, gamerules.NewChestTileEntityAt(
+				area.BlockXyz(cx, y, cz), dungeonChestContents(randGen)))
+			break
+		}
+	}
+
+	mobType := dungeonMobTypes[randGen.Intn(len(dungeonMobTypes))]
+	area.SetBlock(x, y, z, BlockIdMobSpawner, 0)
+	area.AddTileEntity(x, y, z, gamerules.NewMobSpawnerTileEntityAt(
+		area.BlockXyz(x, y, z), mobType))
+
+	return true
+}
+
+// chestAgainstWall returns true if exactly one of the blocks beside (x, y, z)
+// is solid, so that chests are placed against walls, but not in corners.
+func chestAgainstWall(area *PopulationArea, x, y, z int) bool {
+	walls := 0
+	for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		if isSolid(area.BlockAt(x+offset[0], y, z+offset[1])) {
+			walls++
+		}
+	}
+	return walls == 1
+}
+
+// dungeonChestContents chooses the items in a dungeon chest, indexed by slot.
+func dungeonChestContents(randGen *rand.Rand) []gamerules.Slot {
+	totalWeight := 0
+	for i := range dungeonLootTable {
+		totalWeight += dungeonLootTable[i].weight
+	}
+
+	items := make([]gamerules.Slot, dungeonChestSlots)
+	for i := 0; i < dungeonChestItems; i++ {
+		choice := randGen.Intn(totalWeight)
+		var loot *dungeonLoot
+		for j := range dungeonLootTable {
+			loot = &dungeonLootTable[j]
+			if choice < loot.weight {
+				break
+			}
+			choice -= loot.weight
+		}
+
+		count := loot.minCount + ItemCount(randGen.Intn(int(loot.maxCount-loot.minCount)+1))
+		slot := randGen.Intn(len(items))
+		items[slot] = gamerules.Slot{
+			ItemTypeId: loot.itemTypeId,
+			Count:      count,
+			Data:       loot.data,
+		}
+	}
+
+	return items
+}
+
+// addBeaches turns grass and dirt near sea level into sand, where it is close
+// to water.
+func addBeaches(area *PopulationArea) {
+	for x := populateMin; x < populateMax; x++ {
+		for z := populateMin; z < populateMax; z++ {
+			y := area.Height(x, z) - 1
+			if y < SeaLevel || y > SeaLevel+beachHeight {
+				continue
+			}
+			if !nearWater(area, x, z) {
+				continue
+			}
+
+			for by := y; by > y-3 && by > 0; by-- {
+				switch area.BlockAt(x, by, z) {
+				case BlockIdGrass, BlockIdDirt:
+					area.SetBlock(x, by, z, BlockIdSand, 0)
+				}
+			}
+		}
+	}
+}
+
+// nearWater returns true if there is water at sea level within beachWidth
+// blocks of the column.
+func nearWater(area *PopulationArea, x, z int) bool {
+	for dx := -beachWidth; dx <= beachWidth; dx++ {
+		for dz := -beachWidth; dz <= beachWidth; dz++ {
+			if math.Hypot(float64(dx), float64(dz)) > beachWidth {
+				continue
+			}
+			if area.BlockAt(x+dx, SeaLevel, z+dz) == BlockIdStationaryWater {
+				return true
+			}
+		}
+	}
+	return false
+}
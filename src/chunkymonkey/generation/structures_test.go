@@ -0,0 +1,151 @@
+package generation
+
+import (
+	"testing"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+func TestPlaceDungeon(t *testing.T) {
+	area := stoneArea(ChunkXz{0, 0})
+	x, y, z := 16, 40, 16
+
+	// A tunnel leading into the dungeon gives it an opening.
+	for bx := 0; bx <= x; bx++ {
+		area.SetBlock(bx, y, z, BlockIdAir, 0)
+		area.SetBlock(bx, y+1, z, BlockIdAir, 0)
+	}
+
+	if !placeDungeon(area, x, y, z, chunkRand(0, area.Loc)) {
+		t.Fatalf("expected dungeon to be placed")
+	}
+
+	if blockId := area.BlockAt(x, y, z); blockId != BlockIdMobSpawner {
+		t.Errorf("expected mob spawner at the centre of the dungeon, got %d", blockId)
+	}
+
+	ids := make(map[string]int)
+	for _, row := range area.chunks {
+		for _, data := range row {
+			for _, tileEntity := range data.tileEntities {
+				blockLoc := tileEntity.Block()
+				chunkLoc, _ := blockLoc.ToChunkLocal()
+				if !chunkLoc.Equals(data.loc) {
+					t.Errorf("tile entity at %v stored in chunk %v", blockLoc, data.loc)
+				}
+
+				tag := nbt.NewCompound()
+				if err := tileEntity.MarshalNbt(tag); err != nil {
+					t.Fatalf("MarshalNbt returned error: %v", err)
+				}
+				id, _ := tag.Lookup("id").(*nbt.String)
+				if id == nil {
+					t.Fatalf("tile entity at %v has no id", blockLoc)
+				}
+				ids[id.Value]++
+			}
+		}
+	}
+	if ids["MobSpawner"] != 1 {
+		t.Errorf("expected one mob spawner tile entity, got %d", ids["MobSpawner"])
+	}
+	if ids["Chest"] < 1 {
+		t.Errorf("expected at least one chest tile entity")
+	}
+}
+
+func TestPlaceDungeon_Replaced(t *testing.T) {
+	area := stoneArea(ChunkXz{0, 0})
+	x, y, z := 16, 40, 16
+	for bx := 0; bx <= x; bx++ {
+		area.SetBlock(bx, y, z, BlockIdAir, 0)
+		area.SetBlock(bx, y+1, z, BlockIdAir, 0)
+	}
+
+	if !placeDungeon(area, x, y, z, chunkRand(0, area.Loc)) {
+		t.Fatalf("expected dungeon to be placed")
+	}
+
+	// Flood the dungeon, as a lake carved through it would.
+	for bx := x - 3; bx <= x+3; bx++ {
+		for bz := z - 3; bz <= z+3; bz++ {
+			area.SetBlock(bx, y, bz, BlockIdStationaryWater, 0)
+		}
+	}
+
+	for _, row := range area.chunks {
+		for _, data := range row {
+			for _, tileEntity := range data.tileEntities {
+				t.Errorf("tile entity left at %v after its block was replaced", tileEntity.Block())
+			}
+		}
+	}
+}
+
+func TestPlaceDungeon_Enclosed(t *testing.T) {
+	area := stoneArea(ChunkXz{0, 0})
+
+	if placeDungeon(area, 16, 40, 16, chunkRand(0, area.Loc)) {
+		t.Errorf("expected no dungeon without an opening")
+	}
+}
+
+func TestDungeonChestContents(t *testing.T) {
+	items := dungeonChestContents(chunkRand(0, ChunkXz{0, 0}))
+	if len(items) != dungeonChestSlots {
+		t.Fatalf("expected %d slots, got %d", dungeonChestSlots, len(items))
+	}
+
+	for i := range items {
+		item := &items[i]
+		if item.Count == 0 {
+			continue
+		}
+		if _, ok := gamerules.Items[item.ItemTypeId]; !ok {
+			t.Errorf("slot %d: unknown item type %d", i, item.ItemTypeId)
+		}
+	}
+}
+
+func TestPlaceLake(t *testing.T) {
+	area := stoneArea(ChunkXz{0, 0})
+	surface := 70
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			for y := surface + 1; y < ChunkSizeY; y++ {
+				area.SetBlock(x, y, z, BlockIdAir, 0)
+			}
+		}
+	}
+
+	if !placeLake(area, 16, surface-1, 16, BlockIdStationaryWater, chunkRand(0, area.Loc)) {
+		t.Fatalf("expected lake to be placed in flat ground")
+	}
+
+	water := 0
+	for x := 0; x < populationAreaSize; x++ {
+		for z := 0; z < populationAreaSize; z++ {
+			for y := 0; y < ChunkSizeY; y++ {
+				if area.BlockAt(x, y, z) != BlockIdStationaryWater {
+					continue
+				}
+				water++
+				if y >= surface {
+					t.Fatalf("water above the ground at (%d, %d, %d)", x, y, z)
+				}
+				// The water must not be able to flow away.
+				for _, offset := range [][3]int{{-1, 0, 0}, {1, 0, 0}, {0, -1, 0}, {0, 0, -1}, {0, 0, 1}} {
+					neighbour := area.BlockAt(x+offset[0], y+offset[1], z+offset[2])
+					if neighbour == BlockIdAir {
+						t.Fatalf("water at (%d, %d, %d) is not contained", x, y, z)
+					}
+				}
+			}
+		}
+	}
+	if water == 0 {
+		t.Errorf("expected some water to be placed")
+	}
+}
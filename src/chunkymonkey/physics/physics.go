@@ -62,6 +62,15 @@ func (obj *PointObject) Init(position *AbsXyz, velocity *AbsVelocity) {
 	obj.onGround = false
 }
 
+// AddVelocity adds to the object's current velocity, e.g when it is pushed by
+// an explosion.
+func (obj *PointObject) AddVelocity(dv *AbsVelocity) {
+	obj.velocity.X += dv.X
+	obj.velocity.Y += dv.Y
+	obj.velocity.Z += dv.Z
+	obj.onGround = false
+}
+
 func (obj *PointObject) UnmarshalNbt(tag *nbt.Compound) (err os.Error) {
 	// Position within the chunk
 	if obj.position, err = nbtutil.ReadAbsXyz(tag, "Pos"); err != nil {
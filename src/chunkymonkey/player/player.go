@@ -5,6 +5,7 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -39,6 +40,8 @@ const (
 
 	PingTimeoutNs  = 1e9 * 60 // Player connection times out after 60 seconds.
 	PingIntervalNs = 1e9 * 20 // Time between receiving keep alive response from client and sending new request.
+
+	PortalCooldownNs = 1e9 * 5 // Minimum time between travelling through portals.
 )
 
 func init() {
@@ -52,7 +55,8 @@ type Player struct {
 
 	EntityId
 	playerClient   playerClient
-	shardConnecter gamerules.IShardConnecter
+	world          *World
+	shardConnecter gamerules.IShardConnecter // For the current dimension.
 	conn           net.Conn
 	name           string
 	loginComplete  bool
@@ -84,16 +88,18 @@ type Player struct {
 	// The following attributes are game-logic related.
 
 	// Data entries that may change
-	spawnBlock BlockXyz
-	position   AbsXyz
-	height     AbsCoord
-	look       LookDegrees
-	chunkSubs  chunkSubscriptions
-	health     Health
-	food       FoodUnits
+	position  AbsXyz
+	height    AbsCoord
+	look      LookDegrees
+	chunkSubs chunkSubscriptions
+	health    Health
+	food      FoodUnits
+	dimension DimensionId
+
+	// Time of the last transfer through a portal, in nanoseconds.
+	lastPortalNs int64
 
 	// The following data fields are loaded, but not used yet
-	dimension    int32
 	onGround     int8
 	sleeping     int8
 	fallDistance float32
@@ -112,23 +118,13 @@ type Player struct {
 	remoteInv    *RemoteInventory
 }
 
-func NewPlayer(entityId EntityId, shardConnecter gamerules.IShardConnecter, conn net.Conn, name string, spawnBlock BlockXyz, onDisconnect chan<- EntityId, game gamerules.IGame) *Player {
+func NewPlayer(entityId EntityId, world *World, conn net.Conn, name string, onDisconnect chan<- EntityId, game gamerules.IGame) *Player {
 	player := &Player{
-		EntityId:       entityId,
-		shardConnecter: shardConnecter,
-		conn:           conn,
-		name:           name,
-		spawnBlock:     spawnBlock,
-		position: AbsXyz{
-			X: AbsCoord(spawnBlock.X),
-			Y: AbsCoord(spawnBlock.Y),
-			Z: AbsCoord(spawnBlock.Z),
-		},
-		height: StanceNormal,
-		look:   LookDegrees{0, 0},
-
-		health: MaxHealth,
-		food:   MaxFoodUnits, // TODO: Check what initial level should be.
+		EntityId: entityId,
+		world:    world,
+		conn:     conn,
+		name:     name,
+		height:   StanceNormal,
 
 		curWindow:    nil,
 		nextWindowId: WindowIdFreeMin,
@@ -145,11 +141,29 @@ func NewPlayer(entityId EntityId, shardConnecter gamerules.IShardConnecter, conn
 	}
 
 	player.playerClient.Init(player)
-	player.inventory.Init(player.EntityId, player)
+	player.resetWorldState()
 
 	return player
 }
 
+// resetWorldState puts the player at the spawn point of their world with full
+// health and an empty inventory, as for a player new to the world.
+func (player *Player) resetWorldState() {
+	spawnBlock := player.world.SpawnBlock
+	player.position = AbsXyz{
+		X: AbsCoord(spawnBlock.X),
+		Y: AbsCoord(spawnBlock.Y),
+		Z: AbsCoord(spawnBlock.Z),
+	}
+	player.look = LookDegrees{0, 0}
+	player.dimension = DimensionNormal
+
+	player.health = MaxHealth
+	player.food = MaxFoodUnits // TODO: Check what initial level should be.
+
+	player.inventory.Init(player.EntityId, player)
+}
+
 func (player *Player) Name() string {
 	return player.name
 }
@@ -166,6 +180,18 @@ func (player *Player) SetPosition(pos AbsXyz) {
 	player.position = pos
 }
 
+// WorldName returns the name of the world that the player is in.
+func (player *Player) WorldName() string {
+	return player.world.Name
+}
+
+// Dimension returns the dimension of the world that the player is in. It must
+// be called from the player's goroutine, such as by a function given to
+// Enqueue.
+func (player *Player) Dimension() DimensionId {
+	return player.dimension
+}
+
 func (player *Player) Client() gamerules.IPlayerClient {
 	return &player.playerClient
 }
@@ -199,9 +225,11 @@ func (player *Player) UnmarshalNbt(tag *nbt.Compound) (err os.Error) {
 		return
 	}
 
-	if player.dimension, err = nbtutil.ReadInt(tag, "Dimension"); err != nil {
+	dimension, err := nbtutil.ReadInt(tag, "Dimension")
+	if err != nil {
 		return
 	}
+	player.dimension = DimensionId(dimension)
 
 	if player.sleeping, err = nbtutil.ReadByte(tag, "Sleeping"); err != nil {
 		return
@@ -250,7 +278,7 @@ func (player *Player) MarshalNbt(tag *nbt.Compound) (err os.Error) {
 	}
 
 	tag.Set("OnGround", &nbt.Byte{player.onGround})
-	tag.Set("Dimension", &nbt.Int{player.dimension})
+	tag.Set("Dimension", &nbt.Int{int32(player.dimension)})
 	tag.Set("Sleeping", &nbt.Byte{player.sleeping})
 	tag.Set("FallDistance", &nbt.Float{player.fallDistance})
 	tag.Set("SleepTimer", &nbt.Short{player.sleepTimer})
@@ -288,14 +316,14 @@ func (player *Player) getHeldItemTypeId() ItemTypeId {
 }
 
 func (player *Player) Run() {
+	player.selectShardConnecter()
+
 	buf := &bytes.Buffer{}
-	// TODO pass proper dimension. This is low priority, because we don't yet
-	// support multiple dimensions.
 	// TODO pass proper map seed.
 	// TODO pass proper values for the difficulty.
 	// TODO proper max number of players.
-	proto.ServerWriteLogin(buf, player.EntityId, 0, 0, DimensionNormal, GameDifficultyNormal, MaxYCoord+1, 8)
-	proto.WriteSpawnPosition(buf, &player.spawnBlock)
+	proto.ServerWriteLogin(buf, player.EntityId, 0, 0, player.dimension, GameDifficultyNormal, MaxYCoord+1, 8)
+	proto.WriteSpawnPosition(buf, &player.world.SpawnBlock)
 	player.TransmitPacket(buf.Bytes())
 
 	go player.receiveLoop()
@@ -303,6 +331,19 @@ func (player *Player) Run() {
 	go player.mainLoop()
 }
 
+// selectShardConnecter connects the player to the shards of the dimension that
+// they are in.
+func (player *Player) selectShardConnecter() {
+	var ok bool
+	if player.shardConnecter, ok = player.world.ShardConnecters[player.dimension]; !ok {
+		// The player was saved in a dimension that this world doesn't have.
+		log.Printf("%v: unknown dimension %d, moving to spawn", player, player.dimension)
+		player.dimension = DimensionNormal
+		player.shardConnecter = player.world.ShardConnecters[DimensionNormal]
+		player.position = *player.world.SpawnBlock.ToAbsXyz()
+	}
+}
+
 func (player *Player) Stop() {
 	// Don't block. If the channel has a message in already, then that's good
 	// enough.
@@ -916,6 +957,136 @@ func (player *Player) closeCurrentWindow(sendClosePacket bool) {
 	player.inventory.Resubscribe()
 }
 
+// inflictDamage reduces the player's health and sends them the knockback
+// velocity.
+func (player *Player) inflictDamage(damage Health, knockback *AbsVelocity) {
+	player.health -= damage
+	if player.health < 0 {
+		player.health = 0
+	}
+
+	buf := new(bytes.Buffer)
+	proto.WriteUpdateHealth(buf, player.health, player.food, 0)
+	proto.WriteEntityVelocity(buf, player.EntityId, knockback.ToVelocity())
+	player.TransmitPacket(buf.Bytes())
+}
+
+// transferDimension moves the player into another dimension. Their chunk
+// subscriptions are moved over to the shards of the new dimension, and the
+// destination shard is asked to link them to a portal. It must be called with
+// player.lock held.
+func (player *Player) transferDimension(dimension DimensionId, pos AbsXyz) {
+	now := time.Nanoseconds()
+	if now-player.lastPortalNs < PortalCooldownNs {
+		// Don't keep bouncing the player between dimensions while they stand in
+		// the portal that they arrived through.
+		return
+	}
+
+	shardConnecter, ok := player.world.ShardConnecters[dimension]
+	if !ok {
+		log.Printf("%v: cannot transfer to unknown dimension %d", player, dimension)
+		return
+	}
+	player.lastPortalNs = now
+
+	player.closeCurrentWindow(true)
+	player.chunkSubs.Close()
+
+	player.dimension = dimension
+	player.shardConnecter = shardConnecter
+	player.position = pos
+	player.spawnComplete = false
+
+	buf := new(bytes.Buffer)
+	writeRespawn(buf, dimension)
+	player.TransmitPacket(buf.Bytes())
+
+	player.chunkSubs.Init(player)
+	if shard, ok := player.chunkSubs.CurrentShardClient(); ok {
+		shard.ReqLinkPortal(*pos.ToBlockXyz())
+	}
+}
+
+// ChangeWorld moves the player into another world.
+func (player *Player) ChangeWorld(world *World) {
+	player.Enqueue(func(_ *Player) {
+		player.changeWorld(world)
+	})
+}
+
+// changeWorld moves the player into another world. The player's data for the
+// world that they are leaving is stored, and their data for the new world is
+// loaded. It must be called with player.lock held.
+func (player *Player) changeWorld(world *World) {
+	if world == player.world {
+		player.echoMessage(fmt.Sprintf("You are already in world %q", world.Name))
+		return
+	}
+
+	// Load and store data before leaving the current world, so that the player
+	// can stay where they are if either fails.
+	newData, err := world.Store.PlayerData(player.name)
+	if err != nil {
+		log.Printf("%v: failed to read player data for world %q: %v", player, world.Name, err)
+		player.echoMessage(fmt.Sprintf("Could not enter world %q", world.Name))
+		return
+	}
+
+	oldData := nbt.NewCompound()
+	if err = player.MarshalNbt(oldData); err == nil {
+		err = player.world.Store.WritePlayerData(player.name, oldData)
+	}
+	if err != nil {
+		log.Printf("%v: failed to write player data for world %q: %v", player, player.world.Name, err)
+		player.echoMessage(fmt.Sprintf("Could not leave world %q", player.world.Name))
+		return
+	}
+
+	player.closeCurrentWindow(true)
+	player.chunkSubs.Close()
+
+	oldDimension := player.dimension
+	player.world = world
+	player.resetWorldState()
+	if newData != nil {
+		if err = player.UnmarshalNbt(newData); err != nil {
+			log.Printf("%v: bad player data for world %q, starting at spawn: %v", player, world.Name, err)
+			player.resetWorldState()
+		}
+	}
+	player.selectShardConnecter()
+	player.spawnComplete = false
+
+	buf := new(bytes.Buffer)
+	if player.dimension == oldDimension {
+		// The client only discards its loaded chunks when respawning into a
+		// different dimension, so go via the other dimension.
+		if oldDimension == DimensionNormal {
+			writeRespawn(buf, DimensionNether)
+		} else {
+			writeRespawn(buf, DimensionNormal)
+		}
+	}
+	writeRespawn(buf, player.dimension)
+	proto.WriteSpawnPosition(buf, &world.SpawnBlock)
+	player.TransmitPacket(buf.Bytes())
+
+	player.chunkSubs.Init(player)
+}
+
+// writeRespawn writes a respawn packet for the given dimension.
+func writeRespawn(writer io.Writer, dimension DimensionId) {
+	// TODO pass proper map seed.
+	proto.WriteRespawn(writer, dimension, GameDifficultyNormal, GameTypeSurvival, MaxYCoord+1, 0)
+}
+
+func (player *Player) echoMessage(msg string) {
+	buf := new(bytes.Buffer)
+	proto.WriteChatMessage(buf, msg)
+	player.TransmitPacket(buf.Bytes())
+}
+
 // setPositionLook sets the player's position and look angle. It also notifies
 // other players in the area of interest that the player has moved.
 func (player *Player) setPositionLook(pos AbsXyz, look LookDegrees) {
@@ -1,7 +1,6 @@
 package player
 
 import (
-	"bytes"
 	"chunkymonkey/gamerules"
 	"chunkymonkey/proto"
 	. "chunkymonkey/types"
@@ -92,13 +91,17 @@ func (p *playerClient) GiveItem(item gamerules.Slot) {
 }
 
 func (p *playerClient) EchoMessage(msg string) {
-	p.player.Enqueue(func(_ *Player) {
-		buf := new(bytes.Buffer)
-		proto.WriteChatMessage(buf, msg)
-		p.TransmitPacket(buf.Bytes())
+	p.player.Enqueue(func(player *Player) {
+		player.echoMessage(msg)
 	})
 }
 
+func (p *playerClient) HasPermission(node string) bool {
+	// Permissions are not changed once loaded, so they are safe to read from
+	// any goroutine.
+	return gamerules.Permissions.UserPermissions(p.player.name).Has(node)
+}
+
 func (p *playerClient) PositionLook() (AbsXyz, LookDegrees) {
 	posChan := make(chan AbsXyz)
 	lookChan := make(chan LookDegrees)
@@ -121,3 +124,15 @@ func (p *playerClient) SetPositionLook(pos AbsXyz, look LookDegrees) {
 		player.setPositionLook(pos, look)
 	})
 }
+
+func (p *playerClient) InflictDamage(damage Health, knockback AbsVelocity) {
+	p.player.Enqueue(func(player *Player) {
+		player.inflictDamage(damage, &knockback)
+	})
+}
+
+func (p *playerClient) TransferDimension(dimension DimensionId, position AbsXyz) {
+	p.player.Enqueue(func(player *Player) {
+		player.transferDimension(dimension, position)
+	})
+}
@@ -0,0 +1,28 @@
+package player
+
+import (
+	"os"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+// IPlayerStore stores the data of players within a world.
+type IPlayerStore interface {
+	// PlayerData returns the stored data for the named player, or nil if there
+	// is none.
+	PlayerData(user string) (playerData *nbt.Compound, err os.Error)
+
+	// WritePlayerData stores the data for the named player.
+	WritePlayerData(user string, data *nbt.Compound) (err os.Error)
+}
+
+// World holds what a player needs to know about one of the worlds hosted by
+// the server. Each world keeps its own player positions and inventories.
+type World struct {
+	Name            string
+	ShardConnecters map[DimensionId]gamerules.IShardConnecter
+	SpawnBlock      BlockXyz
+	Store           IPlayerStore
+}
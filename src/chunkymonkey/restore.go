@@ -0,0 +1,74 @@
+package chunkymonkey
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/player"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+// restoreChunksForPlayer restores the chunks at chunkLocs from the named
+// snapshot of the world that the player is in, into that world and the
+// player's dimension.
+func (game *Game) restoreChunksForPlayer(client gamerules.IPlayerClient, snapshotName string, chunkLocs []ChunkXz) (count int, err os.Error) {
+	// The name must not lead outside of the world's snapshots.
+	if !worldstore.IsSnapshotName(snapshotName) {
+		return 0, fmt.Errorf("%q is not the name of a snapshot", snapshotName)
+	}
+
+	playerChan := make(chan *player.Player)
+	game.enqueue(func(_ *Game) {
+		playerChan <- game.players[client.GetEntityId()]
+	})
+	p := <-playerChan
+	if p == nil {
+		return 0, os.NewError("player is not connected")
+	}
+
+	type location struct {
+		worldName string
+		dimension DimensionId
+	}
+
+	result := make(chan location, 1)
+	p.Enqueue(func(p *player.Player) {
+		result <- location{p.WorldName(), p.Dimension()}
+	})
+
+	var loc location
+	select {
+	case loc = <-result:
+	case <-time.After(playerQueryTimeout):
+		return 0, os.NewError("timed out finding the player's world")
+	}
+
+	backupPath := path.Join(SnapshotDir, loc.worldName, snapshotName)
+	count, err = game.worlds[loc.worldName].restoreChunks(loc.dimension, backupPath, chunkLocs)
+	if err == nil {
+		log.Printf("%v restored %d chunks in world %q from %s", p, count, loc.worldName, backupPath)
+	}
+
+	return
+}
+
+// The following functions implement the IGame interface
+
+func (game *Game) RestoreChunks(client gamerules.IPlayerClient, snapshotName string, from, to ChunkXz) {
+	go func() {
+		chunkLocs := chunkstore.ChunksInArea(from, to)
+		count, err := game.restoreChunksForPlayer(client, snapshotName, chunkLocs)
+		if err != nil {
+			log.Printf("Restoring chunks from snapshot %s failed: %v", snapshotName, err)
+			client.EchoMessage(fmt.Sprintf("Restored %d chunks, then failed: %v", count, err))
+			return
+		}
+		client.EchoMessage(fmt.Sprintf("Restored %d of %d chunks from snapshot %s", count, len(chunkLocs), snapshotName))
+	}()
+}
@@ -13,6 +13,15 @@ import (
 	. "chunkymonkey/types"
 )
 
+const (
+	// Distance from a player at which creepers light their fuse.
+	creeperIgniteDistance = 3
+
+	// Burning entities take fireDamage every fireDamageTicks.
+	fireDamage      = Health(1)
+	fireDamageTicks = Ticks(20)
+)
+
 // A chunk is slice of the world map.
 type Chunk struct {
 	shard        *ChunkShard
@@ -30,6 +39,7 @@ type Chunk struct {
 	playersData  map[EntityId]*playerData               // Some player data for player(s) in the chunk.
 	onUnsub      map[EntityId][]gamerules.IUnsubscribed // Functions to be called when unsubscribed.
 	storeDirty   bool                                   // Is the chunk store copy of this chunk dirty?
+	populated    bool                                   // Has the world generator populated the chunk?
 
 	activeBlocks    map[BlockIndex]bool // Blocks that need to "tick".
 	newActiveBlocks map[BlockIndex]bool // Blocks added as active for next "tick".
@@ -45,6 +55,7 @@ func newChunkFromReader(reader chunkstore.IChunkReader, shard *ChunkShard) (chun
 		skyLight:     reader.SkyLight(),
 		blockLight:   reader.BlockLight(),
 		heightMap:    reader.HeightMap(),
+		populated:    reader.TerrainPopulated(),
 		entities:     make(map[EntityId]gamerules.INonPlayerEntity),
 		tileEntities: make(map[BlockIndex]gamerules.ITileEntity),
 		rand:         rand.New(rand.NewSource(time.UTC().Seconds())),
@@ -92,6 +103,8 @@ func (chunk *Chunk) save(chunkStore chunkstore.IChunkStore) {
 		writer.SetBlockLight(chunk.blockLight)
 		writer.SetSkyLight(chunk.skyLight)
 		writer.SetHeightMap(chunk.heightMap)
+		writer.SetTerrainPopulated(chunk.populated)
+		writer.SetLastUpdate(chunk.shard.time)
 		writer.SetEntities(chunk.entities)
 		writer.SetTileEntities(chunk.tileEntities)
 		chunkStore.WriteChunk(writer)
@@ -99,6 +112,23 @@ func (chunk *Chunk) save(chunkStore chunkstore.IChunkStore) {
 	}
 }
 
+// replace takes over the subscribers and players of old, which the chunk has
+// replaced in the shard, such as when the chunk is restored from a backup.
+// The entities of old are destroyed, and the subscribers are sent the chunk.
+func (chunk *Chunk) replace(old *Chunk) {
+	for _, e := range old.entities {
+		old.removeEntity(e)
+	}
+
+	for entityId, player := range old.subscribers {
+		old.reqUnsubscribeChunk(entityId, false)
+		chunk.reqSubscribeChunk(entityId, player, false)
+	}
+
+	// The players are already spawned for the subscribers.
+	chunk.playersData = old.playersData
+}
+
 func (chunk *Chunk) String() string {
 	return fmt.Sprintf("Chunk[%d,%d]", chunk.loc.X, chunk.loc.Z)
 }
@@ -106,6 +136,19 @@ func (chunk *Chunk) String() string {
 // Sets a block and its data. Returns true if the block was not changed.
 func (chunk *Chunk) setBlock(blockLoc *BlockXyz, subLoc *SubChunkXyz, index BlockIndex, blockType BlockId, blockData byte) {
 
+	chunk.setBlockSilently(index, blockType, blockData)
+
+	// Tell players that the block changed.
+	packet := new(bytes.Buffer)
+	proto.WriteBlockChange(packet, blockLoc, blockType, blockData)
+	chunk.reqMulticastPlayers(-1, packet.Bytes())
+
+	return
+}
+
+// setBlockSilently sets a block and its data without telling subscribers about
+// the change. The caller is responsible for notifying them.
+func (chunk *Chunk) setBlockSilently(index BlockIndex, blockType BlockId, blockData byte) {
 	// Invalidate cached packet.
 	chunk.cachedPacket = nil
 
@@ -116,17 +159,10 @@ func (chunk *Chunk) setBlock(blockLoc *BlockXyz, subLoc *SubChunkXyz, index Bloc
 	index.SetBlockData(chunk.blockData, blockData)
 
 	chunk.tileEntities[index] = nil, false
-
-	// Tell players that the block changed.
-	packet := new(bytes.Buffer)
-	proto.WriteBlockChange(packet, blockLoc, blockType, blockData)
-	chunk.reqMulticastPlayers(-1, packet.Bytes())
-
-	return
 }
 
 func (chunk *Chunk) blockId(index BlockIndex) BlockId {
-	return BlockId(index.BlockData(chunk.blocks))
+	return index.BlockId(chunk.blocks)
 }
 
 func (chunk *Chunk) SetBlockByIndex(blockIndex BlockIndex, blockId BlockId, blockData byte) {
@@ -237,6 +273,19 @@ func (chunk *Chunk) blockInstanceAndType(blockLoc *BlockXyz) (blockInstance *gam
 		return
 	}
 
+	return chunk.blockInstanceAt(blockLoc, subLoc, index)
+}
+
+// blockInstanceByIndex is the same as blockInstanceAndType, but for a block
+// index within the chunk.
+func (chunk *Chunk) blockInstanceByIndex(index BlockIndex) (blockInstance *gamerules.BlockInstance, blockType *gamerules.BlockType, ok bool) {
+	subLoc := index.ToSubChunkXyz()
+	blockLoc := chunk.loc.ToBlockXyz(&subLoc)
+
+	return chunk.blockInstanceAt(blockLoc, &subLoc, index)
+}
+
+func (chunk *Chunk) blockInstanceAt(blockLoc *BlockXyz, subLoc *SubChunkXyz, index BlockIndex) (blockInstance *gamerules.BlockInstance, blockType *gamerules.BlockType, ok bool) {
 	blockType, blockData, ok := chunk.blockTypeAndData(index)
 	if !ok {
 		return
@@ -254,6 +303,28 @@ func (chunk *Chunk) blockInstanceAndType(blockLoc *BlockXyz) (blockInstance *gam
 	return
 }
 
+// BlockInstance implements IChunkBlock.BlockInstance. It can look up blocks in
+// this chunk, or in other loaded chunks within the shard.
+// TODO Look up blocks in neighbouring shards.
+func (chunk *Chunk) BlockInstance(blockLoc *BlockXyz) (instance *gamerules.BlockInstance, ok bool) {
+	chunkLoc, subLoc := blockLoc.ToChunkLocal()
+
+	target := chunk
+	if !chunk.isSameChunk(chunkLoc) {
+		if target = chunk.shard.loadedChunkAt(*chunkLoc); target == nil {
+			return
+		}
+	}
+
+	index, ok := subLoc.BlockIndex()
+	if !ok {
+		return
+	}
+
+	instance, _, ok = target.blockInstanceAt(blockLoc, subLoc, index)
+	return
+}
+
 func (chunk *Chunk) reqHitBlock(player gamerules.IPlayerClient, held gamerules.Slot, digStatus DigStatus, target *BlockXyz, face Face) {
 
 	blockInstance, blockType, ok := chunk.blockInstanceAndType(target)
@@ -280,7 +351,13 @@ func (chunk *Chunk) reqInteractBlock(player gamerules.IPlayerClient, held gameru
 		return
 	}
 
-	if _, isBlockHeld := held.ItemTypeId.ToBlockId(); isBlockHeld && blockType.Attachable {
+	if held.ItemTypeId == ItemTypeIdFlintAndSteel {
+		if ignitable, ok := blockType.Aspect.(gamerules.IIgnitable); ok {
+			ignitable.Ignite(blockInstance, 0)
+		} else {
+			chunk.lightFire(target, againstFace)
+		}
+	} else if _, isBlockHeld := held.ItemTypeId.ToBlockId(); isBlockHeld && blockType.Attachable {
 		// The player is interacting with a block that can be attached to.
 
 		// Work out the position to put the block at.
@@ -300,6 +377,29 @@ func (chunk *Chunk) reqInteractBlock(player gamerules.IPlayerClient, held gameru
 	return
 }
 
+// lightFire places fire against the given face of the target block, if the
+// block there can be replaced. Fire lit inside an obsidian frame creates a
+// portal instead.
+func (chunk *Chunk) lightFire(target *BlockXyz, againstFace Face) {
+	dx, dy, dz := againstFace.Dxyz()
+	destLoc := target.AddXyz(dx, dy, dz)
+	if destLoc == nil {
+		return
+	}
+
+	dest, ok := chunk.BlockInstance(destLoc)
+	if !ok || !dest.BlockType.Replaceable {
+		return
+	}
+
+	if chunk.shard.lightPortal(destLoc) {
+		return
+	}
+
+	dest.Chunk.SetBlockByIndex(dest.Index, BlockIdFire, 0)
+	dest.Chunk.AddActiveBlockIndex(dest.Index)
+}
+
 // placeBlock attempts to place a block. This is called by PlayerBlockInteract
 // in the situation where the player interacts with an attachable block
 // (potentially in a different chunk to the one where the block gets placed).
@@ -431,6 +531,10 @@ func (chunk *Chunk) BlockQuery(blockLoc BlockXyz) (isSolid bool, isWithinChunk b
 }
 
 func (chunk *Chunk) tick() {
+	if chunk.shard.raining && chunk.shard.thundering && chunk.rand.Intn(lightningChance) == 0 {
+		chunk.strikeLightning()
+	}
+
 	chunk.spawnTick()
 	if chunk.tickAll {
 		chunk.tickAll = false
@@ -448,8 +552,17 @@ func (chunk *Chunk) spawnTick() {
 	}
 
 	outgoingEntities := []gamerules.INonPlayerEntity{}
+	var detonated []gamerules.INonPlayerEntity
 
 	for _, e := range chunk.entities {
+		if explosive, ok := e.(gamerules.IExplosive); ok && explosive.Detonated() {
+			detonated = append(detonated, e)
+			continue
+		}
+		if chunk.burnTick(e) {
+			chunk.removeEntity(e)
+			continue
+		}
 		if e.Tick(chunk) {
 			if e.Position().Y <= 0 {
 				// Item or mob fell out of the world.
@@ -460,6 +573,12 @@ func (chunk *Chunk) spawnTick() {
 		}
 	}
 
+	for _, e := range detonated {
+		chunk.removeEntity(e)
+		power := e.(gamerules.IExplosive).ExplosionPower()
+		chunk.shard.explode(e.Position(), power, chunk.rand)
+	}
+
 	if len(outgoingEntities) > 0 {
 		// Transfer spawns to new chunk.
 		for _, e := range outgoingEntities {
@@ -482,6 +601,62 @@ func (chunk *Chunk) spawnTick() {
 	chunk.storeDirty = true
 }
 
+// burnTick sets an entity burning if it is within a fire block, and damages
+// entities that are burning. Burning entities are put out after a while, or
+// when they are in water. Returns true if the entity burnt to death.
+func (chunk *Chunk) burnTick(e gamerules.INonPlayerEntity) (dead bool) {
+	burnable, ok := e.(gamerules.IBurnable)
+	if !ok {
+		return
+	}
+
+	var inFire, inWater bool
+	if position := e.Position(); position.Y >= 0 && position.Y < ChunkSizeY {
+		if target, index, ok := chunk.shard.loadedBlockAt(position.ToBlockXyz()); ok {
+			blockId := index.BlockId(target.blocks)
+			inWater = blockId == BlockIdWater || blockId == BlockIdStationaryWater
+			if blockType, ok := gamerules.Blocks.Get(blockId); ok {
+				_, inFire = blockType.Aspect.(*gamerules.FireAspect)
+			}
+		}
+	}
+
+	wasBurning := burnable.Burning()
+	hurt := false
+	if inWater {
+		burnable.SetBurning(false)
+	} else if inFire && !wasBurning {
+		burnable.SetBurning(true)
+	} else if wasBurning {
+		ticksLeft := burnable.BurnTick()
+		hurt = ticksLeft%fireDamageTicks == 0
+		if ticksLeft == 0 {
+			// Staying in the fire keeps the entity burning.
+			burnable.SetBurning(inFire)
+		}
+	}
+
+	if burnable.Burning() != wasBurning {
+		buf := new(bytes.Buffer)
+		proto.WriteEntityMetadata(buf, e.GetEntityId(), burnable.FormatMetadata())
+		chunk.reqMulticastPlayers(-1, buf.Bytes())
+	}
+
+	if !hurt {
+		return
+	}
+
+	if damageable, ok := e.(gamerules.IDamageable); ok {
+		if dead = damageable.Damage(fireDamage); !dead {
+			buf := new(bytes.Buffer)
+			proto.WriteEntityStatus(buf, e.GetEntityId(), EntityStatusHurt)
+			chunk.reqMulticastPlayers(-1, buf.Bytes())
+		}
+	}
+
+	return
+}
+
 // blockTick runs any blocks that need to do something each tick.
 func (chunk *Chunk) blockTick() {
 	if len(chunk.activeBlocks) == 0 && len(chunk.newActiveBlocks) == 0 {
@@ -559,6 +734,22 @@ func (chunk *Chunk) AddActiveBlockIndex(blockIndex BlockIndex) {
 	chunk.newActiveBlocks[blockIndex] = true
 }
 
+// RainingAt returns true if it is raining and the block is open to the sky.
+func (chunk *Chunk) RainingAt(blockIndex BlockIndex) bool {
+	if !chunk.shard.raining {
+		return false
+	}
+
+	subLoc := blockIndex.ToSubChunkXyz()
+	return int(subLoc.Y) >= chunk.heightAt(subLoc.X, subLoc.Z)
+}
+
+// heightAt returns the height of the lowest block open to the sky in the
+// given column.
+func (chunk *Chunk) heightAt(x, z SubChunkCoord) int {
+	return int(chunk.heightMap[int(x)*ChunkSizeH+int(z)])
+}
+
 func (chunk *Chunk) mobs() (s []*gamerules.Mob) {
 	s = make([]*gamerules.Mob, 0, 3)
 	for _, e := range chunk.entities {
@@ -724,9 +915,13 @@ func (chunk *Chunk) reqSetPlayerPosition(entityId EntityId, pos AbsXyz) {
 	data.sendPositionLook(buf)
 	chunk.reqMulticastPlayers(entityId, buf.Bytes())
 
+	chunk.igniteCreepersNear(&pos)
+
 	player, ok := chunk.subscribers[entityId]
 
 	if ok {
+		chunk.checkPortal(data, player)
+
 		// Does the player overlap with any items?
 		for _, item := range chunk.items() {
 			if item.PickupImmunity > 0 {
@@ -742,6 +937,23 @@ func (chunk *Chunk) reqSetPlayerPosition(entityId EntityId, pos AbsXyz) {
 	}
 }
 
+// igniteCreepersNear lights the fuse of any creepers in the chunk close to the
+// given player position.
+func (chunk *Chunk) igniteCreepersNear(pos *AbsXyz) {
+	for _, e := range chunk.entities {
+		creeper, ok := e.(*gamerules.Creeper)
+		if !ok || creeper.Ignited() || !creeper.Position().IsWithinDistanceOf(pos, creeperIgniteDistance) {
+			continue
+		}
+
+		creeper.Ignite()
+
+		buf := new(bytes.Buffer)
+		proto.WriteEntityMetadata(buf, creeper.EntityId, creeper.FormatMetadata())
+		chunk.reqMulticastPlayers(-1, buf.Bytes())
+	}
+}
+
 func (chunk *Chunk) reqSetPlayerLook(entityId EntityId, look LookBytes) {
 	data, ok := chunk.playersData[entityId]
 
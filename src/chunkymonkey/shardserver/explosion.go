@@ -0,0 +1,391 @@
+package shardserver
+
+import (
+	"bytes"
+	"math"
+	"rand"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const (
+	// Number of rays cast along each edge of the cube of rays that an explosion
+	// sends out.
+	explosionRaysPerEdge = 16
+
+	// Distance that an explosion ray travels per step, in blocks.
+	explosionRayStep = 0.3
+
+	// Intensity lost by a ray per step regardless of what it passes through.
+	explosionStepAttenuation = 0.75 * explosionRayStep
+
+	// Chance (as a percentage) of a destroyed block dropping its items.
+	explosionDropChance = 30
+
+	// Range of the fuse given to TNT blocks caught in an explosion.
+	explosionTntFuseMin   = Ticks(10)
+	explosionTntFuseRange = 20
+)
+
+// explosionChunk holds the blocks destroyed by an explosion within a single
+// chunk.
+type explosionChunk struct {
+	chunk  *Chunk
+	blocks map[BlockIndex]bool
+}
+
+// explosionShard holds the rays of an explosion that have crossed into another
+// shard.
+type explosionShard struct {
+	loc  ShardXz
+	rays []gamerules.ExplosionRay
+}
+
+// explosion holds the state of a single explosion while it is calculated and
+// applied to the shard.
+type explosion struct {
+	shard  *ChunkShard
+	center AbsXyz
+	power  float32
+	rand   *rand.Rand
+
+	// forwarded is true if the explosion is centered in another shard.
+	forwarded bool
+
+	// Affected blocks, grouped by chunk key.
+	chunks  map[uint64]*explosionChunk
+	offsets []proto.ExplosionOffsetXyz
+
+	// Other shards that the explosion reaches, by shard key.
+	otherShards map[uint64]*explosionShard
+}
+
+func newExplosion(shard *ChunkShard, center *AbsXyz, power float32, rand *rand.Rand) *explosion {
+	return &explosion{
+		shard:       shard,
+		center:      *center,
+		power:       power,
+		rand:        rand,
+		chunks:      make(map[uint64]*explosionChunk),
+		otherShards: make(map[uint64]*explosionShard),
+	}
+}
+
+// explode creates an explosion centered at the given position. Blocks that the
+// explosion reaches are destroyed, nearby entities and players are damaged and
+// knocked back, and TNT is ignited. Rays that leave the shard, and the damage
+// to entities in other shards, are passed on to the other shards.
+func (shard *ChunkShard) explode(center *AbsXyz, power float32, rand *rand.Rand) {
+	ex := newExplosion(shard, center, power, rand)
+
+	ex.castRays()
+	ex.destroyBlocks()
+	ex.affectEntities()
+	ex.notify()
+	ex.forward()
+}
+
+// reqExplode continues an explosion centered in another shard, casting the
+// rays that crossed into this shard. Entities within range are only damaged if
+// affectEntities is true, as the shard that the explosion is centered in asks
+// each shard in range once, while rays may pass through several shards.
+// Subscribers are told about destroyed blocks by block changes, as the
+// explosion packet is sent by the shard that the explosion is centered in.
+func (shard *ChunkShard) reqExplode(center *AbsXyz, power float32, rays []gamerules.ExplosionRay, affectEntities bool) {
+	ex := newExplosion(shard, center, power, rand.New(rand.NewSource(time.UTC().Seconds())))
+	ex.forwarded = true
+
+	for _, ray := range rays {
+		ex.castRay(ray)
+	}
+	ex.destroyBlocks()
+	if affectEntities {
+		ex.affectEntities()
+	}
+	ex.forward()
+}
+
+// castRays finds the blocks destroyed by the explosion by sending rays out
+// from the center, each of which loses intensity according to the blast
+// resistance of the blocks that it passes through.
+func (ex *explosion) castRays() {
+	const edge = explosionRaysPerEdge - 1
+
+	for i := 0; i <= edge; i++ {
+		for j := 0; j <= edge; j++ {
+			for k := 0; k <= edge; k++ {
+				if i != 0 && i != edge && j != 0 && j != edge && k != 0 && k != edge {
+					// Only cast rays from the surface of the cube.
+					continue
+				}
+
+				dx := float64(i)/edge*2 - 1
+				dy := float64(j)/edge*2 - 1
+				dz := float64(k)/edge*2 - 1
+				length := math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+				ex.castRay(gamerules.ExplosionRay{
+					X:         float64(ex.center.X),
+					Y:         float64(ex.center.Y),
+					Z:         float64(ex.center.Z),
+					Dx:        dx / length * explosionRayStep,
+					Dy:        dy / length * explosionRayStep,
+					Dz:        dz / length * explosionRayStep,
+					Intensity: float64(ex.power) * (0.7 + 0.6*ex.rand.Float64()),
+				})
+			}
+		}
+	}
+}
+
+// castRay follows a ray until it runs out of intensity. A ray that crosses into
+// another shard is passed on to that shard by forward.
+func (ex *explosion) castRay(ray gamerules.ExplosionRay) {
+	for ; ray.Intensity > 0; ray.Intensity -= explosionStepAttenuation {
+		if ray.Y < 0 {
+			// Nothing below the world.
+			return
+		}
+
+		position := AbsXyz{AbsCoord(ray.X), AbsCoord(ray.Y), AbsCoord(ray.Z)}
+		if shardLoc := position.ToShardXz(); !ex.shard.loc.Equals(&shardLoc) {
+			exShard := ex.otherShard(shardLoc)
+			exShard.rays = append(exShard.rays, ray)
+			return
+		}
+
+		if ray.Y < ChunkSizeY {
+			blockLoc := BlockXyz{
+				BlockCoord(math.Floor(ray.X)),
+				BlockYCoord(math.Floor(ray.Y)),
+				BlockCoord(math.Floor(ray.Z)),
+			}
+
+			chunk, index, ok := ex.shard.loadedBlockAt(&blockLoc)
+			if !ok {
+				// Blocks that aren't loaded stop the ray.
+				return
+			}
+
+			if blockId := index.BlockId(chunk.blocks); blockId != BlockIdAir {
+				if blockType, ok := gamerules.Blocks.Get(blockId); ok {
+					ray.Intensity -= (blockType.BlastResistance/5 + 0.3) * explosionRayStep
+				}
+				if ray.Intensity > 0 {
+					ex.addBlock(chunk, index, &blockLoc)
+				}
+			}
+		}
+
+		ray.X += ray.Dx
+		ray.Y += ray.Dy
+		ray.Z += ray.Dz
+	}
+}
+
+// otherShard returns the rays passed on to the given shard.
+func (ex *explosion) otherShard(shardLoc ShardXz) *explosionShard {
+	key := shardLoc.Key()
+	exShard, ok := ex.otherShards[key]
+	if !ok {
+		exShard = &explosionShard{loc: shardLoc}
+		ex.otherShards[key] = exShard
+	}
+	return exShard
+}
+
+func (ex *explosion) addBlock(chunk *Chunk, index BlockIndex, blockLoc *BlockXyz) {
+	key := chunk.loc.ChunkKey()
+	exChunk, ok := ex.chunks[key]
+	if !ok {
+		exChunk = &explosionChunk{
+			chunk:  chunk,
+			blocks: make(map[BlockIndex]bool),
+		}
+		ex.chunks[key] = exChunk
+	}
+
+	if exChunk.blocks[index] {
+		return
+	}
+	exChunk.blocks[index] = true
+
+	if ex.forwarded {
+		// The explosion packet is sent by the shard that the explosion is
+		// centered in.
+		return
+	}
+	ex.offsets = append(ex.offsets, proto.ExplosionOffsetXyz{
+		X: int8(blockLoc.X - BlockCoord(math.Floor(float64(ex.center.X)))),
+		Y: int8(int(blockLoc.Y) - int(math.Floor(float64(ex.center.Y)))),
+		Z: int8(blockLoc.Z - BlockCoord(math.Floor(float64(ex.center.Z)))),
+	})
+}
+
+// destroyBlocks removes all blocks found by castRays in a single batch per
+// chunk. Subscribers are told about the removed blocks by the explosion packet
+// rather than by individual block changes, unless the explosion is centered in
+// another shard.
+func (ex *explosion) destroyBlocks() {
+	for _, exChunk := range ex.chunks {
+		chunk := exChunk.chunk
+		for index := range exChunk.blocks {
+			blockInstance, blockType, ok := chunk.blockInstanceByIndex(index)
+			if !ok || !blockType.Destructable {
+				continue
+			}
+
+			if ignitable, ok := blockType.Aspect.(gamerules.IIgnitable); ok {
+				fuse := explosionTntFuseMin + Ticks(ex.rand.Intn(explosionTntFuseRange))
+				ignitable.Ignite(blockInstance, fuse)
+				continue
+			}
+
+			if ex.rand.Intn(100) < explosionDropChance {
+				blockType.Aspect.Destroy(blockInstance)
+			}
+			if ex.forwarded {
+				chunk.SetBlockByIndex(index, BlockIdAir, 0)
+			} else {
+				chunk.setBlockSilently(index, BlockIdAir, 0)
+			}
+		}
+	}
+}
+
+// affectEntities damages and knocks back entities and players within range of
+// the explosion. Entities in other shards are left to forward.
+func (ex *explosion) affectEntities() {
+	radius := AbsCoord(2 * ex.power)
+
+	minLoc := AbsXyz{ex.center.X - radius, 0, ex.center.Z - radius}
+	maxLoc := AbsXyz{ex.center.X + radius, 0, ex.center.Z + radius}
+	minChunk := minLoc.ToChunkXz()
+	maxChunk := maxLoc.ToChunkXz()
+
+	var chunkLoc ChunkXz
+	for chunkLoc.X = minChunk.X; chunkLoc.X <= maxChunk.X; chunkLoc.X++ {
+		for chunkLoc.Z = minChunk.Z; chunkLoc.Z <= maxChunk.Z; chunkLoc.Z++ {
+			if shardLoc := chunkLoc.ToShardXz(); !ex.shard.loc.Equals(&shardLoc) {
+				ex.otherShard(shardLoc)
+				continue
+			}
+
+			chunk := ex.shard.loadedChunkAt(chunkLoc)
+			if chunk == nil {
+				continue
+			}
+
+			for _, e := range chunk.entities {
+				ex.affectEntity(chunk, e)
+			}
+
+			for entityId, data := range chunk.playersData {
+				player, ok := chunk.subscribers[entityId]
+				if !ok {
+					continue
+				}
+				impact, knockback, ok := ex.impactAt(&data.position)
+				if !ok {
+					continue
+				}
+				player.InflictDamage(ex.damage(impact), knockback)
+			}
+		}
+	}
+}
+
+func (ex *explosion) affectEntity(chunk *Chunk, e gamerules.INonPlayerEntity) {
+	impact, knockback, ok := ex.impactAt(e.Position())
+	if !ok {
+		return
+	}
+
+	if pushable, ok := e.(gamerules.IPushable); ok {
+		pushable.AddVelocity(&knockback)
+	}
+
+	if damageable, ok := e.(gamerules.IDamageable); ok {
+		if damageable.Damage(ex.damage(impact)) {
+			chunk.removeEntity(e)
+		} else {
+			buf := new(bytes.Buffer)
+			proto.WriteEntityStatus(buf, e.GetEntityId(), EntityStatusHurt)
+			chunk.reqMulticastPlayers(-1, buf.Bytes())
+		}
+	}
+}
+
+// impactAt returns the strength of the explosion at the given position, and
+// the resulting knockback. ok is false if the position is out of range.
+func (ex *explosion) impactAt(position *AbsXyz) (impact float64, knockback AbsVelocity, ok bool) {
+	radius := 2 * float64(ex.power)
+
+	dx := float64(position.X - ex.center.X)
+	dy := float64(position.Y - ex.center.Y)
+	dz := float64(position.Z - ex.center.Z)
+	distance := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if distance > radius {
+		return
+	}
+
+	// TODO Reduce the impact for entities shielded by blocks.
+	impact = 1 - distance/radius
+
+	if distance > 0 {
+		knockback = AbsVelocity{
+			AbsVelocityCoord(dx / distance * impact),
+			AbsVelocityCoord(dy / distance * impact),
+			AbsVelocityCoord(dz / distance * impact),
+		}
+	}
+	ok = true
+
+	return
+}
+
+func (ex *explosion) damage(impact float64) Health {
+	return Health((impact*impact+impact)/2*8*2*float64(ex.power) + 1)
+}
+
+// notify sends a single explosion packet to all players subscribed to the
+// affected chunks.
+func (ex *explosion) notify() {
+	buf := new(bytes.Buffer)
+	proto.WriteExplosion(buf, &ex.center, ex.power, ex.offsets)
+	packet := buf.Bytes()
+
+	notified := make(map[EntityId]bool)
+	notifyChunk := func(chunk *Chunk) {
+		for entityId, player := range chunk.subscribers {
+			if !notified[entityId] {
+				notified[entityId] = true
+				player.TransmitPacket(packet)
+			}
+		}
+	}
+
+	if chunk := ex.shard.loadedChunkAt(ex.center.ToChunkXz()); chunk != nil {
+		notifyChunk(chunk)
+	}
+	for _, exChunk := range ex.chunks {
+		notifyChunk(exChunk.chunk)
+	}
+}
+
+// forward passes the explosion on to the other shards that it reaches. Only
+// the shard that the explosion is centered in asks other shards to damage
+// their entities.
+func (ex *explosion) forward() {
+	for _, exShard := range ex.otherShards {
+		if ex.forwarded && len(exShard.rays) == 0 {
+			continue
+		}
+		if client := ex.shard.clientForShard(exShard.loc); client != nil {
+			client.ReqExplode(ex.center, ex.power, exShard.rays, !ex.forwarded)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package shardserver
+
+import (
+	"rand"
+	"testing"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// testShardConnecter connects shards to the clients that it holds.
+type testShardConnecter struct {
+	clients map[uint64]gamerules.IShardShardClient
+}
+
+func (c *testShardConnecter) PlayerShardConnect(entityId EntityId, player gamerules.IPlayerClient, shardLoc ShardXz) gamerules.IPlayerShardClient {
+	return nil
+}
+
+func (c *testShardConnecter) ShardShardConnect(shardLoc ShardXz) gamerules.IShardShardClient {
+	return c.clients[shardLoc.Key()]
+}
+
+// recordingShardClient passes explosions straight on to its shard, and counts
+// them.
+type recordingShardClient struct {
+	shardSelfClient
+	explosions     int
+	affectEntities bool
+}
+
+func (c *recordingShardClient) ReqExplode(center AbsXyz, power float32, rays []gamerules.ExplosionRay, affectEntities bool) {
+	c.explosions++
+	c.affectEntities = affectEntities
+	c.shardSelfClient.ReqExplode(center, power, rays, affectEntities)
+}
+
+// isDestroyed returns true if the explosion destroys the block.
+func isDestroyed(ex *explosion, blockLoc *BlockXyz) bool {
+	chunk, index, ok := ex.shard.loadedBlockAt(blockLoc)
+	if !ok {
+		return false
+	}
+	exChunk, ok := ex.chunks[chunk.loc.ChunkKey()]
+	return ok && exChunk.blocks[index]
+}
+
+func TestExplosion_CastRay(t *testing.T) {
+	dirt := BlockXyz{10, 64, 8}
+	obsidian := BlockXyz{11, 64, 8}
+	stone := BlockXyz{12, 64, 8}
+
+	tests := []struct {
+		intensity float64
+		destroyed []BlockXyz
+		kept      []BlockXyz
+	}{
+		// The ray runs out before reaching any blocks.
+		{1, nil, []BlockXyz{dirt, obsidian, stone}},
+		// The ray passes through the dirt, but is stopped by the obsidian.
+		{5, []BlockXyz{dirt}, []BlockXyz{obsidian, stone}},
+	}
+
+	for _, test := range tests {
+		shard, service := testShard(t, nil, ShardXz{0, 0}, ChunkXz{0, 0})
+		shard.setBlockAt(&dirt, BlockIdDirt)
+		shard.setBlockAt(&obsidian, BlockIdObsidian)
+		shard.setBlockAt(&stone, BlockIdStone)
+
+		center := AbsXyz{8.5, 64.5, 8.5}
+		ex := newExplosion(shard, &center, 4, rand.New(rand.NewSource(0)))
+		ex.castRay(gamerules.ExplosionRay{
+			X: 8.5, Y: 64.5, Z: 8.5,
+			Dx:        explosionRayStep,
+			Intensity: test.intensity,
+		})
+
+		for _, blockLoc := range test.destroyed {
+			if !isDestroyed(ex, &blockLoc) {
+				t.Errorf("intensity %v: expected block at %v to be destroyed", test.intensity, blockLoc)
+			}
+		}
+		for _, blockLoc := range test.kept {
+			if isDestroyed(ex, &blockLoc) {
+				t.Errorf("intensity %v: expected block at %v to be kept", test.intensity, blockLoc)
+			}
+		}
+
+		service.Close()
+	}
+}
+
+func TestExplosion_ShardBoundary(t *testing.T) {
+	connecter := &testShardConnecter{make(map[uint64]gamerules.IShardShardClient)}
+
+	// The explosion is just inside the shard at the origin, next to dirt in
+	// the shard beyond it.
+	shard, service := testShard(t, connecter, ShardXz{0, 0}, ChunkXz{ShardSize - 1, 0})
+	defer service.Close()
+	neighbourLoc := ShardXz{1, 0}
+	neighbour, neighbourService := testShard(t, connecter, neighbourLoc, ChunkXz{ShardSize, 0})
+	defer neighbourService.Close()
+
+	client := &recordingShardClient{shardSelfClient: shardSelfClient{neighbour}}
+	connecter.clients[neighbourLoc.Key()] = client
+
+	dirt := BlockXyz{ShardSize * ChunkSizeH, 64, 8}
+	neighbour.setBlockAt(&dirt, BlockIdDirt)
+
+	center := AbsXyz{AbsCoord(dirt.X) - 1.5, 64.5, 8.5}
+	shard.explode(&center, 4, rand.New(rand.NewSource(0)))
+
+	if client.explosions != 1 {
+		t.Fatalf("expected the explosion to be passed on to the neighbouring shard once but got %d", client.explosions)
+	}
+	if !client.affectEntities {
+		t.Errorf("expected the neighbouring shard to be asked to damage its entities")
+	}
+	if blockId, _ := neighbour.blockIdAt(&dirt); blockId != BlockIdAir {
+		t.Errorf("expected the dirt in the neighbouring shard to be destroyed but got block %d", blockId)
+	}
+}
@@ -0,0 +1,154 @@
+package shardserver
+
+import (
+	"rand"
+	"testing"
+
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+// Upper bound on the number of ticks to run fire for. Fire only updates on
+// average once every 30 ticks, so this allows for a few hundred updates.
+const fireTestTicks = 10000
+
+// fireTestShard returns a test shard with a deterministic random source for
+// its chunk, and fire lit at fireLoc on top of a block of type onBlockId.
+func fireTestShard(t *testing.T, fireLoc *BlockXyz, onBlockId BlockId) (*ChunkShard, *Chunk, func()) {
+	shard, service := testShard(t, nil, ShardXz{0, 0}, ChunkXz{0, 0})
+	chunk := shard.chunkAt(ChunkXz{0, 0})
+	chunk.rand = rand.New(rand.NewSource(1))
+
+	shard.setBlockAt(&BlockXyz{fireLoc.X, fireLoc.Y - 1, fireLoc.Z}, onBlockId)
+	shard.setBlockAt(fireLoc, BlockIdFire)
+	_, index, _ := shard.loadedBlockAt(fireLoc)
+	chunk.AddActiveBlockIndex(index)
+
+	return shard, chunk, func() { service.Close() }
+}
+
+func blockIdAt(shard *ChunkShard, blockLoc *BlockXyz) BlockId {
+	chunk, index, _ := shard.loadedBlockAt(blockLoc)
+	return chunk.blockId(index)
+}
+
+func TestFire_IgnitesNeighbours(t *testing.T) {
+	fireLoc := &BlockXyz{8, 64, 8}
+	shard, chunk, closer := fireTestShard(t, fireLoc, BlockIdStone)
+	defer closer()
+
+	leaves := []BlockXyz{
+		{7, 64, 8},
+		{9, 64, 8},
+		{8, 64, 7},
+		{8, 64, 9},
+	}
+	for i := range leaves {
+		shard.setBlockAt(&leaves[i], BlockIdLeaves)
+	}
+
+	// spreadFire returns true if fire exists anywhere other than where it was
+	// lit.
+	spreadFire := func() bool {
+		for x := BlockCoord(6); x <= 10; x++ {
+			for z := BlockCoord(6); z <= 10; z++ {
+				for y := BlockYCoord(63); y <= 69; y++ {
+					loc := &BlockXyz{x, y, z}
+					if *loc != *fireLoc && blockIdAt(shard, loc) == BlockIdFire {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	for tick := 0; tick < fireTestTicks; tick++ {
+		chunk.blockTick()
+		if spreadFire() {
+			return
+		}
+		if len(chunk.activeBlocks) == 0 && len(chunk.newActiveBlocks) == 0 {
+			t.Fatalf("fire went out after %d ticks without igniting its neighbours", tick)
+		}
+	}
+	t.Errorf("fire did not ignite its neighbours after %d ticks", fireTestTicks)
+}
+
+func TestFire_BurnsOut(t *testing.T) {
+	tests := []struct {
+		name      string
+		onBlockId BlockId
+		burnsOut  bool
+	}{
+		{"on stone", BlockIdStone, true},
+		{"on netherrack", BlockIdNetherrack, false},
+	}
+
+	for _, test := range tests {
+		fireLoc := &BlockXyz{8, 64, 8}
+		shard, chunk, closer := fireTestShard(t, fireLoc, test.onBlockId)
+
+		for tick := 0; tick < fireTestTicks && blockIdAt(shard, fireLoc) == BlockIdFire; tick++ {
+			chunk.blockTick()
+		}
+
+		if burntOut := blockIdAt(shard, fireLoc) == BlockIdAir; burntOut != test.burnsOut {
+			t.Errorf("%s: expected burnt out=%t, got %t", test.name, test.burnsOut, burntOut)
+		}
+		if active := len(chunk.activeBlocks) != 0; active == test.burnsOut {
+			t.Errorf("%s: expected fire active=%t, got %t", test.name, !test.burnsOut, active)
+		}
+
+		closer()
+	}
+}
+
+// damageRecorder is a pig that records the damage done to it.
+type damageRecorder struct {
+	*gamerules.Pig
+	damage Health
+}
+
+func (d *damageRecorder) Damage(damage Health) (dead bool) {
+	d.damage += damage
+	return d.Pig.Damage(damage)
+}
+
+func TestChunk_BurnTick(t *testing.T) {
+	blockLoc := &BlockXyz{8, 64, 8}
+	shard, service := testShard(t, nil, ShardXz{0, 0}, ChunkXz{0, 0})
+	defer service.Close()
+	chunk := shard.chunkAt(ChunkXz{0, 0})
+
+	pig := &damageRecorder{Pig: gamerules.NewPig().(*gamerules.Pig)}
+	pig.PointObject.Init(&AbsXyz{8.5, 64.5, 8.5}, &AbsVelocity{})
+
+	// Standing in fire sets the pig alight.
+	shard.setBlockAt(blockLoc, BlockIdFire)
+	chunk.burnTick(pig)
+	if !pig.Burning() {
+		t.Fatalf("pig not set alight by fire")
+	}
+
+	// The pig keeps burning, and taking damage, after leaving the fire.
+	shard.setBlockAt(blockLoc, BlockIdAir)
+	for tick := Ticks(0); tick < fireDamageTicks; tick++ {
+		if chunk.burnTick(pig) {
+			t.Fatalf("pig burnt to death")
+		}
+	}
+	if !pig.Burning() {
+		t.Errorf("pig stopped burning after %d ticks", fireDamageTicks)
+	}
+	if pig.damage != fireDamage {
+		t.Errorf("expected damage %d after %d ticks, got %d", fireDamage, fireDamageTicks, pig.damage)
+	}
+
+	// Water puts the pig out.
+	shard.setBlockAt(blockLoc, BlockIdStationaryWater)
+	chunk.burnTick(pig)
+	if pig.Burning() {
+		t.Errorf("pig still burning in water")
+	}
+}
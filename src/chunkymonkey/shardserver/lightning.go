@@ -0,0 +1,92 @@
+package shardserver
+
+import (
+	"bytes"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+const (
+	// Each chunk has a 1 in lightningChance chance per tick of being struck by
+	// lightning during a thunderstorm.
+	lightningChance = 100000
+
+	// Entities and players within this distance of a lightning strike are
+	// hurt and set alight.
+	lightningRadius = 3
+
+	lightningDamage = Health(5)
+)
+
+// strikeLightning strikes a random column of the chunk with lightning. Fire is
+// started where it lands, and nearby entities and players are hurt.
+func (chunk *Chunk) strikeLightning() {
+	x := SubChunkCoord(chunk.rand.Intn(ChunkSizeH))
+	z := SubChunkCoord(chunk.rand.Intn(ChunkSizeH))
+	height := chunk.heightAt(x, z)
+	if height >= ChunkSizeY {
+		return
+	}
+
+	subLoc := SubChunkXyz{x, SubChunkCoord(height), z}
+	blockLoc := chunk.loc.ToBlockXyz(&subLoc)
+	position := blockLoc.MidPointToAbsXyz()
+
+	// The lightning bolt is a short-lived entity.
+	entityId := chunk.shard.entityMgr.NewEntity()
+	buf := new(bytes.Buffer)
+	proto.WriteWeather(buf, entityId, true, position.ToAbsIntXyz())
+	chunk.reqMulticastPlayers(-1, buf.Bytes())
+	chunk.shard.entityMgr.RemoveEntityById(entityId)
+
+	if index, ok := subLoc.BlockIndex(); ok {
+		if blockType, ok := gamerules.Blocks.Get(index.BlockId(chunk.blocks)); ok && blockType.Replaceable {
+			chunk.setBlock(blockLoc, &subLoc, index, BlockIdFire, 0)
+			chunk.AddActiveBlockIndex(index)
+		}
+	}
+
+	chunk.lightningStrikeEntities(&position)
+}
+
+// lightningStrikeEntities hurts and sets alight entities and players near to
+// the lightning strike in this and neighbouring loaded chunks.
+func (chunk *Chunk) lightningStrikeEntities(position *AbsXyz) {
+	var chunkLoc ChunkXz
+	for chunkLoc.X = chunk.loc.X - 1; chunkLoc.X <= chunk.loc.X+1; chunkLoc.X++ {
+		for chunkLoc.Z = chunk.loc.Z - 1; chunkLoc.Z <= chunk.loc.Z+1; chunkLoc.Z++ {
+			target := chunk.shard.loadedChunkAt(chunkLoc)
+			if target == nil {
+				continue
+			}
+
+			for _, e := range target.entities {
+				if !e.Position().IsWithinDistanceOf(position, lightningRadius) {
+					continue
+				}
+
+				if burnable, ok := e.(gamerules.IBurnable); ok && !burnable.Burning() {
+					burnable.SetBurning(true)
+					buf := new(bytes.Buffer)
+					proto.WriteEntityMetadata(buf, e.GetEntityId(), burnable.FormatMetadata())
+					target.reqMulticastPlayers(-1, buf.Bytes())
+				}
+
+				if damageable, ok := e.(gamerules.IDamageable); ok && damageable.Damage(lightningDamage) {
+					target.removeEntity(e)
+				}
+			}
+
+			for entityId, data := range target.playersData {
+				if !data.position.IsWithinDistanceOf(position, lightningRadius) {
+					continue
+				}
+				if player, ok := target.subscribers[entityId]; ok {
+					player.InflictDamage(lightningDamage, AbsVelocity{})
+				}
+			}
+		}
+	}
+}
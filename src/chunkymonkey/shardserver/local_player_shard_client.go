@@ -120,3 +120,9 @@ func (conn *localPlayerShardClient) ReqInventoryUnsubscribed(block BlockXyz) {
 		chunk.reqInventoryUnsubscribed(conn.player, &block)
 	})
 }
+
+func (conn *localPlayerShardClient) ReqLinkPortal(target BlockXyz) {
+	conn.shard.enqueue(func() {
+		conn.shard.reqLinkPortal(conn.player, &target)
+	})
+}
@@ -1,6 +1,7 @@
 package shardserver
 
 import (
+	"os"
 	"sync"
 
 	"chunkymonkey/chunkstore"
@@ -15,14 +16,26 @@ import (
 type LocalShardManager struct {
 	entityMgr  *entity.EntityManager
 	chunkStore chunkstore.IChunkStore
+	dimension  DimensionId
 	shards     map[uint64]*ChunkShard
 	lock       sync.Mutex
+
+	// Weather state given to new shards.
+	raining    bool
+	thundering bool
+
+	// World time given to new shards.
+	time Ticks
+
+	// savesPaused is given to new shards, and stops them from writing chunks.
+	savesPaused bool
 }
 
-func NewLocalShardManager(chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager) *LocalShardManager {
+func NewLocalShardManager(chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager, dimension DimensionId) *LocalShardManager {
 	return &LocalShardManager{
 		entityMgr:  entityMgr,
 		chunkStore: chunkStore,
+		dimension:  dimension,
 		shards:     make(map[uint64]*ChunkShard),
 	}
 }
@@ -39,7 +52,11 @@ func (mgr *LocalShardManager) getShard(loc ShardXz, create bool) *ChunkShard {
 	}
 
 	// Create shard.
-	shard := NewChunkShard(mgr, mgr.chunkStore, mgr.entityMgr, loc)
+	shard := NewChunkShard(mgr, mgr.chunkStore, mgr.entityMgr, mgr.dimension, loc)
+	shard.raining = mgr.raining
+	shard.thundering = mgr.thundering
+	shard.time = mgr.time
+	shard.savesPaused = mgr.savesPaused
 	mgr.shards[shardKey] = shard
 	go shard.serve()
 
@@ -67,6 +84,117 @@ func (mgr *LocalShardManager) ShardShardConnect(shardLoc ShardXz) gamerules.ISha
 	return newLocalShardShardClient(shard)
 }
 
+// SetWeather informs all shards of a change in the weather.
+func (mgr *LocalShardManager) SetWeather(raining, thundering bool) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.raining = raining
+	mgr.thundering = thundering
+
+	for _, shard := range mgr.shards {
+		shard := shard
+		shard.enqueue(func() {
+			shard.raining = raining
+			shard.thundering = thundering
+		})
+	}
+}
+
+// SetTime informs all shards of the world time, which is recorded in chunks
+// when they are saved.
+func (mgr *LocalShardManager) SetTime(time Ticks) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.time = time
+
+	for _, shard := range mgr.shards {
+		shard := shard
+		shard.enqueue(func() {
+			shard.time = time
+		})
+	}
+}
+
+// PauseSaves writes the changed chunks of every shard to the chunk store, and
+// then stops the shards from writing chunks until ResumeSaves is called. It
+// returns once the chunks have been submitted to the chunk store.
+func (mgr *LocalShardManager) PauseSaves() {
+	mgr.lock.Lock()
+	mgr.savesPaused = true
+	shards := make([]*ChunkShard, 0, len(mgr.shards))
+	for _, shard := range mgr.shards {
+		shards = append(shards, shard)
+	}
+	// The lock is released before waiting, as shards may need it to connect
+	// to other shards.
+	mgr.lock.Unlock()
+
+	done := make(chan bool, len(shards))
+	for _, shard := range shards {
+		shard := shard
+		shard.enqueue(func() {
+			if shard.saveChunks && shard.chunkStore.SupportsWrite() {
+				shard.saveAllChunks()
+			}
+			shard.savesPaused = true
+			done <- true
+		})
+	}
+	for _ = range shards {
+		<-done
+	}
+}
+
+// ResumeSaves lets the shards write chunks again after PauseSaves.
+func (mgr *LocalShardManager) ResumeSaves() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.savesPaused = false
+
+	for _, shard := range mgr.shards {
+		shard := shard
+		shard.enqueue(func() {
+			shard.savesPaused = false
+		})
+	}
+}
+
+// ReplaceChunk writes the chunk read by reader to the chunk store in place of
+// the chunk at the same location. If that chunk is loaded, it is reloaded and
+// players are sent the new chunk. It returns once the chunk is replaced.
+func (mgr *LocalShardManager) ReplaceChunk(reader chunkstore.IChunkReader) (err os.Error) {
+	if !mgr.chunkStore.SupportsWrite() {
+		return os.NewError("chunk store does not support writes")
+	}
+
+	chunkLoc := reader.ChunkLoc()
+
+	mgr.lock.Lock()
+	shard := mgr.getShard(chunkLoc.ToShardXz(), false)
+	if shard == nil {
+		// No shard can load the chunk until the lock is released, by which time
+		// the store has been given the new chunk.
+		writer := mgr.chunkStore.Writer()
+		chunkstore.CopyChunkExactly(writer, reader)
+		mgr.chunkStore.WriteChunk(writer)
+		mgr.lock.Unlock()
+		return
+	}
+	mgr.lock.Unlock()
+
+	done := make(chan bool)
+	shard.enqueue(func() {
+		shard.replaceChunk(reader)
+		done <- true
+	})
+	<-done
+
+	return
+}
+
 // TODO remove Enqueue* methods
 
 // EnqueueAllChunks runs a given function on all loaded chunks.
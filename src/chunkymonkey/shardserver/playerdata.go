@@ -24,6 +24,7 @@ type playerData struct {
 	position   AbsXyz
 	look       LookBytes
 	heldItemId ItemTypeId
+	inPortal   bool // True while the player is standing in a portal block.
 	// TODO Armor data.
 }
 
@@ -0,0 +1,251 @@
+package shardserver
+
+import (
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+const (
+	// Size of the inside of a portal frame.
+	portalWidth  = 2
+	portalHeight = 3
+
+	// Horizontal distance within which an existing portal is linked to in
+	// preference to creating a new one.
+	portalSearchRadius = 16
+
+	// Horizontal distances in the nether are this many times shorter than
+	// in the normal world.
+	portalScale = 8
+
+	// Lowest and highest Y coordinates of the bottom of created portals.
+	portalMinY = 8
+	portalMaxY = ChunkSizeY - portalHeight - 8
+)
+
+// portalFrame describes the location of a portal frame. The portal lies along
+// either the X or Z axis.
+type portalFrame struct {
+	// origin is the lowest interior block of the frame along its axis.
+	origin BlockXyz
+	dx, dz BlockCoord
+}
+
+// portalAxes are the directions along which a portal frame can lie.
+var portalAxes = []struct{ dx, dz BlockCoord }{
+	{1, 0},
+	{0, 1},
+}
+
+// at returns the location of the block at the given offset along the axis of
+// the frame (i) and upwards (j) from the origin. Returns nil if out of range.
+func (frame *portalFrame) at(i, j int) *BlockXyz {
+	return frame.origin.AddXyz(frame.dx*BlockCoord(i), BlockYCoord(j), frame.dz*BlockCoord(i))
+}
+
+// isFrameBlock returns true if the offset (relative to the origin) is part of
+// the obsidian frame. The corners are not required to complete a frame.
+func isFrameBlock(i, j int, corners bool) bool {
+	sideI := i == -1 || i == portalWidth
+	sideJ := j == -1 || j == portalHeight
+	if sideI && sideJ {
+		return corners
+	}
+	return sideI || sideJ
+}
+
+// isComplete returns true if the frame is made of obsidian and the inside is
+// empty (or on fire).
+func (frame *portalFrame) isComplete(shard *ChunkShard) bool {
+	for i := -1; i <= portalWidth; i++ {
+		for j := -1; j <= portalHeight; j++ {
+			loc := frame.at(i, j)
+			if loc == nil {
+				return false
+			}
+			blockId, ok := shard.blockIdAt(loc)
+			if !ok {
+				return false
+			}
+
+			if isFrameBlock(i, j, false) {
+				if blockId != BlockIdObsidian {
+					return false
+				}
+			} else if i >= 0 && i < portalWidth && j >= 0 && j < portalHeight {
+				if blockId != BlockIdAir && blockId != BlockIdFire {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// fill sets the inside of the frame to portal blocks.
+func (frame *portalFrame) fill(shard *ChunkShard) {
+	for i := 0; i < portalWidth; i++ {
+		for j := 0; j < portalHeight; j++ {
+			if loc := frame.at(i, j); loc != nil {
+				shard.setBlockAt(loc, BlockIdPortal)
+			}
+		}
+	}
+}
+
+// build creates a complete portal, along with a small obsidian platform either
+// side of it with room to stand on.
+func (frame *portalFrame) build(shard *ChunkShard) {
+	for i := -1; i <= portalWidth; i++ {
+		for j := -1; j <= portalHeight; j++ {
+			loc := frame.at(i, j)
+			if loc == nil {
+				continue
+			}
+
+			if isFrameBlock(i, j, true) {
+				shard.setBlockAt(loc, BlockIdObsidian)
+			}
+
+			// Clear space (and build a floor) in front of and behind the portal.
+			for _, side := range []BlockCoord{-1, 1} {
+				sideLoc := loc.AddXyz(frame.dz*side, 0, frame.dx*side)
+				if sideLoc == nil || j == portalHeight {
+					continue
+				}
+				if j == -1 {
+					shard.setBlockAt(sideLoc, BlockIdObsidian)
+				} else {
+					shard.setBlockAt(sideLoc, BlockIdAir)
+				}
+			}
+		}
+	}
+
+	frame.fill(shard)
+}
+
+// blockIdAt returns the block ID at the given location, if it is within a
+// loaded chunk in the shard.
+func (shard *ChunkShard) blockIdAt(loc *BlockXyz) (blockId BlockId, ok bool) {
+	chunk, index, ok := shard.loadedBlockAt(loc)
+	if !ok {
+		return
+	}
+	return chunk.blockId(index), true
+}
+
+// setBlockAt sets the block at the given location, if it is within a loaded
+// chunk in the shard.
+func (shard *ChunkShard) setBlockAt(loc *BlockXyz, blockId BlockId) {
+	if chunk, index, ok := shard.loadedBlockAt(loc); ok {
+		chunk.SetBlockByIndex(index, blockId, 0)
+	}
+}
+
+// lightPortal fills an obsidian frame with portal blocks if fire is lit at the
+// bottom of the inside of the frame. Returns true if a portal was created.
+// TODO Detect frames that cross into other shards.
+func (shard *ChunkShard) lightPortal(fireLoc *BlockXyz) bool {
+	for _, axis := range portalAxes {
+		for offset := 0; offset < portalWidth; offset++ {
+			origin := fireLoc.AddXyz(-axis.dx*BlockCoord(offset), 0, -axis.dz*BlockCoord(offset))
+			if origin == nil {
+				continue
+			}
+
+			frame := &portalFrame{*origin, axis.dx, axis.dz}
+			if frame.isComplete(shard) {
+				frame.fill(shard)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findPortal looks for the portal nearest to the target. loc is the lowest
+// portal block in the column found.
+// TODO Search for portals in neighbouring shards.
+func (shard *ChunkShard) findPortal(target *BlockXyz) (loc *BlockXyz, ok bool) {
+	var bestDistSq BlockCoord
+
+	for dx := BlockCoord(-portalSearchRadius); dx <= portalSearchRadius; dx++ {
+		for dz := BlockCoord(-portalSearchRadius); dz <= portalSearchRadius; dz++ {
+			distSq := dx*dx + dz*dz
+			if ok && distSq >= bestDistSq {
+				continue
+			}
+
+			column := BlockXyz{target.X + dx, 0, target.Z + dz}
+			chunk, baseIndex, columnOk := shard.loadedBlockAt(&column)
+			if !columnOk {
+				continue
+			}
+
+			for y := 0; y < ChunkSizeY; y++ {
+				if chunk.blockId(baseIndex+BlockIndex(y)) == BlockIdPortal {
+					column.Y = BlockYCoord(y)
+					loc = &column
+					bestDistSq = distSq
+					ok = true
+					break
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// reqLinkPortal moves the player into the nearest portal to the target,
+// building a new portal at the target if there are none nearby.
+func (shard *ChunkShard) reqLinkPortal(player gamerules.IPlayerClient, target *BlockXyz) {
+	loc, ok := shard.findPortal(target)
+	if !ok {
+		origin := *target
+		if origin.Y < portalMinY {
+			origin.Y = portalMinY
+		} else if origin.Y > portalMaxY {
+			origin.Y = portalMaxY
+		}
+
+		frame := &portalFrame{origin, 1, 0}
+		frame.build(shard)
+		loc = &origin
+	}
+
+	position := loc.MidPointToAbsXyz()
+	position.Y = AbsCoord(loc.Y)
+	player.SetPositionLook(position, LookDegrees{})
+}
+
+// portalDestination returns the dimension and position that a player entering
+// a portal at the given position is sent to.
+func (shard *ChunkShard) portalDestination(pos *AbsXyz) (dimension DimensionId, dest AbsXyz) {
+	dest = *pos
+	if shard.dimension == DimensionNether {
+		dimension = DimensionNormal
+		dest.X *= portalScale
+		dest.Z *= portalScale
+	} else {
+		dimension = DimensionNether
+		dest.X /= portalScale
+		dest.Z /= portalScale
+	}
+	return
+}
+
+// checkPortal sends the player to the other dimension when they step into a
+// portal.
+func (chunk *Chunk) checkPortal(data *playerData, player gamerules.IPlayerClient) {
+	blockId, ok := chunk.shard.blockIdAt(data.position.ToBlockXyz())
+	inPortal := ok && blockId == BlockIdPortal
+
+	if inPortal && !data.inPortal {
+		dimension, dest := chunk.shard.portalDestination(&data.position)
+		player.TransferDimension(dimension, dest)
+	}
+
+	data.inPortal = inPortal
+}
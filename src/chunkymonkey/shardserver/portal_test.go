@@ -0,0 +1,155 @@
+package shardserver
+
+import (
+	"testing"
+
+	. "chunkymonkey/types"
+)
+
+// buildTestFrame sets the blocks of the frame and its inside, as given by
+// blockAt for each offset from the origin.
+func buildTestFrame(shard *ChunkShard, frame *portalFrame, blockAt func(i, j int) BlockId) {
+	for i := -1; i <= portalWidth; i++ {
+		for j := -1; j <= portalHeight; j++ {
+			shard.setBlockAt(frame.at(i, j), blockAt(i, j))
+		}
+	}
+}
+
+// obsidianFrame returns an empty frame of obsidian, without its corners.
+func obsidianFrame(i, j int) BlockId {
+	if isFrameBlock(i, j, false) {
+		return BlockIdObsidian
+	}
+	return BlockIdAir
+}
+
+func TestPortalFrame_IsComplete(t *testing.T) {
+	tests := []struct {
+		name     string
+		blockAt  func(i, j int) BlockId
+		complete bool
+	}{
+		{"empty frame", obsidianFrame, true},
+		{
+			"with corners",
+			func(i, j int) BlockId {
+				if isFrameBlock(i, j, true) {
+					return BlockIdObsidian
+				}
+				return BlockIdAir
+			},
+			true,
+		},
+		{
+			"fire inside",
+			func(i, j int) BlockId {
+				if i == 0 && j == 0 {
+					return BlockIdFire
+				}
+				return obsidianFrame(i, j)
+			},
+			true,
+		},
+		{
+			"missing top",
+			func(i, j int) BlockId {
+				if i == 1 && j == portalHeight {
+					return BlockIdAir
+				}
+				return obsidianFrame(i, j)
+			},
+			false,
+		},
+		{
+			"missing side",
+			func(i, j int) BlockId {
+				if i == portalWidth && j == 1 {
+					return BlockIdAir
+				}
+				return obsidianFrame(i, j)
+			},
+			false,
+		},
+		{
+			"stone side",
+			func(i, j int) BlockId {
+				if i == -1 && j == 2 {
+					return BlockIdStone
+				}
+				return obsidianFrame(i, j)
+			},
+			false,
+		},
+		{
+			"stone bottom",
+			func(i, j int) BlockId {
+				if i == 0 && j == -1 {
+					return BlockIdStone
+				}
+				return obsidianFrame(i, j)
+			},
+			false,
+		},
+		{
+			"blocked inside",
+			func(i, j int) BlockId {
+				if i == 1 && j == 2 {
+					return BlockIdStone
+				}
+				return obsidianFrame(i, j)
+			},
+			false,
+		},
+	}
+
+	for _, axis := range portalAxes {
+		for _, test := range tests {
+			shard, service := testShard(t, nil, ShardXz{0, 0}, ChunkXz{0, 0})
+			frame := &portalFrame{BlockXyz{5, 64, 5}, axis.dx, axis.dz}
+			buildTestFrame(shard, frame, test.blockAt)
+
+			if complete := frame.isComplete(shard); complete != test.complete {
+				t.Errorf("%s along %d,%d: expected complete=%v but got %v", test.name, axis.dx, axis.dz, test.complete, complete)
+			}
+
+			// The frame along the other axis is never complete.
+			other := &portalFrame{frame.origin, axis.dz, axis.dx}
+			if other.isComplete(shard) {
+				t.Errorf("%s along %d,%d: frame complete along the other axis", test.name, axis.dx, axis.dz)
+			}
+
+			service.Close()
+		}
+	}
+}
+
+func TestPortalFrame_Fill(t *testing.T) {
+	for _, axis := range portalAxes {
+		shard, service := testShard(t, nil, ShardXz{0, 0}, ChunkXz{0, 0})
+		frame := &portalFrame{BlockXyz{5, 64, 5}, axis.dx, axis.dz}
+		buildTestFrame(shard, frame, obsidianFrame)
+
+		frame.fill(shard)
+
+		for i := -1; i <= portalWidth; i++ {
+			for j := -1; j <= portalHeight; j++ {
+				expected := obsidianFrame(i, j)
+				if !isFrameBlock(i, j, true) {
+					expected = BlockIdPortal
+				}
+				if blockId, _ := shard.blockIdAt(frame.at(i, j)); blockId != expected {
+					t.Errorf("along %d,%d: expected block %d at %d,%d but got %d", axis.dx, axis.dz, expected, i, j, blockId)
+				}
+
+				// Nothing in front of or behind the frame is changed.
+				front := frame.at(i, j).AddXyz(axis.dz, 0, axis.dx)
+				if blockId, _ := shard.blockIdAt(front); blockId != BlockIdAir {
+					t.Errorf("along %d,%d: expected air in front of %d,%d but got %d", axis.dx, axis.dz, i, j, blockId)
+				}
+			}
+		}
+
+		service.Close()
+	}
+}
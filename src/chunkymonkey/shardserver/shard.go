@@ -28,6 +28,7 @@ type ChunkShard struct {
 	shardConnecter   gamerules.IShardConnecter
 	chunkStore       chunkstore.IChunkStore
 	entityMgr        *entity.EntityManager
+	dimension        DimensionId
 	loc              ShardXz
 	originChunkLoc   ChunkXz // The lowest X and Z located chunk in the shard.
 	chunks           [chunksPerShard]*Chunk
@@ -35,19 +36,27 @@ type ChunkShard struct {
 	ticksSinceUpdate Ticks
 	ticksSinceSave   Ticks
 	saveChunks       bool
+	savesPaused      bool
 
 	newActiveBlocks []BlockXyz
 	newActiveShards map[uint64]*destActiveShard
 
 	shardClients map[uint64]gamerules.IShardShardClient
 	selfClient   shardSelfClient
+
+	raining    bool
+	thundering bool
+
+	// time is the world time, updated every second.
+	time Ticks
 }
 
-func NewChunkShard(shardConnecter gamerules.IShardConnecter, chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager, loc ShardXz) (shard *ChunkShard) {
+func NewChunkShard(shardConnecter gamerules.IShardConnecter, chunkStore chunkstore.IChunkStore, entityMgr *entity.EntityManager, dimension DimensionId, loc ShardXz) (shard *ChunkShard) {
 	shard = &ChunkShard{
 		shardConnecter:   shardConnecter,
 		chunkStore:       chunkStore,
 		entityMgr:        entityMgr,
+		dimension:        dimension,
 		loc:              loc,
 		originChunkLoc:   loc.ToChunkXz(),
 		requests:         make(chan iShardRequest, 256),
@@ -101,16 +110,12 @@ func (shard *ChunkShard) tick() {
 		shard.ticksSinceUpdate = 0
 	}
 
-	if shard.saveChunks && shard.chunkStore.SupportsWrite() {
+	if shard.saveChunks && shard.chunkStore.SupportsWrite() && !shard.savesPaused {
 		shard.ticksSinceSave++
 		if shard.ticksSinceSave > ticksBetweenSaves {
 			log.Printf("%s: Writing chunks.", shard)
 			// TODO Stagger the per-chunk saves over multiple ticks.
-			for _, chunk := range shard.chunks {
-				if chunk != nil {
-					chunk.save(shard.chunkStore)
-				}
-			}
+			shard.saveAllChunks()
 			shard.ticksSinceSave = 0
 		}
 	}
@@ -118,6 +123,16 @@ func (shard *ChunkShard) tick() {
 	shard.transferActiveBlocks()
 }
 
+// saveAllChunks writes the chunks that have changed since they were last
+// written to the chunk store.
+func (shard *ChunkShard) saveAllChunks() {
+	for _, chunk := range shard.chunks {
+		if chunk != nil {
+			chunk.save(shard.chunkStore)
+		}
+	}
+}
+
 // clientForShard is used to get a IShardShardClient for a given shard, reusing
 // IShardShardClient connections for use within the shard. Returns nil if the
 // shard does not exist.
@@ -166,6 +181,31 @@ func (shard *ChunkShard) blockQuery(chunkLoc ChunkXz, subLoc *SubChunkXyz) (bloc
 	return
 }
 
+// loadedChunkAt returns the Chunk at the given coordinates if it is within the
+// shard and already loaded, otherwise nil.
+func (shard *ChunkShard) loadedChunkAt(loc ChunkXz) *Chunk {
+	chunkIndex, _, _, ok := shard.chunkIndexAndRelLoc(loc)
+	if !ok {
+		return nil
+	}
+
+	return shard.chunks[chunkIndex]
+}
+
+// loadedBlockAt looks up the chunk and index of the given block. ok is false if
+// the block is not within a loaded chunk in the shard.
+func (shard *ChunkShard) loadedBlockAt(blockLoc *BlockXyz) (chunk *Chunk, index BlockIndex, ok bool) {
+	chunkLoc, subLoc := blockLoc.ToChunkLocal()
+
+	if chunk = shard.loadedChunkAt(*chunkLoc); chunk == nil {
+		return
+	}
+
+	index, ok = subLoc.BlockIndex()
+
+	return
+}
+
 // transferActiveBlocks takes blocks marked as newly active by addActiveBlock,
 // and informs the chunk in the destination shards.
 func (shard *ChunkShard) transferActiveBlocks() {
@@ -221,7 +261,7 @@ func (shard *ChunkShard) addActiveBlock(block *BlockXyz) {
 }
 
 func (shard *ChunkShard) String() string {
-	return fmt.Sprintf("ChunkShard[%#v/%#v]", shard.loc, shard.originChunkLoc)
+	return fmt.Sprintf("ChunkShard[%d:%#v/%#v]", shard.dimension, shard.loc, shard.originChunkLoc)
 }
 
 func (shard *ChunkShard) chunkIndexAndRelLoc(loc ChunkXz) (index int, x, z ChunkCoord, ok bool) {
@@ -289,6 +329,37 @@ func (shard *ChunkShard) loadChunk(loc ChunkXz, locDelta ChunkXz) *Chunk {
 	return chunk
 }
 
+// replaceChunk writes the chunk read by reader to the chunk store. If the
+// chunk is loaded, it is then loaded again from the store, so that its
+// subscribers are sent the new chunk.
+func (shard *ChunkShard) replaceChunk(reader chunkstore.IChunkReader) {
+	chunkLoc := reader.ChunkLoc()
+
+	writer := shard.chunkStore.Writer()
+	chunkstore.CopyChunkExactly(writer, reader)
+	shard.chunkStore.WriteChunk(writer)
+
+	chunkIndex, dx, dz, ok := shard.chunkIndexAndRelLoc(chunkLoc)
+	if !ok {
+		log.Printf("%v.replaceChunk(%#v): ChunkXz outside of shard", shard, chunkLoc)
+		return
+	}
+
+	oldChunk := shard.chunks[chunkIndex]
+	if oldChunk == nil {
+		return
+	}
+
+	chunk := shard.loadChunk(chunkLoc, ChunkXz{dx, dz})
+	if chunk == nil {
+		log.Printf("%v.replaceChunk(%#v): could not load the new chunk", shard, chunkLoc)
+		return
+	}
+
+	shard.chunks[chunkIndex] = chunk
+	chunk.replace(oldChunk)
+}
+
 // enqueueAllChunks runs a given function on all loaded chunks in the shard.
 func (shard *ChunkShard) enqueueAllChunks(fn func(chunk *Chunk)) {
 	shard.requests <- &runOnAllChunks{fn}
@@ -332,3 +403,7 @@ func (client *shardSelfClient) ReqTransferEntity(loc ChunkXz, entity gamerules.I
 		chunk.transferEntity(entity)
 	}
 }
+
+func (client *shardSelfClient) ReqExplode(center AbsXyz, power float32, rays []gamerules.ExplosionRay, affectEntities bool) {
+	client.shard.reqExplode(&center, power, rays, affectEntities)
+}
@@ -0,0 +1,94 @@
+package shardserver
+
+import (
+	"testing"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	. "chunkymonkey/types"
+)
+
+func init() {
+	if err := gamerules.LoadGameRules("blocks.json", "items.json", "recipes.json", "furnace.json", "users.json", "groups.json"); err != nil {
+		panic(err)
+	}
+}
+
+// emptyChunkStore returns a MemoryStore holding an empty chunk at chunkLoc.
+func emptyChunkStore(t *testing.T, chunkLoc ChunkXz) *chunkstore.MemoryStore {
+	const blockCount = ChunkSizeH * ChunkSizeH * ChunkSizeY
+
+	store := chunkstore.NewMemoryStore(nil)
+	writer := store.Writer()
+	writer.SetChunkLoc(chunkLoc)
+	writer.SetBlocks(make([]byte, blockCount))
+	writer.SetBlockData(make([]byte, blockCount/2))
+	writer.SetBlockLight(make([]byte, blockCount/2))
+	writer.SetSkyLight(make([]byte, blockCount/2))
+	writer.SetHeightMap(make([]byte, ChunkSizeH*ChunkSizeH))
+	if err := store.WriteChunk(writer); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	return store
+}
+
+// testShard returns the shard at shardLoc, with an empty chunk loaded at
+// chunkLoc. The caller must close the returned service.
+func testShard(t *testing.T, connecter gamerules.IShardConnecter, shardLoc ShardXz, chunkLoc ChunkXz) (*ChunkShard, *chunkstore.ChunkService) {
+	service := chunkstore.NewChunkService(emptyChunkStore(t, chunkLoc))
+	go service.Serve()
+
+	entityMgr := new(entity.EntityManager)
+	entityMgr.Init()
+
+	shard := NewChunkShard(connecter, service, entityMgr, DimensionNormal, shardLoc)
+	if shard.chunkAt(chunkLoc) == nil {
+		service.Close()
+		t.Fatalf("chunk %v not loaded", chunkLoc)
+	}
+
+	return shard, service
+}
+
+func TestChunkShard_SaveAndLoad(t *testing.T) {
+	chunkLoc := ChunkXz{1, 2}
+	service := chunkstore.NewChunkService(emptyChunkStore(t, chunkLoc))
+	go service.Serve()
+	defer service.Close()
+
+	var entityMgr entity.EntityManager
+	entityMgr.Init()
+
+	shard := NewChunkShard(nil, service, &entityMgr, DimensionNormal, ShardXz{0, 0})
+	chunk := shard.chunkAt(chunkLoc)
+	if chunk == nil {
+		t.Fatalf("chunk %v not loaded", chunkLoc)
+	}
+
+	subLoc := SubChunkXyz{X: 3, Y: 64, Z: 5}
+	index, _ := subLoc.BlockIndex()
+	chunk.SetBlockByIndex(index, BlockIdStone, 2)
+	if !chunk.storeDirty {
+		t.Errorf("chunk not marked as changed after setting a block")
+	}
+
+	shard.saveAllChunks()
+	if chunk.storeDirty {
+		t.Errorf("chunk still marked as changed after saving")
+	}
+
+	// A new shard loads the saved chunk from the store.
+	shard = NewChunkShard(nil, service, &entityMgr, DimensionNormal, ShardXz{0, 0})
+	chunk = shard.chunkAt(chunkLoc)
+	if chunk == nil {
+		t.Fatalf("saved chunk %v not loaded", chunkLoc)
+	}
+	if blockId := index.BlockId(chunk.blocks); blockId != BlockIdStone {
+		t.Errorf("expected stone but got block %d", blockId)
+	}
+	if blockData := index.BlockData(chunk.blockData); blockData != 2 {
+		t.Errorf("expected block data 2 but got %d", blockData)
+	}
+}
@@ -0,0 +1,161 @@
+package chunkymonkey
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"chunkymonkey/gamerules"
+	"chunkymonkey/player"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+	"nbt"
+)
+
+// SnapshotDir is the directory in which snapshots of the worlds are made. The
+// snapshots of each world are kept in a directory named after the world.
+var SnapshotDir = "snapshots"
+
+// SnapshotRetention decides which snapshots of each world are kept when a new
+// snapshot is made.
+var SnapshotRetention = worldstore.SnapshotRetention{Count: 10}
+
+// MakeSnapshot writes the level data, the data of connected players and the
+// changed chunks of every world, and copies each world into a new directory
+// in SnapshotDir named by the time. Older snapshots are then removed according
+// to SnapshotRetention. Chunks are not written while a world is copied. The
+// paths of the new snapshots are returned.
+func (game *Game) MakeSnapshot() (snapshotPaths []string, err os.Error) {
+	game.snapshotLock.Lock()
+	defer game.snapshotLock.Unlock()
+
+	start := time.Nanoseconds()
+	game.savePlayersAndLevels()
+
+	name := time.UTC().Format(worldstore.SnapshotTimeLayout)
+	for _, worldName := range game.worldNames {
+		snapshotPath := path.Join(SnapshotDir, worldName, name)
+		if err = game.worlds[worldName].snapshot(snapshotPath); err != nil {
+			return snapshotPaths, fmt.Errorf("world %q: %v", worldName, err)
+		}
+		snapshotPaths = append(snapshotPaths, snapshotPath)
+
+		removed, err := worldstore.PruneSnapshots(path.Dir(snapshotPath), SnapshotRetention)
+		for _, removedPath := range removed {
+			log.Printf("Removed old snapshot %s", removedPath)
+		}
+		if err != nil {
+			log.Printf("Failed to remove old snapshots of world %q: %v", worldName, err)
+		}
+	}
+
+	log.Printf("Made snapshots %s in %.1fs", strings.Join(snapshotPaths, ", "), float64(time.Nanoseconds()-start)/1e9)
+
+	return
+}
+
+// Shutdown writes the level data of every world and the data of connected
+// players, then writes the changed chunks of every world and closes the
+// worlds' chunk stores, so that the server can exit. Any snapshot being made
+// is finished first, and none are made afterwards. The game must not be used
+// afterwards.
+func (game *Game) Shutdown() {
+	game.snapshotLock.Lock()
+
+	start := time.Nanoseconds()
+	game.savePlayersAndLevels()
+
+	for _, worldName := range game.worldNames {
+		game.worlds[worldName].close()
+	}
+
+	log.Printf("Saved and closed the worlds in %.1fs", float64(time.Nanoseconds()-start)/1e9)
+}
+
+// ScheduleSnapshots makes a snapshot of the worlds every interval seconds.
+func (game *Game) ScheduleSnapshots(interval int64) {
+	ticker := time.NewTicker(interval * NanosecondsInSecond)
+	go func() {
+		for _ = range ticker.C {
+			if _, err := game.MakeSnapshot(); err != nil {
+				log.Printf("Scheduled snapshot failed: %v", err)
+			}
+		}
+	}()
+}
+
+// savePlayersAndLevels writes the level data of every world and the data of
+// every connected player.
+func (game *Game) savePlayersAndLevels() {
+	playersChan := make(chan []*player.Player)
+	game.enqueue(func(_ *Game) {
+		game.saveLevelData()
+
+		players := make([]*player.Player, 0, len(game.players))
+		for _, p := range game.players {
+			players = append(players, p)
+		}
+		playersChan <- players
+	})
+
+	for _, p := range <-playersChan {
+		game.savePlayer(p)
+	}
+}
+
+// savePlayer writes the data of a connected player. The data is packed by the
+// player's goroutine, and written by the game's goroutine unless the player
+// has disconnected meanwhile, in which case it was written on disconnection.
+func (game *Game) savePlayer(p *player.Player) {
+	type packedPlayer struct {
+		worldName string
+		data      *nbt.Compound
+	}
+
+	result := make(chan *packedPlayer, 1)
+	p.Enqueue(func(p *player.Player) {
+		playerData := nbt.NewCompound()
+		if err := p.MarshalNbt(playerData); err != nil {
+			log.Printf("Failed to marshal player data: %v", err)
+			result <- nil
+			return
+		}
+		result <- &packedPlayer{p.WorldName(), playerData}
+	})
+
+	var packed *packedPlayer
+	select {
+	case packed = <-result:
+	case <-time.After(playerQueryTimeout):
+		log.Printf("%v: timed out packing player data for snapshot", p)
+	}
+	if packed == nil {
+		return
+	}
+
+	done := make(chan bool)
+	game.enqueue(func(_ *Game) {
+		if game.players[p.GetEntityId()] == p {
+			game.writePlayerData(packed.worldName, p.Name(), packed.data)
+		}
+		done <- true
+	})
+	<-done
+}
+
+// The following functions implement the IGame interface
+
+func (game *Game) SnapshotWorlds(client gamerules.IPlayerClient) {
+	go func() {
+		snapshotPaths, err := game.MakeSnapshot()
+		if err != nil {
+			log.Printf("Snapshot failed: %v", err)
+			client.EchoMessage("Snapshot failed: " + err.String())
+			return
+		}
+		client.EchoMessage("Snapshot made in " + strings.Join(snapshotPaths, ", "))
+	}()
+}
@@ -65,6 +65,11 @@ func (id ItemTypeId) ToBlockId() (blockId BlockId, ok bool) {
 	return 0, false
 }
 
+// Item type IDs that have behaviour special to them.
+const (
+	ItemTypeIdFlintAndSteel = ItemTypeId(259)
+)
+
 // Item metadata. The meaning of this varies depending upon the item type. In
 // the case of tools/armor it indicates "uses" or "damage".
 type ItemData int16
@@ -126,6 +131,11 @@ var MobNameByType = map[EntityMobType]string{}
 
 type EntityStatus byte
 
+const (
+	EntityStatusHurt = EntityStatus(2)
+	EntityStatusDead = EntityStatus(3)
+)
+
 type EntityAnimation byte
 
 const (
@@ -240,9 +250,33 @@ const (
 type BlockId byte
 
 const (
-	BlockIdMin = 0
-	BlockIdAir = BlockId(0)
-	BlockIdMax = 255
+	BlockIdMin              = 0
+	BlockIdAir              = BlockId(0)
+	BlockIdStone            = BlockId(1)
+	BlockIdGrass            = BlockId(2)
+	BlockIdDirt             = BlockId(3)
+	BlockIdCobblestone      = BlockId(4)
+	BlockIdBedrock          = BlockId(7)
+	BlockIdWater            = BlockId(8)
+	BlockIdStationaryWater  = BlockId(9)
+	BlockIdFlowingLava      = BlockId(10)
+	BlockIdLava             = BlockId(11)
+	BlockIdSand             = BlockId(12)
+	BlockIdGravel           = BlockId(13)
+	BlockIdWood             = BlockId(17)
+	BlockIdLeaves           = BlockId(18)
+	BlockIdMossyCobblestone = BlockId(48)
+	BlockIdObsidian         = BlockId(49)
+	BlockIdFire             = BlockId(51)
+	BlockIdMobSpawner       = BlockId(52)
+	BlockIdChest            = BlockId(54)
+	BlockIdSnow             = BlockId(78)
+	BlockIdIce              = BlockId(79)
+	BlockIdNetherrack       = BlockId(87)
+	BlockIdSoulSand         = BlockId(88)
+	BlockIdGlowstone        = BlockId(89)
+	BlockIdPortal           = BlockId(90)
+	BlockIdMax              = 255
 )
 
 // Block face (0-5)
@@ -0,0 +1,140 @@
+package chunkymonkey
+
+import (
+	"bytes"
+	"log"
+	"rand"
+
+	"chunkymonkey/proto"
+	. "chunkymonkey/types"
+)
+
+// Reasons sent in the state packet to change the weather.
+const (
+	stateReasonBeginRain = 1
+	stateReasonEndRain   = 2
+)
+
+// Durations of weather periods. Each period lasts for the minimum plus a
+// random amount up to the range.
+const (
+	rainDurationMin      = Ticks(12000)
+	rainDurationRange    = 12000
+	clearDurationMin     = Ticks(12000)
+	clearDurationRange   = 168000
+	thunderDurationMin   = Ticks(3600)
+	thunderDurationRange = 12000
+	calmDurationMin      = Ticks(12000)
+	calmDurationRange    = 168000
+)
+
+// TODO Make level.dat save interval configurable.
+const ticksBetweenLevelSaves = TicksPerSecond * 60
+
+// weather holds the state of rain and thunderstorms across the world. Snow
+// falls instead of rain in cold biomes, which is decided by the client.
+type weather struct {
+	raining     bool
+	rainTime    Ticks
+	thundering  bool
+	thunderTime Ticks
+	rand        *rand.Rand
+}
+
+// tick counts down the current weather periods, and starts or stops rain and
+// thunder when they run out. It returns true if the weather changed.
+func (w *weather) tick() (changed bool) {
+	if w.rainTime <= 0 {
+		// No period has been set yet, e.g in a newly created world.
+		w.rainTime = w.nextRainTime()
+	} else {
+		w.rainTime--
+		if w.rainTime == 0 {
+			w.raining = !w.raining
+			w.rainTime = w.nextRainTime()
+			changed = true
+		}
+	}
+
+	if w.thunderTime <= 0 {
+		w.thunderTime = w.nextThunderTime()
+	} else {
+		w.thunderTime--
+		if w.thunderTime == 0 {
+			w.thundering = !w.thundering
+			w.thunderTime = w.nextThunderTime()
+			changed = true
+		}
+	}
+
+	return
+}
+
+func (w *weather) nextRainTime() Ticks {
+	if w.raining {
+		return rainDurationMin + Ticks(w.rand.Intn(rainDurationRange))
+	}
+	return clearDurationMin + Ticks(w.rand.Intn(clearDurationRange))
+}
+
+func (w *weather) nextThunderTime() Ticks {
+	if w.thundering {
+		return thunderDurationMin + Ticks(w.rand.Intn(thunderDurationRange))
+	}
+	return calmDurationMin + Ticks(w.rand.Intn(calmDurationRange))
+}
+
+// set forces the weather into the given state, starting new weather periods.
+func (w *weather) set(raining, thundering bool) {
+	w.raining = raining
+	w.thundering = thundering
+	w.rainTime = w.nextRainTime()
+	w.thunderTime = w.nextThunderTime()
+}
+
+// weatherTick runs the weather for a tick, and informs players and shards of
+// any change.
+func (game *Game) weatherTick() {
+	wasRaining := game.weather.raining
+	if game.weather.tick() {
+		game.weatherChanged(wasRaining)
+	}
+}
+
+// weatherChanged sends the current weather state to players and shards.
+func (game *Game) weatherChanged(wasRaining bool) {
+	for _, w := range game.worlds {
+		w.setWeather(game.weather.raining, game.weather.thundering)
+	}
+
+	if wasRaining != game.weather.raining {
+		game.multicastPacket(game.weatherPacket(), nil)
+	}
+}
+
+// weatherPacket returns the state packet for the current rain state.
+func (game *Game) weatherPacket() []byte {
+	buf := new(bytes.Buffer)
+	if game.weather.raining {
+		proto.WriteState(buf, stateReasonBeginRain, 0)
+	} else {
+		proto.WriteState(buf, stateReasonEndRain, 0)
+	}
+	return buf.Bytes()
+}
+
+// saveLevelData writes the time and weather to the level.dat of each world.
+func (game *Game) saveLevelData() {
+	for _, w := range game.worlds {
+		store := w.store
+		store.Time = game.time
+		store.Raining = game.weather.raining
+		store.RainTime = game.weather.rainTime
+		store.Thundering = game.weather.thundering
+		store.ThunderTime = game.weather.thunderTime
+
+		if err := store.WriteLevelData(); err != nil {
+			log.Printf("Failed to write level data for world %q: %v", w.name, err)
+		}
+	}
+}
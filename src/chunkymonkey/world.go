@@ -0,0 +1,131 @@
+package chunkymonkey
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"chunkymonkey/chunkstore"
+	. "chunkymonkey/entity"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/player"
+	"chunkymonkey/shardserver"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+// world is one of the worlds hosted by the game. Each world has its own
+// directory, seed, generator and shards.
+type world struct {
+	name          string
+	store         *worldstore.WorldStore
+	shardManagers map[DimensionId]*shardserver.LocalShardManager
+
+	// playerWorld is given to players in the world.
+	playerWorld player.World
+}
+
+// loadWorld loads the world stored in worldPath. The world is named after the
+// last element of its path.
+func loadWorld(worldPath string, entityManager *EntityManager) (w *world, err os.Error) {
+	store, err := worldstore.LoadWorldStore(worldPath)
+	if err != nil {
+		return
+	}
+
+	w = &world{
+		name:          path.Base(path.Clean(worldPath)),
+		store:         store,
+		shardManagers: make(map[DimensionId]*shardserver.LocalShardManager),
+	}
+
+	shardConnecters := make(map[DimensionId]gamerules.IShardConnecter)
+	for dimension, chunkStore := range store.ChunkStores {
+		shardManager := shardserver.NewLocalShardManager(chunkStore, entityManager, dimension)
+		w.shardManagers[dimension] = shardManager
+		shardConnecters[dimension] = shardManager
+	}
+
+	w.playerWorld = player.World{
+		Name:            w.name,
+		ShardConnecters: shardConnecters,
+		SpawnBlock:      store.SpawnPosition,
+		Store:           store,
+	}
+
+	return
+}
+
+// setWeather informs the shards of the world of a change in the weather.
+// There is no weather in the nether.
+func (w *world) setWeather(raining, thundering bool) {
+	if shardManager, ok := w.shardManagers[DimensionNormal]; ok {
+		shardManager.SetWeather(raining, thundering)
+	}
+}
+
+// setTime informs the shards of the world of the world time.
+func (w *world) setTime(time Ticks) {
+	for _, shardManager := range w.shardManagers {
+		shardManager.SetTime(time)
+	}
+}
+
+// snapshot copies the world into snapshotPath. The shards write their changed
+// chunks first, and do not write chunks again until the copy is complete.
+func (w *world) snapshot(snapshotPath string) os.Error {
+	for _, shardManager := range w.shardManagers {
+		shardManager.PauseSaves()
+		defer shardManager.ResumeSaves()
+	}
+
+	return w.store.Snapshot(snapshotPath)
+}
+
+// close writes the changed chunks of every shard, and closes the world's chunk
+// stores. The shards do not write chunks afterwards.
+func (w *world) close() {
+	for _, shardManager := range w.shardManagers {
+		shardManager.PauseSaves()
+	}
+
+	w.store.Close()
+}
+
+// restoreChunks copies the chunks at chunkLocs in the given dimension from the
+// world in backupPath, replacing the chunks of this world, and returns the
+// number of chunks restored. Chunks that are not in the backup are left as
+// they are. The backup is only read.
+func (w *world) restoreChunks(dimension DimensionId, backupPath string, chunkLocs []ChunkXz) (count int, err os.Error) {
+	if path.Clean(backupPath) == path.Clean(w.store.WorldPath) {
+		return 0, os.NewError("the backup is the world itself")
+	}
+
+	shardManager, ok := w.shardManagers[dimension]
+	if !ok {
+		return 0, fmt.Errorf("world %q has no dimension %d", w.name, dimension)
+	}
+
+	backupStore, err := worldstore.OpenChunkStoreReadOnly(backupPath, dimension)
+	if err != nil {
+		return
+	}
+	defer backupStore.Close()
+
+	for _, chunkLoc := range chunkLocs {
+		reader, err := backupStore.ReadChunk(chunkLoc)
+		if err != nil {
+			if _, ok := err.(chunkstore.NoSuchChunkError); ok {
+				continue
+			}
+			return count, err
+		}
+
+		if err = shardManager.ReplaceChunk(reader); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return
+}
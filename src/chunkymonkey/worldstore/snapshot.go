@@ -0,0 +1,218 @@
+package worldstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"chunkymonkey/chunkstore"
+)
+
+// SnapshotTimeLayout is the layout of the UTC times by which the snapshots of
+// a world are named.
+const SnapshotTimeLayout = "20060102-150405"
+
+// SnapshotRetention decides which snapshots of a world are kept. The most
+// recent snapshot is always kept.
+type SnapshotRetention struct {
+	// Count is the number of the most recent snapshots to keep. If 0, any
+	// number are kept.
+	Count int
+
+	// MaxAge is the age in seconds after which snapshots are removed. If 0,
+	// snapshots are kept regardless of age.
+	MaxAge int64
+}
+
+// Snapshot copies the world into snapshotPath, which must not already exist,
+// so that it can be loaded as a world of its own. Chunks are neither read nor
+// written while the world's files are copied, so chunks that should be in the
+// snapshot must already have been submitted to the chunk stores. Files that
+// are never changed once written are hard linked rather than copied where
+// possible. Chunks kept in memory, if KeepChunksInMemory was set, are written
+// into the snapshot.
+func (world *WorldStore) Snapshot(snapshotPath string) (err os.Error) {
+	worldPath := path.Clean(world.WorldPath)
+	if strings.HasPrefix(path.Clean(snapshotPath)+"/", worldPath+"/") {
+		return fmt.Errorf("snapshot %s would be within the world %s", snapshotPath, worldPath)
+	}
+
+	if err = os.MkdirAll(path.Dir(snapshotPath), 0777); err != nil {
+		return
+	}
+	if err = os.Mkdir(snapshotPath, 0777); err != nil {
+		return
+	}
+
+	pauseChunkServices(world.chunkServices, nil, func(sealedFiles []string) {
+		linkable := make(map[string]bool)
+		for _, filePath := range sealedFiles {
+			linkable[path.Clean(filePath)] = true
+		}
+		if err = copyWorldDir(worldPath, snapshotPath, linkable); err != nil {
+			return
+		}
+		err = world.snapshotMemoryStores(snapshotPath)
+	})
+
+	if err != nil {
+		os.RemoveAll(snapshotPath)
+	}
+
+	return
+}
+
+// snapshotMemoryStores writes the chunks kept in memory into the snapshot. The
+// chunk services must be paused.
+func (world *WorldStore) snapshotMemoryStores(snapshotPath string) (err os.Error) {
+	for dimension, memoryStore := range world.memoryStores {
+		store, err := OpenChunkStore(snapshotPath, dimension)
+		if err != nil {
+			return err
+		}
+		err = memoryStore.Snapshot(store)
+		store.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// pauseChunkServices pauses each service in turn, and calls fn with the
+// sealed files of all of them once they are all paused.
+func pauseChunkServices(services []*chunkstore.ChunkService, sealedFiles []string, fn func(sealedFiles []string)) {
+	if len(services) == 0 {
+		fn(sealedFiles)
+		return
+	}
+
+	services[0].Pause(func(serviceSealedFiles []string) {
+		pauseChunkServices(services[1:], append(sealedFiles, serviceSealedFiles...), fn)
+	})
+}
+
+// copyWorldDir copies the contents of the directory srcPath into the existing
+// directory dstPath. Files in linkable are hard linked instead.
+func copyWorldDir(srcPath, dstPath string, linkable map[string]bool) (err os.Error) {
+	dir, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	fileInfos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+
+	for _, fi := range fileInfos {
+		srcFilePath := path.Join(srcPath, fi.Name)
+		dstFilePath := path.Join(dstPath, fi.Name)
+
+		switch {
+		case fi.IsDirectory():
+			if err = os.Mkdir(dstFilePath, 0777); err == nil {
+				err = copyWorldDir(srcFilePath, dstFilePath, linkable)
+			}
+		case !fi.IsRegular() || strings.HasSuffix(fi.Name, ".tmp"):
+			// Temporary files are only complete once they have been renamed.
+			continue
+		case linkable[srcFilePath]:
+			if err = os.Link(srcFilePath, dstFilePath); err != nil {
+				// The snapshot might be on another filesystem.
+				err = copyFile(srcFilePath, dstFilePath)
+			}
+		default:
+			err = copyFile(srcFilePath, dstFilePath)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func copyFile(srcPath, dstPath string) (err os.Error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(dst, src); err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// IsSnapshotName returns true if name is a time in SnapshotTimeLayout, as the
+// names of snapshots are. Such a name is a single element of a path.
+func IsSnapshotName(name string) bool {
+	if strings.Contains(name, "/") {
+		return false
+	}
+	_, err := time.Parse(SnapshotTimeLayout, name)
+	return err == nil
+}
+
+// PruneSnapshots removes the snapshots in snapshotsPath that the retention
+// policy does not keep, and returns their paths. Only directories named by
+// SnapshotTimeLayout are regarded as snapshots.
+func PruneSnapshots(snapshotsPath string, retention SnapshotRetention) (removed []string, err os.Error) {
+	dir, err := os.Open(snapshotsPath)
+	if err != nil {
+		return
+	}
+	fileInfos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for _, fi := range fileInfos {
+		if IsSnapshotName(fi.Name) && fi.IsDirectory() {
+			names = append(names, fi.Name)
+		}
+	}
+	// The layout sorts the oldest snapshots first.
+	sort.Strings(names)
+
+	cutoff := time.Seconds() - retention.MaxAge
+	for i, name := range names {
+		newerCount := len(names) - 1 - i
+		if newerCount == 0 {
+			break
+		}
+
+		t, _ := time.Parse(SnapshotTimeLayout, name)
+		expired := retention.MaxAge > 0 && t.Seconds() < cutoff
+		excess := retention.Count > 0 && newerCount >= retention.Count
+		if !expired && !excess {
+			continue
+		}
+
+		snapshotPath := path.Join(snapshotsPath, name)
+		if err = os.RemoveAll(snapshotPath); err != nil {
+			return
+		}
+		removed = append(removed, snapshotPath)
+	}
+
+	return
+}
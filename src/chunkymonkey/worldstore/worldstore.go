@@ -18,15 +18,44 @@ import (
 	"nbt"
 )
 
+// KeepChunksInMemory makes the worlds loaded by LoadWorldStore keep the chunks
+// that are changed or generated in memory rather than saving them, so that
+// the worlds are reset to their saved chunks when the server restarts.
+var KeepChunksInMemory = false
+
 type WorldStore struct {
 	WorldPath string
 
 	Seed int64
 	Time Ticks
 
-	LevelData     nbt.ITag
-	ChunkStore    chunkstore.IChunkStore
+	// Weather state.
+	Raining     bool
+	RainTime    Ticks
+	Thundering  bool
+	ThunderTime Ticks
+
+	LevelData nbt.ITag
+
+	// Biomes gives the biome of any column in the normal dimension.
+	Biomes *generation.BiomeSource
+
+	// Generator generates the terrain of the normal dimension.
+	Generator generation.IChunkGenerator
+
+	// ChunkStores holds the chunk store for each dimension in the world.
+	ChunkStores map[DimensionId]chunkstore.IChunkStore
+
 	SpawnPosition BlockXyz
+
+	// chunkServices holds the services that chunks of the world pass through
+	// on their way to disk. Each dimension's services are in the order in
+	// which chunks pass through them.
+	chunkServices []*chunkstore.ChunkService
+
+	// memoryStores holds the store of each dimension that keeps chunks in
+	// memory, if KeepChunksInMemory was set.
+	memoryStores map[DimensionId]*chunkstore.MemoryStore
 }
 
 func LoadWorldStore(worldPath string) (world *WorldStore, err os.Error) {
@@ -56,38 +85,132 @@ func LoadWorldStore(worldPath string) (world *WorldStore, err os.Error) {
 		timeTicks = Ticks(timeTag.Value)
 	}
 
-	var chunkStores []chunkstore.IChunkStore
-	persistantChunkStore, err := chunkstore.ChunkStoreForLevel(worldPath, levelData, DimensionNormal)
+	var raining, thundering bool
+	var rainTime, thunderTime Ticks
+	if rainingTag, ok := levelData.Lookup("Data/raining").(*nbt.Byte); ok {
+		raining = rainingTag.Value != 0
+	}
+	if rainTimeTag, ok := levelData.Lookup("Data/rainTime").(*nbt.Int); ok {
+		rainTime = Ticks(rainTimeTag.Value)
+	}
+	if thunderingTag, ok := levelData.Lookup("Data/thundering").(*nbt.Byte); ok {
+		thundering = thunderingTag.Value != 0
+	}
+	if thunderTimeTag, ok := levelData.Lookup("Data/thunderTime").(*nbt.Int); ok {
+		thunderTime = Ticks(thunderTimeTag.Value)
+	}
+
+	seed := levelSeed(levelData)
+	generator, err := levelGenerator(levelData, seed)
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	persistantChunkService := chunkstore.NewChunkService(persistantChunkStore)
-	chunkStores = append(chunkStores, persistantChunkService)
+	memoryStores := make(map[DimensionId]*chunkstore.MemoryStore)
 
-	var seed int64
-	if seedNbt, ok := levelData.Lookup("Data/RandomSeed").(*nbt.Long); ok {
-		seed = seedNbt.Value
-	} else {
-		seed = rand.NewSource(time.Seconds()).Int63()
+	chunkStore, chunkServices, err := chunkStoreWithGenerator(worldPath, levelData, DimensionNormal, generator, memoryStores)
+	if err != nil {
+		return nil, err
 	}
 
-	chunkStores = append(chunkStores, chunkstore.NewChunkService(generation.NewTestGenerator(seed)))
-
-	for _, store := range chunkStores {
-		go store.Serve()
+	netherChunkStore, netherChunkServices, err := chunkStoreWithGenerator(worldPath, levelData, DimensionNether, generation.NewNetherGenerator(seed), memoryStores)
+	if err != nil {
+		return nil, err
 	}
 
 	world = &WorldStore{
-		WorldPath:     worldPath,
-		Seed:          seed,
-		Time:          timeTicks,
-		LevelData:     levelData,
-		ChunkStore:    chunkstore.NewChunkService(chunkstore.NewMultiStore(chunkStores, persistantChunkService)),
+		WorldPath:   worldPath,
+		Seed:        seed,
+		Time:        timeTicks,
+		Raining:     raining,
+		RainTime:    rainTime,
+		Thundering:  thundering,
+		ThunderTime: thunderTime,
+		LevelData:   levelData,
+		Biomes:      generation.NewBiomeSource(seed),
+		Generator:   generator,
+		ChunkStores: map[DimensionId]chunkstore.IChunkStore{
+			DimensionNormal: chunkStore,
+			DimensionNether: netherChunkStore,
+		},
 		SpawnPosition: spawnPosition,
+		chunkServices: append(chunkServices, netherChunkServices...),
+		memoryStores:  memoryStores,
+	}
+
+	return
+}
+
+// LoadGenerator returns the terrain generator of the normal dimension of the
+// world in worldPath, and the biomes that it generates, without opening the
+// world's chunks.
+func LoadGenerator(worldPath string) (generator generation.IChunkGenerator, biomes *generation.BiomeSource, err os.Error) {
+	levelData, err := loadLevelData(worldPath)
+	if err != nil {
+		return
+	}
+
+	seed := levelSeed(levelData)
+	if generator, err = levelGenerator(levelData, seed); err != nil {
+		return
 	}
 
-	go world.ChunkStore.Serve()
+	return generator, generation.NewBiomeSource(seed), nil
+}
+
+// levelSeed returns the world seed in level.dat, or a random seed if it has
+// none.
+func levelSeed(levelData nbt.ITag) int64 {
+	if seedNbt, ok := levelData.Lookup("Data/RandomSeed").(*nbt.Long); ok {
+		return seedNbt.Value
+	}
+	return rand.NewSource(time.Seconds()).Int63()
+}
+
+// levelGenerator returns the terrain generator for the normal dimension,
+// which is chosen by the generatorName and generatorOptions in level.dat.
+func levelGenerator(levelData nbt.ITag, seed int64) (generator generation.IChunkGenerator, err os.Error) {
+	var generatorName, generatorOptions string
+	if nameTag, ok := levelData.Lookup("Data/generatorName").(*nbt.String); ok {
+		generatorName = nameTag.Value
+	}
+	if optionsTag, ok := levelData.Lookup("Data/generatorOptions").(*nbt.String); ok {
+		generatorOptions = optionsTag.Value
+	}
+	return generation.NewGenerator(generatorName, seed, generatorOptions)
+}
+
+// chunkStoreWithGenerator creates a chunk store for the given dimension that
+// reads chunks from the world's save, falling back to generating chunks that
+// are not yet saved. Generated chunks are populated and saved before being
+// returned. The services that the store is made of are also returned, front
+// first. A store that keeps chunks in memory is added to memoryStores.
+func chunkStoreWithGenerator(worldPath string, levelData nbt.ITag, dimension DimensionId, generator generation.IChunkGenerator, memoryStores map[DimensionId]*chunkstore.MemoryStore) (store chunkstore.IChunkStore, services []*chunkstore.ChunkService, err os.Error) {
+	var persistantChunkStore chunkstore.IChunkStoreForeground
+	if KeepChunksInMemory {
+		// The saved chunks are only read, and must be left as they are.
+		persistantChunkStore, err = chunkstore.ReadOnlyChunkStoreForLevel(worldPath, levelData, dimension)
+		if err != nil {
+			return
+		}
+		memoryStore := chunkstore.NewMemoryStore(persistantChunkStore)
+		memoryStores[dimension] = memoryStore
+		persistantChunkStore = memoryStore
+	} else {
+		persistantChunkStore, err = chunkstore.ChunkStoreForLevel(worldPath, levelData, dimension)
+		if err != nil {
+			return
+		}
+	}
+
+	persistantChunkService := chunkstore.NewChunkService(persistantChunkStore)
+	go persistantChunkService.Serve()
+
+	generatingChunkService := chunkstore.NewChunkService(generation.NewPopulatingStore(persistantChunkService, generator))
+	go generatingChunkService.Serve()
+
+	store = generatingChunkService
+	services = []*chunkstore.ChunkService{generatingChunkService, persistantChunkService}
 
 	return
 }
@@ -111,8 +234,76 @@ func loadLevelData(worldPath string) (levelData nbt.ITag, err os.Error) {
 	return
 }
 
-// NOTE: ChunkStoreForDimension shouldn't really be used in the server just
-// yet.
+// WriteLevelData writes the current time and weather back into level.dat.
+func (world *WorldStore) WriteLevelData() (err os.Error) {
+	levelData, ok := world.LevelData.(*nbt.Compound)
+	if !ok {
+		return os.NewError("Invalid map level data: not a compound")
+	}
+	data, ok := levelData.Lookup("Data").(*nbt.Compound)
+	if !ok {
+		return os.NewError("Invalid map level data: does not contain Data")
+	}
+
+	// The seed is kept so that chunks not yet generated continue to match the
+	// existing terrain if level.dat was missing it.
+	data.Set("RandomSeed", &nbt.Long{world.Seed})
+	data.Set("Time", &nbt.Long{int64(world.Time)})
+	data.Set("raining", &nbt.Byte{boolToByte(world.Raining)})
+	data.Set("rainTime", &nbt.Int{int32(world.RainTime)})
+	data.Set("thundering", &nbt.Byte{boolToByte(world.Thundering)})
+	data.Set("thunderTime", &nbt.Int{int32(world.ThunderTime)})
+	data.Set("LastPlayed", &nbt.Long{time.Nanoseconds() / 1e6})
+
+	return writeNbtFile(path.Join(world.WorldPath, "level.dat"), levelData)
+}
+
+// writeNbtFile writes the tag to a temporary file first, and then renames it
+// over filename so that a crash cannot leave a truncated file behind.
+func writeNbtFile(filename string, tag nbt.ITag) (err os.Error) {
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+
+	gzipWriter, err := gzip.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	err = nbt.Write(gzipWriter, tag)
+	gzipWriter.Close()
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
+
+func boolToByte(b bool) int8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Close closes the chunk stores of every dimension, once the chunks already
+// submitted to them are written. The chunk stores must not be used
+// afterwards.
+func (world *WorldStore) Close() {
+	// Each service is closed before those behind it, so that the chunks
+	// passing through it reach them first.
+	for _, service := range world.chunkServices {
+		service.Close()
+	}
+}
+
+// ChunkStoreForDimension returns a store that reads only the saved chunks of
+// the given dimension, without generating missing chunks. The server uses
+// ChunkStores instead.
 func (world *WorldStore) ChunkStoreForDimension(dimension DimensionId) (store chunkstore.IChunkStore, err os.Error) {
 	fgStore, err := chunkstore.ChunkStoreForLevel(world.WorldPath, world.LevelData, dimension)
 	if err != nil {
@@ -123,6 +314,26 @@ func (world *WorldStore) ChunkStoreForDimension(dimension DimensionId) (store ch
 	return
 }
 
+// OpenChunkStore opens the saved chunks of a dimension of the world in
+// worldPath, such as a backup of a world, without generating missing chunks.
+func OpenChunkStore(worldPath string, dimension DimensionId) (store chunkstore.IChunkStoreForeground, err os.Error) {
+	levelData, err := loadLevelData(worldPath)
+	if err != nil {
+		return
+	}
+	return chunkstore.ChunkStoreForLevel(worldPath, levelData, dimension)
+}
+
+// OpenChunkStoreReadOnly is like OpenChunkStore, except that the store never
+// changes the world's files, so that a backup is left as it is.
+func OpenChunkStoreReadOnly(worldPath string, dimension DimensionId) (store chunkstore.IChunkStoreForeground, err os.Error) {
+	levelData, err := loadLevelData(worldPath)
+	if err != nil {
+		return
+	}
+	return chunkstore.ReadOnlyChunkStoreForLevel(worldPath, levelData, dimension)
+}
+
 func (world *WorldStore) PlayerData(user string) (playerData *nbt.Compound, err os.Error) {
 	file, err := os.Open(path.Join(world.WorldPath, "players", user+".dat"))
 	if err != nil {
@@ -152,48 +363,61 @@ func (world *WorldStore) WritePlayerData(user string, data *nbt.Compound) (err o
 		return
 	}
 
-	filename := path.Join(world.WorldPath, "players", user+".dat")
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
+	return writeNbtFile(path.Join(playerDir, user+".dat"), data)
+}
+
+// Creates a new world at 'worldPath', generated by the named generator with
+// the given options. An empty generatorName selects the default generator.
+// Chunks are stored in the given chunk format, such as
+// chunkstore.ChunkFormatLog, or in region files if chunkFormat is empty.
+func CreateWorld(worldPath, generatorName, generatorOptions, chunkFormat string) (err os.Error) {
+	switch chunkFormat {
+	case "", chunkstore.ChunkFormatRegion, chunkstore.ChunkFormatLog:
+	default:
+		return chunkstore.UnknownChunkFormat(chunkFormat)
 	}
-	defer file.Close()
 
-	gzipWriter, err := gzip.NewWriter(file)
+	source := rand.NewSource(time.Nanoseconds())
+	seed := source.Int63()
+
+	generator, err := generation.NewGenerator(generatorName, seed, generatorOptions)
 	if err != nil {
 		return
 	}
 
-	err = nbt.Write(gzipWriter, data)
-	gzipWriter.Close()
-
-	return
-}
+	spawnPosition := BlockXyz{0, 75, 0}
+	if spawnGenerator, ok := generator.(generation.ISpawnGenerator); ok {
+		spawnPosition = spawnGenerator.SpawnPosition()
+	}
 
-// Creates a new world at 'worldPath'
-func CreateWorld(worldPath string) (err os.Error) {
-	source := rand.NewSource(time.Nanoseconds())
-	seed := source.Int63()
+	dataTag := &nbt.Compound{
+		map[string]nbt.ITag{
+			"Time":        &nbt.Long{0},
+			"rainTime":    &nbt.Int{0},
+			"thunderTime": &nbt.Int{0},
+			"version":     &nbt.Int{chunkstore.LevelVersionMcRegion},
+			"thundering":  &nbt.Byte{0},
+			"raining":     &nbt.Byte{0},
+			"LevelName":   &nbt.String{"world"}, // TODO: Should be specifyable
+			"SpawnX":      &nbt.Int{int32(spawnPosition.X)},
+			"SpawnY":      &nbt.Int{int32(spawnPosition.Y)},
+			"SpawnZ":      &nbt.Int{int32(spawnPosition.Z)},
+			"LastPlayed":  &nbt.Long{0},
+			"SizeOnDisk":  &nbt.Long{0}, // Needs to be accurate?
+			"RandomSeed":  &nbt.Long{seed},
+		},
+	}
+	if generatorName != "" {
+		dataTag.Set("generatorName", &nbt.String{generatorName})
+		dataTag.Set("generatorOptions", &nbt.String{generatorOptions})
+	}
+	if chunkFormat != "" && chunkFormat != chunkstore.ChunkFormatRegion {
+		dataTag.Set("chunkFormat", &nbt.String{chunkFormat})
+	}
 
 	data := &nbt.Compound{
 		map[string]nbt.ITag{
-			"Data": &nbt.Compound{
-				map[string]nbt.ITag{
-					"Time":        &nbt.Long{0},
-					"rainTime":    &nbt.Int{0},
-					"thunderTime": &nbt.Int{0},
-					"version":     &nbt.Int{19132}, // TODO: What should this be?
-					"thundering":  &nbt.Byte{0},
-					"raining":     &nbt.Byte{0},
-					"LevelName":   &nbt.String{"world"}, // TODO: Should be specifyable
-					"SpawnX":      &nbt.Int{0},          // TODO: Figure this out from chunk generator?
-					"SpawnY":      &nbt.Int{75},         // TODO: Figure this out from chunk generator?
-					"SpawnZ":      &nbt.Int{0},          // TODO: Figure this out from chunk generator?
-					"LastPlayed":  &nbt.Long{0},
-					"SizeOnDisk":  &nbt.Long{0}, // Needs to be accurate?
-					"RandomSeed":  &nbt.Long{seed},
-				},
-			},
+			"Data": dataTag,
 		},
 	}
 
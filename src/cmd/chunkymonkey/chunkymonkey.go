@@ -3,14 +3,18 @@ package main
 import (
 	_ "expvar"
 	"flag"
+	"fmt"
 	"http"
 	_ "http/pprof"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 
 	"chunkymonkey"
+	"chunkymonkey/chunkstore"
 	"chunkymonkey/gamerules"
+	"chunkymonkey/generation"
 	"chunkymonkey/worldstore"
 )
 
@@ -19,8 +23,8 @@ var addr = flag.String(
 	"Serves on the given address:port.")
 
 var httpAddr = flag.String(
-	"http_addr", ":25566",
-	"Serves HTTP diagnostics on the given address:port.")
+	"http_addr", "127.0.0.1:25566",
+	"Serves HTTP diagnostics on the given address:port. The server is not authenticated, so it only listens locally by default.")
 
 var blockDefs = flag.String(
 	"blocks", "blocks.json",
@@ -38,6 +42,10 @@ var furnaceDefs = flag.String(
 	"furnace", "furnace.json",
 	"The JSON file containing furnace fuel and reaction definitions.")
 
+var oreDefs = flag.String(
+	"ores", "ores.json",
+	"The JSON file containing ore definitions for world generation.")
+
 var serverDesc = flag.String(
 	"server_desc", "Chunkymonkey Minecraft server",
 	"The server description.")
@@ -54,6 +62,46 @@ var groupDefs = flag.String(
 	"groups", "groups.json",
 	"The JSON file containing group permissions.")
 
+var generatorName = flag.String(
+	"generator", generation.DefaultGeneratorName,
+	"The terrain generator for new worlds: default, density, flat or void.")
+
+var generatorOptions = flag.String(
+	"generator_options", "",
+	"Options for the terrain generator of new worlds, e.g the layers of a flat world such as \"bedrock,2*dirt,grass\".")
+
+var chunkFormat = flag.String(
+	"chunk_format", chunkstore.ChunkFormatRegion,
+	"How the chunks of new worlds are stored: region (compatible with Notchian servers) or log (append-only, keeping older versions of chunks).")
+
+var chunkLogRetention = flag.Int64(
+	"chunk_log_retention", chunkstore.LogRetention,
+	"Seconds to keep the older versions of chunks in worlds using the log chunk format.")
+
+var maxRegionFiles = flag.Int(
+	"max_region_files", chunkstore.MaxOpenRegionFiles,
+	"Maximum number of region files to keep open for each dimension of each world.")
+
+var ephemeral = flag.Bool(
+	"ephemeral", false,
+	"Keep changed and generated chunks in memory instead of saving them, so that worlds are reset to their saved chunks on restart.")
+
+var snapshotDir = flag.String(
+	"snapshot_dir", chunkymonkey.SnapshotDir,
+	"The directory in which snapshots of the worlds are made.")
+
+var snapshotInterval = flag.Int64(
+	"snapshot_interval", 0,
+	"Seconds between snapshots of the worlds, or 0 to only make snapshots when asked to.")
+
+var snapshotKeep = flag.Int(
+	"snapshot_keep", chunkymonkey.SnapshotRetention.Count,
+	"Number of the most recent snapshots of each world to keep, or 0 to keep any number.")
+
+var snapshotMaxAge = flag.Int64(
+	"snapshot_max_age", chunkymonkey.SnapshotRetention.MaxAge,
+	"Seconds after which snapshots are removed, or 0 to keep them regardless of age. The most recent snapshot is always kept.")
+
 // TODO Implement max player count enforcement. Probably would have to be
 // implemented atomically at the game level.
 var maxPlayerCount = flag.Int(
@@ -61,26 +109,83 @@ var maxPlayerCount = flag.Int(
 	"Maximum number of players to allow concurrently. (Does not work yet)")
 
 func usage() {
-	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world>\n")
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world> [<world>...]\n")
+	os.Stderr.WriteString("Players join the first world when they log in.\n")
 	flag.PrintDefaults()
 }
 
-func startHttpServer(addr string) (err os.Error) {
+func startHttpServer(addr string, game *chunkymonkey.Game) (err os.Error) {
 	httpPort, err := net.Listen("tcp", addr)
 	if err != nil {
 		return
 	}
+	http.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		handleSnapshot(w, r, game)
+	})
 	go http.Serve(httpPort, nil)
 	return
 }
 
+// handleSnapshot makes a snapshot of the worlds when POSTed to, and responds
+// once it is complete.
+func handleSnapshot(w http.ResponseWriter, r *http.Request, game *chunkymonkey.Game) {
+	if r.Method != "POST" {
+		http.Error(w, "Snapshots must be requested with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshotPaths, err := game.MakeSnapshot()
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, snapshotPath := range snapshotPaths {
+		fmt.Fprintln(w, snapshotPath)
+	}
+}
+
+// shutdownOnSignal saves the game and exits when the server is interrupted or
+// terminated.
+func shutdownOnSignal(game *chunkymonkey.Game) {
+	for sig := range signal.Incoming {
+		if unixSig, ok := sig.(os.UnixSignal); ok && (unixSig == os.SIGINT || unixSig == os.SIGTERM) {
+			log.Printf("Received %v, shutting down", sig)
+			game.Shutdown()
+			os.Exit(0)
+		}
+	}
+}
+
+// openWorld checks that there is a world in worldPath, creating a new world
+// there if there is nothing yet.
+func openWorld(worldPath string) (err os.Error) {
+	fi, err := os.Stat(worldPath)
+	if err != nil {
+		log.Printf("Could not load world from directory %v: %v", worldPath, err)
+		log.Printf("Creating a new world in directory %v", worldPath)
+		if err = worldstore.CreateWorld(worldPath, *generatorName, *generatorOptions, *chunkFormat); err != nil {
+			return
+		}
+		if fi, err = os.Stat(worldPath); err != nil {
+			return
+		}
+	}
+
+	if !fi.IsDirectory() {
+		return os.NewError("not a directory")
+	}
+
+	return
+}
+
 func main() {
 	var err os.Error
 
 	flag.Usage = usage
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	if flag.NArg() < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -91,22 +196,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	worldPath := flag.Arg(0)
-	fi, err := os.Stat(worldPath)
+	generation.Ores, err = generation.LoadOresFromFile(*oreDefs)
 	if err != nil {
-		log.Printf("Could not load world from directory %v: %v", worldPath, err)
-		log.Printf("Creating a new world in directory %v", worldPath)
-		err = worldstore.CreateWorld(worldPath)
+		log.Print("Error loading ore definitions: ", err)
+		os.Exit(1)
 	}
-	if err != nil {
-		log.Printf("Error creating new world: %v", err)
-	} else {
-		fi, err = os.Stat(worldPath)
+
+	chunkstore.MaxOpenRegionFiles = *maxRegionFiles
+	chunkstore.LogRetention = *chunkLogRetention
+	worldstore.KeepChunksInMemory = *ephemeral
+	chunkymonkey.SnapshotDir = *snapshotDir
+	chunkymonkey.SnapshotRetention = worldstore.SnapshotRetention{
+		Count:  *snapshotKeep,
+		MaxAge: *snapshotMaxAge,
 	}
 
-	if fi == nil || !fi.IsDirectory() {
-		log.Printf("Error loading world %v: Not a directory", worldPath)
-		os.Exit(1)
+	worldPaths := flag.Args()
+	for _, worldPath := range worldPaths {
+		if err = openWorld(worldPath); err != nil {
+			log.Printf("Error loading world %v: %v", worldPath, err)
+			os.Exit(1)
+		}
 	}
 
 	listener, err := net.Listen("tcp", *addr)
@@ -114,15 +224,21 @@ func main() {
 		log.Fatal(err)
 	}
 
-	game, err := chunkymonkey.NewGame(worldPath, listener, *serverDesc, *maintenanceMsg, *maxPlayerCount)
+	game, err := chunkymonkey.NewGame(worldPaths, listener, *serverDesc, *maintenanceMsg, *maxPlayerCount)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = startHttpServer(*httpAddr)
+	err = startHttpServer(*httpAddr, game)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *snapshotInterval > 0 {
+		game.ScheduleSnapshots(*snapshotInterval)
+	}
+
+	go shutdownOnSignal(game)
+
 	game.Serve()
 }
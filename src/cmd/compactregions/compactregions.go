@@ -0,0 +1,107 @@
+// Utility to compact the region files of worlds, removing the unused sectors
+// left behind when chunks grow. The server must not be running on the worlds.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"chunkymonkey/chunkstore"
+)
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " <world>...\n")
+	flag.PrintDefaults()
+}
+
+// readDirNames returns the names in the directory that have the given prefix
+// and suffix.
+func readDirNames(dirPath, prefix, suffix string) (names []string, err os.Error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+
+	allNames, err := dir.Readdirnames(-1)
+	if err != nil {
+		return
+	}
+
+	for _, name := range allNames {
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+
+	return
+}
+
+// regionDirs returns the region directories of each dimension in the world.
+func regionDirs(worldPath string) (dirs []string, err os.Error) {
+	dirs = []string{path.Join(worldPath, "region")}
+
+	dimensions, err := readDirNames(worldPath, "DIM", "")
+	if err != nil {
+		return
+	}
+	for _, dimension := range dimensions {
+		dirs = append(dirs, path.Join(worldPath, dimension, "region"))
+	}
+
+	return
+}
+
+// compactWorld compacts all of the region files in the world, and returns the
+// number of files that could not be compacted.
+func compactWorld(worldPath string) (failures int) {
+	dirs, err := regionDirs(worldPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", worldPath, err)
+		return 1
+	}
+
+	for _, dir := range dirs {
+		names, err := readDirNames(dir, "r.", ".mcr")
+		if err != nil {
+			// Dimensions that have not been visited have no region directory.
+			continue
+		}
+
+		for _, name := range names {
+			filePath := path.Join(dir, name)
+			oldSize, newSize, err := chunkstore.CompactRegionFile(filePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", filePath, err)
+				failures++
+				continue
+			}
+			fmt.Printf("%s: %d -> %d bytes\n", filePath, oldSize, newSize)
+		}
+	}
+
+	return
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	failures := 0
+	for _, worldPath := range flag.Args() {
+		failures += compactWorld(worldPath)
+	}
+
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "%d region files could not be compacted and were left unchanged\n", failures)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,252 @@
+// Utility to convert the chunks of a world between formats. The server must
+// not be running on the world.
+//
+// Worlds from Alpha, with each chunk in a file of its own, are converted to the
+// McRegion format used by Beta, with chunks grouped into region files, and
+// their player data is updated. McRegion worlds can have their chunks moved
+// between region files and the append-only log format with the -format flag.
+//
+// The chunks of each dimension are copied and checked, and finally level.dat
+// is updated. If the conversion fails part way, level.dat is left unchanged
+// and the world continues to be read in its old format. The chunks in the old
+// format are not removed.
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"chunkymonkey/chunkstore"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+var format = flag.String(
+	"format", chunkstore.ChunkFormatRegion,
+	"The chunk format to convert the world to: region or log.")
+
+// progressInterval is the minimum time in nanoseconds between progress
+// reports.
+const progressInterval = 5e9
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world>\n")
+	flag.PrintDefaults()
+}
+
+func readNbtFile(filePath string) (tag *nbt.Compound, err os.Error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return
+	}
+	defer gzipReader.Close()
+
+	return nbt.Read(gzipReader)
+}
+
+// writeNbtFile writes the tag to a temporary file, and then renames it over
+// the original so that a crash cannot leave a truncated file behind.
+func writeNbtFile(filePath string, tag *nbt.Compound) (err os.Error) {
+	tmpFilePath := filePath + ".tmp"
+	file, err := os.OpenFile(tmpFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+
+	gzipWriter, err := gzip.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	err = nbt.Write(gzipWriter, tag)
+	gzipWriter.Close()
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmpFilePath, filePath)
+}
+
+// convertPlayer adds the tags that Beta reads from player data but that Alpha
+// did not write. It returns true if any were added.
+func convertPlayer(player *nbt.Compound) (changed bool) {
+	defaults := map[string]nbt.ITag{
+		"Dimension":  &nbt.Int{int32(DimensionNormal)},
+		"Sleeping":   &nbt.Byte{0},
+		"SleepTimer": &nbt.Short{0},
+	}
+
+	for name, tag := range defaults {
+		if player.Lookup(name) == nil {
+			player.Set(name, tag)
+			changed = true
+		}
+	}
+
+	return
+}
+
+// convertPlayerFiles converts the data of each player in the players
+// directory, and returns the number of files changed.
+func convertPlayerFiles(worldPath string) (count int, err os.Error) {
+	playersPath := path.Join(worldPath, "players")
+	dir, err := os.Open(playersPath)
+	if err != nil {
+		// A single player world has no players directory.
+		return 0, nil
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".dat") {
+			continue
+		}
+
+		filePath := path.Join(playersPath, name)
+		player, err := readNbtFile(filePath)
+		if err != nil {
+			return count, fmt.Errorf("%s: %v", filePath, err)
+		}
+		if !convertPlayer(player) {
+			continue
+		}
+		if err = writeNbtFile(filePath, player); err != nil {
+			return count, fmt.Errorf("%s: %v", filePath, err)
+		}
+		count++
+	}
+
+	return
+}
+
+// convertChunks converts the chunks of a dimension, logging progress, and
+// returns the number of chunks that were skipped.
+func convertChunks(worldPath string, dimension DimensionId) (skipped int, err os.Error) {
+	start := time.Nanoseconds()
+	lastReport := start
+	reportProgress := func(done, total int) {
+		now := time.Nanoseconds()
+		if now-lastReport < progressInterval {
+			return
+		}
+		lastReport = now
+		log.Printf("Dimension %d: %d/%d chunks (%.1f%%)", dimension, done, total, float64(done)*100/float64(total))
+	}
+
+	result, err := chunkstore.ConvertAlphaChunks(worldPath, dimension, reportProgress)
+	if err != nil {
+		return
+	}
+
+	log.Printf(
+		"Dimension %d: converted and verified %d chunks in %.1fs, %d chunks skipped",
+		dimension, result.Converted, float64(time.Nanoseconds()-start)/1e9, result.Skipped)
+
+	return result.Skipped, nil
+}
+
+func convertWorld(worldPath string) (err os.Error) {
+	levelPath := path.Join(worldPath, "level.dat")
+	level, err := readNbtFile(levelPath)
+	if err != nil {
+		return
+	}
+
+	data, ok := level.Lookup("Data").(*nbt.Compound)
+	if !ok {
+		return os.NewError("Invalid level.dat: does not contain Data")
+	}
+
+	fromAlpha := true
+	fromFormat := chunkstore.ChunkFormatRegion
+	if version, ok := data.Lookup("version").(*nbt.Int); ok {
+		if version.Value != chunkstore.LevelVersionMcRegion {
+			return chunkstore.UnknownLevelVersion(version.Value)
+		}
+		fromAlpha = false
+		if formatTag, ok := data.Lookup("chunkFormat").(*nbt.String); ok {
+			fromFormat = formatTag.Value
+		}
+		if fromFormat == *format {
+			return fmt.Errorf("World is already in the %s format", *format)
+		}
+	}
+
+	dimensions := []DimensionId{DimensionNormal, DimensionNether}
+
+	if fromAlpha {
+		skipped := 0
+		for _, dimension := range dimensions {
+			dimensionSkipped, err := convertChunks(worldPath, dimension)
+			if err != nil {
+				return err
+			}
+			skipped += dimensionSkipped
+		}
+		if skipped > 0 {
+			log.Printf("%d chunks could not be read and will be generated again", skipped)
+		}
+
+		playerCount, err := convertPlayerFiles(worldPath)
+		if err != nil {
+			return err
+		}
+		if player, ok := data.Lookup("Player").(*nbt.Compound); ok && convertPlayer(player) {
+			playerCount++
+		}
+		log.Printf("Converted %d players", playerCount)
+	}
+
+	if fromFormat != *format {
+		for _, dimension := range dimensions {
+			count, err := chunkstore.ConvertChunkFormat(worldPath, dimension, fromFormat, *format)
+			if err != nil {
+				return err
+			}
+			log.Printf("Dimension %d: converted and verified %d chunks from %s to %s", dimension, count, fromFormat, *format)
+		}
+	}
+
+	data.Set("version", &nbt.Int{chunkstore.LevelVersionMcRegion})
+	if *format == chunkstore.ChunkFormatRegion {
+		data.Tags["chunkFormat"] = nil, false
+	} else {
+		data.Set("chunkFormat", &nbt.String{*format})
+	}
+
+	return writeNbtFile(levelPath, level)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	worldPath := flag.Arg(0)
+	if err := convertWorld(worldPath); err != nil {
+		log.Printf("%s: %v", worldPath, err)
+		os.Exit(1)
+	}
+}
@@ -1,5 +1,5 @@
-// Utility to perform basic checks on supplied data files for blocks, items and
-// recipes.
+// Utility to perform basic checks on supplied data files for blocks, items,
+// recipes and ores.
 package main
 
 import (
@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"chunkymonkey/gamerules"
+	"chunkymonkey/generation"
 )
 
 var blockDefs = flag.String(
@@ -26,6 +27,10 @@ var furnaceDefs = flag.String(
 	"furnace", "furnace.json",
 	"The JSON file containing furnace fuel and reaction definitions.")
 
+var oreDefs = flag.String(
+	"ores", "ores.json",
+	"The JSON file containing ore definitions for world generation.")
+
 var userDefs = flag.String(
 	"users", "users.json",
 	"The JSON file container user permissions.")
@@ -42,5 +47,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if _, err = generation.LoadOresFromFile(*oreDefs); err != nil {
+		fmt.Fprintf(os.Stdout, "Error loading ore definitions: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Println("PASS")
 }
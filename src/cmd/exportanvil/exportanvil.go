@@ -0,0 +1,296 @@
+// Utility to export a world to the Anvil format read by Minecraft 1.2 and
+// later, so that it can be loaded by newer clients and tools. The server must
+// not be running on the world.
+//
+// The export is written to a new directory, leaving the world unchanged. The
+// chunks of each dimension are converted and written to ".mca" region files,
+// level.dat is converted, and the player data is copied.
+//
+// Notchian servers do not have the generators of this server, so new terrain
+// in the exported world is generated by their default generator, or by their
+// superflat generator for flat worlds.
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/generation"
+	. "chunkymonkey/types"
+	"nbt"
+)
+
+var blockDefs = flag.String(
+	"blocks", "blocks.json",
+	"The JSON file containing block type definitions, used to read the layers of flat worlds.")
+
+// Anvil biome IDs given to columns whose biome is not decided by the
+// generator's biome source.
+const (
+	anvilBiomePlains = 1
+	anvilBiomeHell   = 8
+)
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world> <export>\n")
+	flag.PrintDefaults()
+}
+
+func readNbtFile(filePath string) (tag *nbt.Compound, err os.Error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return
+	}
+	defer gzipReader.Close()
+
+	return nbt.Read(gzipReader)
+}
+
+func writeNbtFile(filePath string, tag *nbt.Compound) (err os.Error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	gzipWriter, err := gzip.NewWriter(file)
+	if err != nil {
+		return
+	}
+
+	if err = nbt.Write(gzipWriter, tag); err != nil {
+		gzipWriter.Close()
+		return
+	}
+
+	return gzipWriter.Close()
+}
+
+// flatPreset returns the Notchian superflat preset with the given layers,
+// such as "2;7,2x3,2;1" for bedrock, two layers of dirt and grass.
+func flatPreset(layers []BlockId) string {
+	var parts []string
+	for i := 0; i < len(layers); {
+		count := 1
+		for i+count < len(layers) && layers[i+count] == layers[i] {
+			count++
+		}
+		if count == 1 {
+			parts = append(parts, fmt.Sprintf("%d", layers[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%dx%d", count, layers[i]))
+		}
+		i += count
+	}
+
+	return fmt.Sprintf("2;%s;%d", strings.Join(parts, ","), anvilBiomePlains)
+}
+
+// convertLevel converts the Data compound of level.dat to the Anvil format,
+// replacing the generator with the closest Notchian one.
+func convertLevel(data *nbt.Compound) (err os.Error) {
+	generatorName := generation.DefaultGeneratorName
+	if nameTag, ok := data.Lookup("generatorName").(*nbt.String); ok && nameTag.Value != "" {
+		generatorName = nameTag.Value
+	}
+
+	if generatorName == "flat" {
+		options := generation.DefaultFlatLayers
+		if optionsTag, ok := data.Lookup("generatorOptions").(*nbt.String); ok && optionsTag.Value != "" {
+			options = optionsTag.Value
+		}
+		if gamerules.Blocks, err = gamerules.LoadBlocksFromFile(*blockDefs); err != nil {
+			return
+		}
+		layers, err := generation.ParseFlatLayers(options)
+		if err != nil {
+			return err
+		}
+		data.Set("generatorOptions", &nbt.String{flatPreset(layers)})
+	} else {
+		if generatorName != generation.DefaultGeneratorName {
+			log.Printf("The %s generator is replaced by the Notchian default generator", generatorName)
+		}
+		generatorName = generation.DefaultGeneratorName
+		data.Tags["generatorOptions"] = nil, false
+	}
+
+	data.Set("generatorName", &nbt.String{generatorName})
+	data.Set("version", &nbt.Int{chunkstore.LevelVersionAnvil})
+	data.Tags["chunkFormat"] = nil, false
+
+	return
+}
+
+// biomesForLevel returns the biomes of the chunks in a dimension. Only the
+// default and density generators decide the biomes of the normal dimension.
+func biomesForLevel(data *nbt.Compound, dimension DimensionId) chunkstore.AnvilBiomeFunc {
+	generatorName := generation.DefaultGeneratorName
+	if nameTag, ok := data.Lookup("generatorName").(*nbt.String); ok && nameTag.Value != "" {
+		generatorName = nameTag.Value
+	}
+
+	biomeId := byte(anvilBiomePlains)
+	if dimension == DimensionNether {
+		biomeId = anvilBiomeHell
+	} else if generatorName == generation.DefaultGeneratorName || generatorName == "density" {
+		var seed int64
+		if seedTag, ok := data.Lookup("RandomSeed").(*nbt.Long); ok {
+			seed = seedTag.Value
+		}
+		source := generation.NewBiomeSource(seed)
+
+		return func(chunkLoc ChunkXz) []byte {
+			corner := chunkLoc.ChunkCornerBlockXY()
+			biomes := make([]byte, ChunkSizeH*ChunkSizeH)
+			for z := 0; z < ChunkSizeH; z++ {
+				for x := 0; x < ChunkSizeH; x++ {
+					biome := source.BiomeAt(corner.X+BlockCoord(x), corner.Z+BlockCoord(z))
+					biomes[x+z*ChunkSizeH] = biome.AnvilId
+				}
+			}
+			return biomes
+		}
+	}
+
+	return func(chunkLoc ChunkXz) []byte {
+		biomes := make([]byte, ChunkSizeH*ChunkSizeH)
+		for i := range biomes {
+			biomes[i] = biomeId
+		}
+		return biomes
+	}
+}
+
+func copyFile(srcPath, dstPath string) (err os.Error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return
+}
+
+// copyPlayerFiles copies the data of each player in the players directory,
+// which is the same in the Anvil format, and returns the number copied.
+func copyPlayerFiles(worldPath, exportPath string) (count int, err os.Error) {
+	playersPath := path.Join(worldPath, "players")
+	dir, err := os.Open(playersPath)
+	if err != nil {
+		// A single player world has no players directory.
+		return 0, nil
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+
+	exportPlayersPath := path.Join(exportPath, "players")
+	if err = os.Mkdir(exportPlayersPath, 0777); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".dat") {
+			continue
+		}
+		if err = copyFile(path.Join(playersPath, name), path.Join(exportPlayersPath, name)); err != nil {
+			return
+		}
+		count++
+	}
+
+	return
+}
+
+func exportWorld(worldPath, exportPath string) (err os.Error) {
+	if strings.HasPrefix(path.Clean(exportPath)+"/", path.Clean(worldPath)+"/") {
+		return os.NewError("the export would be within the world")
+	}
+
+	level, err := readNbtFile(path.Join(worldPath, "level.dat"))
+	if err != nil {
+		return
+	}
+
+	data, ok := level.Lookup("Data").(*nbt.Compound)
+	if !ok {
+		return os.NewError("Invalid level.dat: does not contain Data")
+	}
+
+	version, ok := data.Lookup("version").(*nbt.Int)
+	if !ok {
+		return os.NewError("Alpha worlds must be converted with convertworld first")
+	} else if version.Value != chunkstore.LevelVersionMcRegion {
+		return chunkstore.UnknownLevelVersion(version.Value)
+	}
+	format := chunkstore.ChunkFormatRegion
+	if formatTag, ok := data.Lookup("chunkFormat").(*nbt.String); ok {
+		format = formatTag.Value
+	}
+
+	if err = os.Mkdir(exportPath, 0777); err != nil {
+		return
+	}
+
+	for _, dimension := range []DimensionId{DimensionNormal, DimensionNether} {
+		start := time.Nanoseconds()
+		count, err := chunkstore.ExportAnvilChunks(worldPath, dimension, format, exportPath, biomesForLevel(data, dimension))
+		if err != nil {
+			return err
+		}
+		log.Printf("Dimension %d: exported and verified %d chunks in %.1fs", dimension, count, float64(time.Nanoseconds()-start)/1e9)
+	}
+
+	playerCount, err := copyPlayerFiles(worldPath, exportPath)
+	if err != nil {
+		return
+	}
+	log.Printf("Copied %d players", playerCount)
+
+	if err = convertLevel(data); err != nil {
+		return
+	}
+
+	return writeNbtFile(path.Join(exportPath, "level.dat"), level)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	worldPath, exportPath := flag.Arg(0), flag.Arg(1)
+	if err := exportWorld(worldPath, exportPath); err != nil {
+		log.Printf("%s: %v", worldPath, err)
+		os.Exit(1)
+	}
+}
@@ -1,15 +1,93 @@
+// Utility to render previews of generated terrain, for tuning world generators
+// without starting a server.
 package main
 
 import (
+	"flag"
+	"fmt"
 	"image"
 	"image/png"
 	"log"
 	"os"
 
-	. "chunkymonkey/generation"
-	"perlin"
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/generation"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
 )
 
+var blockDefs = flag.String(
+	"blocks", "blocks.json",
+	"The JSON file containing block type definitions.")
+
+var itemDefs = flag.String(
+	"items", "items.json",
+	"The JSON file containing item type definitions.")
+
+var recipeDefs = flag.String(
+	"recipes", "recipes.json",
+	"The JSON file containing recipe definitions.")
+
+var furnaceDefs = flag.String(
+	"furnace", "furnace.json",
+	"The JSON file containing furnace fuel and reaction definitions.")
+
+var oreDefs = flag.String(
+	"ores", "ores.json",
+	"The JSON file containing ore definitions for world generation.")
+
+var userDefs = flag.String(
+	"users", "users.json",
+	"The JSON file container user permissions.")
+
+var groupDefs = flag.String(
+	"groups", "groups.json",
+	"The JSON file containing group permissions.")
+
+var worldPath = flag.String(
+	"world", "",
+	"Take the generator and seed from the world in this directory, instead of "+
+		"from the -generator, -generator_options and -seed flags.")
+
+var generatorName = flag.String(
+	"generator", generation.DefaultGeneratorName,
+	"The world generator to preview.")
+
+var generatorOptions = flag.String(
+	"generator_options", "",
+	"The options for the world generator.")
+
+var seed = flag.Int64(
+	"seed", 0,
+	"The world seed.")
+
+var centreX = flag.Int(
+	"x", 0,
+	"The X coordinate of the block at the centre of the preview.")
+
+var centreZ = flag.Int(
+	"z", 0,
+	"The Z coordinate of the block at the centre of the preview. Slices are "+
+		"taken along the X axis at this coordinate.")
+
+var size = flag.Int(
+	"size", 512,
+	"The width and depth of the previewed area, in blocks.")
+
+var preview = flag.String(
+	"preview", "height",
+	"The preview to render: height, water, biome, ores or slice.")
+
+var populate = flag.Bool(
+	"populate", false,
+	"Populate chunks with features such as trees and lakes before rendering. "+
+		"The ores preview always populates chunks.")
+
+var output = flag.String(
+	"output", "output.png",
+	"The PNG file to write the preview to.")
+
 type Stat struct {
 	count    int
 	min, max float64
@@ -30,80 +108,378 @@ func (s *Stat) Add(sample float64) {
 	s.count++
 }
 
-func main() {
-	w := 512
-	h := 512
-
-	perlin := perlin.NewPerlinNoise(0)
-
-	gen := &Sum{
-		Inputs: []ISource{
-			&Turbulence{
-				Dx:     &Scale{50, 1, &Offset{20.1, 0, perlin}},
-				Dy:     &Scale{50, 1, &Offset{10.1, 0, perlin}},
-				Factor: 100,
-				Source: &Scale{
-					Wavelength: 200,
-					Amplitude:  100,
-					Source:     perlin,
-				},
-			},
-			&Turbulence{
-				Dx:     &Scale{40, 1, &Offset{20.1, 0, perlin}},
-				Dy:     &Scale{40, 1, &Offset{10.1, 0, perlin}},
-				Factor: 10,
-				Source: &Mult{
-					A: &Scale{
-						Wavelength: 40,
-						Amplitude:  20,
-						Source:     perlin,
-					},
-					// Local steepness.
-					B: &Scale{
-						Wavelength: 200,
-						Amplitude:  1,
-						Source:     &Add{perlin, 0.6},
-					},
-				},
-			},
-			&Scale{
-				Wavelength: 5,
-				Amplitude:  2,
-				Source:     perlin,
-			},
-		},
-	}
-
-	values := make([]float64, h*h)
-
-	var valueStat Stat
-
-	for row := 0; row < h; row++ {
-		for col := 0; col < w; col++ {
-			value := gen.At2d(float64(col), float64(row))
-			valueStat.Add(value)
-			values[row*w+col] = value
+// A renderer draws the pixels of a preview from one chunk at a time. The
+// corner is the block at the top left of the image.
+type renderer func(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader)
+
+var renderers = map[string]renderer{
+	"height": renderHeight,
+	"water":  renderWater,
+	"biome":  renderBiome,
+	"ores":   renderOres,
+	"slice":  renderSlice,
+}
+
+var (
+	// biomes is used by the biome preview.
+	biomes *generation.BiomeSource
+
+	// oreCounts holds the number of ore blocks in each column of the ores
+	// preview.
+	oreCounts []int
+
+	// heightStat collects the heights of the columns in top-down previews.
+	heightStat Stat
+)
+
+type colourStop struct {
+	y      int
+	colour image.RGBAColor
+}
+
+// heightColours is the colour ramp of the height preview, from the bottom of
+// the world to the top.
+var heightColours = []colourStop{
+	{0, image.RGBAColor{0, 0, 0, 255}},
+	{40, image.RGBAColor{40, 60, 40, 255}},
+	{generation.SeaLevel, image.RGBAColor{70, 130, 50, 255}},
+	{90, image.RGBAColor{160, 140, 80, 255}},
+	{110, image.RGBAColor{140, 110, 90, 255}},
+	{ChunkSizeY - 1, image.RGBAColor{255, 255, 255, 255}},
+}
+
+// biomeColours holds the colour of each biome, indexed by BiomeId.
+var biomeColours = []image.RGBAColor{
+	generation.BiomeIdTundra:     {220, 230, 240, 255},
+	generation.BiomeIdTaiga:      {50, 100, 90, 255},
+	generation.BiomeIdSwampland:  {70, 90, 50, 255},
+	generation.BiomeIdSavanna:    {190, 180, 90, 255},
+	generation.BiomeIdShrubland:  {130, 150, 70, 255},
+	generation.BiomeIdDesert:     {240, 220, 140, 255},
+	generation.BiomeIdPlains:     {120, 190, 80, 255},
+	generation.BiomeIdForest:     {40, 130, 40, 255},
+	generation.BiomeIdRainforest: {20, 90, 20, 255},
+}
+
+// blockColours holds the colours of blocks in slices. Other blocks are drawn
+// in otherBlockColour.
+var blockColours = map[BlockId]image.RGBAColor{
+	BlockIdAir:              {200, 230, 255, 255},
+	BlockIdStone:            {120, 120, 120, 255},
+	BlockIdGrass:            {80, 170, 60, 255},
+	BlockIdDirt:             {130, 90, 60, 255},
+	BlockIdCobblestone:      {90, 90, 90, 255},
+	BlockIdBedrock:          {30, 30, 30, 255},
+	BlockIdWater:            {40, 80, 220, 255},
+	BlockIdStationaryWater:  {40, 80, 220, 255},
+	BlockIdFlowingLava:      {240, 110, 0, 255},
+	BlockIdLava:             {240, 110, 0, 255},
+	BlockIdSand:             {230, 215, 150, 255},
+	BlockIdGravel:           {150, 140, 130, 255},
+	BlockIdWood:             {100, 70, 40, 255},
+	BlockIdLeaves:           {30, 110, 30, 255},
+	BlockIdMossyCobblestone: {70, 110, 70, 255},
+	BlockIdSnow:             {250, 250, 250, 255},
+	BlockIdIce:              {160, 200, 255, 255},
+	BlockIdNetherrack:       {110, 50, 50, 255},
+	BlockIdSoulSand:         {80, 60, 50, 255},
+	BlockIdGlowstone:        {250, 220, 120, 255},
+}
+
+var otherBlockColour = image.RGBAColor{255, 0, 255, 255}
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags]\n")
+	flag.PrintDefaults()
+}
+
+// blend mixes the colours a and b, in the proportion t of b.
+func blend(a, b image.RGBAColor, t float64) image.RGBAColor {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + t*(float64(b)-float64(a)))
+	}
+	return image.RGBAColor{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+// shade darkens the colour in proportion to height, so that previews show the
+// relief of the terrain.
+func shade(colour image.RGBAColor, height int) image.RGBAColor {
+	return blend(image.RGBAColor{0, 0, 0, 255}, colour, 0.5+0.5*float64(height)/ChunkSizeY)
+}
+
+func heightColour(height int) image.RGBAColor {
+	for i := 1; i < len(heightColours); i++ {
+		lower, upper := &heightColours[i-1], &heightColours[i]
+		if height <= upper.y {
+			return blend(lower.colour, upper.colour, float64(height-lower.y)/float64(upper.y-lower.y))
+		}
+	}
+	return heightColours[len(heightColours)-1].colour
+}
+
+// topBlock returns the height and ID of the highest block in a column that is
+// not air.
+func topBlock(column []byte) (y int, blockId BlockId) {
+	for y = ChunkSizeY - 1; y > 0; y-- {
+		if column[y] != byte(BlockIdAir) {
+			break
+		}
+	}
+	return y, BlockId(column[y])
+}
+
+// forEachColumn calls fn with the pixel and blocks of each column of the
+// chunk that is in the image.
+func forEachColumn(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader, fn func(px, py int, column []byte)) {
+	chunkLoc := chunk.ChunkLoc()
+	chunkCorner := chunkLoc.ChunkCornerBlockXY()
+	blocks := chunk.Blocks()
+	bounds := img.Bounds()
+
+	for x := 0; x < ChunkSizeH; x++ {
+		for z := 0; z < ChunkSizeH; z++ {
+			px := int(chunkCorner.X) + x - int(corner.X)
+			py := int(chunkCorner.Z) + z - int(corner.Z)
+			if px < 0 || py < 0 || px >= bounds.Max.X || py >= bounds.Max.Y {
+				continue
+			}
+			index := (x*ChunkSizeH + z) * ChunkSizeY
+			fn(px, py, blocks[index:index+ChunkSizeY])
+		}
+	}
+}
+
+// renderHeight colours each column by the height of the terrain, ignoring any
+// water.
+func renderHeight(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader) {
+	forEachColumn(img, corner, chunk, func(px, py int, column []byte) {
+		y, _ := topBlock(column)
+		for y > 0 && isLiquid(BlockId(column[y])) {
+			y--
+		}
+		heightStat.Add(float64(y))
+		img.Set(px, py, heightColour(y))
+	})
+}
+
+// renderWater draws water in blue, darker where it is deeper, and land in grey
+// by its height.
+func renderWater(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader) {
+	forEachColumn(img, corner, chunk, func(px, py int, column []byte) {
+		y, blockId := topBlock(column)
+		heightStat.Add(float64(y))
+
+		switch blockId {
+		case BlockIdWater, BlockIdStationaryWater, BlockIdIce:
+			depth := 0
+			for y-depth > 0 && (isLiquid(BlockId(column[y-depth])) || BlockId(column[y-depth]) == BlockIdIce) {
+				depth++
+			}
+			if depth > 32 {
+				depth = 32
+			}
+			img.Set(px, py, blend(image.RGBAColor{100, 160, 255, 255}, image.RGBAColor{0, 20, 100, 255}, float64(depth)/32))
+		case BlockIdFlowingLava, BlockIdLava:
+			img.Set(px, py, blockColours[BlockIdLava])
+		default:
+			grey := uint8(255 * y / ChunkSizeY)
+			img.Set(px, py, image.RGBAColor{grey, grey, grey, 255})
+		}
+	})
+}
+
+// renderBiome colours each column by its biome, shaded by height.
+func renderBiome(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader) {
+	forEachColumn(img, corner, chunk, func(px, py int, column []byte) {
+		y, _ := topBlock(column)
+		heightStat.Add(float64(y))
+
+		biome := biomes.BiomeAt(corner.X+BlockCoord(px), corner.Z+BlockCoord(py))
+		img.Set(px, py, shade(biomeColours[biome.Id], y))
+	})
+}
+
+// isOre returns true if the block is one of the ores in the ore definitions.
+// The dirt and gravel pockets that are defined alongside them are not ores.
+func isOre(blockId BlockId) bool {
+	if blockId == BlockIdDirt || blockId == BlockIdGravel {
+		return false
+	}
+	for i := range generation.Ores {
+		if blockId == generation.Ores[i].BlockId {
+			return true
 		}
 	}
+	return false
+}
+
+// renderOres counts the ore blocks in each column. The counts are turned
+// into colours once all of the chunks are counted.
+func renderOres(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader) {
+	width := img.Bounds().Max.X
 
-	img := image.NewGray(w, h)
+	forEachColumn(img, corner, chunk, func(px, py int, column []byte) {
+		count := 0
+		for _, blockId := range column {
+			if isOre(BlockId(blockId)) {
+				count++
+			}
+		}
+		oreCounts[py*width+px] = count
+	})
+}
+
+func colourOres(img *image.RGBA) {
+	max := 1
+	for _, count := range oreCounts {
+		if count > max {
+			max = count
+		}
+	}
+
+	bounds := img.Bounds()
+	for py := 0; py < bounds.Max.Y; py++ {
+		for px := 0; px < bounds.Max.X; px++ {
+			t := float64(oreCounts[py*bounds.Max.X+px]) / float64(max)
+			img.Set(px, py, blend(image.RGBAColor{20, 20, 40, 255}, image.RGBAColor{255, 220, 0, 255}, t))
+		}
+	}
+}
 
-	base := valueStat.min
-	scale := 255 / (valueStat.max - valueStat.min)
+// renderSlice draws the blocks in a vertical slice along the X axis, with the
+// top of the world at the top of the image.
+func renderSlice(img *image.RGBA, corner BlockXyz, chunk chunkstore.IChunkReader) {
+	chunkLoc := chunk.ChunkLoc()
+	chunkCorner := chunkLoc.ChunkCornerBlockXY()
+	z := BlockCoord(*centreZ) - chunkCorner.Z
+	if z < 0 || z >= ChunkSizeH {
+		return
+	}
 
-	for row := 0; row < h; row++ {
-		for col := 0; col < w; col++ {
-			scaled := scale * (values[row*w+col] - base)
-			img.Set(col, row, image.GrayColor{uint8(scaled)})
+	blocks := chunk.Blocks()
+	for x := 0; x < ChunkSizeH; x++ {
+		px := int(chunkCorner.X) + x - int(corner.X)
+		if px < 0 || px >= img.Bounds().Max.X {
+			continue
+		}
+		index := (x*ChunkSizeH + int(z)) * ChunkSizeY
+		for y := 0; y < ChunkSizeY; y++ {
+			colour, ok := blockColours[BlockId(blocks[index+y])]
+			if !ok {
+				colour = otherBlockColour
+			}
+			img.Set(px, ChunkSizeY-1-y, colour)
 		}
 	}
+}
 
-	log.Printf("value stats %#v", valueStat)
+func isLiquid(blockId BlockId) bool {
+	switch blockId {
+	case BlockIdWater, BlockIdStationaryWater, BlockIdFlowingLava, BlockIdLava:
+		return true
+	}
+	return false
+}
 
-	outFile, err := os.Create("output.png")
+// loadGenerator returns the generator to preview, and the biomes of the world
+// it generates.
+func loadGenerator() (generator generation.IChunkGenerator, biomeSource *generation.BiomeSource, err os.Error) {
+	if *worldPath != "" {
+		return worldstore.LoadGenerator(*worldPath)
+	}
+
+	generator, err = generation.NewGenerator(*generatorName, *seed, *generatorOptions)
+	if err != nil {
+		return
+	}
+	return generator, generation.NewBiomeSource(*seed), nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	render, ok := renderers[*preview]
+	if !ok || *size < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	err := gamerules.LoadGameRules(*blockDefs, *itemDefs, *recipeDefs, *furnaceDefs, *userDefs, *groupDefs)
+	if err != nil {
+		log.Fatal("Error loading game rules: ", err)
+	}
+
+	generation.Ores, err = generation.LoadOresFromFile(*oreDefs)
+	if err != nil {
+		log.Fatal("Error loading ore definitions: ", err)
+	}
+
+	generator, biomeSource, err := loadGenerator()
+	if err != nil {
+		log.Fatal("Error loading generator: ", err)
+	}
+	biomes = biomeSource
+
+	corner := BlockXyz{BlockCoord(*centreX - *size/2), 0, BlockCoord(*centreZ - *size/2)}
+	var img *image.RGBA
+	if *preview == "slice" {
+		img = image.NewRGBA(*size, ChunkSizeY)
+	} else {
+		img = image.NewRGBA(*size, *size)
+	}
+	if *preview == "ores" {
+		*populate = true
+		oreCounts = make([]int, *size**size)
+	}
+
+	// Chunks are populated without an underlying store, so they are all kept
+	// in memory.
+	var populatingStore *generation.PopulatingStore
+	if *populate {
+		populatingStore = generation.NewPopulatingStore(nil, generator)
+	}
+
+	minX, _ := corner.X.ToChunkLocalCoord()
+	minZ, _ := corner.Z.ToChunkLocalCoord()
+	maxX, _ := (corner.X + BlockCoord(*size-1)).ToChunkLocalCoord()
+	maxZ, _ := (corner.Z + BlockCoord(*size-1)).ToChunkLocalCoord()
+	if *preview == "slice" {
+		minZ, _ = BlockCoord(*centreZ).ToChunkLocalCoord()
+		maxZ = minZ
+	}
+
+	for x := minX; x <= maxX; x++ {
+		for z := minZ; z <= maxZ; z++ {
+			chunkLoc := ChunkXz{x, z}
+			var chunk chunkstore.IChunkReader
+			if populatingStore != nil {
+				if chunk, err = populatingStore.ReadChunk(chunkLoc); err != nil {
+					log.Fatal("Error populating chunk: ", err)
+				}
+			} else {
+				chunk = generator.GenerateTerrain(chunkLoc)
+			}
+			render(img, corner, chunk)
+		}
+	}
+
+	if *preview == "ores" {
+		colourOres(img)
+	}
+
+	if heightStat.count > 0 {
+		log.Printf("height stats %#v", heightStat)
+	}
+
+	outFile, err := os.Create(*output)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer outFile.Close()
-	png.Encode(outFile, img)
+	if err = png.Encode(outFile, img); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Wrote %s preview to %s\n", *preview, *output)
 }
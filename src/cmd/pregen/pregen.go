@@ -0,0 +1,274 @@
+// Utility to generate the chunks of a world ahead of time, so that players do
+// not wait for chunks to be generated as they explore a new world.
+//
+// Chunks are generated in two passes. The terrain of every chunk in the area
+// is generated in parallel and saved, and then each chunk is populated with
+// features. Chunks already in the world are left untouched, so the tool can
+// be run again to resume after being interrupted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"chunkymonkey/chunkstore"
+	"chunkymonkey/gamerules"
+	"chunkymonkey/generation"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+var blockDefs = flag.String(
+	"blocks", "blocks.json",
+	"The JSON file containing block type definitions.")
+
+var itemDefs = flag.String(
+	"items", "items.json",
+	"The JSON file containing item type definitions.")
+
+var recipeDefs = flag.String(
+	"recipes", "recipes.json",
+	"The JSON file containing recipe definitions.")
+
+var furnaceDefs = flag.String(
+	"furnace", "furnace.json",
+	"The JSON file containing furnace fuel and reaction definitions.")
+
+var oreDefs = flag.String(
+	"ores", "ores.json",
+	"The JSON file containing ore definitions for world generation.")
+
+var userDefs = flag.String(
+	"users", "users.json",
+	"The JSON file container user permissions.")
+
+var groupDefs = flag.String(
+	"groups", "groups.json",
+	"The JSON file containing group permissions.")
+
+var centreX = flag.Int(
+	"x", 0,
+	"The X coordinate of the chunk at the centre of the area to generate.")
+
+var centreZ = flag.Int(
+	"z", 0,
+	"The Z coordinate of the chunk at the centre of the area to generate.")
+
+var radius = flag.Int(
+	"radius", 16,
+	"The distance in chunks from the centre to the edges of the area to generate.")
+
+var workers = flag.Int(
+	"workers", 4,
+	"The number of goroutines generating terrain in parallel.")
+
+// progressInterval is the minimum time in nanoseconds between progress
+// reports.
+const progressInterval = 5e9
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <world>\n")
+	flag.PrintDefaults()
+}
+
+// progress reports how far through a pass the tool is, and estimates when the
+// pass will finish.
+type progress struct {
+	name       string
+	total      int
+	done       int
+	start      int64
+	lastReport int64
+}
+
+func newProgress(name string, total int) *progress {
+	now := time.Nanoseconds()
+	return &progress{
+		name:       name,
+		total:      total,
+		start:      now,
+		lastReport: now,
+	}
+}
+
+// step records that another chunk has been done.
+func (p *progress) step() {
+	p.done++
+
+	now := time.Nanoseconds()
+	if now-p.lastReport < progressInterval && p.done < p.total {
+		return
+	}
+	p.lastReport = now
+
+	elapsed := now - p.start
+	remaining := elapsed * int64(p.total-p.done) / int64(p.done)
+	log.Printf(
+		"%s: %d/%d chunks (%.1f%%), %s remaining",
+		p.name, p.done, p.total, 100*float64(p.done)/float64(p.total),
+		formatDuration(remaining))
+}
+
+func formatDuration(nanoseconds int64) string {
+	seconds := nanoseconds / 1e9
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds/60)%60, seconds%60)
+}
+
+// countingStore counts the chunks that its store fails to write. The
+// ChunkService that it is served by logs the errors.
+type countingStore struct {
+	chunkstore.IChunkStoreForeground
+	writeErrors int
+}
+
+func (s *countingStore) WriteChunk(writer chunkstore.IChunkWriter) (err os.Error) {
+	if err = s.IChunkStoreForeground.WriteChunk(writer); err != nil {
+		s.writeErrors++
+	}
+	return
+}
+
+// chunkArea returns the locations of the chunks within the given distance of
+// the centre.
+func chunkArea(centre ChunkXz, distance int) (locs []ChunkXz) {
+	locs = make([]ChunkXz, 0, (2*distance+1)*(2*distance+1))
+	for x := -distance; x <= distance; x++ {
+		for z := -distance; z <= distance; z++ {
+			locs = append(locs, ChunkXz{centre.X + ChunkCoord(x), centre.Z + ChunkCoord(z)})
+		}
+	}
+	return
+}
+
+// generateTerrain generates and saves the terrain of each chunk that is not
+// already in the store, using several goroutines.
+func generateTerrain(store chunkstore.IChunkStore, generator generation.IChunkGenerator, locs []ChunkXz) {
+	jobs := make(chan ChunkXz)
+	results := make(chan *generation.ChunkData)
+
+	for i := 0; i < *workers; i++ {
+		go func() {
+			for loc := range jobs {
+				result := <-store.ReadChunk(loc)
+				if _, ok := result.Err.(chunkstore.NoSuchChunkError); ok {
+					results <- generator.GenerateTerrain(loc)
+					continue
+				} else if result.Err != nil {
+					log.Printf("Error reading chunk %v: %v", loc, result.Err)
+				}
+				results <- nil
+			}
+		}()
+	}
+
+	go func() {
+		for _, loc := range locs {
+			jobs <- loc
+		}
+		close(jobs)
+	}()
+
+	p := newProgress("terrain", len(locs))
+	for _ = range locs {
+		if data := <-results; data != nil {
+			writer := store.Writer()
+			chunkstore.CopyChunk(writer, data)
+			store.WriteChunk(writer)
+		}
+		p.step()
+	}
+}
+
+// populate populates each chunk, which also saves the chunks around it.
+func populate(store chunkstore.IChunkStore, generator generation.IChunkGenerator, locs []ChunkXz) (err os.Error) {
+	populatingStore := generation.NewPopulatingStore(store, generator)
+
+	p := newProgress("populate", len(locs))
+	for _, loc := range locs {
+		if _, err = populatingStore.ReadChunk(loc); err != nil {
+			return
+		}
+		p.step()
+	}
+
+	return
+}
+
+// pregen generates and populates the area of the world, and returns once all
+// of the chunks are written.
+func pregen(worldPath string) (err os.Error) {
+	// The world is not loaded as a WorldStore, as its stores would write to
+	// the same files as this one.
+	generator, _, err := worldstore.LoadGenerator(worldPath)
+	if err != nil {
+		return
+	}
+
+	fgStore, err := worldstore.OpenChunkStore(worldPath, DimensionNormal)
+	if err != nil {
+		return
+	}
+	if !fgStore.SupportsWrite() {
+		fgStore.Close()
+		return os.NewError("The world's chunk store does not support writing")
+	}
+
+	counter := &countingStore{IChunkStoreForeground: fgStore}
+	store := chunkstore.NewChunkService(counter)
+	go store.Serve()
+
+	centre := ChunkXz{ChunkCoord(*centreX), ChunkCoord(*centreZ)}
+
+	// Populating a chunk needs the terrain of the chunks next to it.
+	generateTerrain(store, generator, chunkArea(centre, *radius+1))
+
+	err = populate(store, generator, chunkArea(centre, *radius))
+
+	// Wait for all of the chunks to be written.
+	store.Close()
+
+	if err == nil && counter.writeErrors > 0 {
+		err = fmt.Errorf("%d chunks could not be written", counter.writeErrors)
+	}
+
+	return
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *radius < 0 || *workers < 1 {
+		log.Print("The radius must not be negative, and there must be at least one worker")
+		os.Exit(1)
+	}
+	runtime.GOMAXPROCS(*workers)
+
+	err := gamerules.LoadGameRules(*blockDefs, *itemDefs, *recipeDefs, *furnaceDefs, *userDefs, *groupDefs)
+	if err != nil {
+		log.Print("Error loading game rules: ", err)
+		os.Exit(1)
+	}
+
+	generation.Ores, err = generation.LoadOresFromFile(*oreDefs)
+	if err != nil {
+		log.Print("Error loading ore definitions: ", err)
+		os.Exit(1)
+	}
+
+	if err = pregen(flag.Arg(0)); err != nil {
+		log.Print("Error generating chunks: ", err)
+		os.Exit(1)
+	}
+
+	log.Print("Done")
+}
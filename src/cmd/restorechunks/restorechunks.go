@@ -0,0 +1,106 @@
+// Utility to restore chunks of a world from a backup of it, such as a snapshot
+// made by the server. The server must not be running on the world; while it
+// runs, use the /restore command instead.
+//
+// The chunks in the rectangle with the given coordinates at opposite corners
+// are copied from the backup, replacing those in the world. Chunks that are
+// not in the backup are left unchanged, as is the backup itself.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path"
+	"strconv"
+
+	"chunkymonkey/chunkstore"
+	. "chunkymonkey/types"
+	"chunkymonkey/worldstore"
+)
+
+var dimension = flag.Int(
+	"dimension", int(DimensionNormal),
+	"The dimension to restore chunks in: 0 for the normal world, -1 for the nether.")
+
+var blocks = flag.Bool(
+	"blocks", false,
+	"The coordinates are of blocks, rather than of chunks.")
+
+func usage() {
+	os.Stderr.WriteString("usage: " + os.Args[0] + " [flags] <backup> <world> <x1> <z1> [<x2> <z2>]\n")
+	flag.PrintDefaults()
+}
+
+// parseCorners returns the chunks at the corners of the area given by the
+// coordinate arguments.
+func parseCorners(args []string) (from, to ChunkXz, err os.Error) {
+	var coords []ChunkCoord
+	for _, arg := range args {
+		coord, err := strconv.Atoi(arg)
+		if err != nil {
+			return from, to, err
+		}
+		if *blocks {
+			chunkCoord, _ := BlockCoord(coord).ToChunkLocalCoord()
+			coords = append(coords, chunkCoord)
+		} else {
+			coords = append(coords, ChunkCoord(coord))
+		}
+	}
+	if len(coords) == 2 {
+		coords = append(coords, coords...)
+	}
+
+	return ChunkXz{coords[0], coords[1]}, ChunkXz{coords[2], coords[3]}, nil
+}
+
+func restoreChunks(backupPath, worldPath string, chunkLocs []ChunkXz) (count int, err os.Error) {
+	if path.Clean(backupPath) == path.Clean(worldPath) {
+		return 0, os.NewError("the backup is the world itself")
+	}
+
+	backupStore, err := worldstore.OpenChunkStoreReadOnly(backupPath, DimensionId(*dimension))
+	if err != nil {
+		return
+	}
+	defer backupStore.Close()
+
+	worldStore, err := worldstore.OpenChunkStore(worldPath, DimensionId(*dimension))
+	if err != nil {
+		return
+	}
+	defer worldStore.Close()
+
+	if !worldStore.SupportsWrite() {
+		return 0, os.NewError("the world's chunks cannot be written")
+	}
+
+	return chunkstore.CopyChunks(backupStore, worldStore, chunkLocs)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 4 && flag.NArg() != 6 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	backupPath, worldPath := flag.Arg(0), flag.Arg(1)
+	from, to, err := parseCorners(flag.Args()[2:])
+	if err != nil {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	chunkLocs := chunkstore.ChunksInArea(from, to)
+	count, err := restoreChunks(backupPath, worldPath, chunkLocs)
+	if err != nil {
+		log.Printf("%s: restored %d chunks, then failed: %v", worldPath, count, err)
+		os.Exit(1)
+	}
+
+	log.Printf("%s: restored %d of %d chunks from %s", worldPath, count, len(chunkLocs), backupPath)
+}
@@ -63,6 +63,7 @@ const (
 	TagString    = TagType(8)
 	TagList      = TagType(9)
 	TagCompound  = TagType(10)
+	TagIntArray  = TagType(11)
 )
 
 // NewTag creates a new tag of the given TagType. TagEnd is not a valid value
@@ -89,6 +90,8 @@ func (tt TagType) NewTag() (tag ITag, err os.Error) {
 		tag = new(List)
 	case TagCompound:
 		tag = new(Compound)
+	case TagIntArray:
+		tag = new(IntArray)
 	default:
 		err = fmt.Errorf("invalid NBT tag type %#x", tt)
 	}
@@ -264,6 +267,45 @@ func (*ByteArray) Lookup(path string) ITag {
 	return nil
 }
 
+type IntArray struct {
+	Value []int32
+}
+
+func (*IntArray) Type() TagType {
+	return TagIntArray
+}
+
+func (i *IntArray) Read(reader io.Reader) (err os.Error) {
+	var length Int
+
+	err = length.Read(reader)
+	if err != nil {
+		return
+	}
+
+	ints := make([]int32, length.Value)
+	if err = binary.Read(reader, binary.BigEndian, ints); err != nil {
+		return
+	}
+
+	i.Value = ints
+	return
+}
+
+func (i *IntArray) Write(writer io.Writer) (err os.Error) {
+	length := Int{int32(len(i.Value))}
+
+	if err = length.Write(writer); err != nil {
+		return
+	}
+
+	return binary.Write(writer, binary.BigEndian, i.Value)
+}
+
+func (*IntArray) Lookup(path string) ITag {
+	return nil
+}
+
 type String struct {
 	Value string
 }
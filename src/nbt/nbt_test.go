@@ -69,6 +69,7 @@ func TestSerialization(t *testing.T) {
 		{te.LiteralString("\x3f\x80\x00\x00"), &Float{1.0}},
 		{te.LiteralString("\x3f\xf0\x00\x00\x00\x00\x00\x00"), &Double{1.0}},
 		{te.LiteralString("\x00\x00\x00\x04\x00\x01\x02\x03"), &ByteArray{[]byte{0, 1, 2, 3}}},
+		{te.LiteralString("\x00\x00\x00\x02\x00\x00\x00\x01\xff\xff\xff\xfe"), &IntArray{[]int32{1, -2}}},
 		{te.LiteralString("\x00\x03foo"), &String{"foo"}},
 		{te.LiteralString("\x01\x00\x00\x00\x02\x01\x02"), &List{TagByte, []ITag{&Byte{1}, &Byte{2}}}},
 		{te.LiteralString("\x03\x00\x00\x00\x02\x00\x00\x00\x01\x00\x00\x00\x02"), &List{TagInt, []ITag{&Int{1}, &Int{2}}}},
@@ -14,6 +14,7 @@ type PerlinNoise struct {
 	seed   int64
 	permut [256]int
 	g2d    [256][2]float64 // Randomly generated 2D unit vectors.
+	g3d    [256][3]float64 // Randomly generated 3D unit vectors.
 }
 
 func NewPerlinNoise(seed int64) *PerlinNoise {
@@ -41,6 +42,13 @@ func NewPerlinNoise(seed int64) *PerlinNoise {
 		normVector(gen.g2d[i][:])
 	}
 
+	// Initialize gen.g3d.
+	source.Seed(seed)
+	for i := range perm {
+		randVector(gen.g3d[i][:], rnd)
+		normVector(gen.g3d[i][:])
+	}
+
 	return gen
 }
 
@@ -82,6 +90,50 @@ func (gen *PerlinNoise) At2d(x, y float64) float64 {
 	return a + sy*(b-a)
 }
 
+func (gen *PerlinNoise) grad3d(x, y, z int) *[3]float64 {
+	gradIndex := x&0xff + gen.permut[(y+gen.permut[z&0xff])&0xff]
+	return &gen.g3d[gradIndex&0xff]
+}
+
+// At3d returns the noise value at a given 3D point.
+func (gen *PerlinNoise) At3d(x, y, z float64) float64 {
+	x0 := floor(x)
+	y0 := floor(y)
+	z0 := floor(z)
+	x1 := x0 + 1
+	y1 := y0 + 1
+	z1 := z0 + 1
+
+	// The dot products of each corner's gradient with the vector from the corner
+	// to the point, for the near (z0) and far (z1) faces of the cube.
+	dot := func(xi, yi, zi float64) float64 {
+		grad := gen.grad3d(int(xi), int(yi), int(zi))
+		return grad[0]*(x-xi) + grad[1]*(y-yi) + grad[2]*(z-zi)
+	}
+
+	dx := x - x0
+	sx := 3*dx*dx - 2*dx*dx*dx
+	dy := y - y0
+	sy := 3*dy*dy - 2*dy*dy*dy
+	dz := z - z0
+	sz := 3*dz*dz - 2*dz*dz*dz
+
+	// Trilinear interpolation using the same "ease" function as At2d.
+	a0 := dot(x0, y0, z0)
+	a0 += sx * (dot(x1, y0, z0) - a0)
+	b0 := dot(x0, y1, z0)
+	b0 += sx * (dot(x1, y1, z0) - b0)
+	near := a0 + sy*(b0-a0)
+
+	a1 := dot(x0, y0, z1)
+	a1 += sx * (dot(x1, y0, z1) - a1)
+	b1 := dot(x0, y1, z1)
+	b1 += sx * (dot(x1, y1, z1) - b1)
+	far := a1 + sy*(b1-a1)
+
+	return near + sz*(far-near)
+}
+
 func (gen *PerlinNoise) MeanMagnitude() float64 {
 	return 0.5
 }
@@ -12,3 +12,32 @@ func Benchmark_Perlin_At2d(b *testing.B) {
 		n.At2d(0, 0)
 	}
 }
+
+func Benchmark_Perlin_At3d(b *testing.B) {
+	n := NewPerlinNoise(0)
+	b.ResetTimer()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		n.At3d(0, 0, 0)
+	}
+}
+
+func TestPerlin_At3d(t *testing.T) {
+	n := NewPerlinNoise(0)
+
+	// Noise is zero at lattice points.
+	if v := n.At3d(3, -2, 7); v != 0 {
+		t.Errorf("expected 0 at lattice point, got %v", v)
+	}
+
+	for i := 0; i < 1000; i++ {
+		x, y, z := float64(i)*0.37, float64(i)*-0.71, float64(i)*0.13
+		v := n.At3d(x, y, z)
+		if v < -1.5 || v > 1.5 {
+			t.Errorf("At3d(%v, %v, %v) = %v, out of range", x, y, z, v)
+		}
+		if w := n.At3d(x, y, z); v != w {
+			t.Errorf("At3d(%v, %v, %v) not repeatable: %v != %v", x, y, z, v, w)
+		}
+	}
+}